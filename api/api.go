@@ -327,9 +327,14 @@ func (api API) validate(r *http.Request) response {
 // Retrieve "domain" parameter from request as ASCII
 // If fails, returns an error.
 func getASCIIDomain(r *http.Request) (string, error) {
-	domain, err := getParam("domain", r)
+	input, err := getParam("domain", r)
 	if err != nil {
-		return domain, err
+		return input, err
+	}
+	// Users often paste an email address rather than a bare domain.
+	domain, err := checker.DomainFromInput(input)
+	if err != nil {
+		return "", err
 	}
 	ascii, err := idna.ToASCII(domain)
 	if err != nil {
@@ -0,0 +1,134 @@
+package checker
+
+import "sync"
+
+// AlertKind is the kind of result an Alert is about.
+type AlertKind string
+
+// Kinds of results that AlertHandler watches for.
+const (
+	// AlertErrors are results suggesting the scan itself is broken: errors,
+	// timeouts, and domains none of whose mailservers could be connected to.
+	AlertErrors AlertKind = "errors"
+	// AlertFailures are results of domains that failed a policy check, e.g.
+	// by not supporting STARTTLS.
+	AlertFailures AlertKind = "failures"
+)
+
+// An Alert reports that the rate of a kind of result rose above
+// AlertHandler.Threshold.
+type Alert struct {
+	Kind AlertKind
+	// Rate is the fraction of the results in the window of that kind.
+	Rate float64
+	// Domain is the domain whose result raised the rate above the threshold.
+	Domain string
+}
+
+// alertKind returns the kind of r, or "" if it's neither an error nor a
+// failure.
+func alertKind(r DomainResult) AlertKind {
+	if r.TimedOut {
+		return AlertErrors
+	}
+	switch r.Status {
+	case DomainError, DomainCouldNotConnect:
+		return AlertErrors
+	case DomainFailure, DomainNoSTARTTLSFailure, DomainBadHostnameFailure:
+		return AlertFailures
+	}
+	return ""
+}
+
+const (
+	defaultAlertWindow    = 100
+	defaultAlertThreshold = 0.5
+)
+
+// AlertHandler watches a stream of domain results for a high rate of errors
+// or failures over the last Window results, which usually means the scanner's
+// network is broken rather than everyone's mail.
+// Implements ResultHandler, and is safe for concurrent use.
+type AlertHandler struct {
+	// Window is the number of most recent results the rates are computed
+	// over. No alerts are raised until that many results have been handled.
+	// If zero, a default of 100 is used.
+	Window int
+
+	// Threshold is the rate (from 0 to 1) of errors or failures above which
+	// OnAlert is called.
+	// If zero, a default of 0.5 is used.
+	Threshold float64
+
+	// OnAlert is called when the rate of errors or of failures rises above
+	// Threshold. It isn't called again for the same kind until the rate has
+	// fallen back to Threshold or below.
+	OnAlert func(Alert)
+
+	mu       sync.Mutex
+	recent   []AlertKind
+	next     int
+	counts   map[AlertKind]int
+	alerting map[AlertKind]bool
+}
+
+func (h *AlertHandler) window() int {
+	if h.Window > 0 {
+		return h.Window
+	}
+	return defaultAlertWindow
+}
+
+func (h *AlertHandler) threshold() float64 {
+	if h.Threshold > 0 {
+		return h.Threshold
+	}
+	return defaultAlertThreshold
+}
+
+// HandleDomain adds a single domain's result to the window, and calls OnAlert
+// if it raises the rate of errors or failures above the threshold.
+func (h *AlertHandler) HandleDomain(r DomainResult) {
+	alerts := h.add(r)
+	if h.OnAlert == nil {
+		return
+	}
+	for _, alert := range alerts {
+		h.OnAlert(alert)
+	}
+}
+
+// add adds r to the window, and returns the alerts it raises.
+func (h *AlertHandler) add(r DomainResult) []Alert {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.counts == nil {
+		h.counts = make(map[AlertKind]int)
+		h.alerting = make(map[AlertKind]bool)
+	}
+	kind := alertKind(r)
+	if len(h.recent) < h.window() {
+		h.recent = append(h.recent, kind)
+	} else {
+		h.counts[h.recent[h.next]]--
+		h.recent[h.next] = kind
+		h.next = (h.next + 1) % len(h.recent)
+	}
+	h.counts[kind]++
+	if len(h.recent) < h.window() {
+		return nil
+	}
+	var alerts []Alert
+	for _, k := range []AlertKind{AlertErrors, AlertFailures} {
+		rate := float64(h.counts[k]) / float64(len(h.recent))
+		if rate <= h.threshold() {
+			h.alerting[k] = false
+			continue
+		}
+		if !h.alerting[k] {
+			h.alerting[k] = true
+			alerts = append(alerts, Alert{Kind: k, Rate: rate, Domain: r.Domain})
+		}
+	}
+	return alerts
+}
@@ -0,0 +1,41 @@
+package checker
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestAlertHandler(t *testing.T) {
+	var alerts []Alert
+	handler := AlertHandler{Window: 4, Threshold: 0.5, OnAlert: func(a Alert) { alerts = append(alerts, a) }}
+	statuses := []DomainStatus{
+		// The window isn't full yet.
+		DomainSuccess, DomainError, DomainCouldNotConnect,
+		// 3 of 4 are errors, then still 3 of 4, which was already alerted.
+		DomainError, DomainSuccess, DomainError,
+		// Back down to 1 of 4.
+		DomainSuccess, DomainSuccess,
+		// 2 of 4 failures isn't above the threshold, 3 of 4 is.
+		DomainNoSTARTTLSFailure, DomainFailure, DomainError, DomainBadHostnameFailure,
+		DomainError,
+	}
+	for i, status := range statuses {
+		handler.HandleDomain(DomainResult{Domain: fmt.Sprintf("%d.example.com", i), Status: status})
+	}
+	expected := []Alert{
+		{Kind: AlertErrors, Rate: 0.75, Domain: "3.example.com"},
+		{Kind: AlertFailures, Rate: 0.75, Domain: "11.example.com"},
+	}
+	if !reflect.DeepEqual(alerts, expected) {
+		t.Errorf("Expected alerts %v, got %v", expected, alerts)
+	}
+
+	alerts = nil
+	handler = AlertHandler{Window: 2, OnAlert: func(a Alert) { alerts = append(alerts, a) }}
+	handler.HandleDomain(DomainResult{Status: DomainSuccess, TimedOut: true})
+	handler.HandleDomain(DomainResult{Status: DomainSuccess, TimedOut: true})
+	if len(alerts) != 1 || alerts[0].Kind != AlertErrors {
+		t.Errorf("Expected timeouts to count as errors, got %v", alerts)
+	}
+}
@@ -0,0 +1,56 @@
+package checker
+
+import (
+	"net/smtp"
+	"strings"
+)
+
+// AuthInfo records the SMTP AUTH mechanisms (RFC 4954) advertised by a server
+// in response to EHLO.
+type AuthInfo struct {
+	// Mechanisms advertised on the cleartext connection, before STARTTLS.
+	BeforeSTARTTLS []string `json:"before_starttls,omitempty"`
+	// Mechanisms advertised after STARTTLS.
+	AfterSTARTTLS []string `json:"after_starttls,omitempty"`
+}
+
+// plaintextAuthMechanisms send the password itself, rather than proof of it.
+var plaintextAuthMechanisms = map[string]bool{
+	"PLAIN": true,
+	"LOGIN": true,
+}
+
+// authMechanisms returns the AUTH mechanisms client's server advertised in
+// its most recent EHLO response.
+func authMechanisms(client *smtp.Client) []string {
+	ok, params := client.Extension("AUTH")
+	if !ok {
+		return nil
+	}
+	return strings.Fields(strings.ToUpper(params))
+}
+
+// checkAuth fails if AUTH was offered before STARTTLS, since clients could
+// send their credentials in the clear. Returns nil if AUTH wasn't offered at
+// all, which is normal for MX servers.
+func checkAuth(info AuthInfo) *Result {
+	if len(info.BeforeSTARTTLS) == 0 && len(info.AfterSTARTTLS) == 0 {
+		return nil
+	}
+	result := MakeResult(Auth)
+	if len(info.BeforeSTARTTLS) == 0 {
+		return result.Success()
+	}
+	result.Failure("Server offers AUTH (%s) before STARTTLS, so credentials could be sent in the clear.",
+		strings.Join(info.BeforeSTARTTLS, " "))
+	weak := []string{}
+	for _, mechanism := range info.BeforeSTARTTLS {
+		if plaintextAuthMechanisms[mechanism] {
+			weak = append(weak, mechanism)
+		}
+	}
+	if len(weak) > 0 {
+		result.Warning("AUTH mechanisms %s send passwords unencrypted on a cleartext connection.", strings.Join(weak, " "))
+	}
+	return result
+}
@@ -0,0 +1,86 @@
+package checker
+
+import (
+	"crypto/tls"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mhale/smtpd"
+)
+
+func TestCheckAuth(t *testing.T) {
+	tests := []struct {
+		info   AuthInfo
+		status Status
+	}{
+		{AuthInfo{AfterSTARTTLS: []string{"PLAIN", "LOGIN"}}, Success},
+		{AuthInfo{BeforeSTARTTLS: []string{"CRAM-MD5"}}, Failure},
+		{AuthInfo{BeforeSTARTTLS: []string{"PLAIN"}, AfterSTARTTLS: []string{"PLAIN"}}, Failure},
+	}
+	for _, test := range tests {
+		result := checkAuth(test.info)
+		if result == nil || result.Status != test.status {
+			t.Errorf("checkAuth(%v) = %v, want status %d", test.info, result, test.status)
+		}
+	}
+	if result := checkAuth(AuthInfo{}); result != nil {
+		t.Errorf("Expected no AUTH check when AUTH isn't offered, got %v", result)
+	}
+	result := checkAuth(AuthInfo{BeforeSTARTTLS: []string{"CRAM-MD5", "LOGIN"}})
+	if len(result.Messages) != 2 {
+		t.Errorf("Expected plaintext mechanism LOGIN to be warned about, got %v", result.Messages)
+	}
+}
+
+func TestAuthBeforeSTARTTLS(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serveSMTPWithoutSTARTTLS(t, ln, "AUTH plain LOGIN", "502 Command not implemented")
+
+	result := FullCheckHostname("", ln.Addr().String(), testTimeout)
+	if result.Auth == nil || !reflect.DeepEqual(result.Auth.BeforeSTARTTLS, []string{"PLAIN", "LOGIN"}) {
+		t.Errorf("Expected AUTH mechanisms PLAIN LOGIN to be recorded, got %v", result.Auth)
+	}
+	if auth := result.Checks[Auth]; auth == nil || auth.Status != Failure {
+		t.Errorf("Expected AUTH before STARTTLS to fail, got %v", auth)
+	}
+}
+
+func TestAuthDoesNotSkipTLSChecks(t *testing.T) {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &smtpd.Server{
+		Handler:   noopHandler,
+		Hostname:  "example.com",
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		// CRAM-MD5 is advertised before STARTTLS.
+		AuthHandler: func(net.Addr, string, []byte, []byte, []byte) (bool, error) { return false, nil },
+	}
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !strings.Contains(err.Error(), "closed") {
+			t.Error(err)
+		}
+	}()
+
+	result := FullCheckHostname("", ln.Addr().String(), testTimeout)
+	if auth := result.Checks[Auth]; auth == nil || auth.Status != Failure {
+		t.Errorf("Expected AUTH before STARTTLS to fail, got %v", auth)
+	}
+	for _, name := range []string{Certificate, Version} {
+		if result.Checks[name] == nil {
+			t.Errorf("Expected the %s check to run despite the AUTH failure", name)
+		}
+	}
+}
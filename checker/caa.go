@@ -0,0 +1,170 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// CAARecord is a DNS Certification Authority Authorization record (RFC 8659).
+type CAARecord struct {
+	Flag  uint8  `json:"flag"`
+	Tag   string `json:"tag"`
+	Value string `json:"value"`
+}
+
+// caaFlagCritical marks a CAA property that CAs must understand to issue.
+const caaFlagCritical = 128
+
+func parseCAARecord(data []byte) (CAARecord, error) {
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return CAARecord{}, fmt.Errorf("CAA record too short")
+	}
+	tagLength := int(data[1])
+	return CAARecord{
+		Flag:  data[0],
+		Tag:   string(data[2 : 2+tagLength]),
+		Value: string(data[2+tagLength:]),
+	}, nil
+}
+
+// lookupCAA retrieves the CAA records relevant to domain, by climbing the DNS
+// tree from domain towards (but excluding) its TLD, as described in RFC 8659.
+// Returns the records, and the name at which they were found.
+func (c *Checker) lookupCAA(ctx context.Context, domain string) ([]CAARecord, string, error) {
+	if c.lookupCAAOverride != nil {
+		return c.lookupCAAOverride(domain)
+	}
+	name := strings.TrimSuffix(domain, ".")
+	for strings.Contains(name, ".") {
+		response, err := c.queryDNS(ctx, name, dnsTypeCAA)
+		if err != nil {
+			return nil, name, err
+		}
+		records := []CAARecord{}
+		for _, data := range response.recordsOfType(dnsTypeCAA) {
+			record, err := parseCAARecord(data)
+			if err != nil {
+				return nil, name, err
+			}
+			records = append(records, record)
+		}
+		if len(records) > 0 {
+			return records, name, nil
+		}
+		name = name[strings.Index(name, ".")+1:]
+	}
+	return []CAARecord{}, "", nil
+}
+
+// caaIdentifiers maps the organization names in certificate issuers to the
+// identifiers their CAs recognize in CAA records.
+// This list isn't exhaustive; issuers that aren't listed aren't checked against
+// CAA records.
+var caaIdentifiers = map[string][]string{
+	"Let's Encrypt":                {"letsencrypt.org"},
+	"DigiCert Inc":                 {"digicert.com", "symantec.com", "thawte.com", "geotrust.com", "rapidssl.com"},
+	"Sectigo Limited":              {"sectigo.com", "comodoca.com", "comodo.com", "usertrust.com"},
+	"COMODO CA Limited":            {"sectigo.com", "comodoca.com", "comodo.com", "usertrust.com"},
+	"ZeroSSL":                      {"sectigo.com", "zerossl.com"},
+	"GlobalSign nv-sa":             {"globalsign.com"},
+	"Google Trust Services LLC":    {"pki.goog", "google.com"},
+	"Google Trust Services":        {"pki.goog", "google.com"},
+	"Amazon":                       {"amazon.com", "amazontrust.com", "awstrust.com", "amazonaws.com"},
+	"GoDaddy.com, Inc.":            {"godaddy.com", "starfieldtech.com"},
+	"Starfield Technologies, Inc.": {"starfieldtech.com", "godaddy.com"},
+	"Entrust, Inc.":                {"entrust.net"},
+	"Buypass AS-983163327":         {"buypass.com", "buypass.no"},
+}
+
+var caaTagPattern = regexp.MustCompile("^[a-zA-Z0-9]{1,15}$")
+
+// checkCAA reports on the CAA records of a domain, and warns if the
+// certificates presented by any of the domain's hostnames within the domain
+// were issued by a CA that isn't authorized by them.
+func (c *Checker) checkCAA(ctx context.Context, domain string, hostnameResults map[string]HostnameResult) *Result {
+	result := MakeResult(CAA)
+	records, foundAt, err := c.lookupCAA(ctx, domain)
+	if err != nil {
+		return result.Error("Couldn't look up CAA records: %v", err)
+	}
+	if len(records) == 0 {
+		return result.Info("No CAA records found, so any certificate authority may issue certificates for %s.", domain)
+	}
+	authorized := map[string]bool{}
+	hasIssue := false
+	for _, record := range records {
+		tag := strings.ToLower(record.Tag)
+		if !caaTagPattern.MatchString(record.Tag) {
+			result.Warning("CAA record at %s has a malformed tag %q.", foundAt, record.Tag)
+			continue
+		}
+		switch tag {
+		case "issue", "issuewild":
+			issuer := strings.TrimSpace(strings.SplitN(record.Value, ";", 2)[0])
+			if issuer != "" && validateDomainName(issuer) != nil {
+				result.Warning("CAA record at %s has a malformed %s value %q.", foundAt, tag, record.Value)
+				continue
+			}
+			if tag == "issue" {
+				hasIssue = true
+				if issuer != "" {
+					authorized[strings.ToLower(issuer)] = true
+				}
+			}
+		case "iodef":
+			if !strings.HasPrefix(record.Value, "mailto:") && !strings.HasPrefix(record.Value, "http://") &&
+				!strings.HasPrefix(record.Value, "https://") {
+				result.Warning("CAA record at %s has a malformed iodef URL %q.", foundAt, record.Value)
+			}
+		default:
+			if record.Flag&caaFlagCritical != 0 {
+				result.Warning("CAA record at %s has an unknown critical tag %q, so compliant CAs won't issue certificates for %s.",
+					foundAt, record.Tag, domain)
+			}
+		}
+	}
+	if !hasIssue {
+		return result.Info("CAA records at %s don't restrict which certificate authorities may issue certificates.", foundAt)
+	}
+	if len(authorized) == 0 {
+		return result.Info("CAA records at %s forbid all certificate authorities from issuing certificates.", foundAt)
+	}
+	names := []string{}
+	for name := range authorized {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result.Info("CAA records at %s authorize %s to issue certificates.", foundAt, strings.Join(names, ", "))
+
+	hostnames := []string{}
+	for hostname := range hostnameResults {
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+	for _, hostname := range hostnames {
+		cert := hostnameResults[hostname].Certificate
+		name := strings.TrimSuffix(hostname, ".")
+		if cert == nil || (name != domain && !strings.HasSuffix(name, "."+domain)) {
+			// The domain's CAA records only govern certificates for names within it.
+			continue
+		}
+		for _, org := range cert.IssuerOrganization {
+			identifiers, ok := caaIdentifiers[org]
+			if !ok {
+				continue
+			}
+			permitted := false
+			for _, identifier := range identifiers {
+				permitted = permitted || authorized[identifier]
+			}
+			if !permitted {
+				result.Warning("The certificate for %s was issued by %s, which isn't authorized by the CAA records at %s.",
+					hostname, org, foundAt)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+package checker
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseCAARecord(t *testing.T) {
+	record, err := parseCAARecord(append([]byte{128, 5}, []byte("issueletsencrypt.org")...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Flag != 128 || record.Tag != "issue" || record.Value != "letsencrypt.org" {
+		t.Errorf("Unexpected CAA record %v", record)
+	}
+	if _, err := parseCAARecord([]byte{0, 5, 'i'}); err == nil {
+		t.Error("Expected short CAA record to fail to parse")
+	}
+}
+
+func TestCheckCAA(t *testing.T) {
+	letsEncrypt := HostnameResult{Certificate: &CertificateInfo{IssuerOrganization: []string{"Let's Encrypt"}}}
+	digiCert := HostnameResult{Certificate: &CertificateInfo{IssuerOrganization: []string{"DigiCert Inc"}}}
+	tests := []struct {
+		records   []CAARecord
+		hostnames map[string]HostnameResult
+		status    Status
+	}{
+		{[]CAARecord{}, nil, Info},
+		{[]CAARecord{{0, "issue", "letsencrypt.org"}}, nil, Info},
+		{[]CAARecord{{0, "issue", ";"}}, nil, Info},
+		{[]CAARecord{{0, "iodef", "mailto:security@example.com"}}, nil, Info},
+		{[]CAARecord{{0, "iodef", "security@example.com"}}, nil, Warning},
+		{[]CAARecord{{0, "issue", "not a domain"}}, nil, Warning},
+		{[]CAARecord{{0, "is-sue", "letsencrypt.org"}}, nil, Warning},
+		{[]CAARecord{{128, "future", "value"}}, nil, Warning},
+		{[]CAARecord{{0, "future", "value"}}, nil, Info},
+		{
+			[]CAARecord{{0, "issue", "letsencrypt.org"}},
+			map[string]HostnameResult{"mx.example.com": letsEncrypt},
+			Info,
+		},
+		{
+			[]CAARecord{{0, "issue", "letsencrypt.org"}},
+			map[string]HostnameResult{"mx.example.com": digiCert},
+			Warning,
+		},
+		// CAA records for example.com don't govern certs for other domains.
+		{
+			[]CAARecord{{0, "issue", "letsencrypt.org"}},
+			map[string]HostnameResult{"mx.provider.net": digiCert},
+			Info,
+		},
+		// Only issuewild records, so any CA may issue non-wildcard certificates.
+		{
+			[]CAARecord{{0, "issuewild", "letsencrypt.org"}},
+			map[string]HostnameResult{"mx.example.com": digiCert},
+			Info,
+		},
+	}
+	for _, test := range tests {
+		c := Checker{
+			lookupCAAOverride: func(string) ([]CAARecord, string, error) {
+				return test.records, "example.com", nil
+			},
+		}
+		result := c.checkCAA(context.Background(), "example.com", test.hostnames)
+		if result.Status != test.status {
+			t.Errorf("checkCAA with records %v = %v, want status %d", test.records, result, test.status)
+		}
+	}
+}
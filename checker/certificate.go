@@ -0,0 +1,264 @@
+package checker
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// CertificateFailure is a machine-readable reason for failing the Certificate
+// check. A certificate can fail for several reasons at once.
+type CertificateFailure string
+
+// Reasons for failing the Certificate check.
+const (
+	// CertExpired means a certificate in the chain has expired.
+	CertExpired CertificateFailure = "expired"
+	// CertNotYetValid means a certificate in the chain isn't valid yet.
+	CertNotYetValid CertificateFailure = "not_yet_valid"
+	// CertUntrustedRoot means the chain ends in a root that isn't trusted.
+	CertUntrustedRoot CertificateFailure = "untrusted_root"
+	// CertHostnameMismatch means the certificate isn't valid for the hostname.
+	CertHostnameMismatch CertificateFailure = "hostname_mismatch"
+	// CertSelfSigned means the certificate is signed by its own key.
+	CertSelfSigned CertificateFailure = "self_signed"
+	// CertIncompleteChain means the chain doesn't reach a trusted root, or any
+	// root, usually because the server doesn't send an intermediate.
+	CertIncompleteChain CertificateFailure = "incomplete_chain"
+	// CertWeakKey means a certificate in the chain has an RSA key shorter
+	// than 2048 bits.
+	CertWeakKey CertificateFailure = "weak_key"
+	// CertDSAKey means a certificate in the chain has a DSA key.
+	CertDSAKey CertificateFailure = "dsa_key"
+	// CertWeakSignature means a certificate in the chain is signed with SHA-1
+	// (or MD5).
+	CertWeakSignature CertificateFailure = "weak_signature"
+	// CertInvalid is any other problem with the chain, e.g. a certificate
+	// that isn't permitted for use by mail servers.
+	CertInvalid CertificateFailure = "invalid"
+)
+
+// SelfSignedPolicy is how the Certificate check treats a self-signed
+// certificate that isn't trusted.
+type SelfSignedPolicy string
+
+// Ways of treating self-signed certificates.
+const (
+	// SelfSignedFail fails the Certificate check, as for any untrusted
+	// certificate.
+	SelfSignedFail SelfSignedPolicy = "fail"
+	// SelfSignedWarn reports a Warning instead, e.g. for internal
+	// mailservers whose certificates are pinned by their clients.
+	SelfSignedWarn SelfSignedPolicy = "warn"
+	// SelfSignedIgnore accepts self-signed certificates, only noting them.
+	SelfSignedIgnore SelfSignedPolicy = "ignore"
+)
+
+// isSelfSigned returns true if cert is signed by its own key.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// chainTop follows issuers from the leaf through the certificates presented
+// in state, and returns the last one found.
+func chainTop(state tls.ConnectionState) *x509.Certificate {
+	top := state.PeerCertificates[0]
+	for range state.PeerCertificates {
+		if isSelfSigned(top) {
+			return top
+		}
+		var issuer *x509.Certificate
+		for _, cert := range state.PeerCertificates {
+			if cert != top && bytes.Equal(cert.RawSubject, top.RawIssuer) {
+				issuer = cert
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		top = issuer
+	}
+	return top
+}
+
+// checkCertValidity adds a failure to result for each certificate in state's
+// chain which isn't valid at now.
+func checkCertValidity(result *Result, state tls.ConnectionState, now time.Time) []CertificateFailure {
+	failures := []CertificateFailure{}
+	for _, cert := range state.PeerCertificates {
+		if now.After(cert.NotAfter) {
+			result.Failure("Certificate for %s expired at %s.", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339))
+			failures = appendCertFailure(failures, CertExpired)
+		} else if now.Before(cert.NotBefore) {
+			result.Failure("Certificate for %s isn't valid until %s.", cert.Subject.CommonName, cert.NotBefore.Format(time.RFC3339))
+			failures = appendCertFailure(failures, CertNotYetValid)
+		}
+	}
+	return failures
+}
+
+// minRSAKeyBits is the shortest RSA key that the Certificate check accepts,
+// as the CA/Browser Forum requires of publicly trusted certificates.
+const minRSAKeyBits = 2048
+
+// checkCertAlgorithms adds a failure to result for each certificate in state's
+// chain with a weak key (an RSA key shorter than minRSAKeyBits, or a DSA key),
+// or signed with a weak hash. The signatures of self-issued certificates
+// aren't checked, since a root's own signature isn't relied on.
+func checkCertAlgorithms(result *Result, state tls.ConnectionState) []CertificateFailure {
+	var failures []CertificateFailure
+	for _, cert := range state.PeerCertificates {
+		if key, ok := cert.PublicKey.(*rsa.PublicKey); ok && key.N.BitLen() < minRSAKeyBits {
+			result.Failure("Certificate for %s has a %d-bit RSA key. RSA keys should be at least %d bits.", cert.Subject.CommonName, key.N.BitLen(), minRSAKeyBits)
+			failures = appendCertFailure(failures, CertWeakKey)
+		}
+		if cert.PublicKeyAlgorithm == x509.DSA {
+			result.Failure("Certificate for %s has a DSA key, which is no longer considered secure.", cert.Subject.CommonName)
+			failures = appendCertFailure(failures, CertDSAKey)
+		}
+		switch cert.SignatureAlgorithm {
+		case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+			if !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+				result.Failure("Certificate for %s is signed with %s, which is no longer considered secure.", cert.Subject.CommonName, cert.SignatureAlgorithm)
+				failures = appendCertFailure(failures, CertWeakSignature)
+			}
+		}
+	}
+	return failures
+}
+
+// defaultCertExpiryWarning is how soon before a certificate expires the
+// CertExpiry check warns about it, if the Checker doesn't specify otherwise.
+const defaultCertExpiryWarning = 14 * 24 * time.Hour
+
+// checkCertExpiry checks that none of the certificates in state expire
+// within warning of now, or within failure of now, which fails the check.
+// Certificates that have already expired, or aren't valid yet, are left for
+// the Certificate check to report.
+func checkCertExpiry(state tls.ConnectionState, warning, failure time.Duration, now time.Time) *Result {
+	result := MakeResult(CertExpiry)
+	for _, cert := range state.PeerCertificates {
+		if now.After(cert.NotAfter) || now.Before(cert.NotBefore) {
+			continue
+		}
+		left := cert.NotAfter.Sub(now)
+		if left <= failure {
+			result.Failure("Certificate for %s expires in %s, at %s.", cert.Subject.CommonName, formatDays(left), cert.NotAfter.Format(time.RFC3339))
+		} else if left <= warning {
+			result.Warning("Certificate for %s expires in %s, at %s.", cert.Subject.CommonName, formatDays(left), cert.NotAfter.Format(time.RFC3339))
+		}
+	}
+	return result.Success()
+}
+
+// formatDays formats d as a whole number of days, like "3 days".
+func formatDays(d time.Duration) string {
+	switch days := int(d / (24 * time.Hour)); days {
+	case 0:
+		return "less than a day"
+	case 1:
+		return "1 day"
+	default:
+		return fmt.Sprintf("%d days", days)
+	}
+}
+
+// validityOverlap returns a time at which every certificate in state is valid,
+// if there is one.
+func validityOverlap(state tls.ConnectionState) (time.Time, bool) {
+	notBefore, notAfter := state.PeerCertificates[0].NotBefore, state.PeerCertificates[0].NotAfter
+	for _, cert := range state.PeerCertificates[1:] {
+		if cert.NotBefore.After(notBefore) {
+			notBefore = cert.NotBefore
+		}
+		if cert.NotAfter.Before(notAfter) {
+			notAfter = cert.NotAfter
+		}
+	}
+	return notBefore, !notBefore.After(notAfter)
+}
+
+// rootName identifies a root certificate by its common name, or by its whole
+// subject if it doesn't have one.
+func rootName(root *x509.Certificate) string {
+	if root.Subject.CommonName != "" {
+		return root.Subject.CommonName
+	}
+	return root.Subject.String()
+}
+
+// checkCertChain adds failures to result if state's chain doesn't verify
+// against roots at now. If it does, returns the root the chain ends at.
+// Verification stops at the first expired certificate, so in that case the
+// chain is verified again at a time when the certificates are valid, to
+// find any other problems.
+func checkCertChain(result *Result, state tls.ConnectionState, roots *x509.CertPool, selfSigned SelfSignedPolicy, now time.Time) ([]CertificateFailure, *x509.Certificate) {
+	chains, err := verifyCertChain(state, roots, now)
+	if err == nil {
+		chain := chains[0]
+		return nil, chain[len(chain)-1]
+	}
+	return certChainFailures(result, state, roots, selfSigned, err, now), nil
+}
+
+// certChainFailures classifies err, the error verifying state's chain. A
+// self-signed certificate is treated according to selfSigned, and is only a
+// failure with SelfSignedFail (or an empty policy).
+func certChainFailures(result *Result, state tls.ConnectionState, roots *x509.CertPool, selfSigned SelfSignedPolicy, err error, now time.Time) []CertificateFailure {
+	failures := checkCertValidity(result, state, now)
+	if invalid, ok := err.(x509.CertificateInvalidError); ok && invalid.Reason == x509.Expired {
+		if len(failures) == 0 {
+			// The expired certificate is a trusted one, rather than one the server sent.
+			result.Failure("Certificate is not valid: %v", err)
+			return appendCertFailure(failures, CertInvalid)
+		}
+		at, ok := validityOverlap(state)
+		if !ok {
+			return failures
+		}
+		if _, err = verifyCertChain(state, roots, at); err == nil {
+			return failures
+		}
+	}
+	switch err.(type) {
+	case x509.UnknownAuthorityError, x509.SystemRootsError:
+		if isSelfSigned(state.PeerCertificates[0]) {
+			switch selfSigned {
+			case SelfSignedWarn:
+				result.Warning("Certificate is self-signed.")
+				return failures
+			case SelfSignedIgnore:
+				result.Info("Certificate is self-signed, which is accepted by the self-signed certificate policy.")
+				return failures
+			}
+			result.Failure("Certificate is self-signed.")
+			return appendCertFailure(failures, CertSelfSigned)
+		}
+		if isSelfSigned(chainTop(state)) {
+			result.Failure("Certificate root is not trusted: %v", err)
+			return appendCertFailure(failures, CertUntrustedRoot)
+		}
+		result.Failure("Certificate chain is incomplete. The server may not be sending an intermediate certificate: %v", err)
+		return appendCertFailure(failures, CertIncompleteChain)
+	}
+	if invalid, ok := err.(x509.CertificateInvalidError); ok && invalid.Reason == x509.Expired {
+		// The certificates are each valid at times, but not all at once.
+		return failures
+	}
+	result.Failure("Certificate is not valid: %v", err)
+	return appendCertFailure(failures, CertInvalid)
+}
+
+func appendCertFailure(failures []CertificateFailure, failure CertificateFailure) []CertificateFailure {
+	for _, f := range failures {
+		if f == failure {
+			return failures
+		}
+	}
+	return append(failures, failure)
+}
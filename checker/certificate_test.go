@@ -0,0 +1,329 @@
+package checker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issueTestCert creates a certificate for name, valid from notBefore to
+// notAfter, issued by parent (or self-signed if parent is nil).
+func issueTestCert(t *testing.T, name string, isCA bool, notBefore, notAfter time.Time, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if !isCA {
+		template.DNSNames = []string{name}
+	}
+	issuer, issuerKey := template, key
+	if parent != nil {
+		issuer, issuerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert, key}
+}
+
+func TestCertificateFailures(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	trustedRoot := issueTestCert(t, "Trusted Root", true, past, future, nil)
+	untrustedRoot := issueTestCert(t, "Untrusted Root", true, past, future, nil)
+	intermediate := issueTestCert(t, "Intermediate", true, past, future, trustedRoot)
+	roots := x509.NewCertPool()
+	roots.AddCert(trustedRoot.cert)
+
+	tests := []struct {
+		name     string
+		chain    []*testCert
+		hostname string
+		want     []CertificateFailure
+	}{
+		{"valid", []*testCert{
+			issueTestCert(t, "mx.example.com", false, past, future, trustedRoot),
+		}, "mx.example.com", nil},
+		{"valid with intermediate", []*testCert{
+			issueTestCert(t, "mx.example.com", false, past, future, intermediate), intermediate,
+		}, "mx.example.com", nil},
+		{"hostname mismatch", []*testCert{
+			issueTestCert(t, "mx.example.com", false, past, future, trustedRoot),
+		}, "mx.other.com", []CertificateFailure{CertHostnameMismatch}},
+		{"expired", []*testCert{
+			issueTestCert(t, "mx.example.com", false, past, past.Add(time.Minute), trustedRoot),
+		}, "mx.example.com", []CertificateFailure{CertExpired}},
+		{"not yet valid", []*testCert{
+			issueTestCert(t, "mx.example.com", false, future.Add(-time.Minute), future, trustedRoot),
+		}, "mx.example.com", []CertificateFailure{CertNotYetValid}},
+		{"expired and untrusted", []*testCert{
+			issueTestCert(t, "mx.example.com", false, past, past.Add(time.Minute), untrustedRoot), untrustedRoot,
+		}, "mx.example.com", []CertificateFailure{CertExpired, CertUntrustedRoot}},
+		{"self-signed", []*testCert{
+			issueTestCert(t, "mx.example.com", false, past, future, nil),
+		}, "mx.example.com", []CertificateFailure{CertSelfSigned}},
+		{"incomplete chain", []*testCert{
+			issueTestCert(t, "mx.example.com", false, past, future, intermediate),
+		}, "mx.example.com", []CertificateFailure{CertIncompleteChain}},
+	}
+	for _, test := range tests {
+		state := tls.ConnectionState{}
+		for _, c := range test.chain {
+			state.PeerCertificates = append(state.PeerCertificates, c.cert)
+		}
+		result, verification := checkCertState(state, test.hostname, roots, "", now)
+		if !reflect.DeepEqual(verification.failures, test.want) {
+			t.Errorf("%s: expected failures %v, got %v", test.name, test.want, verification.failures)
+		}
+		if test.want == nil && (verification.root == nil || rootName(verification.root) != "Trusted Root") {
+			t.Errorf("%s: expected chain to end at Trusted Root, got %v", test.name, verification.root)
+		}
+		if expected := len(test.want) > 0; expected != (result.Status == Failure) {
+			t.Errorf("%s: expected failure %t, got status %s: %v", test.name, expected, result.StatusText(), result.Messages)
+		}
+	}
+}
+
+func TestSelfSignedPolicy(t *testing.T) {
+	now := time.Now()
+	selfSigned := issueTestCert(t, "mx.example.com", false, now.Add(-time.Hour), now.Add(time.Hour), nil)
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{selfSigned.cert}}
+	tests := []struct {
+		policy   SelfSignedPolicy
+		hostname string
+		status   Status
+		failures []CertificateFailure
+	}{
+		{"", "mx.example.com", Failure, []CertificateFailure{CertSelfSigned}},
+		{SelfSignedFail, "mx.example.com", Failure, []CertificateFailure{CertSelfSigned}},
+		{SelfSignedWarn, "mx.example.com", Warning, nil},
+		{SelfSignedIgnore, "mx.example.com", Info, nil},
+		// The rest of the certificate is still checked.
+		{SelfSignedIgnore, "mx.other.com", Failure, []CertificateFailure{CertHostnameMismatch}},
+	}
+	for _, test := range tests {
+		result, verification := checkCertState(state, test.hostname, nil, test.policy, now)
+		if result.Status != test.status || !reflect.DeepEqual(verification.failures, test.failures) {
+			t.Errorf("Policy %q for %s: expected status %s and failures %v, got %s and %v: %v", test.policy, test.hostname,
+				statusText[test.status], test.failures, result.StatusText(), verification.failures, result.Messages)
+		}
+	}
+}
+
+func TestLoadRootCAs(t *testing.T) {
+	now := time.Now()
+	root := issueTestCert(t, "Internal Root", true, now.Add(-time.Hour), now.Add(time.Hour), nil)
+	leaf := issueTestCert(t, "mx.example.com", false, now.Add(-time.Hour), now.Add(time.Hour), root)
+	f, err := ioutil.TempFile("", "roots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: root.cert.Raw})
+	f.Close()
+
+	roots, err := LoadRootCAs(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf.cert}}
+	if result, _ := checkCertState(state, "mx.example.com", roots, "", now); result.Status != Success {
+		t.Errorf("Expected certificate issued by loaded root to be valid, got %v", result)
+	}
+	if result, _ := checkCertState(state, "mx.example.com", nil, "", now); result.Status != Failure {
+		t.Errorf("Expected certificate issued by internal root not to be valid by default, got %v", result)
+	}
+	if _, err := LoadRootCAs(os.DevNull); err == nil {
+		t.Error("Expected loading a file without certificates to fail")
+	}
+}
+
+func TestCertificateValidityUsesCheckerClock(t *testing.T) {
+	now := time.Now()
+	root := issueTestCert(t, "Test Root", true, now.Add(-time.Hour), now.Add(48*time.Hour), nil)
+	leaf := issueTestCert(t, "localhost", false, now.Add(-time.Hour), now.Add(time.Hour), root)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	ln := smtpListenAndServe(t, &tls.Config{Certificates: []tls.Certificate{
+		{Certificate: [][]byte{leaf.cert.Raw}, PrivateKey: leaf.key},
+	}})
+	defer ln.Close()
+
+	tomorrow := now.Add(24 * time.Hour)
+	c := Checker{Timeout: testTimeout, RootCAs: roots, Now: func() time.Time { return tomorrow }}
+	result := c.CheckHostnameContext(context.Background(), ln.Addr().String(), 0, "localhost")
+	if !reflect.DeepEqual(result.CertificateFailures, []CertificateFailure{CertExpired}) {
+		t.Errorf("Expected certificate to have expired by tomorrow, got %v", result.CertificateFailures)
+	}
+	if !result.Timestamp.Equal(tomorrow) {
+		t.Errorf("Expected result to be timestamped %v, got %v", tomorrow, result.Timestamp)
+	}
+}
+
+func TestCertificateDetails(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	root := issueTestCert(t, "Test Root", true, now.Add(-time.Hour), now.Add(48*time.Hour), nil)
+	leaf := issueTestCert(t, "localhost", false, now.Add(-time.Hour), now.Add(time.Hour), root)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	ln := smtpListenAndServe(t, &tls.Config{Certificates: []tls.Certificate{
+		{Certificate: [][]byte{leaf.cert.Raw, root.cert.Raw}, PrivateKey: leaf.key},
+	}})
+	defer ln.Close()
+
+	c := Checker{Timeout: testTimeout, RootCAs: roots}
+	result := c.CheckHostnameContext(context.Background(), ln.Addr().String(), 0, "localhost")
+	marshalled, err := json.Marshal(result.Checks[Certificate])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certResult Result
+	if err := json.Unmarshal(marshalled, &certResult); err != nil {
+		t.Fatal(err)
+	}
+	details := certResult.Certificate
+	if details == nil {
+		t.Fatalf("Expected the Certificate check to describe the certificate, got %s", marshalled)
+	}
+	fingerprint := sha256.Sum256(leaf.cert.Raw)
+	if details.Subject != "localhost" || details.Issuer != "Test Root" || details.Root != "Test Root" ||
+		details.ChainLength != 2 || details.SerialNumber != "1" || details.SHA256Fingerprint != hex.EncodeToString(fingerprint[:]) ||
+		!details.NotBefore.Equal(now.Add(-time.Hour)) || !details.NotAfter.Equal(now.Add(time.Hour)) {
+		t.Errorf("Unexpected certificate details %+v", details)
+	}
+}
+
+func TestCertAlgorithms(t *testing.T) {
+	rsaKey := func(bits int) *rsa.PublicKey {
+		return &rsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), uint(bits-1)), E: 65537}
+	}
+	cert := func(key interface{}, algorithm x509.PublicKeyAlgorithm, signature x509.SignatureAlgorithm, selfIssued bool) *x509.Certificate {
+		issuer := []byte("issuer")
+		if selfIssued {
+			issuer = []byte("subject")
+		}
+		return &x509.Certificate{PublicKey: key, PublicKeyAlgorithm: algorithm, SignatureAlgorithm: signature,
+			RawSubject: []byte("subject"), RawIssuer: issuer}
+	}
+	tests := []struct {
+		certs    []*x509.Certificate
+		expected []CertificateFailure
+	}{
+		{[]*x509.Certificate{cert(rsaKey(2048), x509.RSA, x509.SHA256WithRSA, false)}, nil},
+		{[]*x509.Certificate{cert(rsaKey(1024), x509.RSA, x509.SHA256WithRSA, false)}, []CertificateFailure{CertWeakKey}},
+		{[]*x509.Certificate{cert(nil, x509.DSA, x509.DSAWithSHA256, false)}, []CertificateFailure{CertDSAKey}},
+		{[]*x509.Certificate{
+			cert(rsaKey(2048), x509.RSA, x509.SHA1WithRSA, false),
+			cert(rsaKey(1024), x509.RSA, x509.SHA256WithRSA, false),
+		}, []CertificateFailure{CertWeakSignature, CertWeakKey}},
+		// A root's own signature doesn't matter.
+		{[]*x509.Certificate{cert(rsaKey(2048), x509.RSA, x509.SHA1WithRSA, true)}, nil},
+	}
+	for i, test := range tests {
+		result := MakeResult(Certificate)
+		state := tls.ConnectionState{PeerCertificates: test.certs}
+		failures := checkCertAlgorithms(result, state)
+		if !reflect.DeepEqual(failures, test.expected) {
+			t.Errorf("Test %d: expected failures %v, got %v", i, test.expected, failures)
+		}
+		if expected := len(test.expected) > 0; expected != (result.Status == Failure) {
+			t.Errorf("Test %d: expected failure %t, got %v", i, expected, result)
+		}
+	}
+
+	// A certificate with a weak key still fails, although it chains and
+	// matches the hostname.
+	now := time.Now()
+	root := issueTestCert(t, "Trusted Root", true, now.Add(-time.Hour), now.Add(time.Hour), nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		DNSNames:     []string{"mx.example.com"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, root.cert, &key.PublicKey, root.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+	result, verification := checkCertState(state, "mx.example.com", roots, "", now)
+	if result.Status != Failure || !reflect.DeepEqual(verification.failures, []CertificateFailure{CertWeakKey}) {
+		t.Errorf("Expected a weak key failure, got %v: %v", verification.failures, result)
+	}
+}
+
+func TestCheckCertExpiry(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	cert := func(notAfter time.Time) *x509.Certificate {
+		return issueTestCert(t, "localhost", false, now.Add(-day), notAfter, nil).cert
+	}
+	tests := []struct {
+		certs    []*x509.Certificate
+		warning  time.Duration
+		failure  time.Duration
+		expected Status
+	}{
+		{[]*x509.Certificate{cert(now.Add(90 * day))}, defaultCertExpiryWarning, 0, Success},
+		{[]*x509.Certificate{cert(now.Add(90 * day)), cert(now.Add(3 * day))}, defaultCertExpiryWarning, 0, Warning},
+		{[]*x509.Certificate{cert(now.Add(3 * day))}, defaultCertExpiryWarning, 7 * day, Failure},
+		{[]*x509.Certificate{cert(now.Add(20 * day))}, 30 * day, 0, Warning},
+		// Expired certificates are reported by the Certificate check.
+		{[]*x509.Certificate{cert(now.Add(-time.Hour))}, defaultCertExpiryWarning, 0, Success},
+	}
+	for i, test := range tests {
+		state := tls.ConnectionState{PeerCertificates: test.certs}
+		if result := checkCertExpiry(state, test.warning, test.failure, now); result.Status != test.expected {
+			t.Errorf("Test %d: expected %v, got %v", i, test.expected, result)
+		}
+	}
+	if got := formatDays(3*day + time.Hour); got != "3 days" {
+		t.Errorf("Expected 3 days, got %q", got)
+	}
+}
@@ -1,7 +1,13 @@
 package checker
 
 import (
+	"context"
+	"crypto/x509"
+	"fmt"
 	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
 	"time"
 )
 
@@ -9,17 +15,318 @@ import (
 type Checker struct {
 	// Timeout specifies the maximum timeout for network requests made during
 	// checks.
-	// If nil, a default timeout of 10 seconds is used.
+	// If zero, a default timeout of 10 seconds is used (see QuickMode).
 	Timeout time.Duration
 
+	// QuickMode specifies whether checks are limited to whether a domain's
+	// mailservers are reachable and support STARTTLS, e.g. for monitoring
+	// many domains quickly. Only the Connectivity and STARTTLS checks are
+	// performed on each mailserver, and the CAA, MTA-STS and PolicyList checks
+	// (and their lookups) are skipped. If Timeout is zero, a default of 3
+	// seconds is used instead.
+	QuickMode bool
+
+	// HTTPClient specifies the client used to fetch MTA-STS policy files.
+	// Redirects are never followed, regardless of the client's CheckRedirect.
+	// If nil, a client using Proxy (or the environment's proxy settings) is used.
+	HTTPClient *http.Client
+
+	// Proxy specifies the URL of an HTTP(S) proxy for MTA-STS policy fetches.
+	// It is ignored if HTTPClient is set.
+	// If nil, the proxy is read from the environment (see http.ProxyFromEnvironment).
+	Proxy *url.URL
+
+	// SkipMTASTSPolicyFile specifies whether the MTA-STS check skips fetching
+	// the policy file, e.g. where outbound HTTPS is blocked. The TXT record is
+	// still checked, but the policy's mode and MXs are unknown, and the
+	// MTASTSPolicyFile check is left out of the result.
+	SkipMTASTSPolicyFile bool
+
+	// CheckSPF specifies whether CheckDomain also checks the domain's SPF
+	// record, reporting a missing or invalid record, or one that takes more
+	// than 10 DNS lookups to evaluate. It isn't checked in QuickMode.
+	CheckSPF bool
+
+	// CheckDMARC specifies whether CheckDomain also checks the domain's DMARC
+	// record, reporting its policy, alignment modes and report URIs. It isn't
+	// checked in QuickMode.
+	CheckDMARC bool
+
+	// CheckDKIM specifies whether CheckDomain also probes the domain for DKIM
+	// keys at DKIMSelectors, reporting the algorithm and size of those found.
+	// It isn't checked in QuickMode.
+	CheckDKIM bool
+
+	// DKIMSelectors specifies the DKIM selectors probed when CheckDKIM is set,
+	// or by CheckDomainOptions (along with DomainOptions.DKIMSelectors).
+	// If empty, DefaultDKIMSelectors are probed.
+	DKIMSelectors []string
+
+	// MaxPolicyFileSize specifies the most bytes read of an MTA-STS policy
+	// file. Policies are tiny, so the MTASTSPolicyFile check fails if it's
+	// any larger, rather than reading the whole thing.
+	// If zero, a default of 64 KiB is used.
+	MaxPolicyFileSize int64
+
+	// RootCAs specifies the roots that the Certificate check verifies
+	// certificate chains against, e.g. Mozilla's bundle or an internal CA (see
+	// LoadRootCAs).
+	// If nil, the system roots are used.
+	RootCAs *x509.CertPool
+
+	// SelfSignedPolicy specifies how the Certificate check treats self-signed
+	// certificates that don't chain to RootCAs: as a failure, a Warning, or
+	// only noted, e.g. for audits of internal mailservers. The rest of the
+	// certificate is checked as usual.
+	// If empty, SelfSignedFail is used.
+	SelfSignedPolicy SelfSignedPolicy
+
+	// TLSProfile specifies how strictly the Version check treats mailservers
+	// that don't support TLS 1.2 or 1.3, or that accept SSLv3 or weak cipher
+	// suites (see EnumerateCipherSuites), e.g. stricter for a security audit
+	// than for a list of domains that support STARTTLS at all.
+	// If empty, TLSProfileIntermediate is used.
+	TLSProfile TLSProfile
+
+	// StrictMode escalates the warnings of DefaultStrictChecks, as well as
+	// those of StrictChecks, to failures, e.g. for a compliance audit rather
+	// than an informational scan.
+	StrictMode bool
+
+	// StrictChecks names the checks (e.g. Version or MTASTS) whose warnings,
+	// including those of their sub-checks, are escalated to failures.
+	// If empty, and StrictMode isn't set, checks keep their usual severities.
+	StrictChecks []string
+
+	// CRLCache specifies whether the Certificate check also checks each
+	// mailserver's certificate against the CRL at its distribution point,
+	// which is fetched (with Timeout, like MTA-STS policies) and cached in
+	// CRLCache for the rest of the scan.
+	// If nil, CRLs aren't checked, unless CheckOCSP falls back to them.
+	CRLCache *CRLCache
+
+	// CheckOCSP specifies whether the Certificate check also queries the OCSP
+	// responders of each mailserver's certificate and the intermediates
+	// presented with it (with Timeout), failing if any of them is revoked. If
+	// the certificate's own responder can't be queried, its CRL is checked
+	// instead, as with CRLCache (or, if CRLCache is nil, a cache the Checker
+	// creates for itself). This takes an HTTP request per certificate, so bulk
+	// scans may want to leave it unset.
+	CheckOCSP bool
+
+	// CTLogs specifies the Certificate Transparency logs that the Certificate
+	// check verifies each mailserver's signed certificate timestamps (SCTs)
+	// with, e.g. those parsed with ParseCTLogList. A certificate that chains to
+	// the system roots gets a warning if it doesn't have any SCTs from these
+	// logs. SCTs from other logs are noted, but not verified.
+	// If empty, SCTs are only recorded in the certificate's details.
+	CTLogs []CTLog
+
+	// LocalAddr specifies the local address that connections to mailservers
+	// and MTA-STS policy hosts, and DNS queries for CAA, TLSA and CNAME
+	// records, originate from. It should be a *net.TCPAddr (usually with port
+	// 0); its port is ignored for DNS queries. It is ignored for policy fetches
+	// if HTTPClient is set.
+	// If nil, a local address is chosen automatically.
+	LocalAddr net.Addr
+
+	// ConnectionLimiter caps the number of connections to mailservers and
+	// MTA-STS policy hosts (and DNS queries for CAA, TLSA and CNAME records)
+	// that are open at once, across every domain being checked (and every
+	// Checker sharing it). Policy fetches aren't limited if HTTPClient is set.
+	// If nil, the number of open connections isn't limited.
+	ConnectionLimiter *ConnectionLimiter
+
+	// Resolvers specifies the DNS resolvers that MX, CAA, TLSA and CNAME
+	// records are looked up with, in order. If a lookup fails on one of them
+	// (other than with NXDOMAIN), the next is tried. Other lookups use the
+	// system's resolver.
+	// If nil, the system's resolver is used for these lookups too.
+	Resolvers *Resolvers
+
+	// ProxyHeader specifies a PROXY protocol header to send at the start of
+	// each connection to a mailserver, before the SMTP conversation.
+	// If nil, no header is sent.
+	ProxyHeader *ProxyHeader
+
+	// SlowGreeting specifies how long a mailserver can take to send its
+	// greeting before the Connectivity check notes that it's slow, as
+	// tarpitting or greylisting servers are.
+	// If zero, a default of 5 seconds is used.
+	SlowGreeting time.Duration
+
+	// CertExpiryWarning specifies how soon before a certificate presented by
+	// a mailserver (its own, or an intermediate) expires the Certificate check
+	// warns about it, so it can be renewed in time.
+	// If zero, a default of 14 days is used.
+	CertExpiryWarning time.Duration
+
+	// CertExpiryFailure specifies how soon before a certificate expires the
+	// Certificate check fails, as it does once the certificate has expired.
+	// If zero, it only fails once the certificate has expired.
+	CertExpiryFailure time.Duration
+
+	// Port specifies the port mailservers are checked on.
+	// If zero, DefaultSMTPPort (25) is used.
+	Port int
+
+	// PortModes specifies how TLS is negotiated on each port, overriding the
+	// defaults: implicit TLS on 465, and STARTTLS on 25, 587 and any other
+	// port.
+	PortModes map[int]TLSMode
+
+	// ProbeSNI specifies whether the Certificate check also negotiates TLS
+	// with each mailserver without SNI, and with the domain as SNI, to report
+	// servers that present different certificates depending on the SNI. This
+	// takes up to three more connections per mailserver.
+	ProbeSNI bool
+
+	// ProbeTLSRequired specifies whether the STARTTLS check also sends MAIL
+	// FROM before STARTTLS, to find out whether each mailserver requires TLS
+	// (see HostnameResult.TLSRequired). It's off by default, since it sends a
+	// command that isn't part of a normal STARTTLS session, and servers may
+	// respond to it by closing the connection.
+	ProbeTLSRequired bool
+
+	// EnumerateCipherSuites specifies whether the Version check also
+	// enumerates the cipher suites each mailserver accepts, noting RC4, 3DES
+	// and other insecure ones. This takes a connection per cipher suite
+	// accepted, plus one.
+	EnumerateCipherSuites bool
+
+	// CheckResumption specifies whether each mailserver is connected to a
+	// second time, to check whether it lets the TLS session negotiated on the
+	// first connection be resumed (see HostnameResult.SessionResumption).
+	// Whether servers accept TLS 1.3 early data (0-RTT) isn't checked:
+	// crypto/tls neither sends early data over TCP, nor reports whether a
+	// server's session tickets allow it.
+	CheckResumption bool
+
+	// CheckDNSSEC specifies whether CheckDomain records which of the DNS
+	// answers its results depend on were validated with DNSSEC (see
+	// DomainResult.DNSSEC). MX records are then looked up by querying the
+	// nameservers directly, to see whether they validated the answer, and
+	// outside QuickMode, the MTA-STS and TLS-RPT TXT records are queried
+	// again.
+	CheckDNSSEC bool
+
+	// SNIOverride specifies the server name sent when negotiating TLS with
+	// mailservers, in place of the hostname, for troubleshooting servers that
+	// present different certificates depending on the SNI. Certificates are
+	// still checked against the hostname.
+	// If empty, the hostname is sent on implicit TLS ports, and no SNI is sent
+	// with STARTTLS.
+	SNIOverride string
+
+	// DomainBudget specifies the most time CheckDomain spends checking a
+	// single domain, however many mailservers it has. The time left is shared
+	// between the mailservers still to be checked, and the checks that aren't
+	// done in time are marked as timed out.
+	// If zero, only the deadline of the context passed to CheckDomainContext
+	// (if any) applies.
+	DomainBudget time.Duration
+
+	// HostConcurrency specifies the maximum number of hostnames that are
+	// checked concurrently for a single domain.
+	// If zero, a default of 4 is used.
+	HostConcurrency int
+
+	// EHLOName specifies the hostname announced in EHLO when connecting to
+	// mailservers. It should have valid forward and reverse DNS, since some
+	// servers reject clients that don't. Checks fail if it's invalid (see
+	// ValidateEHLOName).
+	// If empty, the HOSTNAME environment variable (or "localhost") is used.
+	EHLOName string
+
+	// Now specifies the current time, as of which certificates are checked,
+	// and which is recorded in results. Timeouts aren't affected. It's called
+	// from several goroutines at once, so it must be safe for concurrent use.
+	// If nil, time.Now is used.
+	Now func() time.Time
+
+	// Logger specifies where the checker logs its progress and errors.
+	// If nil, the standard logger is used. Use NopLogger to silence it.
+	Logger Logger
+
+	// Tracer specifies where the checker records spans of the work it does
+	// for each domain (see Tracer).
+	// If nil, no spans are recorded.
+	Tracer Tracer
+
 	// Cache specifies the hostname scan cache store and expire time.
 	// If `nil`, then scans are not cached.
 	Cache *ScanCache
 
+	// PolicyLists specifies the policy lists consulted by the PolicyList check,
+	// which reports which of them a domain is on.
+	// If empty, CheckDomain doesn't perform the PolicyList check.
+	PolicyLists []PolicyListSource
+
+	// ScoreWeights specifies how the checks are weighted by DomainResult.Score.
+	// If nil, DefaultScoreWeights are used.
+	ScoreWeights ScoreWeights
+
+	// Source labels the results of CheckDomain with where the domains came
+	// from, e.g. TopDomainsSource (see AggregatedScan.OnlySource).
+	Source string
+
+	// DomainCache specifies a cache of DomainResults consulted by CheckDomain.
+	// If nil, domain results are not cached.
+	DomainCache *DomainCache
+
+	// Include specifies the domains that CheckCSV, CheckList, CheckDomains
+	// and RunPool check. Each is a domain, or a pattern like "*.gov" that
+	// matches any subdomain of "gov", and is matched case-insensitively,
+	// ignoring a trailing dot. Other domains are skipped entirely, without a
+	// result.
+	// If empty, every domain is checked (except those excluded).
+	Include []string
+
+	// Exclude specifies domains that CheckCSV, CheckList, CheckDomains and
+	// RunPool skip entirely, without a result, even if they're included.
+	// Patterns are as for Include.
+	Exclude []string
+
+	// Checkpoint specifies where CheckCSV records the domains it completes.
+	// Domains the checkpoint has already completed are skipped.
+	// If nil, every domain is checked.
+	Checkpoint *Checkpoint
+
+	// ownCRLCache holds the *CRLCache used when CheckOCSP falls back to CRLs
+	// without a CRLCache, once it's been created (see crlCache).
+	ownCRLCache atomic.Value
+
 	// lookupMXOverride specifies an alternate function to retrieve hostnames for a given
 	// domain. It is used to mock DNS lookups during testing.
 	lookupMXOverride func(string) ([]*net.MX, error)
 
+	// lookupHostOverride specifies an alternate function to resolve a
+	// hostname's addresses. It is used to mock DNS lookups during testing.
+	lookupHostOverride func(string) ([]string, error)
+
+	// lookupCNAMEOverride specifies an alternate function to retrieve the
+	// chain of aliases a hostname resolves through. It is used to mock DNS
+	// lookups during testing.
+	lookupCNAMEOverride func(string) ([]string, error)
+
+	// lookupTXTOverride specifies an alternate function to retrieve the TXT
+	// records of a name. It is used to mock DNS lookups during testing.
+	lookupTXTOverride func(string) ([]string, error)
+
+	// lookupCAAOverride specifies an alternate function to retrieve the CAA
+	// records relevant to a domain. It is used to mock DNS lookups during testing.
+	lookupCAAOverride func(string) ([]CAARecord, string, error)
+
+	// lookupTLSAOverride specifies an alternate function to retrieve the TLSA
+	// records at a name, and whether they were validated with DNSSEC. It is
+	// used to mock DNS lookups during testing.
+	lookupTLSAOverride func(string) ([]TLSAAssociation, bool, error)
+
+	// lookupDNSSECOverride specifies an alternate function to retrieve the
+	// DNSSEC status of a name's records of a type. It is used to mock DNS
+	// lookups during testing.
+	lookupDNSSECOverride func(string, uint16) DNSSECStatus
+
 	// CheckHostname defines the function that should be used to check each hostname.
 	// If nil, FullCheckHostname (all hostname checks) will be used.
 	CheckHostname func(string, string, time.Duration) HostnameResult
@@ -28,9 +335,234 @@ type Checker struct {
 	checkMTASTSOverride func(string, map[string]HostnameResult) *MTASTSResult
 }
 
+// crlCache returns c.CRLCache, or if it's nil, a cache that c creates the first
+// time it's needed and keeps for the rest of its checks.
+func (c *Checker) crlCache() *CRLCache {
+	if c.CRLCache != nil {
+		return c.CRLCache
+	}
+	if cache, ok := c.ownCRLCache.Load().(*CRLCache); ok {
+		return cache
+	}
+	c.ownCRLCache.CompareAndSwap(nil, MakeCRLCache(0))
+	return c.ownCRLCache.Load().(*CRLCache)
+}
+
+func (c *Checker) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+const quickModeTimeout = 3 * time.Second
+
 func (c *Checker) timeout() time.Duration {
 	if c.Timeout != 0 {
 		return c.Timeout
 	}
+	if c.QuickMode {
+		return quickModeTimeout
+	}
 	return 10 * time.Second
 }
+
+const defaultMaxPolicyFileSize = 64 * 1024
+
+func (c *Checker) maxPolicyFileSize() int64 {
+	if c.MaxPolicyFileSize > 0 {
+		return c.MaxPolicyFileSize
+	}
+	return defaultMaxPolicyFileSize
+}
+
+func (c *Checker) logger() Logger {
+	return loggerOrDefault(c.Logger)
+}
+
+func (c *Checker) tracer() Tracer {
+	return tracerOrDefault(c.Tracer)
+}
+
+// DefaultStrictChecks are the checks whose warnings StrictMode escalates to
+// failures: an outdated TLS version, a self-signed certificate or one that
+// couldn't be checked for revocation, a STARTTLS handshake that failed,
+// cleartext AUTH, and MTA-STS policies in testing mode or served incorrectly.
+var DefaultStrictChecks = []string{Version, Certificate, STARTTLS, Auth, MTASTS}
+
+// strictChecks returns the set of checks whose warnings are escalated to
+// failures.
+func (c *Checker) strictChecks() map[string]bool {
+	strict := make(map[string]bool)
+	if c.StrictMode {
+		for _, name := range DefaultStrictChecks {
+			strict[name] = true
+		}
+	}
+	for _, name := range c.StrictChecks {
+		strict[name] = true
+	}
+	return strict
+}
+
+const defaultHostConcurrency = 4
+
+func (c *Checker) hostConcurrency() int {
+	if c.HostConcurrency > 0 {
+		return c.HostConcurrency
+	}
+	return defaultHostConcurrency
+}
+
+// httpClient returns the client to use for MTA-STS policy fetches.
+func (c *Checker) httpClient() *http.Client {
+	client := http.Client{Timeout: c.timeout()}
+	if c.HTTPClient != nil {
+		client = *c.HTTPClient
+		if client.Timeout == 0 {
+			client.Timeout = c.timeout()
+		}
+	} else if c.Proxy != nil || c.LocalAddr != nil || c.ConnectionLimiter != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if c.Proxy != nil {
+			// Proxied requests are tunneled with CONNECT, so the TLS handshake
+			// (and certificate verification) still targets the policy host.
+			transport.Proxy = http.ProxyURL(c.Proxy)
+		}
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			LocalAddr: c.LocalAddr,
+		}
+		limiter := c.ConnectionLimiter
+		transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return limiter.dialContext(ctx, dialer.DialContext, network, address)
+		}
+		// Each policy host is only contacted once per check.
+		transport.DisableKeepAlives = true
+		client.Transport = transport
+	}
+	// Don't follow redirects.
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &client
+}
+
+// Validate checks that the Checker's configuration is usable, without making
+// any network requests, so that a misconfigured scan can fail at startup.
+// Checks report Validate's error if it fails.
+func (c *Checker) Validate() error {
+	if c.Timeout < 0 {
+		return fmt.Errorf("invalid timeout %v: must not be negative", c.Timeout)
+	}
+	if c.SlowGreeting < 0 {
+		return fmt.Errorf("invalid slow greeting threshold %v: must not be negative", c.SlowGreeting)
+	}
+	if c.CertExpiryWarning < 0 {
+		return fmt.Errorf("invalid certificate expiry warning threshold %v: must not be negative", c.CertExpiryWarning)
+	}
+	if c.CertExpiryFailure < 0 {
+		return fmt.Errorf("invalid certificate expiry failure threshold %v: must not be negative", c.CertExpiryFailure)
+	}
+	warning := c.CertExpiryWarning
+	if warning == 0 {
+		warning = defaultCertExpiryWarning
+	}
+	if c.CertExpiryFailure > warning {
+		return fmt.Errorf("invalid certificate expiry failure threshold %v: must not exceed the warning threshold %v", c.CertExpiryFailure, warning)
+	}
+	if c.DomainBudget < 0 {
+		return fmt.Errorf("invalid domain budget %v: must not be negative", c.DomainBudget)
+	}
+	if c.MaxPolicyFileSize < 0 {
+		return fmt.Errorf("invalid maximum policy file size %d: must not be negative", c.MaxPolicyFileSize)
+	}
+	if c.HostConcurrency < 0 {
+		return fmt.Errorf("invalid host concurrency %d: must not be negative", c.HostConcurrency)
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid port %d", c.Port)
+	}
+	for check, weight := range c.ScoreWeights {
+		if weight < 0 {
+			return fmt.Errorf("invalid score weight %d for %s: must not be negative", weight, check)
+		}
+	}
+	for port, mode := range c.PortModes {
+		if mode != ModeSTARTTLS && mode != ModeImplicitTLS {
+			return fmt.Errorf("invalid TLS mode %q for port %d", mode, port)
+		}
+	}
+	if c.ConnectionLimiter != nil && cap(c.ConnectionLimiter.sem) <= 0 {
+		return fmt.Errorf("invalid connection limit: must allow at least one connection")
+	}
+	if c.Resolvers != nil {
+		if len(c.Resolvers.resolvers) == 0 {
+			return fmt.Errorf("invalid resolvers: must list at least one resolver")
+		}
+		for _, res := range c.Resolvers.resolvers {
+			host, _, err := net.SplitHostPort(res.address)
+			if err != nil || net.ParseIP(host) == nil {
+				return fmt.Errorf("invalid resolver %s: must be an IP address, optionally with a port", res.address)
+			}
+		}
+	}
+	if c.ProxyHeader != nil {
+		if err := c.ProxyHeader.validate(); err != nil {
+			return err
+		}
+	}
+	switch c.SelfSignedPolicy {
+	case "", SelfSignedFail, SelfSignedWarn, SelfSignedIgnore:
+	default:
+		return fmt.Errorf("invalid self-signed certificate policy %q", c.SelfSignedPolicy)
+	}
+	if _, ok := tlsProfiles[c.TLSProfile]; !ok && c.TLSProfile != "" {
+		return fmt.Errorf("invalid TLS profile %q", c.TLSProfile)
+	}
+	for _, pattern := range append(append([]string{}, c.Include...), c.Exclude...) {
+		if err := validateDomainPattern(pattern); err != nil {
+			return fmt.Errorf("invalid domain pattern %q: %v", pattern, err)
+		}
+	}
+	if c.SNIOverride != "" {
+		if err := validateDomainName(c.SNIOverride); err != nil {
+			return fmt.Errorf("invalid SNI override %q: %v", c.SNIOverride, err)
+		}
+	}
+	if c.EHLOName != "" {
+		if err := ValidateEHLOName(c.EHLOName); err != nil {
+			return fmt.Errorf("invalid EHLO name: %v", err)
+		}
+	}
+	if c.Proxy != nil && c.HTTPClient == nil {
+		switch c.Proxy.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("invalid proxy %s: unsupported scheme %q", c.Proxy, c.Proxy.Scheme)
+		}
+		if c.Proxy.Host == "" {
+			return fmt.Errorf("invalid proxy %s: no host", c.Proxy)
+		}
+	}
+	if c.LocalAddr != nil {
+		if _, ok := c.LocalAddr.(*net.TCPAddr); !ok {
+			return fmt.Errorf("invalid local address %v: must be a *net.TCPAddr, got %T", c.LocalAddr, c.LocalAddr)
+		}
+	}
+	names := make(map[string]bool)
+	for _, source := range c.PolicyLists {
+		if source.Name == "" {
+			return fmt.Errorf("invalid policy list: no name")
+		}
+		if source.List == nil {
+			return fmt.Errorf("invalid policy list %s: no list", source.Name)
+		}
+		if names[source.Name] {
+			return fmt.Errorf("invalid policy list %s: duplicate name", source.Name)
+		}
+		names[source.Name] = true
+	}
+	return nil
+}
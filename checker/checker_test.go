@@ -0,0 +1,63 @@
+package checker
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	list := mockDomainSet{}
+	tests := []struct {
+		checker Checker
+		err     string
+	}{
+		{Checker{}, ""},
+		{Checker{
+			Timeout:          time.Second,
+			HostConcurrency:  2,
+			Port:             587,
+			PortModes:        map[int]TLSMode{2465: ModeImplicitTLS},
+			EHLOName:         "mail.example.com",
+			SNIOverride:      "mx.example.com",
+			SelfSignedPolicy: SelfSignedWarn,
+			Proxy:            &url.URL{Scheme: "http", Host: "proxy.example.com:3128"},
+			LocalAddr:        &net.TCPAddr{IP: net.ParseIP("127.0.0.1")},
+			PolicyLists:      []PolicyListSource{{"STARTTLS Everywhere", list}},
+		}, ""},
+		{Checker{Timeout: -time.Second}, "invalid timeout"},
+		{Checker{DomainBudget: -time.Second}, "invalid domain budget"},
+		{Checker{HostConcurrency: -1}, "invalid host concurrency"},
+		{Checker{MaxPolicyFileSize: -1}, "invalid maximum policy file size"},
+		{Checker{Resolvers: MakeResolvers()}, "at least one resolver"},
+		{Checker{Resolvers: MakeResolvers("dns.example.com")}, "invalid resolver"},
+		{Checker{SlowGreeting: -time.Second}, "invalid slow greeting threshold"},
+		{Checker{CertExpiryWarning: -time.Second}, "invalid certificate expiry warning threshold"},
+		{Checker{CertExpiryWarning: 7 * 24 * time.Hour, CertExpiryFailure: 10 * 24 * time.Hour}, "must not exceed the warning threshold"},
+		{Checker{CertExpiryFailure: 30 * 24 * time.Hour}, "must not exceed the warning threshold"},
+		{Checker{Port: 70000}, "invalid port"},
+		{Checker{PortModes: map[int]TLSMode{2525: "tls"}}, "invalid TLS mode"},
+		{Checker{ConnectionLimiter: MakeConnectionLimiter(0)}, "invalid connection limit"},
+		{Checker{EHLOName: "not a hostname"}, "invalid EHLO name"},
+		{Checker{SNIOverride: "mx..example.com"}, "invalid SNI override"},
+		{Checker{SelfSignedPolicy: "allow"}, "invalid self-signed certificate policy"},
+		{Checker{TLSProfile: "old"}, "invalid TLS profile"},
+		{Checker{Include: []string{"*.gov", "example.com"}, Exclude: []string{"mail.*.gov"}}, "invalid domain pattern"},
+		{Checker{Proxy: &url.URL{Scheme: "ftp", Host: "proxy.example.com"}}, "unsupported scheme"},
+		{Checker{Proxy: &url.URL{Scheme: "http"}}, "no host"},
+		{Checker{LocalAddr: &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}}, "invalid local address"},
+		{Checker{PolicyLists: []PolicyListSource{{"", list}}}, "no name"},
+		{Checker{PolicyLists: []PolicyListSource{{"a", nil}}}, "no list"},
+		{Checker{PolicyLists: []PolicyListSource{{"a", list}, {"a", list}}}, "duplicate name"},
+	}
+	for _, test := range tests {
+		err := test.checker.Validate()
+		if test.err == "" && err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", test.checker, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("Expected %+v to be invalid with %q, got %v", test.checker, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package checker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Checkpoint records the domains a scan has completed, so that CheckCSV can
+// resume an interrupted scan without checking them again.
+//
+// Completed domains are appended to a writer (usually a file opened for
+// appending), one per line, as soon as the ResultHandler has handled their
+// results. Since results are handled in whatever order checks finish, the
+// checkpoint is the set of completed domains rather than a position in the
+// input.
+//
+// Resuming is at-least-once: a domain whose result was handled, but which
+// wasn't recorded before the scan was interrupted (including any lines still
+// buffered by the writer), is checked and handled again. A domain that was
+// recorded is never handled again.
+//
+// It is safe for concurrent use.
+type Checkpoint struct {
+	mu        sync.Mutex
+	w         io.Writer
+	completed map[string]bool
+}
+
+// MakeCheckpoint creates a Checkpoint which records completed domains to w.
+// The completed domains previously recorded by a checkpoint are read from r,
+// if it isn't nil.
+func MakeCheckpoint(r io.Reader, w io.Writer) (*Checkpoint, error) {
+	c := Checkpoint{w: w, completed: make(map[string]bool)}
+	if r == nil {
+		return &c, nil
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// A line may be incomplete if the scan was interrupted while writing it,
+		// in which case its domain is checked again.
+		if domain := strings.TrimSpace(scanner.Text()); domain != "" {
+			c.completed[checkpointKey(domain)] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading checkpoint: %v", err)
+	}
+	return &c, nil
+}
+
+func checkpointKey(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// Completed returns true if domain has been recorded as completed.
+func (c *Checkpoint) Completed(domain string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.completed[checkpointKey(domain)]
+}
+
+// Len returns the number of completed domains.
+func (c *Checkpoint) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.completed)
+}
+
+// record marks domain as completed, and writes it to the checkpoint's writer.
+func (c *Checkpoint) record(domain string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := checkpointKey(domain)
+	if c.completed[key] {
+		return nil
+	}
+	c.completed[key] = true
+	if c.w == nil {
+		return nil
+	}
+	_, err := fmt.Fprintln(c.w, key)
+	return err
+}
@@ -0,0 +1,59 @@
+package checker
+
+import (
+	"bytes"
+	"encoding/csv"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMakeCheckpoint(t *testing.T) {
+	checkpoint, err := MakeCheckpoint(strings.NewReader("domain\nDomain.TLD.\n\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.Len() != 2 {
+		t.Errorf("Expected 2 completed domains, got %d", checkpoint.Len())
+	}
+	for _, domain := range []string{"domain", "domain.tld"} {
+		if !checkpoint.Completed(domain) {
+			t.Errorf("Expected %s to be completed", domain)
+		}
+	}
+	if checkpoint.Completed("nostarttls") {
+		t.Error("Expected nostarttls not to be completed")
+	}
+}
+
+func TestCheckCSVResumesFromCheckpoint(t *testing.T) {
+	in := "empty\ndomain\ndomain.tld\nnoconnection\nnostarttls\n"
+	var recorded bytes.Buffer
+	checkpoint, err := MakeCheckpoint(strings.NewReader("domain\nnoconnection\n"), &recorded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Checker{
+		Checkpoint:           checkpoint,
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	totals := AggregatedScan{Logger: NopLogger}
+	c.CheckCSV(csv.NewReader(strings.NewReader(in)), &totals, 0)
+
+	if totals.Attempted != 3 {
+		t.Errorf("Expected completed domains to be skipped, got %d attempted", totals.Attempted)
+	}
+	lines := strings.Split(strings.TrimSpace(recorded.String()), "\n")
+	sort.Strings(lines)
+	expected := "domain.tld,empty,nostarttls"
+	if strings.Join(lines, ",") != expected {
+		t.Errorf("Expected %s to be recorded, got %v", expected, lines)
+	}
+	if checkpoint.Len() != 5 {
+		t.Errorf("Expected 5 completed domains, got %d", checkpoint.Len())
+	}
+}
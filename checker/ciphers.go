@@ -0,0 +1,104 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+)
+
+// CipherSuite is a cipher suite that a mailserver accepted.
+type CipherSuite struct {
+	// Name of the cipher suite, like "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256".
+	Name string `json:"name"`
+	ID   uint16 `json:"id"`
+	// Insecure is whether the cipher suite has known security issues.
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+func makeCipherSuite(id uint16) CipherSuite {
+	suite := CipherSuite{Name: tls.CipherSuiteName(id), ID: id}
+	for _, insecure := range tls.InsecureCipherSuites() {
+		if insecure.ID == id {
+			suite.Insecure = true
+		}
+	}
+	return suite
+}
+
+// enumerableCipherSuites returns the IDs of the cipher suites that can be
+// offered individually: those supported by crypto/tls before TLS 1.3, whose
+// cipher suites can't be configured.
+func enumerableCipherSuites() []uint16 {
+	ids := []uint16{}
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		for _, version := range suite.SupportedVersions {
+			if version <= tls.VersionTLS12 {
+				ids = append(ids, suite.ID)
+				break
+			}
+		}
+	}
+	return ids
+}
+
+// enumerateCipherSuites negotiates TLS with hostname repeatedly, offering the
+// cipher suites it hasn't accepted yet each time, until it doesn't accept
+// any, and returns the cipher suites it accepted in the order it chose them.
+// If state was negotiated with TLS 1.3, its cipher suite comes first.
+// Export-grade cipher suites aren't supported by crypto/tls, so they can't be
+// offered.
+func enumerateCipherSuites(ctx context.Context, hostname string, dialer smtpDialer, state tls.ConnectionState) []CipherSuite {
+	accepted := []CipherSuite{}
+	if state.Version == tls.VersionTLS13 {
+		accepted = append(accepted, makeCipherSuite(state.CipherSuite))
+	}
+	remaining := enumerableCipherSuites()
+	for len(remaining) > 0 && !expired(ctx) {
+		config := tlsConfigForCipher(remaining)
+		config.MinVersion = tls.VersionTLS10
+		config.MaxVersion = tls.VersionTLS12
+		state, ok, err := dialer.tlsConnectionState(ctx, hostname, &config)
+		if err != nil || !ok {
+			break
+		}
+		chosen := -1
+		for i, id := range remaining {
+			if id == state.CipherSuite {
+				chosen = i
+			}
+		}
+		if chosen < 0 {
+			break
+		}
+		accepted = append(accepted, makeCipherSuite(state.CipherSuite))
+		remaining = append(remaining[:chosen], remaining[chosen+1:]...)
+	}
+	return accepted
+}
+
+// checkCipherSuites adds to result (the Version check) the problems with
+// the cipher suites accepted, with the statuses given by rules: RC4 is
+// prohibited (RFC 7465), and 3DES and other insecure cipher suites should be
+// disabled.
+func checkCipherSuites(suites []CipherSuite, rules tlsProfileRules, result *Result) {
+	var rc4, tripleDES, insecure []string
+	for _, suite := range suites {
+		switch {
+		case strings.Contains(suite.Name, "_RC4_"):
+			rc4 = append(rc4, suite.Name)
+		case strings.Contains(suite.Name, "_3DES_"):
+			tripleDES = append(tripleDES, suite.Name)
+		case suite.Insecure:
+			insecure = append(insecure, suite.Name)
+		}
+	}
+	if len(rc4) > 0 {
+		result.report(rules.rc4, "Server should NOT accept RC4 cipher suites, but accepts %s.", strings.Join(rc4, ", "))
+	}
+	if len(tripleDES) > 0 {
+		result.report(rules.tripleDES, "Server should NOT accept 3DES cipher suites, but accepts %s.", strings.Join(tripleDES, ", "))
+	}
+	if len(insecure) > 0 {
+		result.report(rules.insecure, "Server accepts cipher suites with known weaknesses: %s.", strings.Join(insecure, ", "))
+	}
+}
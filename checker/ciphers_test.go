@@ -0,0 +1,76 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnumerateCipherSuites(t *testing.T) {
+	now := time.Now()
+	leaf := issueTestCert(t, "localhost", false, now.Add(-time.Hour), now.Add(time.Hour), nil)
+	ln := smtpListenAndServe(t, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.cert.Raw}, PrivateKey: leaf.key}},
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
+		},
+	})
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	hostname := net.JoinHostPort("localhost", port)
+
+	dialer := smtpDialer{timeout: testTimeout, enumerateCiphers: true}
+	result := fullCheckHostname(context.Background(), "", hostname, dialer, nil)
+	var accepted []string
+	for _, suite := range result.CipherSuites {
+		accepted = append(accepted, suite.Name)
+		if suite.Insecure == (suite.ID == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256) {
+			t.Errorf("Expected only %s to be secure, got %+v", tls.CipherSuiteName(tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256), suite)
+		}
+	}
+	sort.Strings(accepted)
+	expected := "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_ECDSA_WITH_RC4_128_SHA"
+	if got := strings.Join(accepted, ","); got != expected {
+		t.Errorf("Expected cipher suites %s, got %s", expected, got)
+	}
+	versionResult := result.Checks[Version]
+	if versionResult.Status != Failure {
+		t.Errorf("Expected RC4 to fail the Version check, got %v", versionResult)
+	}
+
+	dialer.enumerateCiphers = false
+	result = fullCheckHostname(context.Background(), "", hostname, dialer, nil)
+	if result.CipherSuites != nil {
+		t.Errorf("Expected cipher suites not to be enumerated by default, got %v", result.CipherSuites)
+	}
+}
+
+func TestCheckCipherSuites(t *testing.T) {
+	tests := []struct {
+		suites []uint16
+		status Status
+	}{
+		{[]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, Success},
+		{[]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256}, Info},
+		{[]uint16{tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA}, Warning},
+		{[]uint16{tls.TLS_RSA_WITH_RC4_128_SHA, tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA}, Failure},
+	}
+	for _, test := range tests {
+		var suites []CipherSuite
+		for _, id := range test.suites {
+			suites = append(suites, makeCipherSuite(id))
+		}
+		result := MakeResult(Version)
+		checkCipherSuites(suites, TLSProfileIntermediate.rules(), result)
+		if result.Status != test.status {
+			t.Errorf("checkCipherSuites(%v) = %v, expected %v", suites, result, test.status)
+		}
+	}
+}
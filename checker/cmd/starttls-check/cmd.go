@@ -85,13 +85,12 @@ func main() {
 	if *aggregate {
 		c = checker.Checker{
 			CheckHostname: checker.NoopCheckHostname,
+			Now:           time.Now,
 		}
-		resultHandler = &checker.AggregatedScan{
-			Time:   time.Now(),
-			Source: label,
-		}
+		resultHandler = checker.MakeAggregatedScan(label, c.Now())
 	}
-	c.CheckCSV(domainReader, resultHandler, *column)
+	summary := c.CheckCSV(domainReader, resultHandler, *column)
+	log.Printf("Scan of %s %s", label, summary)
 	json.NewEncoder(out).Encode(resultHandler)
 }
 
@@ -35,6 +35,7 @@ func TestUpdateStats(t *testing.T) {
 		Time:      time.Time{},
 		Source:    ts.URL,
 		Attempted: 3,
+		NoMXCount: 3,
 	})
 	if err != nil {
 		t.Fatal(err)
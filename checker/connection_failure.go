@@ -0,0 +1,59 @@
+package checker
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// ConnectionFailure is a machine-readable reason for failing to connect to a
+// mailserver.
+type ConnectionFailure string
+
+// Reasons for failing to connect to a mailserver.
+const (
+	// ConnRefused means the server actively refused the connection, usually
+	// because nothing is listening on the port.
+	ConnRefused ConnectionFailure = "refused"
+	// ConnTimeout means the connection attempt timed out, usually because a
+	// firewall is dropping packets.
+	ConnTimeout ConnectionFailure = "timeout"
+	// ConnUnreachable means there's no route to the server.
+	ConnUnreachable ConnectionFailure = "unreachable"
+	// ConnOther is any other reason, e.g. the server closing the connection
+	// without a valid greeting.
+	ConnOther ConnectionFailure = "other"
+)
+
+// classifyDialError returns the reason that a dial failed with err.
+func classifyDialError(err error) ConnectionFailure {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return ConnOther
+	}
+	if opErr.Timeout() {
+		return ConnTimeout
+	}
+	var syscallErr *os.SyscallError
+	if !errors.As(opErr.Err, &syscallErr) {
+		return ConnOther
+	}
+	switch syscallErr.Err {
+	case syscall.ECONNREFUSED:
+		return ConnRefused
+	case syscall.ETIMEDOUT:
+		return ConnTimeout
+	case syscall.EHOSTUNREACH, syscall.ENETUNREACH:
+		return ConnUnreachable
+	}
+	return ConnOther
+}
+
+// connectionFailureHints explain what each reason for failing to connect
+// usually means.
+var connectionFailureHints = map[ConnectionFailure]string{
+	ConnRefused:     "The connection was refused, so the mailserver may not be running, or not listening on this port.",
+	ConnTimeout:     "The connection timed out, so a firewall may be blocking connections from the scanner.",
+	ConnUnreachable: "There's no network route to the mailserver.",
+}
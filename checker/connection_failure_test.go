@@ -0,0 +1,58 @@
+package checker
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyDialError(t *testing.T) {
+	dialError := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: err}
+	}
+	tests := []struct {
+		err  error
+		want ConnectionFailure
+	}{
+		{dialError(os.NewSyscallError("connect", syscall.ECONNREFUSED)), ConnRefused},
+		{dialError(timeoutError{}), ConnTimeout},
+		{dialError(os.NewSyscallError("connect", syscall.ETIMEDOUT)), ConnTimeout},
+		{dialError(os.NewSyscallError("connect", syscall.EHOSTUNREACH)), ConnUnreachable},
+		{dialError(os.NewSyscallError("connect", syscall.ENETUNREACH)), ConnUnreachable},
+		{dialError(os.NewSyscallError("connect", syscall.EACCES)), ConnOther},
+		{errors.New("connected, but no valid greeting"), ConnOther},
+	}
+	for _, test := range tests {
+		if got := classifyDialError(test.err); got != test.want {
+			t.Errorf("classifyDialError(%v) = %s, want %s", test.err, got, test.want)
+		}
+	}
+}
+
+func TestConnectionRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing listens on the port once the listener is closed.
+	addr := ln.Addr().String()
+	ln.Close()
+
+	result := FullCheckHostname("", addr, testTimeout)
+	if result.ConnectionFailure != ConnRefused {
+		t.Errorf("Expected connection to be refused, got %q", result.ConnectionFailure)
+	}
+	messages := strings.Join(result.Checks[Connectivity].Messages, " ")
+	if !strings.Contains(messages, "may not be running") {
+		t.Errorf("Expected Connectivity check to explain refused connection, got %q", messages)
+	}
+}
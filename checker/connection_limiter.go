@@ -0,0 +1,92 @@
+package checker
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// ConnectionLimiter caps the number of connections that Checkers sharing it
+// have open at once. Dials wait for a connection to close when the limit is
+// reached, rather than failing.
+// It is safe for concurrent use.
+type ConnectionLimiter struct {
+	inFlight int64 // First, so that it's aligned for atomic operations.
+	sem      chan struct{}
+}
+
+// MakeConnectionLimiter creates a limiter allowing up to max open connections.
+// max must be positive.
+func MakeConnectionLimiter(max int) *ConnectionLimiter {
+	return &ConnectionLimiter{sem: make(chan struct{}, max)}
+}
+
+// InFlight returns the number of connections that are currently open.
+func (l *ConnectionLimiter) InFlight() int {
+	return int(atomic.LoadInt64(&l.inFlight))
+}
+
+func (l *ConnectionLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.sem <- struct{}{}:
+		atomic.AddInt64(&l.inFlight, 1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *ConnectionLimiter) release() {
+	atomic.AddInt64(&l.inFlight, -1)
+	<-l.sem
+}
+
+// dialContext dials with dial once a connection is available. The returned
+// connection makes the slot available again when it is closed.
+// A nil limiter doesn't limit connections.
+func (l *ConnectionLimiter) dialContext(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), network, address string) (net.Conn, error) {
+	if l == nil {
+		return dial(ctx, network, address)
+	}
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	conn, err := dial(ctx, network, address)
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	limited := &limitedConn{Conn: conn, limiter: l}
+	if _, ok := conn.(net.PacketConn); ok {
+		return limitedPacketConn{limited}, nil
+	}
+	return limited, nil
+}
+
+// limitedConn releases its limiter's slot the first time it is closed.
+type limitedConn struct {
+	net.Conn
+	limiter *ConnectionLimiter
+	once    sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.limiter.release)
+	return err
+}
+
+// limitedPacketConn is a limitedConn that is still a net.PacketConn, which
+// net.Resolver relies on to tell UDP connections from TCP ones.
+type limitedPacketConn struct {
+	*limitedConn
+}
+
+func (c limitedPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	return c.Conn.(net.PacketConn).ReadFrom(p)
+}
+
+func (c limitedPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return c.Conn.(net.PacketConn).WriteTo(p, addr)
+}
@@ -0,0 +1,82 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnectionLimiterQueuesDials(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	limiter := MakeConnectionLimiter(1)
+	var dialer net.Dialer
+	first, err := limiter.dialContext(context.Background(), dialer.DialContext, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limiter.InFlight() != 1 {
+		t.Errorf("Expected 1 connection in flight, got %d", limiter.InFlight())
+	}
+
+	dialed := make(chan net.Conn)
+	go func() {
+		conn, err := limiter.dialContext(context.Background(), dialer.DialContext, "tcp", ln.Addr().String())
+		if err != nil {
+			t.Error(err)
+		}
+		dialed <- conn
+	}()
+	select {
+	case <-dialed:
+		t.Fatal("Expected second dial to wait for the first connection to close")
+	case <-time.After(50 * time.Millisecond):
+	}
+	first.Close()
+	first.Close()
+	second := <-dialed
+	if limiter.InFlight() != 1 {
+		t.Errorf("Expected closing a connection twice to release it once, got %d in flight", limiter.InFlight())
+	}
+	second.Close()
+	if limiter.InFlight() != 0 {
+		t.Errorf("Expected no connections in flight, got %d", limiter.InFlight())
+	}
+
+	// Dials waiting for a connection give up when their context expires.
+	limiter.dialContext(context.Background(), dialer.DialContext, "tcp", ln.Addr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.dialContext(ctx, dialer.DialContext, "tcp", ln.Addr().String()); err == nil {
+		t.Error("Expected dial to fail when its context expires")
+	}
+}
+
+func TestHostnameCheckReleasesConnections(t *testing.T) {
+	ln := smtpListenAndServe(t, &tls.Config{})
+	defer ln.Close()
+
+	c := Checker{Timeout: testTimeout, ConnectionLimiter: MakeConnectionLimiter(1)}
+	result := c.checkHostname(context.Background(), "", ln.Addr().String())
+	if !result.couldConnect() {
+		t.Fatalf("Expected to connect, got %v", result)
+	}
+	if inFlight := c.ConnectionLimiter.InFlight(); inFlight != 0 {
+		t.Errorf("Expected connections to be released after the check, got %d in flight", inFlight)
+	}
+}
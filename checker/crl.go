@@ -0,0 +1,173 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CRLCache fetches the certificate revocation lists that mailservers'
+// certificates are checked against (see Checker.CRLCache), and keeps them for
+// the rest of a scan, so that each distribution point is only fetched once.
+// Failed fetches aren't kept.
+// It is safe for concurrent use, and can be shared by several Checkers.
+type CRLCache struct {
+	maxSize int64
+	mu      sync.Mutex
+	entries map[string]*crlEntry
+}
+
+// crlEntry is a CRL that has been fetched, or is being fetched.
+type crlEntry struct {
+	// done is closed once the fetch completes.
+	done chan struct{}
+	crl  *x509.RevocationList
+	err  error
+}
+
+// defaultMaxCRLSize is the default maximum size of a CRL.
+const defaultMaxCRLSize = 10 * 1024 * 1024
+
+// MakeCRLCache creates an empty CRLCache, which reads at most maxSize bytes of
+// each CRL. If maxSize is zero, a default of 10 MiB is used.
+func MakeCRLCache(maxSize int64) *CRLCache {
+	if maxSize <= 0 {
+		maxSize = defaultMaxCRLSize
+	}
+	return &CRLCache{maxSize: maxSize, entries: make(map[string]*crlEntry)}
+}
+
+// get returns the CRL at url, fetching it with client if it isn't cached.
+// If another fetch of url is in progress, waits for it until ctx expires.
+func (c *CRLCache) get(ctx context.Context, url string, client *http.Client) (*x509.RevocationList, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[url]
+	if !ok {
+		entry = &crlEntry{done: make(chan struct{})}
+		c.entries[url] = entry
+	}
+	c.mu.Unlock()
+	if ok {
+		select {
+		case <-entry.done:
+			return entry.crl, entry.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	entry.crl, entry.err = fetchCRL(ctx, url, client, c.maxSize)
+	if entry.err != nil {
+		c.mu.Lock()
+		delete(c.entries, url)
+		c.mu.Unlock()
+	}
+	close(entry.done)
+	return entry.crl, entry.err
+}
+
+// fetchCRL fetches and parses the CRL at url, reading at most maxSize bytes.
+func fetchCRL(ctx context.Context, url string, client *http.Client, maxSize int64) (*x509.RevocationList, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	// Read one byte more than allowed, to tell if the CRL is too large.
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxSize {
+		return nil, fmt.Errorf("CRL at %s is larger than %d bytes", url, maxSize)
+	}
+	return x509.ParseRevocationList(body)
+}
+
+// checkCRL checks the leaf certificate in chain against the CRL at the first
+// of its HTTP distribution points, fetched with client through cache. The CRL's
+// signature is verified if its issuer is in chain.
+func checkCRL(ctx context.Context, chain []*x509.Certificate, cache *CRLCache, client *http.Client, now time.Time) *Result {
+	result := MakeResult(CRL)
+	leaf := chain[0]
+	if len(leaf.CRLDistributionPoints) == 0 {
+		return result.Info("Certificate doesn't list a CRL distribution point, so its revocation wasn't checked with a CRL.")
+	}
+	var url string
+	for _, point := range leaf.CRLDistributionPoints {
+		if strings.HasPrefix(point, "http://") || strings.HasPrefix(point, "https://") {
+			url = point
+			break
+		}
+	}
+	if url == "" {
+		return result.Info("Certificate doesn't list an HTTP CRL distribution point, so its revocation wasn't checked with a CRL.")
+	}
+	crl, err := cache.get(ctx, url, client)
+	if err != nil {
+		return result.Warning("Couldn't fetch the CRL from %s: %v.", url, err)
+	}
+	var issuer *x509.Certificate
+	for _, cert := range chain[1:] {
+		if bytes.Equal(cert.RawSubject, leaf.RawIssuer) {
+			issuer = cert
+			break
+		}
+	}
+	if issuer == nil {
+		result.Info("The certificate's issuer wasn't presented, so the CRL's signature couldn't be verified.")
+	} else if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return result.Warning("The CRL from %s isn't signed by the certificate's issuer: %v.", url, err)
+	}
+	if !crl.NextUpdate.IsZero() && now.After(crl.NextUpdate) {
+		result.Warning("The CRL from %s is out of date: it should have been updated by %s.", url, crl.NextUpdate.Format(time.RFC3339))
+	}
+	for _, revoked := range crl.RevokedCertificateEntries {
+		if revoked.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+			return result.Failure("Certificate was revoked at %s, according to the CRL from %s.",
+				revoked.RevocationTime.Format(time.RFC3339), url)
+		}
+	}
+	return result.Success()
+}
+
+// checkRevocation adds the OCSP check to h's Certificate check, if
+// c.CheckOCSP is set, and the CRL check, if c.CRLCache is set or the OCSP
+// check couldn't find the status of h's certificate. h's certificate chain must
+// have been kept.
+func (c *Checker) checkRevocation(ctx context.Context, h *HostnameResult) {
+	if (c.CRLCache == nil && !c.CheckOCSP) || h.tlsState == nil || len(h.tlsState.PeerCertificates) == 0 || expired(ctx) {
+		return
+	}
+	certResult, ok := h.Checks[Certificate]
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	chain := h.tlsState.PeerCertificates
+	client := c.httpClient()
+	if c.CheckOCSP {
+		ocspResult, leafChecked := checkOCSP(ctx, chain, client, c.now())
+		certResult.addCheck(ocspResult)
+		if leafChecked {
+			h.addCheck(certResult)
+			return
+		}
+	}
+	certResult.addCheck(checkCRL(ctx, chain, c.crlCache(), client, c.now()))
+	h.addCheck(certResult)
+}
@@ -0,0 +1,127 @@
+package checker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// issueCRLTestChain creates a CA, which publishes a CRL revoking serial 2, and
+// leaf certificates with serials 1 and 2 that list crlURL as their
+// distribution point. Returns the CA, the leaves, and the CRL.
+func issueCRLTestChain(t *testing.T, crlURL string) (*testCert, []*x509.Certificate, []byte) {
+	now := time.Now()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(100),
+		Subject:               pkix.Name{CommonName: "CRL Test Root"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &testCert{caCert, key}
+
+	var leaves []*x509.Certificate
+	for serial := int64(1); serial <= 2; serial++ {
+		leaf := issueTestCert(t, "mx.example.com", false, now.Add(-time.Hour), now.Add(time.Hour), ca)
+		template := *leaf.cert
+		template.SerialNumber = big.NewInt(serial)
+		template.CRLDistributionPoints = []string{crlURL}
+		der, err := x509.CreateCertificate(rand.Reader, &template, ca.cert, &leaf.key.PublicKey, ca.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaves = append(leaves, cert)
+	}
+
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: now.Add(-time.Hour),
+		NextUpdate: now.Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: big.NewInt(2), RevocationTime: now.Add(-time.Minute)},
+		},
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, leaves, crl
+}
+
+func TestCheckCRL(t *testing.T) {
+	var fetches int32
+	var crl []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ca.crl" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&fetches, 1)
+		w.Write(crl)
+	}))
+	defer server.Close()
+	ca, leaves, crl := issueCRLTestChain(t, server.URL+"/ca.crl")
+	_, missing, _ := issueCRLTestChain(t, server.URL+"/missing.crl")
+	noDP := issueTestCert(t, "mx.example.com", false, time.Now().Add(-time.Hour), time.Now().Add(time.Hour), ca)
+
+	cache := MakeCRLCache(0)
+	tests := []struct {
+		name   string
+		chain  []*x509.Certificate
+		status Status
+	}{
+		{"valid", []*x509.Certificate{leaves[0], ca.cert}, Success},
+		{"revoked", []*x509.Certificate{leaves[1], ca.cert}, Failure},
+		{"revoked without issuer", []*x509.Certificate{leaves[1]}, Failure},
+		{"no distribution point", []*x509.Certificate{noDP.cert, ca.cert}, Info},
+		{"CRL not found", []*x509.Certificate{missing[0]}, Warning},
+	}
+	for _, test := range tests {
+		result := checkCRL(context.Background(), test.chain, cache, server.Client(), time.Now())
+		if result.Status != test.status {
+			t.Errorf("%s: expected status %s, got %s: %v", test.name, statusText[test.status], result.StatusText(), result.Messages)
+		}
+	}
+	if fetches := atomic.LoadInt32(&fetches); fetches != 1 {
+		t.Errorf("Expected the CRL to be fetched once, got %d fetches", fetches)
+	}
+
+	// A CRL larger than the limit isn't read.
+	result := checkCRL(context.Background(), []*x509.Certificate{leaves[0]}, MakeCRLCache(10), server.Client(), time.Now())
+	if result.Status != Warning {
+		t.Errorf("Expected a CRL over the size limit not to be read, got %v", result)
+	}
+	// The CRL must be signed by the certificate's issuer, here a different CA
+	// with the same name.
+	other, _, _ := issueCRLTestChain(t, server.URL+"/ca.crl")
+	result = checkCRL(context.Background(), []*x509.Certificate{leaves[0], other.cert}, MakeCRLCache(0), server.Client(), time.Now())
+	if result.Status != Warning {
+		t.Errorf("Expected a CRL that isn't signed by the issuer to be rejected, got %v", result)
+	}
+}
@@ -0,0 +1,362 @@
+package checker
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// CTLog is a Certificate Transparency (RFC 6962) log whose signed certificate
+// timestamps the CertTransparency check can verify.
+type CTLog struct {
+	// Description names the log, e.g. "Google 'Argon2024' log".
+	Description string
+	// Key is the log's DER-encoded public key (a SubjectPublicKeyInfo).
+	Key []byte
+}
+
+// ID returns the log's ID: the SHA-256 digest of its key.
+func (l CTLog) ID() [sha256.Size]byte {
+	return sha256.Sum256(l.Key)
+}
+
+// ParseCTLogList parses a list of CT logs in the JSON format published by
+// browsers, e.g. https://www.gstatic.com/ct/log_list/v3/log_list.json.
+func ParseCTLogList(data []byte) ([]CTLog, error) {
+	var list struct {
+		Operators []struct {
+			Logs []struct {
+				Description string `json:"description"`
+				Key         string `json:"key"`
+			} `json:"logs"`
+		} `json:"operators"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("couldn't parse CT log list: %v", err)
+	}
+	logs := []CTLog{}
+	for _, operator := range list.Operators {
+		for _, log := range operator.Logs {
+			key, err := base64.StdEncoding.DecodeString(log.Key)
+			if err != nil {
+				return nil, fmt.Errorf("key of CT log %q isn't valid base64: %v", log.Description, err)
+			}
+			logs = append(logs, CTLog{Description: log.Description, Key: key})
+		}
+	}
+	return logs, nil
+}
+
+// Where a mailserver's signed certificate timestamps came from.
+const (
+	// SCTEmbedded SCTs are embedded in the certificate by its issuer.
+	SCTEmbedded = "embedded"
+	// SCTTLSExtension SCTs are sent by the server in the TLS handshake.
+	SCTTLSExtension = "tls_extension"
+)
+
+// SCT describes a signed certificate timestamp: a CT log's promise to log a
+// certificate.
+type SCT struct {
+	// LogID is the ID of the log that signed the timestamp, in base64.
+	LogID string `json:"log_id"`
+	// Log is the description of the log, if it's one of Checker.CTLogs.
+	Log string `json:"log,omitempty"`
+	// Timestamp is when the log saw the certificate.
+	Timestamp time.Time `json:"timestamp"`
+	// Source is where the SCT came from: SCTEmbedded or SCTTLSExtension.
+	Source string `json:"source"`
+	// Verified is whether the SCT's signature was verified with the log's key.
+	Verified bool `json:"verified"`
+}
+
+// sctListExtension is the OID of the certificate extension that embedded SCTs
+// are in (RFC 6962, section 3.3).
+var sctListExtension = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// parsedSCT is a v1 SCT (RFC 6962, section 3.2), with the fields needed to
+// verify it.
+type parsedSCT struct {
+	logID      [sha256.Size]byte
+	timestamp  uint64
+	extensions []byte
+	hash       byte
+	algorithm  byte
+	signature  []byte
+}
+
+// sctReader reads the TLS encoding of SCTs.
+type sctReader struct {
+	data []byte
+	err  error
+}
+
+func (r *sctReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = fmt.Errorf("truncated")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// vector reads a byte string prefixed with its 2-byte length.
+func (r *sctReader) vector() []byte {
+	length := r.bytes(2)
+	if length == nil {
+		return nil
+	}
+	return r.bytes(int(binary.BigEndian.Uint16(length)))
+}
+
+func parseSCT(data []byte) (*parsedSCT, error) {
+	r := &sctReader{data: data}
+	version := r.bytes(1)
+	if r.err == nil && version[0] != 0 {
+		return nil, fmt.Errorf("unsupported SCT version %d", version[0]+1)
+	}
+	sct := &parsedSCT{}
+	copy(sct.logID[:], r.bytes(sha256.Size))
+	if timestamp := r.bytes(8); timestamp != nil {
+		sct.timestamp = binary.BigEndian.Uint64(timestamp)
+	}
+	sct.extensions = r.vector()
+	if algorithms := r.bytes(2); algorithms != nil {
+		sct.hash, sct.algorithm = algorithms[0], algorithms[1]
+	}
+	sct.signature = r.vector()
+	if r.err == nil && len(r.data) > 0 {
+		r.err = fmt.Errorf("trailing data")
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("SCT is malformed: %v", r.err)
+	}
+	return sct, nil
+}
+
+// parseSCTList parses a SignedCertificateTimestampList, returning the
+// serialized SCTs in it.
+func parseSCTList(data []byte) ([][]byte, error) {
+	r := &sctReader{data: data}
+	list := &sctReader{data: r.vector()}
+	if r.err != nil || len(r.data) > 0 {
+		return nil, fmt.Errorf("SCT list is malformed")
+	}
+	scts := [][]byte{}
+	for len(list.data) > 0 {
+		sct := list.vector()
+		if list.err != nil {
+			return nil, fmt.Errorf("SCT list is malformed")
+		}
+		scts = append(scts, sct)
+	}
+	return scts, nil
+}
+
+// embeddedSCTs returns the serialized SCTs embedded in cert.
+func embeddedSCTs(cert *x509.Certificate) ([][]byte, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(sctListExtension) {
+			continue
+		}
+		var list []byte
+		if rest, err := asn1.Unmarshal(ext.Value, &list); err != nil || len(rest) > 0 {
+			return nil, fmt.Errorf("SCT list extension is malformed")
+		}
+		return parseSCTList(list)
+	}
+	return nil, nil
+}
+
+// tbsCertificate is the ASN.1 structure of a TBSCertificate (RFC 5280,
+// section 4.1), parsed just enough to remove an extension.
+type tbsCertificate struct {
+	Raw                asn1.RawContent
+	Version            int `asn1:"optional,explicit,default:0,tag:0"`
+	SerialNumber       asn1.RawValue
+	SignatureAlgorithm asn1.RawValue
+	Issuer             asn1.RawValue
+	Validity           asn1.RawValue
+	Subject            asn1.RawValue
+	PublicKey          asn1.RawValue
+	IssuerUniqueID     asn1.BitString   `asn1:"optional,tag:1"`
+	SubjectUniqueID    asn1.BitString   `asn1:"optional,tag:2"`
+	Extensions         []pkix.Extension `asn1:"omitempty,optional,explicit,tag:3"`
+}
+
+// precertTBS returns the TBSCertificate of the precertificate that cert was
+// issued from, which its embedded SCTs are signed over: cert's own, without
+// the SCT list extension (RFC 6962, section 3.2).
+func precertTBS(cert *x509.Certificate) ([]byte, error) {
+	var tbs tbsCertificate
+	if rest, err := asn1.Unmarshal(cert.RawTBSCertificate, &tbs); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("couldn't parse the certificate")
+	}
+	extensions := []pkix.Extension{}
+	for _, ext := range tbs.Extensions {
+		if !ext.Id.Equal(sctListExtension) {
+			extensions = append(extensions, ext)
+		}
+	}
+	tbs.Raw, tbs.Extensions = nil, extensions
+	return asn1.Marshal(tbs)
+}
+
+// signedData returns the data that sct is signed over: entryType (0 for a
+// certificate, 1 for a precertificate) and the entry, followed by the SCT's
+// extensions (RFC 6962, section 3.2).
+func (sct *parsedSCT) signedData(entryType uint16, entry []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0}) // Version v1, and signature type certificate_timestamp.
+	binary.Write(&buf, binary.BigEndian, sct.timestamp)
+	binary.Write(&buf, binary.BigEndian, entryType)
+	buf.Write(entry)
+	binary.Write(&buf, binary.BigEndian, uint16(len(sct.extensions)))
+	buf.Write(sct.extensions)
+	return buf.Bytes()
+}
+
+// withLength24 prefixes data with its 3-byte length.
+func withLength24(data []byte) []byte {
+	return append([]byte{byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}, data...)
+}
+
+// verify checks that sct is signed by log over data.
+func (sct *parsedSCT) verify(log CTLog, data []byte) error {
+	if sct.hash != 4 { // SHA-256
+		return fmt.Errorf("unsupported hash algorithm %d", sct.hash)
+	}
+	key, err := x509.ParsePKIXPublicKey(log.Key)
+	if err != nil {
+		return fmt.Errorf("couldn't parse the log's key: %v", err)
+	}
+	digest := sha256.Sum256(data)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if sct.algorithm != 3 || !ecdsa.VerifyASN1(key, digest[:], sct.signature) {
+			return fmt.Errorf("signature is invalid")
+		}
+	case *rsa.PublicKey:
+		if sct.algorithm != 1 {
+			return fmt.Errorf("signature is invalid")
+		}
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sct.signature); err != nil {
+			return fmt.Errorf("signature is invalid")
+		}
+	default:
+		return fmt.Errorf("unsupported log key type %T", key)
+	}
+	return nil
+}
+
+// checkSCTs checks the SCTs embedded in the leaf certificate of state, and
+// those the server sent in the TLS handshake, verifying those from logs. root
+// is the trusted root the chain ends at, or nil if it isn't trusted, and
+// public is whether it's one of the system's roots. A publicly trusted
+// certificate without SCTs, or without any from logs, gets a warning, since
+// browsers require them. Returns nil for any other certificate without SCTs,
+// since private CAs rarely log their certificates.
+func checkSCTs(state tls.ConnectionState, root *x509.Certificate, public bool, logs []CTLog, now time.Time) (*Result, []SCT) {
+	if len(state.PeerCertificates) == 0 {
+		return nil, nil
+	}
+	public = public && root != nil
+	result := MakeResult(CertTransparency)
+	leaf := state.PeerCertificates[0]
+	embedded, err := embeddedSCTs(leaf)
+	if err != nil {
+		result.Warning("Certificate's embedded SCTs couldn't be parsed: %v.", err)
+	}
+	if len(embedded) == 0 && len(state.SignedCertificateTimestamps) == 0 {
+		if !public && err == nil {
+			return nil, nil
+		}
+		if public {
+			result.Warning("Certificate doesn't have any signed certificate timestamps (SCTs), so it may not have been logged for Certificate Transparency.")
+		}
+		return result.Success(), nil
+	}
+	known := make(map[[sha256.Size]byte]CTLog)
+	for _, log := range logs {
+		known[log.ID()] = log
+	}
+	issuer := root
+	for _, cert := range state.PeerCertificates[1:] {
+		if bytes.Equal(cert.RawSubject, leaf.RawIssuer) {
+			issuer = cert
+			break
+		}
+	}
+	scts := []SCT{}
+	verified := 0
+	check := func(data []byte, source string, signedData func(*parsedSCT) ([]byte, error)) {
+		parsed, err := parseSCT(data)
+		if err != nil {
+			result.Warning("Certificate has an invalid SCT: %v.", err)
+			return
+		}
+		sct := SCT{
+			LogID:     base64.StdEncoding.EncodeToString(parsed.logID[:]),
+			Timestamp: time.Unix(0, int64(parsed.timestamp)*int64(time.Millisecond)).UTC(),
+			Source:    source,
+		}
+		defer func() { scts = append(scts, sct) }()
+		log, ok := known[parsed.logID]
+		if !ok {
+			result.Info("SCT from unknown log %s couldn't be verified.", sct.LogID)
+			return
+		}
+		sct.Log = log.Description
+		signed, err := signedData(parsed)
+		if err != nil {
+			result.Info("SCT from %s couldn't be verified: %v.", log.Description, err)
+			return
+		}
+		if err := parsed.verify(log, signed); err != nil {
+			result.Warning("SCT from %s couldn't be verified: %v.", log.Description, err)
+			return
+		}
+		sct.Verified = true
+		verified++
+		if sct.Timestamp.After(now) {
+			result.Warning("SCT from %s is timestamped in the future, at %s.", log.Description, sct.Timestamp.Format(time.RFC3339))
+		}
+	}
+	for _, data := range embedded {
+		check(data, SCTEmbedded, func(sct *parsedSCT) ([]byte, error) {
+			if issuer == nil {
+				return nil, fmt.Errorf("the certificate's issuer wasn't presented")
+			}
+			tbs, err := precertTBS(leaf)
+			if err != nil {
+				return nil, err
+			}
+			issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+			return sct.signedData(1, append(issuerKeyHash[:], withLength24(tbs)...)), nil
+		})
+	}
+	for _, data := range state.SignedCertificateTimestamps {
+		check(data, SCTTLSExtension, func(sct *parsedSCT) ([]byte, error) {
+			return sct.signedData(0, withLength24(leaf.Raw)), nil
+		})
+	}
+	if verified == 0 && public {
+		result.Warning("None of the certificate's SCTs could be verified with a known CT log.")
+	}
+	return result.Success(), scts
+}
@@ -0,0 +1,171 @@
+package checker
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func generateCTLog(t *testing.T, description string) (CTLog, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return CTLog{Description: description, Key: der}, key
+}
+
+// createSCT returns an SCT for entry, claiming to be from log, but signed with
+// key.
+func createSCT(t *testing.T, log CTLog, key *ecdsa.PrivateKey, timestamp time.Time, entryType uint16, entry []byte) []byte {
+	sct := &parsedSCT{logID: log.ID(), timestamp: uint64(timestamp.UnixNano() / int64(time.Millisecond))}
+	digest := sha256.Sum256(sct.signedData(entryType, entry))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append([]byte{0}, sct.logID[:]...)
+	data = binary.BigEndian.AppendUint64(data, sct.timestamp)
+	data = append(data, 0, 0, 4, 3)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(signature)))
+	return append(data, signature...)
+}
+
+func createSCTList(scts ...[]byte) []byte {
+	list := []byte{}
+	for _, sct := range scts {
+		list = binary.BigEndian.AppendUint16(list, uint16(len(sct)))
+		list = append(list, sct...)
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(list))), list...)
+}
+
+// issueSCTCert issues a certificate for localhost from issuer, with an SCT
+// from log embedded in it.
+func issueSCTCert(t *testing.T, issuer *testCert, log CTLog, logKey *ecdsa.PrivateKey, now time.Time) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(90 * 24 * time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	issue := func() *x509.Certificate {
+		der, err := x509.CreateCertificate(rand.Reader, template, issuer.cert, &key.PublicKey, issuer.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	precert := issue()
+	issuerKeyHash := sha256.Sum256(issuer.cert.RawSubjectPublicKeyInfo)
+	entry := append(issuerKeyHash[:], withLength24(precert.RawTBSCertificate)...)
+	value, err := asn1.Marshal(createSCTList(createSCT(t, log, logKey, now.Add(-time.Hour), 1, entry)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.ExtraExtensions = []pkix.Extension{{Id: sctListExtension, Value: value}}
+	return issue()
+}
+
+func TestCheckSCTs(t *testing.T) {
+	now := time.Now()
+	root := issueTestCert(t, "Root", true, now.Add(-time.Hour), now.Add(365*24*time.Hour), nil)
+	log, logKey := generateCTLog(t, "Test log")
+	otherLog, otherKey := generateCTLog(t, "Other log")
+	embedded := issueSCTCert(t, root, log, logKey, now)
+	plain := issueTestCert(t, "localhost", false, now.Add(-time.Hour), now.Add(time.Hour), root).cert
+	tlsSCT := func(log CTLog, key *ecdsa.PrivateKey) [][]byte {
+		return [][]byte{createSCT(t, log, key, now.Add(-time.Hour), 0, withLength24(plain.Raw))}
+	}
+	tests := []struct {
+		leaf      *x509.Certificate
+		scts      [][]byte
+		trusted   bool
+		logs      []CTLog
+		expected  Status
+		verified  bool
+		numberSCT int
+	}{
+		{embedded, nil, true, []CTLog{log}, Success, true, 1},
+		{embedded, nil, true, nil, Warning, false, 1},
+		{embedded, nil, true, []CTLog{otherLog}, Warning, false, 1},
+		{plain, tlsSCT(log, logKey), true, []CTLog{otherLog, log}, Success, true, 1},
+		// Signed with the wrong key.
+		{plain, tlsSCT(log, otherKey), true, []CTLog{log}, Warning, false, 1},
+		{plain, nil, true, []CTLog{log}, Warning, false, 0},
+		{plain, [][]byte{{1, 2, 3}}, false, []CTLog{log}, Warning, false, 0},
+	}
+	for i, test := range tests {
+		state := tls.ConnectionState{
+			PeerCertificates:            []*x509.Certificate{test.leaf, root.cert},
+			SignedCertificateTimestamps: test.scts,
+		}
+		var trustedRoot *x509.Certificate
+		if test.trusted {
+			trustedRoot = root.cert
+		}
+		result, scts := checkSCTs(state, trustedRoot, test.trusted, test.logs, now)
+		if result == nil || result.Status != test.expected {
+			t.Errorf("Test %d: expected %v, got %v", i, test.expected, result)
+			continue
+		}
+		if len(scts) != test.numberSCT {
+			t.Errorf("Test %d: expected %d SCTs, got %v", i, test.numberSCT, scts)
+		} else if len(scts) > 0 && scts[0].Verified != test.verified {
+			t.Errorf("Test %d: expected SCT to be verified: %v, got %+v", i, test.verified, scts[0])
+		}
+	}
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{plain}}
+	if result, _ := checkSCTs(state, nil, true, []CTLog{log}, now); result != nil {
+		t.Errorf("Expected no check for an untrusted certificate without SCTs, got %v", result)
+	}
+	if result, _ := checkSCTs(state, root.cert, false, []CTLog{log}, now); result != nil {
+		t.Errorf("Expected no check for a privately trusted certificate without SCTs, got %v", result)
+	}
+	state = tls.ConnectionState{PeerCertificates: []*x509.Certificate{embedded, root.cert}}
+	_, scts := checkSCTs(state, root.cert, true, []CTLog{log}, now)
+	if len(scts) != 1 || scts[0].Source != SCTEmbedded || scts[0].Log != "Test log" {
+		t.Errorf("Expected an embedded SCT from Test log, got %+v", scts)
+	}
+}
+
+func TestParseCTLogList(t *testing.T) {
+	log, _ := generateCTLog(t, "Test log")
+	data := fmt.Sprintf(`{"operators": [{"name": "Test", "logs": [
+		{"description": "Test log", "key": "%s", "url": "https://ct.example.com/"}]}]}`,
+		base64.StdEncoding.EncodeToString(log.Key))
+	logs, err := ParseCTLogList([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Description != "Test log" || logs[0].ID() != log.ID() {
+		t.Errorf("Expected Test log, got %+v", logs)
+	}
+	if _, err := ParseCTLogList([]byte(`{"operators": [{"logs": [{"key": "!"}]}]}`)); err == nil {
+		t.Error("Expected an error for an invalid key")
+	}
+}
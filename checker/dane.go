@@ -0,0 +1,133 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// tlsaName returns the name of the TLSA records for hostname (which may
+// include a port, and otherwise is on port 25), e.g. _25._tcp.mx.example.com.
+func tlsaName(hostname string) string {
+	host, port, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host, port = hostname, "25"
+	}
+	return fmt.Sprintf("_%s._tcp.%s", port, strings.TrimSuffix(host, "."))
+}
+
+// parseTLSARecord parses the data of a TLSA record.
+func parseTLSARecord(data []byte) (TLSAAssociation, error) {
+	if len(data) < 3 {
+		return TLSAAssociation{}, fmt.Errorf("TLSA record too short")
+	}
+	return TLSAAssociation{
+		Usage:        data[0],
+		Selector:     data[1],
+		MatchingType: data[2],
+		Data:         append([]byte{}, data[3:]...),
+	}, nil
+}
+
+// lookupTLSA returns the TLSA records at name, and whether the resolver
+// validated them with DNSSEC. Records that can't be parsed are skipped.
+func (c *Checker) lookupTLSA(ctx context.Context, name string) ([]TLSAAssociation, bool, error) {
+	if c.lookupTLSAOverride != nil {
+		return c.lookupTLSAOverride(name)
+	}
+	response, err := c.queryDNS(ctx, name, dnsTypeTLSA)
+	if err != nil {
+		return nil, false, err
+	}
+	records := []TLSAAssociation{}
+	for _, data := range response.recordsOfType(dnsTypeTLSA) {
+		if record, err := parseTLSARecord(data); err == nil {
+			records = append(records, record)
+		}
+	}
+	return records, response.Authenticated, nil
+}
+
+// checkDANE checks the certificate chain presented by h against the TLSA
+// records published for it (RFC 7672), if any. Only DANE-TA(2) and DANE-EE(3)
+// records are usable for SMTP, and the chain must match at least one of them.
+// With DANE-TA(2), the certificate must also chain to the matching trust
+// anchor, and be valid for the hostname or its domain.
+func checkDANE(h HostnameResult, name string, records []TLSAAssociation, authenticated bool) *Result {
+	result := MakeResult(DANE)
+	if len(records) == 0 {
+		return result.Info("No TLSA records found at %s, so senders won't authenticate %s with DANE.", name, h.Hostname)
+	}
+	if !authenticated {
+		result.Warning("TLSA records at %s weren't validated with DNSSEC by the resolver, so senders won't use them. The zone may not be signed.", name)
+	}
+	usable := []TLSAAssociation{}
+	for _, record := range records {
+		if err := record.validate(); err != nil {
+			result.Warning("TLSA record %s at %s is unusable: %v.", record, name, err)
+		} else if record.Usage == tlsaUsagePKIXTA || record.Usage == tlsaUsagePKIXEE {
+			result.Warning("TLSA record %s at %s is unusable: usages PKIX-TA(0) and PKIX-EE(1) aren't supported for SMTP (RFC 7672, section 3.1.3).", record, name)
+		} else {
+			usable = append(usable, record)
+		}
+	}
+	if len(usable) == 0 {
+		return result.Warning("None of the TLSA records at %s are usable, so senders won't authenticate %s with DANE.", name, h.Hostname)
+	}
+	if !h.couldSTARTTLS() {
+		return result.Failure("TLSA records are published at %s, but TLS couldn't be negotiated with %s, so senders using DANE won't deliver mail to it.", name, h.Hostname)
+	}
+	if h.tlsState == nil || len(h.tlsState.PeerCertificates) == 0 {
+		return result.Error("Couldn't check %s against its TLSA records, since its certificate chain isn't available.", h.Hostname)
+	}
+	state := *h.tlsState
+	names := []string{h.Hostname, h.Domain}
+	errs := make([]error, len(usable))
+	for i, record := range usable {
+		if errs[i] = record.verify(state, names, h.Timestamp); errs[i] == nil {
+			return result.Success()
+		}
+	}
+	for i, record := range usable {
+		if errs[i] != errTLSAMismatch {
+			result.Failure("Certificate chain presented by %s matches the TLSA record %s at %s, but %v.", h.Hostname, record, name, errs[i])
+			continue
+		}
+		cert := state.PeerCertificates[0]
+		if record.Usage == tlsaUsageDANETA {
+			cert = chainTop(state)
+		}
+		result.Failure("Certificate chain presented by %s doesn't match the TLSA record at %s: computed %s, published %s.",
+			h.Hostname, name, record.compute(cert), record)
+	}
+	return result
+}
+
+// checkDANEHostname adds the DANE check to h, unless h is an IP address, ctx
+// has expired, or only the QuickMode checks are performed.
+func (c *Checker) checkDANEHostname(ctx context.Context, h *HostnameResult) {
+	if c.QuickMode || h.Result == nil || expired(ctx) {
+		return
+	}
+	host, _, err := net.SplitHostPort(h.Hostname)
+	if err != nil {
+		host = h.Hostname
+	}
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return
+	}
+	ctx, span := c.tracer().Start(ctx, "dane")
+	defer span.End()
+	name := tlsaName(h.Hostname)
+	records, authenticated, err := c.lookupTLSA(ctx, name)
+	h.TLSADNSSEC = dnssecStatus(authenticated, err)
+	var daneResult *Result
+	if err != nil {
+		daneResult = MakeResult(DANE).Warning("Couldn't look up TLSA records at %s: %v. Senders using DANE defer mail when the lookup fails.", name, err)
+	} else {
+		daneResult = checkDANE(*h, name, records, authenticated)
+	}
+	span.SetAttribute("status", daneResult.StatusText())
+	h.addCheck(daneResult)
+}
@@ -0,0 +1,137 @@
+package checker
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTLSAName(t *testing.T) {
+	tests := map[string]string{
+		"mx.example.com":       "_25._tcp.mx.example.com",
+		"mx.example.com.":      "_25._tcp.mx.example.com",
+		"localhost:2525":       "_2525._tcp.localhost",
+		"mx.example.com.:2525": "_2525._tcp.mx.example.com",
+	}
+	for hostname, expected := range tests {
+		if name := tlsaName(hostname); name != expected {
+			t.Errorf("Expected TLSA records for %s at %s, got %s", hostname, expected, name)
+		}
+	}
+}
+
+func TestCheckDANE(t *testing.T) {
+	now := time.Now()
+	root := issueTestCert(t, "Test Root", true, now.Add(-time.Hour), now.Add(time.Hour), nil)
+	leaf := issueTestCert(t, "mx.example.com", false, now.Add(-time.Hour), now.Add(time.Hour), root)
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf.cert, root.cert}}
+	leafSPKI := sha256.Sum256(leaf.cert.RawSubjectPublicKeyInfo)
+	rootCert := sha256.Sum256(root.cert.Raw)
+	starttls := MakeResult("hostnames")
+	starttls.addCheck(MakeResult(STARTTLS).Success())
+	h := HostnameResult{Result: starttls, Hostname: "mx.example.com", tlsState: &state}
+	noSTARTTLS := MakeResult("hostnames")
+	noSTARTTLS.addCheck(MakeResult(STARTTLS).Failure("Server does not advertise support for STARTTLS."))
+
+	daneEE := TLSAAssociation{Usage: 3, Selector: 1, MatchingType: 1, Data: leafSPKI[:]}
+	daneTA := TLSAAssociation{Usage: 2, Selector: 0, MatchingType: 1, Data: rootCert[:]}
+	wrongEE := TLSAAssociation{Usage: 3, Selector: 1, MatchingType: 1, Data: rootCert[:]}
+	pkixEE := TLSAAssociation{Usage: 1, Selector: 1, MatchingType: 1, Data: leafSPKI[:]}
+	// An unrelated leaf presented alongside the published trust anchor.
+	other := issueTestCert(t, "Other Root", true, now.Add(-time.Hour), now.Add(time.Hour), nil)
+	unrelated := issueTestCert(t, "mx.example.com", false, now.Add(-time.Hour), now.Add(time.Hour), other)
+	unrelatedState := tls.ConnectionState{PeerCertificates: []*x509.Certificate{unrelated.cert, root.cert}}
+	unrelatedH := HostnameResult{Result: starttls, Hostname: "mx.example.com", tlsState: &unrelatedState}
+	// A leaf issued by the trust anchor, but for another name.
+	misnamed := issueTestCert(t, "mx.example.net", false, now.Add(-time.Hour), now.Add(time.Hour), root)
+	misnamedState := tls.ConnectionState{PeerCertificates: []*x509.Certificate{misnamed.cert, root.cert}}
+	misnamedH := HostnameResult{Result: starttls, Hostname: "mx.example.com", tlsState: &misnamedState}
+	tests := []struct {
+		name          string
+		h             HostnameResult
+		records       []TLSAAssociation
+		authenticated bool
+		status        Status
+	}{
+		{"no records", h, []TLSAAssociation{}, true, Info},
+		{"DANE-EE", h, []TLSAAssociation{daneEE}, true, Success},
+		{"DANE-TA", h, []TLSAAssociation{daneTA}, true, Success},
+		{"DANE-TA with an unrelated leaf", unrelatedH, []TLSAAssociation{daneTA}, true, Failure},
+		{"DANE-TA with another name", misnamedH, []TLSAAssociation{daneTA}, true, Failure},
+		{"one of several", h, []TLSAAssociation{wrongEE, daneEE}, true, Success},
+		{"mismatch", h, []TLSAAssociation{wrongEE}, true, Failure},
+		{"not DNSSEC-validated", h, []TLSAAssociation{daneEE}, false, Warning},
+		{"PKIX usages are unusable", h, []TLSAAssociation{pkixEE}, true, Warning},
+		{"unknown usage", h, []TLSAAssociation{{Usage: 4, Data: leafSPKI[:]}}, true, Warning},
+		{"no STARTTLS", HostnameResult{Result: noSTARTTLS, Hostname: "mx.example.com"}, []TLSAAssociation{daneEE}, true, Failure},
+		{"no chain", HostnameResult{Result: starttls, Hostname: "mx.example.com"}, []TLSAAssociation{daneEE}, true, Error},
+	}
+	for _, test := range tests {
+		result := checkDANE(test.h, "_25._tcp.mx.example.com", test.records, test.authenticated)
+		if result.Status != test.status {
+			t.Errorf("%s: expected status %s, got %s: %v", test.name, statusText[test.status], result.StatusText(), result.Messages)
+		}
+	}
+
+	result := checkDANE(h, "_25._tcp.mx.example.com", []TLSAAssociation{wrongEE}, true)
+	messages := strings.Join(result.Messages, "\n")
+	expected := fmt.Sprintf("computed %s, published %s", daneEE, wrongEE)
+	if !strings.Contains(messages, expected) {
+		t.Errorf("Expected mismatch to report %q, got %s", expected, messages)
+	}
+
+	result = checkDANE(unrelatedH, "_25._tcp.mx.example.com", []TLSAAssociation{daneTA}, true)
+	messages = strings.Join(result.Messages, "\n")
+	if !strings.Contains(messages, "doesn't chain to the matching trust anchor") {
+		t.Errorf("Expected an unrelated leaf to fail chain verification, got %s", messages)
+	}
+}
+
+func TestDANEChecksEachHostname(t *testing.T) {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := smtpListenAndServe(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	digest := sha256.Sum256(cert.Certificate[0])
+
+	c := Checker{
+		Timeout: testTimeout,
+		lookupMXOverride: func(string) ([]*net.MX, error) {
+			return []*net.MX{{Host: "localhost", Pref: 10}, {Host: "mx.example.com", Pref: 20}}, nil
+		},
+		lookupHostOverride: func(string) ([]string, error) { return []string{"127.0.0.1"}, nil },
+		lookupTLSAOverride: func(name string) ([]TLSAAssociation, bool, error) {
+			if name == "_25._tcp.localhost" {
+				return []TLSAAssociation{{Usage: 3, Selector: 0, MatchingType: 1, Data: digest[:]}}, true, nil
+			}
+			return nil, false, nil
+		},
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	c.Port, _ = strconv.Atoi(port)
+	result := c.CheckDomain("example.com", nil)
+	if dane := result.HostnameResults["localhost"].Checks[DANE]; dane == nil || dane.Status != Success {
+		t.Errorf("Expected localhost to match its TLSA record, got %v", dane)
+	}
+	// mx.example.com is at the same address, but doesn't publish TLSA records.
+	if dane := result.HostnameResults["mx.example.com"].Checks[DANE]; dane == nil || dane.Status != Info {
+		t.Errorf("Expected mx.example.com to be checked for its own TLSA records, got %v", dane)
+	}
+
+	c.QuickMode = true
+	result = c.CheckDomain("example.com", nil)
+	if _, ok := result.HostnameResults["localhost"].Checks[DANE]; ok {
+		t.Error("Expected no DANE check in QuickMode")
+	}
+}
@@ -0,0 +1,148 @@
+package checker
+
+import (
+	"sort"
+)
+
+// Direction describes how a check's status changed between two scans.
+type Direction string
+
+// Values for Change Direction
+const (
+	// Improved means the check's new status is less severe than its old one.
+	Improved Direction = "improved"
+	// Regressed means the check's new status is more severe than its old one.
+	Regressed Direction = "regressed"
+	// Unchanged means the check's status is the same, but its messages differ.
+	Unchanged Direction = "unchanged"
+)
+
+// Change describes a single check whose result differs between two scans of
+// the same domain.
+type Change struct {
+	// Check is the path to the check in the result tree, e.g.
+	// "mx.example.com/certificate" or "mta-sts/mta-sts-text".
+	Check     string    `json:"check"`
+	OldStatus Status    `json:"old_status"`
+	NewStatus Status    `json:"new_status"`
+	Direction Direction `json:"direction"`
+	// Appeared is set if the check is only present in the new scan.
+	// OldStatus is then reported as Success.
+	Appeared bool `json:"appeared,omitempty"`
+	// Disappeared is set if the check is only present in the old scan.
+	// NewStatus is then reported as Success.
+	Disappeared bool `json:"disappeared,omitempty"`
+	// Messages present in the new scan, but not the old one.
+	AddedMessages []string `json:"added_messages,omitempty"`
+	// Messages present in the old scan, but not the new one.
+	RemovedMessages []string `json:"removed_messages,omitempty"`
+}
+
+// DiffResults compares the check trees of two scans of a domain, and returns
+// the checks whose status or messages changed, sorted by path.
+// Checks that appeared or disappeared are reported, but their sub-checks are not.
+func DiffResults(before, after DomainResult) []Change {
+	changes := []Change{}
+	for _, hostname := range unionKeys(hostnameResultTrees(before), hostnameResultTrees(after)) {
+		changes = diffResult(hostname, before.HostnameResults[hostname].Result,
+			after.HostnameResults[hostname].Result, changes)
+	}
+	var beforeMTASTS, afterMTASTS *Result
+	if before.MTASTSResult != nil {
+		beforeMTASTS = before.MTASTSResult.Result
+	}
+	if after.MTASTSResult != nil {
+		afterMTASTS = after.MTASTSResult.Result
+	}
+	changes = diffResult(MTASTS, beforeMTASTS, afterMTASTS, changes)
+	for _, name := range unionKeys(before.ExtraResults, after.ExtraResults) {
+		changes = diffResult(name, before.ExtraResults[name], after.ExtraResults[name], changes)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Check < changes[j].Check })
+	return changes
+}
+
+func hostnameResultTrees(d DomainResult) map[string]*Result {
+	trees := make(map[string]*Result)
+	for hostname, hostnameResult := range d.HostnameResults {
+		trees[hostname] = hostnameResult.Result
+	}
+	return trees
+}
+
+// unionKeys returns the sorted keys present in either map.
+func unionKeys(a, b map[string]*Result) []string {
+	keys := []string{}
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diffResult appends the changes between before and after (and their
+// sub-checks) to changes.
+func diffResult(path string, before, after *Result, changes []Change) []Change {
+	if before == nil && after == nil {
+		return changes
+	}
+	change := Change{Check: path}
+	if before == nil {
+		change.Appeared = true
+		change.OldStatus = Success
+		change.NewStatus = after.Status
+		change.AddedMessages = after.Messages
+	} else if after == nil {
+		change.Disappeared = true
+		change.OldStatus = before.Status
+		change.NewStatus = Success
+		change.RemovedMessages = before.Messages
+	} else {
+		change.OldStatus = before.Status
+		change.NewStatus = after.Status
+		change.AddedMessages = missingMessages(after.Messages, before.Messages)
+		change.RemovedMessages = missingMessages(before.Messages, after.Messages)
+	}
+	change.Direction = direction(change.OldStatus, change.NewStatus)
+	if change.Appeared || change.Disappeared || change.Direction != Unchanged ||
+		len(change.AddedMessages) > 0 || len(change.RemovedMessages) > 0 {
+		changes = append(changes, change)
+	}
+	if before == nil || after == nil {
+		return changes
+	}
+	for _, name := range unionKeys(before.Checks, after.Checks) {
+		changes = diffResult(path+"/"+name, before.Checks[name], after.Checks[name], changes)
+	}
+	return changes
+}
+
+func direction(before, after Status) Direction {
+	if before == after {
+		return Unchanged
+	}
+	if SetStatus(before, after) == after {
+		return Regressed
+	}
+	return Improved
+}
+
+// missingMessages returns the messages in a which aren't in b.
+func missingMessages(a, b []string) []string {
+	inB := make(map[string]bool)
+	for _, message := range b {
+		inB[message] = true
+	}
+	missing := []string{}
+	for _, message := range a {
+		if !inB[message] {
+			missing = append(missing, message)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,44 @@
+package checker
+
+import (
+	"testing"
+)
+
+func TestDiffResults(t *testing.T) {
+	before := NewSampleDomainResult("example.com")
+	after := NewSampleDomainResult("example.com")
+	if changes := DiffResults(before, after); len(changes) != 0 {
+		t.Fatalf("Expected identical results to have no changes, got %v", changes)
+	}
+
+	after.HostnameResults["mx.example.com"].Checks[Certificate].Failure("Certificate root is not trusted")
+	after.HostnameResults["mx.example.com"].Checks["extra"] = MakeResult("extra")
+	delete(after.ExtraResults, PolicyList)
+	after.MTASTSResult.Checks[MTASTSText].Warning("Something")
+	before.MTASTSResult.Checks[MTASTSPolicyFile].Failure("Couldn't find policy file")
+
+	expected := []Change{
+		{Check: "mta-sts/mta-sts-policy-file", OldStatus: Failure, NewStatus: Success, Direction: Improved},
+		{Check: "mta-sts/mta-sts-text", OldStatus: Success, NewStatus: Warning, Direction: Regressed},
+		{Check: "mx.example.com/certificate", OldStatus: Success, NewStatus: Failure, Direction: Regressed},
+		{Check: "mx.example.com/extra", OldStatus: Success, NewStatus: Success, Direction: Unchanged, Appeared: true},
+		{Check: PolicyList, OldStatus: Success, NewStatus: Success, Direction: Unchanged, Disappeared: true},
+	}
+	changes := DiffResults(before, after)
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %v", len(expected), changes)
+	}
+	for i, want := range expected {
+		got := changes[i]
+		if got.Check != want.Check || got.OldStatus != want.OldStatus || got.NewStatus != want.NewStatus ||
+			got.Direction != want.Direction || got.Appeared != want.Appeared || got.Disappeared != want.Disappeared {
+			t.Errorf("Expected change %v, got %v", want, got)
+		}
+	}
+	if len(changes[0].RemovedMessages) != 1 || len(changes[0].AddedMessages) != 0 {
+		t.Errorf("Expected a removed message, got %v", changes[0])
+	}
+	if len(changes[2].AddedMessages) != 1 {
+		t.Errorf("Expected an added message, got %v", changes[2])
+	}
+}
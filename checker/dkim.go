@@ -0,0 +1,213 @@
+package checker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// DefaultDKIMSelectors are common DKIM selectors, used by popular mail
+// providers and software, that the DKIM check probes (see Checker.CheckDKIM).
+var DefaultDKIMSelectors = []string{
+	"default", "dkim", "mail", "selector1", "selector2", "google", "k1", "k2",
+	"s1", "s2", "smtp", "mx", "mandrill", "everlytickey1", "zoho",
+}
+
+// DKIMKey is a DKIM (RFC 6376) public key published by a domain.
+type DKIMKey struct {
+	// Selector the key was found at, under _domainkey.<domain>.
+	Selector string `json:"selector"`
+	// Algorithm of the key: "rsa" or "ed25519".
+	Algorithm string `json:"algorithm"`
+	// Bits is the size of the key, or 0 if it couldn't be parsed.
+	Bits int `json:"bits,omitempty"`
+	// Revoked is whether the key is empty, which revokes it.
+	Revoked bool `json:"revoked,omitempty"`
+	// Testing is whether the key is in testing mode (t=y), so verifiers
+	// treat signatures with it like unsigned mail.
+	Testing bool `json:"testing,omitempty"`
+}
+
+// dkimSelectors returns the selectors that the DKIM check probes: those of
+// c.DKIMSelectors, or DefaultDKIMSelectors, followed by extra, without
+// duplicates.
+func (c *Checker) dkimSelectors(extra []string) []string {
+	selectors := []string{}
+	seen := make(map[string]bool)
+	base := c.DKIMSelectors
+	if len(base) == 0 {
+		base = DefaultDKIMSelectors
+	}
+	for _, selector := range append(append([]string{}, base...), extra...) {
+		selector = strings.ToLower(strings.TrimSpace(selector))
+		if selector != "" && !seen[selector] {
+			seen[selector] = true
+			selectors = append(selectors, selector)
+		}
+	}
+	return selectors
+}
+
+// parseDKIMKey parses the DKIM key record found at selector.
+func parseDKIMKey(selector, record string) (DKIMKey, error) {
+	key := DKIMKey{Selector: selector, Algorithm: "rsa"}
+	tags := make(map[string]string)
+	for i, field := range strings.Split(record, ";") {
+		pair := strings.SplitN(field, "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		tag := strings.ToLower(strings.TrimSpace(pair[0]))
+		if tag == "v" && (i != 0 || strings.TrimSpace(pair[1]) != "DKIM1") {
+			return key, fmt.Errorf("v=DKIM1 must be the first tag")
+		}
+		tags[tag] = strings.TrimSpace(pair[1])
+	}
+	if k, ok := tags["k"]; ok {
+		key.Algorithm = strings.ToLower(k)
+	}
+	for _, flag := range strings.Split(tags["t"], ":") {
+		if strings.TrimSpace(flag) == "y" {
+			key.Testing = true
+		}
+	}
+	p, ok := tags["p"]
+	if !ok {
+		return key, fmt.Errorf("the record doesn't have a public key (p=)")
+	}
+	p = strings.Join(strings.Fields(p), "")
+	if p == "" {
+		key.Revoked = true
+		return key, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return key, fmt.Errorf("the public key isn't valid base64: %v", err)
+	}
+	switch key.Algorithm {
+	case "rsa":
+		pub, err := x509.ParsePKIXPublicKey(data)
+		if err != nil {
+			// Some keys are published as a bare RSAPublicKey.
+			if rsaKey, pkcs1Err := x509.ParsePKCS1PublicKey(data); pkcs1Err == nil {
+				pub, err = rsaKey, nil
+			}
+		}
+		if err != nil {
+			return key, fmt.Errorf("the RSA public key couldn't be parsed: %v", err)
+		}
+		switch pub := pub.(type) {
+		case *rsa.PublicKey:
+			key.Bits = pub.N.BitLen()
+		case *ecdsa.PublicKey:
+			return key, fmt.Errorf("the public key is an ECDSA key, which DKIM doesn't support")
+		default:
+			return key, fmt.Errorf("the public key isn't an RSA key")
+		}
+	case "ed25519":
+		if len(data) != ed25519.PublicKeySize {
+			return key, fmt.Errorf("the Ed25519 public key is %d bytes, rather than %d", len(data), ed25519.PublicKeySize)
+		}
+		key.Bits = 256
+	default:
+		return key, fmt.Errorf("unknown key type k=%s", key.Algorithm)
+	}
+	return key, nil
+}
+
+// dkimProbe is the outcome of looking up a DKIM selector.
+type dkimProbe struct {
+	records []string
+	err     error
+}
+
+// probeDKIM looks up each of selectors under _domainkey.domain concurrently,
+// and returns the key records found at each, in the order of selectors.
+func (c *Checker) probeDKIM(ctx context.Context, domain string, selectors []string) []dkimProbe {
+	probes := make([]dkimProbe, len(selectors))
+	var wg sync.WaitGroup
+	for i, selector := range selectors {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			records, err := c.lookupTXT(ctx, name)
+			if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+				records, err = nil, nil
+			}
+			// A selector may also have other TXT records, e.g. for
+			// verification, and keys may leave out v=.
+			keys := []string{}
+			for _, record := range records {
+				if strings.HasPrefix(record, "v=DKIM1") || strings.Contains(record, "p=") {
+					keys = append(keys, record)
+				}
+			}
+			probes[i] = dkimProbe{keys, err}
+		}(i, fmt.Sprintf("%s._domainkey.%s", selector, domain))
+	}
+	wg.Wait()
+	return probes
+}
+
+// checkDKIM probes selectors for DKIM keys of domain, and reports the size and
+// algorithm of those found. RSA keys shorter than 1024 bits fail, since
+// verifiers ignore them (RFC 8301, section 3.2), and shorter than 2048 bits
+// get a warning. Since selectors can be named anything, not finding any keys
+// is only noted.
+func (c *Checker) checkDKIM(ctx context.Context, domain string, selectors []string) (*Result, []DKIMKey) {
+	result := MakeResult(DKIM)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	keys := []DKIMKey{}
+	failedLookups := 0
+	for i, probe := range c.probeDKIM(ctx, domain, selectors) {
+		selector := selectors[i]
+		if probe.err != nil {
+			failedLookups++
+			continue
+		}
+		if len(probe.records) > 1 {
+			result.Failure("Selector %s has %d DKIM key records, rather than exactly 1.", selector, len(probe.records))
+			continue
+		}
+		if len(probe.records) == 0 {
+			continue
+		}
+		key, err := parseDKIMKey(selector, probe.records[0])
+		keys = append(keys, key)
+		switch {
+		case err != nil:
+			result.Failure("DKIM key at selector %s is invalid: %v.", selector, err)
+		case key.Revoked:
+			result.Info("DKIM key at selector %s has been revoked.", selector)
+		case key.Algorithm == "rsa" && key.Bits < 1024:
+			result.Failure("DKIM key at selector %s is a %d-bit RSA key. Verifiers ignore RSA keys shorter than 1024 bits.", selector, key.Bits)
+		case key.Algorithm == "rsa" && key.Bits < 2048:
+			result.Warning("DKIM key at selector %s is a %d-bit RSA key. RSA keys should be at least 2048 bits.", selector, key.Bits)
+		}
+		if key.Testing {
+			result.Info("DKIM key at selector %s is in testing mode (t=y), so verifiers treat mail signed with it like unsigned mail.", selector)
+		}
+	}
+	if failedLookups == len(selectors) && len(selectors) > 0 {
+		if expired(ctx) {
+			return result.Error("Timed out looking up DKIM selectors."), keys
+		}
+		return result.Error("Couldn't look up any of the DKIM selectors."), keys
+	}
+	if len(keys) == 0 {
+		return result.Info("No DKIM keys found at the selectors probed: %s. Selectors can be named anything, so keys may be published under others.",
+			strings.Join(selectors, ", ")), keys
+	}
+	if failedLookups > 0 {
+		result.Info("Couldn't look up %d of the DKIM selectors probed.", failedLookups)
+	}
+	return result.Success(), keys
+}
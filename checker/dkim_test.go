@@ -0,0 +1,150 @@
+package checker
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func dkimRecord(t *testing.T, key interface{}) string {
+	t.Helper()
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(key)
+	case *rsa.PublicKey:
+		data, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(data)
+	}
+	t.Fatalf("Unexpected key type %T", key)
+	return ""
+}
+
+func rsaPublicKey(t *testing.T, bits int) *rsa.PublicKey {
+	t.Helper()
+	// Keys shorter than 1024 bits can't be generated, but only their size
+	// matters here.
+	n := new(big.Int).Lsh(big.NewInt(1), uint(bits-1))
+	return &rsa.PublicKey{N: n.Add(n, big.NewInt(1)), E: 65537}
+}
+
+func TestParseDKIMKey(t *testing.T) {
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs1 := base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PublicKey(rsaPublicKey(t, 2048)))
+	tests := []struct {
+		record string
+		key    DKIMKey
+		valid  bool
+	}{
+		{dkimRecord(t, rsaPublicKey(t, 2048)), DKIMKey{Selector: "s", Algorithm: "rsa", Bits: 2048}, true},
+		{"p=" + pkcs1, DKIMKey{Selector: "s", Algorithm: "rsa", Bits: 2048}, true},
+		{dkimRecord(t, edKey), DKIMKey{Selector: "s", Algorithm: "ed25519", Bits: 256}, true},
+		{"v=DKIM1; t=s:y; p=" + pkcs1, DKIMKey{Selector: "s", Algorithm: "rsa", Bits: 2048, Testing: true}, true},
+		{"v=DKIM1; p=", DKIMKey{Selector: "s", Algorithm: "rsa", Revoked: true}, true},
+		{"v=DKIM1; k=rsa", DKIMKey{}, false},
+		{"k=rsa; v=DKIM1; p=" + pkcs1, DKIMKey{}, false},
+		{"v=DKIM1; p=not base64!", DKIMKey{}, false},
+		{"v=DKIM1; k=ed25519; p=" + pkcs1, DKIMKey{}, false},
+		{"v=DKIM1; k=dsa; p=" + pkcs1, DKIMKey{}, false},
+	}
+	for _, test := range tests {
+		key, err := parseDKIMKey("s", test.record)
+		if (err == nil) != test.valid {
+			t.Errorf("parseDKIMKey(%q) returned error %v, expected valid: %v", test.record, err, test.valid)
+			continue
+		}
+		if test.valid && key != test.key {
+			t.Errorf("parseDKIMKey(%q) = %+v, expected %+v", test.record, key, test.key)
+		}
+	}
+}
+
+func TestDKIMSelectors(t *testing.T) {
+	c := Checker{DKIMSelectors: []string{"a", "B"}}
+	got := c.dkimSelectors([]string{" b ", "c", "", "a"})
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected selectors %v, got %v", expected, got)
+	}
+	c = Checker{}
+	if got := c.dkimSelectors(nil); !reflect.DeepEqual(got, DefaultDKIMSelectors) {
+		t.Errorf("Expected the default selectors, got %v", got)
+	}
+}
+
+func TestCheckDKIM(t *testing.T) {
+	strong := dkimRecord(t, rsaPublicKey(t, 2048))
+	c := spfChecker(map[string][]string{
+		"s1._domainkey.strong.example":   {strong},
+		"s1._domainkey.medium.example":   {dkimRecord(t, rsaPublicKey(t, 1024))},
+		"s1._domainkey.weak.example":     {dkimRecord(t, rsaPublicKey(t, 512))},
+		"s1._domainkey.revoked.example":  {"v=DKIM1; p="},
+		"s1._domainkey.testing.example":  {"v=DKIM1; t=y; " + strong[len("v=DKIM1; "):]},
+		"s1._domainkey.multiple.example": {strong, strong},
+		"s1._domainkey.invalid.example":  {"v=DKIM1; k=rsa; p=abc"},
+		"s1._domainkey.other.example":    {"google-site-verification=abc"},
+		"s2._domainkey.mixed.example":    {strong},
+	})
+	tests := []struct {
+		domain string
+		status Status
+		keys   int
+	}{
+		{"strong.example", Success, 1},
+		{"medium.example", Warning, 1},
+		{"weak.example", Failure, 1},
+		{"revoked.example", Info, 1},
+		{"testing.example", Info, 1},
+		{"multiple.example", Failure, 0},
+		{"invalid.example", Failure, 1},
+		{"other.example", Info, 0},
+		{"nonexistent.example", Info, 0},
+		{"mixed.example", Success, 1},
+	}
+	for _, test := range tests {
+		result, keys := c.checkDKIM(context.Background(), test.domain, []string{"s1", "s2"})
+		if result.Status != test.status || len(keys) != test.keys {
+			t.Errorf("checkDKIM(%s) = %v with %d keys, expected %v with %d", test.domain, result, len(keys), test.status, test.keys)
+		}
+	}
+	c.lookupTXTOverride = func(string) ([]string, error) { return nil, errors.New("SERVFAIL") }
+	result, _ := c.checkDKIM(context.Background(), "strong.example", []string{"s1", "s2"})
+	if result.Status != Error {
+		t.Errorf("Expected an error when no selectors can be looked up, got %v", result)
+	}
+}
+
+func TestCheckDomainDKIM(t *testing.T) {
+	c := spfChecker(map[string][]string{
+		"default._domainkey.domain": {dkimRecord(t, rsaPublicKey(t, 2048))},
+		"custom._domainkey.domain":  {dkimRecord(t, rsaPublicKey(t, 1024))},
+	})
+	c.lookupMXOverride = mockLookupMX
+	c.CheckHostname = mockCheckHostname
+	c.checkMTASTSOverride = mockCheckMTASTS
+	c.lookupCAAOverride = mockLookupCAA
+	c.lookupDNSSECOverride = mockLookupDNSSEC
+	if result := c.CheckDomain("domain", nil); result.ExtraResults[DKIM] != nil || result.DKIM != nil {
+		t.Error("Expected DKIM not to be checked by default")
+	}
+	result := c.CheckDomainOptions(context.Background(), "domain", DomainOptions{DKIMSelectors: []string{"custom"}})
+	if r := result.ExtraResults[DKIM]; r == nil || r.Status != Warning || len(result.DKIM) != 2 {
+		t.Errorf("Expected the default and custom selectors to be probed, got %v and %+v", r, result.DKIM)
+	}
+	c.CheckDKIM = true
+	result = c.CheckDomain("domain", nil)
+	if r := result.ExtraResults[DKIM]; r == nil || r.Status != Success || len(result.DKIM) != 1 || result.DKIM[0].Selector != "default" {
+		t.Errorf("Expected a strong key at the default selector, got %v and %+v", r, result.DKIM)
+	}
+}
@@ -0,0 +1,222 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// DMARCRecord is a domain's DMARC (RFC 7489) policy record.
+type DMARCRecord struct {
+	// Domain is where the record was found: the domain being checked, or its
+	// organizational domain.
+	Domain string `json:"domain"`
+	// Policy is the requested handling of failing mail: "none",
+	// "quarantine" or "reject".
+	Policy string `json:"policy"`
+	// SubdomainPolicy is the policy for subdomains, if it differs.
+	SubdomainPolicy string `json:"subdomain_policy,omitempty"`
+	// DKIMAlignment and SPFAlignment are "r" (relaxed) or "s" (strict).
+	DKIMAlignment string `json:"adkim"`
+	SPFAlignment  string `json:"aspf"`
+	// Percent of failing mail that the policy applies to.
+	Percent int `json:"pct"`
+	// AggregateURIs and FailureURIs are where aggregate (rua) and failure
+	// (ruf) reports are sent.
+	AggregateURIs []string `json:"rua,omitempty"`
+	FailureURIs   []string `json:"ruf,omitempty"`
+}
+
+// dmarcPolicies ranks the DMARC policies from weakest to strongest.
+var dmarcPolicies = map[string]int{"none": 0, "quarantine": 1, "reject": 2}
+
+// lookupDMARC returns the DMARC records of domain, from _dmarc.<domain>.
+func (c *Checker) lookupDMARC(ctx context.Context, domain string) ([]string, error) {
+	records, err := c.lookupTXT(ctx, fmt.Sprintf("_dmarc.%s", domain))
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		records, err = nil, nil
+	}
+	return filterByPrefix(records, "v=DMARC1"), err
+}
+
+// parseDMARCTags parses the tag-value pairs of a DMARC record. Values may
+// contain "=", so pairs are only split at the first one.
+func parseDMARCTags(record string) ([]string, map[string]string) {
+	order := []string{}
+	tags := make(map[string]string)
+	for _, field := range strings.Split(record, ";") {
+		pair := strings.SplitN(field, "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		tag := strings.ToLower(strings.TrimSpace(pair[0]))
+		if _, ok := tags[tag]; !ok {
+			order = append(order, tag)
+		}
+		tags[tag] = strings.TrimSpace(pair[1])
+	}
+	return order, tags
+}
+
+// checkDMARC checks the DMARC record of domain, or of its organizational
+// domain if domain doesn't have one. The DMARCPolicy, DMARCAlignment and
+// DMARCReporting checks report on the record's policy, alignment modes and
+// report URIs.
+func (c *Checker) checkDMARC(ctx context.Context, domain string) (*Result, *DMARCRecord) {
+	result := MakeResult(DMARC)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	records, err := c.lookupDMARC(ctx, domain)
+	foundAt := domain
+	if err == nil && len(records) == 0 {
+		if org, orgErr := publicsuffix.EffectiveTLDPlusOne(domain); orgErr == nil && org != domain {
+			records, err = c.lookupDMARC(ctx, org)
+			foundAt = org
+		}
+	}
+	if err != nil && (expired(ctx) || isTimeout(err)) {
+		return result.Error("Timed out looking up the DMARC record."), nil
+	}
+	if err != nil {
+		return result.Error("Couldn't look up the DMARC record of %s: %v.", domain, err), nil
+	}
+	if len(records) == 0 {
+		return result.Warning("No DMARC record found at _dmarc.%s, so receivers won't act on mail from it that fails SPF and DKIM.", domain), nil
+	}
+	if len(records) > 1 {
+		return result.Failure("Exactly 1 DMARC record required at _dmarc.%s, found %d.", foundAt, len(records)), nil
+	}
+	order, tags := parseDMARCTags(records[0])
+	if len(order) == 0 || order[0] != "v" || tags["v"] != "DMARC1" {
+		return result.Failure("DMARC record at _dmarc.%s must start with v=DMARC1.", foundAt), nil
+	}
+	record := &DMARCRecord{
+		Domain:          foundAt,
+		Policy:          strings.ToLower(tags["p"]),
+		SubdomainPolicy: strings.ToLower(tags["sp"]),
+		DKIMAlignment:   strings.ToLower(tags["adkim"]),
+		SPFAlignment:    strings.ToLower(tags["aspf"]),
+		Percent:         100,
+		AggregateURIs:   splitDMARCURIs(tags["rua"]),
+		FailureURIs:     splitDMARCURIs(tags["ruf"]),
+	}
+	result.addCheck(checkDMARCPolicy(record, tags, foundAt != domain))
+	result.addCheck(checkDMARCAlignment(record))
+	result.addCheck(checkDMARCReporting(record))
+	return result.Success(), record
+}
+
+// checkDMARCPolicy checks the p, sp and pct tags of a DMARC record, and fills
+// in record.Percent. inherited is whether the record is the organizational
+// domain's, in which case sp applies.
+func checkDMARCPolicy(record *DMARCRecord, tags map[string]string, inherited bool) *Result {
+	result := MakeResult(DMARCPolicy)
+	if _, ok := dmarcPolicies[record.Policy]; !ok {
+		return result.Failure("DMARC policy p=%q must be one of \"none\", \"quarantine\" or \"reject\".", tags["p"])
+	}
+	if record.SubdomainPolicy != "" {
+		if _, ok := dmarcPolicies[record.SubdomainPolicy]; !ok {
+			return result.Failure("DMARC subdomain policy sp=%q must be one of \"none\", \"quarantine\" or \"reject\".", tags["sp"])
+		}
+	}
+	if pct, ok := tags["pct"]; ok {
+		n, err := strconv.Atoi(pct)
+		if err != nil || n < 0 || n > 100 {
+			return result.Failure("DMARC pct=%q must be a percentage from 0 to 100.", pct)
+		}
+		record.Percent = n
+	}
+	policy := record.Policy
+	if inherited && record.SubdomainPolicy != "" {
+		policy = record.SubdomainPolicy
+	}
+	if policy == "none" {
+		result.Warning("DMARC policy is \"none\", so receivers only report mail that fails DMARC, rather than quarantining or rejecting it.")
+	} else if record.Percent < 100 {
+		result.Warning("DMARC policy %q only applies to %d%% of mail that fails DMARC.", policy, record.Percent)
+	}
+	if !inherited && record.SubdomainPolicy != "" && dmarcPolicies[record.SubdomainPolicy] < dmarcPolicies[record.Policy] {
+		result.Warning("DMARC subdomain policy %q is weaker than the policy %q, so mail from subdomains is easier to spoof.", record.SubdomainPolicy, record.Policy)
+	}
+	return result.Success()
+}
+
+// checkDMARCAlignment checks the adkim and aspf tags of a DMARC record, and
+// fills in their default, relaxed alignment.
+func checkDMARCAlignment(record *DMARCRecord) *Result {
+	result := MakeResult(DMARCAlignment)
+	for _, mode := range []struct {
+		tag   string
+		value *string
+	}{{"adkim", &record.DKIMAlignment}, {"aspf", &record.SPFAlignment}} {
+		switch *mode.value {
+		case "":
+			*mode.value = "r"
+		case "r", "s":
+		default:
+			result.Failure("DMARC %s=%q must be \"r\" (relaxed) or \"s\" (strict).", mode.tag, *mode.value)
+		}
+	}
+	return result.Success()
+}
+
+// checkDMARCReporting checks the rua and ruf URIs of a DMARC record.
+func checkDMARCReporting(record *DMARCRecord) *Result {
+	result := MakeResult(DMARCReporting)
+	for _, tag := range []struct {
+		name string
+		uris []string
+	}{{"rua", record.AggregateURIs}, {"ruf", record.FailureURIs}} {
+		invalid := []string{}
+		for _, uri := range tag.uris {
+			if !validDMARCURI(uri) {
+				invalid = append(invalid, uri)
+			}
+		}
+		if len(invalid) > 0 {
+			result.Failure("These %s URIs in your DMARC record aren't valid: %s.", tag.name, strings.Join(invalid, ", "))
+		}
+	}
+	if len(record.AggregateURIs) == 0 {
+		result.Info("DMARC record doesn't specify rua, so you won't receive aggregate reports of mail that fails DMARC.")
+	}
+	return result.Success()
+}
+
+func splitDMARCURIs(value string) []string {
+	if value == "" {
+		return nil
+	}
+	uris := []string{}
+	for _, uri := range strings.Split(value, ",") {
+		uris = append(uris, strings.TrimSpace(uri))
+	}
+	return uris
+}
+
+// validDMARCURI returns whether uri is a valid report URI: a mailto: URI
+// with an email address, or another absolute URI, optionally followed by a
+// maximum report size like "!10m" (RFC 7489, section 6.2).
+func validDMARCURI(uri string) bool {
+	if i := strings.LastIndex(uri, "!"); i >= 0 {
+		size := strings.TrimRight(uri[i+1:], "kmgtKMGT")
+		if _, err := strconv.ParseUint(size, 10, 64); err != nil || len(uri[i+1:])-len(size) > 1 {
+			return false
+		}
+		uri = uri[:i]
+	}
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" {
+		return false
+	}
+	if strings.ToLower(u.Scheme) == "mailto" {
+		at := strings.LastIndex(u.Opaque, "@")
+		return at > 0 && at < len(u.Opaque)-1
+	}
+	return u.Host != "" || u.Opaque != ""
+}
@@ -0,0 +1,90 @@
+package checker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCheckDMARC(t *testing.T) {
+	c := spfChecker(map[string][]string{
+		"_dmarc.example.com":       {"v=DMARC1; p=reject; rua=mailto:dmarc@example.com,mailto:reports@example.net!10m; adkim=s"},
+		"_dmarc.none.example":      {"v=DMARC1; p=none; rua=mailto:dmarc@none.example"},
+		"_dmarc.pct.example":       {"v=DMARC1; p=quarantine; pct=50; rua=mailto:dmarc@pct.example"},
+		"_dmarc.weaksub.example":   {"v=DMARC1; p=reject; sp=none; rua=mailto:dmarc@weaksub.example"},
+		"_dmarc.norua.example":     {"v=DMARC1; p=reject"},
+		"_dmarc.badpolicy.example": {"v=DMARC1; p=block"},
+		"_dmarc.badpct.example":    {"v=DMARC1; p=reject; pct=150"},
+		"_dmarc.badalign.example":  {"v=DMARC1; p=reject; aspf=x; rua=mailto:dmarc@badalign.example"},
+		"_dmarc.badrua.example":    {"v=DMARC1; p=reject; rua=dmarc@badrua.example"},
+		"_dmarc.multiple.example":  {"v=DMARC1; p=reject", "v=DMARC1; p=none"},
+		"_dmarc.order.example":     {"v=DMARC1 p=reject"},
+		"_dmarc.org.example":       {"v=DMARC1; p=reject; sp=quarantine; rua=mailto:dmarc@org.example"},
+	})
+	tests := []struct {
+		domain string
+		status Status
+	}{
+		{"example.com", Success},
+		{"mail.org.example", Success},
+		{"norua.example", Info},
+		{"none.example", Warning},
+		{"pct.example", Warning},
+		{"weaksub.example", Warning},
+		{"nonexistent.example", Warning},
+		{"badpolicy.example", Failure},
+		{"badpct.example", Failure},
+		{"badalign.example", Failure},
+		{"badrua.example", Failure},
+		{"multiple.example", Failure},
+		{"order.example", Failure},
+	}
+	for _, test := range tests {
+		result, _ := c.checkDMARC(context.Background(), test.domain)
+		if result.Status != test.status {
+			t.Errorf("checkDMARC(%s) = %v, want status %s", test.domain, result, statusText[test.status])
+		}
+	}
+
+	result, record := c.checkDMARC(context.Background(), "example.com")
+	expected := &DMARCRecord{
+		Domain:        "example.com",
+		Policy:        "reject",
+		DKIMAlignment: "s",
+		SPFAlignment:  "r",
+		Percent:       100,
+		AggregateURIs: []string{"mailto:dmarc@example.com", "mailto:reports@example.net!10m"},
+	}
+	if !reflect.DeepEqual(record, expected) {
+		t.Errorf("Expected DMARC record %+v, got %+v", expected, record)
+	}
+	for _, name := range []string{DMARCPolicy, DMARCAlignment, DMARCReporting} {
+		if result.Checks[name] == nil {
+			t.Errorf("Expected %s check in %v", name, result)
+		}
+	}
+
+	// Subdomains without a record of their own inherit the organizational
+	// domain's, and its subdomain policy.
+	_, record = c.checkDMARC(context.Background(), "mail.org.example")
+	if record == nil || record.Domain != "org.example" || record.SubdomainPolicy != "quarantine" {
+		t.Errorf("Expected the organizational domain's record, got %+v", record)
+	}
+}
+
+func TestCheckDomainDMARC(t *testing.T) {
+	c := spfChecker(map[string][]string{"_dmarc.domain": {"v=DMARC1; p=reject; rua=mailto:dmarc@domain"}})
+	c.lookupMXOverride = mockLookupMX
+	c.CheckHostname = mockCheckHostname
+	c.checkMTASTSOverride = mockCheckMTASTS
+	c.lookupCAAOverride = mockLookupCAA
+	c.lookupDNSSECOverride = mockLookupDNSSEC
+	if result := c.CheckDomain("domain", nil); result.ExtraResults[DMARC] != nil || result.DMARC != nil {
+		t.Error("Expected DMARC not to be checked by default")
+	}
+	c.CheckDMARC = true
+	result := c.CheckDomain("domain", nil)
+	if r := result.ExtraResults[DMARC]; r == nil || r.Status != Success || result.DMARC == nil || result.DMARC.Policy != "reject" {
+		t.Errorf("Expected a valid DMARC record, got %v and %+v", r, result.DMARC)
+	}
+}
@@ -0,0 +1,278 @@
+package checker
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNS record types which aren't supported by dnsmessage.
+const (
+	dnsTypeCAA  uint16 = 257
+	dnsTypeTLSA uint16 = 52
+)
+
+const dnsTypeCNAME = uint16(dnsmessage.TypeCNAME)
+
+const dnsRCodeNameError = 3 // NXDOMAIN
+
+// dnsRecord is a resource record from the answer section of a DNS response.
+type dnsRecord struct {
+	// Name is the owner name of the record, in lower case with a trailing dot.
+	Name string
+	Type uint16
+	// Data is the record's data, unless it's a CNAME or MX record.
+	Data []byte
+	// Target is the canonical name of a CNAME record, or the mail exchange of
+	// an MX record, in lower case with a trailing dot.
+	Target string
+	// Preference is the preference of an MX record.
+	Preference uint16
+}
+
+// dnsResponse is the answer to a DNS query.
+type dnsResponse struct {
+	RCode   int
+	Records []dnsRecord
+	// Authenticated is whether the resolver validated the answer with
+	// DNSSEC (the AD bit).
+	Authenticated bool
+	// Truncated is whether the response didn't fit in a UDP message (the TC
+	// bit), in which case the query should be retried over TCP.
+	Truncated bool
+}
+
+// recordsOfType returns the data of each answer record of type qtype.
+// Answers can contain other types of records, e.g. CNAMEs.
+func (r dnsResponse) recordsOfType(qtype uint16) [][]byte {
+	data := [][]byte{}
+	for _, record := range r.Records {
+		if record.Type == qtype {
+			data = append(data, record.Data)
+		}
+	}
+	return data
+}
+
+// systemNameservers returns the nameservers configured in /etc/resolv.conf.
+func systemNameservers() []string {
+	nameservers := []string{}
+	f, err := os.Open("/etc/resolv.conf")
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				nameservers = append(nameservers, net.JoinHostPort(fields[1], "53"))
+			}
+		}
+	}
+	if len(nameservers) == 0 {
+		nameservers = append(nameservers, "127.0.0.1:53")
+	}
+	return nameservers
+}
+
+// dnsNameservers returns the addresses of the resolvers that queryDNS tries,
+// in order: those of c.Resolvers, or the system's if it's nil.
+func (c *Checker) dnsNameservers() []string {
+	if c.Resolvers == nil {
+		return systemNameservers()
+	}
+	nameservers := []string{}
+	for _, res := range c.Resolvers.resolvers {
+		nameservers = append(nameservers, res.address)
+	}
+	return nameservers
+}
+
+// queryDNS sends a recursive query for records of type qtype at name to each
+// of c's nameservers in turn, until one of them answers.
+// It's used for record types that the net package can't look up, like CAA.
+func (c *Checker) queryDNS(ctx context.Context, name string, qtype uint16) (dnsResponse, error) {
+	return c.queryNameservers(ctx, c.dnsNameservers(), name, qtype)
+}
+
+// queryNameservers sends a recursive query for records of type qtype at name to
+// each of servers in turn, until one of them answers.
+func (c *Checker) queryNameservers(ctx context.Context, servers []string, name string, qtype uint16) (dnsResponse, error) {
+	err := errors.New("no nameservers")
+	for _, server := range servers {
+		var response dnsResponse
+		response, err = c.queryNameserver(ctx, server, name, qtype)
+		if err == nil {
+			return response, nil
+		}
+		if expired(ctx) {
+			break
+		}
+	}
+	return dnsResponse{}, err
+}
+
+// queryNameserver sends a recursive query for records of type qtype at name to
+// server over UDP, and retries over TCP if the response is truncated. The
+// query is given c's timeout, and fails early if ctx expires.
+func (c *Checker) queryNameserver(ctx context.Context, server, name string, qtype uint16) (dnsResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	id := uint16(rand.Uint32())
+	query, err := buildDNSQuery(id, name, qtype)
+	if err != nil {
+		return dnsResponse{}, err
+	}
+	msg, err := c.exchangeDNS(ctx, "udp", server, query)
+	if err != nil {
+		return dnsResponse{}, err
+	}
+	response, err := parseDNSResponse(msg, id)
+	if err == nil && response.Truncated {
+		msg, err = c.exchangeDNS(ctx, "tcp", server, query)
+		if err != nil {
+			return dnsResponse{}, err
+		}
+		response, err = parseDNSResponse(msg, id)
+	}
+	if err != nil {
+		return response, err
+	}
+	if response.RCode != 0 && response.RCode != dnsRCodeNameError {
+		return response, fmt.Errorf("lookup %s failed with rcode %d", name, response.RCode)
+	}
+	return response, nil
+}
+
+func buildDNSQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	// Setting AD asks the resolver whether the answer was validated with DNSSEC
+	// (RFC 6840, section 5.7).
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true, AuthenticData: true})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	err = b.Question(dnsmessage.Question{
+		Name:  qname,
+		Type:  dnsmessage.Type(qtype),
+		Class: dnsmessage.ClassINET,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// dialDNS connects to the DNS server at address, from c.LocalAddr (but any
+// port), once c.ConnectionLimiter has a connection available.
+func (c *Checker) dialDNS(ctx context.Context, network, address string) (net.Conn, error) {
+	var dialer net.Dialer
+	if local, ok := c.LocalAddr.(*net.TCPAddr); ok {
+		if network == "udp" {
+			dialer.LocalAddr = &net.UDPAddr{IP: local.IP, Zone: local.Zone}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: local.IP, Zone: local.Zone}
+		}
+	}
+	return c.ConnectionLimiter.dialContext(ctx, dialer.DialContext, network, address)
+}
+
+func (c *Checker) exchangeDNS(ctx context.Context, network, server string, query []byte) ([]byte, error) {
+	conn, err := c.dialDNS(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if network == "tcp" {
+		// Messages over TCP are prefixed with their length.
+		length := make([]byte, 2)
+		binary.BigEndian.PutUint16(length, uint16(len(query)))
+		query = append(length, query...)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	if network == "tcp" {
+		length := make([]byte, 2)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return nil, err
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(length))
+		_, err := io.ReadFull(conn, msg)
+		return msg, err
+	}
+	msg := make([]byte, 65535)
+	n, err := conn.Read(msg)
+	return msg[:n], err
+}
+
+// parseDNSResponse parses the header and answer section of a DNS response
+// with ID id. The answer section isn't parsed if the response is truncated.
+func parseDNSResponse(msg []byte, id uint16) (dnsResponse, error) {
+	response := dnsResponse{}
+	var p dnsmessage.Parser
+	header, err := p.Start(msg)
+	if err != nil {
+		return response, fmt.Errorf("invalid DNS response: %v", err)
+	}
+	if header.ID != id {
+		return response, fmt.Errorf("DNS response ID doesn't match query")
+	}
+	response.RCode = int(header.RCode)
+	response.Authenticated = header.AuthenticData
+	response.Truncated = header.Truncated
+	if header.Truncated {
+		return response, nil
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return response, fmt.Errorf("invalid DNS response: %v", err)
+	}
+	for {
+		h, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			return response, nil
+		}
+		if err != nil {
+			return response, fmt.Errorf("invalid DNS response: %v", err)
+		}
+		record := dnsRecord{Name: strings.ToLower(h.Name.String()), Type: uint16(h.Type)}
+		if h.Type == dnsmessage.TypeCNAME {
+			cname, err := p.CNAMEResource()
+			if err != nil {
+				return response, fmt.Errorf("invalid DNS response: %v", err)
+			}
+			record.Target = strings.ToLower(cname.CNAME.String())
+		} else if h.Type == dnsmessage.TypeMX {
+			mx, err := p.MXResource()
+			if err != nil {
+				return response, fmt.Errorf("invalid DNS response: %v", err)
+			}
+			record.Target = strings.ToLower(mx.MX.String())
+			record.Preference = mx.Pref
+		} else {
+			data, err := p.UnknownResource()
+			if err != nil {
+				return response, fmt.Errorf("invalid DNS response: %v", err)
+			}
+			record.Data = data.Data
+		}
+		response.Records = append(response.Records, record)
+	}
+}
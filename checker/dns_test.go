@@ -0,0 +1,145 @@
+package checker
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveDNS answers a single DNS query on conn with an answer section
+// containing one record with type qtype and data.
+func serveDNS(t *testing.T, conn net.PacketConn, qtype uint16, data []byte) {
+	query := make([]byte, 512)
+	n, addr, err := conn.ReadFrom(query)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	query = query[:n]
+	response := append([]byte{}, query[:12]...)
+	response[2] |= 0x80 // QR
+	binary.BigEndian.PutUint16(response[6:8], 1)
+	response = append(response, query[12:]...)
+	// Compression pointer to the question name, type, class, TTL.
+	response = append(response, 0xc0, 12)
+	response = append(response, byte(qtype>>8), byte(qtype), 0, 1, 0, 0, 0, 60)
+	response = append(response, byte(len(data)>>8), byte(len(data)))
+	response = append(response, data...)
+	if _, err := conn.WriteTo(response, addr); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueryDNS(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	data := append([]byte{0, 5}, []byte("issueletsencrypt.org")...)
+	go serveDNS(t, conn, dnsTypeCAA, data)
+
+	// Queries go to c.Resolvers, from c.LocalAddr, and count towards
+	// c.ConnectionLimiter.
+	limiter := MakeConnectionLimiter(1)
+	c := Checker{
+		Timeout:           time.Second,
+		Resolvers:         MakeResolvers(conn.LocalAddr().String()),
+		LocalAddr:         &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		ConnectionLimiter: limiter,
+	}
+	response, err := c.queryDNS(context.Background(), "example.com", dnsTypeCAA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limiter.InFlight() != 0 {
+		t.Errorf("Expected the query's connection to be released, got %d in flight", limiter.InFlight())
+	}
+	records := response.recordsOfType(dnsTypeCAA)
+	if len(records) != 1 || string(records[0]) != string(data) {
+		t.Errorf("Expected a single CAA record, got %v", response)
+	}
+	// serveDNS echoes the query's AD bit, which asks for the DNSSEC status.
+	if !response.Authenticated {
+		t.Errorf("Expected the query to ask whether the answer was validated with DNSSEC")
+	}
+}
+
+func TestParseDNSResponseCNAMEs(t *testing.T) {
+	header := []byte{0, 1, 0x81, 0x80, 0, 1, 0, 3, 0, 0, 0, 0}
+	msg := append(header, []byte("\x02mx\x07Example\x03com\x00\x00\x01\x00\x01")...)
+	// mx.example.com. CNAME mx.net.
+	msg = append(msg, 0xc0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 8)
+	msg = append(msg, []byte("\x02mx\x03net\x00")...)
+	// mx.net. CNAME mx.example.com., both compressed.
+	target := len(msg) - 8
+	msg = append(msg, 0xc0, byte(target), 0, 5, 0, 1, 0, 0, 0, 60, 0, 2, 0xc0, 12)
+	msg = append(msg, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 192, 0, 2, 1)
+
+	response, err := parseDNSResponse(msg, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct{ name, target string }{
+		{"mx.example.com.", "mx.net."},
+		{"mx.net.", "mx.example.com."},
+		{"mx.example.com.", ""},
+	}
+	if len(response.Records) != len(expected) {
+		t.Fatalf("Expected %d records, got %v", len(expected), response.Records)
+	}
+	for i, record := range response.Records {
+		if record.Name != expected[i].name || record.Target != expected[i].target {
+			t.Errorf("Expected record %d to be %v, got %v", i, expected[i], record)
+		}
+	}
+
+	// A pointer must point backwards.
+	loop := append([]byte{}, header...)
+	loop[5], loop[7] = 0, 1
+	loop = append(loop, 0xc0, 12)
+	if _, err := parseDNSResponse(loop, 1); err == nil {
+		t.Error("Expected a looping compression pointer to fail to parse")
+	}
+}
+
+func TestParseDNSResponseErrors(t *testing.T) {
+	if _, err := parseDNSResponse([]byte{0, 1}, 1); err == nil {
+		t.Error("Expected short response to fail to parse")
+	}
+	header := []byte{0, 1, 0x80, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+	if _, err := parseDNSResponse(header, 2); err == nil {
+		t.Error("Expected mismatched ID to fail to parse")
+	}
+	if _, err := parseDNSResponse(header, 1); err == nil {
+		t.Error("Expected response with missing answer to fail to parse")
+	}
+	// A question without its type and class.
+	question := []byte{0, 1, 0x80, 0, 0, 1, 0, 0, 0, 0, 0, 0, 2, 'm', 'x', 0}
+	for i := len(question) - 3; i <= len(question); i++ {
+		if _, err := parseDNSResponse(question[:i], 1); err == nil {
+			t.Errorf("Expected response truncated at %d bytes to fail to parse", i)
+		}
+	}
+}
+
+func TestQueryDNSContext(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The server never answers.
+	c := Checker{Timeout: time.Minute, Resolvers: MakeResolvers(conn.LocalAddr().String())}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.queryDNS(ctx, "example.com", dnsTypeCAA); err == nil {
+		t.Error("Expected the query to fail when ctx expires")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the query to stop when ctx expired, took %v", elapsed)
+	}
+}
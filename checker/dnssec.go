@@ -0,0 +1,88 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSSECStatus is whether a DNS answer was validated with DNSSEC, according
+// to the resolver that answered (its AD bit). It's only as trustworthy as the
+// resolver, and the path to it, e.g. a validating resolver on localhost.
+type DNSSECStatus string
+
+// Values for DNSSECStatus.
+const (
+	// DNSSECValidated means the resolver validated the answer, including an
+	// answer that the name doesn't exist.
+	DNSSECValidated DNSSECStatus = "validated"
+	// DNSSECUnvalidated means the resolver answered, but didn't validate the
+	// answer, e.g. because the zone isn't signed. Such answers can be spoofed.
+	DNSSECUnvalidated DNSSECStatus = "unvalidated"
+	// DNSSECUnknown means the lookup failed.
+	DNSSECUnknown DNSSECStatus = "unknown"
+)
+
+func dnssecStatus(authenticated bool, err error) DNSSECStatus {
+	switch {
+	case err != nil:
+		return DNSSECUnknown
+	case authenticated:
+		return DNSSECValidated
+	default:
+		return DNSSECUnvalidated
+	}
+}
+
+// DNSSECInfo records whether the DNS answers that a domain's results depend
+// on were validated with DNSSEC.
+type DNSSECInfo struct {
+	// MX is the status of the domain's MX records.
+	MX DNSSECStatus `json:"mx"`
+	// MTASTS is the status of the domain's MTA-STS TXT record, at
+	// _mta-sts.<domain>. It's empty in QuickMode.
+	MTASTS DNSSECStatus `json:"mta_sts,omitempty"`
+	// TLSRPT is the status of the domain's TLS reporting (RFC 8460) TXT
+	// record, at _smtp._tls.<domain>. It's empty in QuickMode.
+	TLSRPT DNSSECStatus `json:"tls_rpt,omitempty"`
+	// TLSA is the status of the TLSA records of each MX hostname that was
+	// checked with DANE.
+	TLSA map[string]DNSSECStatus `json:"tlsa,omitempty"`
+}
+
+// lookupDNSSEC returns the DNSSEC status of the answer to a query for records
+// of type qtype at name, asked of the system's nameservers, which the records
+// that aren't looked up with c.Resolvers are looked up with.
+func (c *Checker) lookupDNSSEC(ctx context.Context, name string, qtype uint16) DNSSECStatus {
+	if c.lookupDNSSECOverride != nil {
+		return c.lookupDNSSECOverride(name, qtype)
+	}
+	response, err := c.queryNameservers(ctx, systemNameservers(), name, qtype)
+	return dnssecStatus(response.Authenticated, err)
+}
+
+// checkDNSSEC collects the DNSSEC status of domain's MX records, mx, as
+// returned by lookupMX, and of the TLSA records looked up for
+// hostnameResults. Outside QuickMode, it also looks up the status of domain's
+// MTA-STS and TLS-RPT TXT records.
+func (c *Checker) checkDNSSEC(ctx context.Context, domain string, mx DNSSECStatus, hostnameResults map[string]HostnameResult) *DNSSECInfo {
+	ctx, span := c.tracer().Start(ctx, "dnssec")
+	defer span.End()
+	info := &DNSSECInfo{MX: mx}
+	span.SetAttribute("mx", string(info.MX))
+	if !c.QuickMode {
+		info.MTASTS = c.lookupDNSSEC(ctx, fmt.Sprintf("_mta-sts.%s", domain), uint16(dnsmessage.TypeTXT))
+		info.TLSRPT = c.lookupDNSSEC(ctx, fmt.Sprintf("_smtp._tls.%s", domain), uint16(dnsmessage.TypeTXT))
+	}
+	for hostname, h := range hostnameResults {
+		if h.TLSADNSSEC == "" {
+			continue
+		}
+		if info.TLSA == nil {
+			info.TLSA = make(map[string]DNSSECStatus)
+		}
+		info.TLSA[hostname] = h.TLSADNSSEC
+	}
+	return info
+}
@@ -0,0 +1,105 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDNSSECStatus(t *testing.T) {
+	if status := dnssecStatus(true, nil); status != DNSSECValidated {
+		t.Errorf("Expected an authenticated answer to be validated, got %s", status)
+	}
+	if status := dnssecStatus(false, nil); status != DNSSECUnvalidated {
+		t.Errorf("Expected an unauthenticated answer to be unvalidated, got %s", status)
+	}
+	if status := dnssecStatus(true, errors.New("timeout")); status != DNSSECUnknown {
+		t.Errorf("Expected a failed lookup to be unknown, got %s", status)
+	}
+}
+
+func TestCheckDNSSEC(t *testing.T) {
+	var queries []string
+	c := Checker{
+		lookupMXOverride:    mockLookupMX,
+		CheckHostname:       mockCheckHostname,
+		checkMTASTSOverride: mockCheckMTASTS,
+		lookupCAAOverride:   mockLookupCAA,
+		lookupDNSSECOverride: func(name string, qtype uint16) DNSSECStatus {
+			queries = append(queries, name)
+			if qtype == uint16(dnsmessage.TypeMX) {
+				return DNSSECValidated
+			}
+			if strings.HasPrefix(name, "_smtp._tls.") {
+				return DNSSECUnknown
+			}
+			return DNSSECUnvalidated
+		},
+	}
+	result := c.CheckDomain("domain", nil)
+	if result.DNSSEC != nil || len(queries) > 0 {
+		t.Fatalf("Expected DNSSEC not to be looked up unless CheckDNSSEC is set, got %+v after %v", result.DNSSEC, queries)
+	}
+
+	c.CheckDNSSEC = true
+	result = c.CheckDomain("domain", nil)
+	expected := &DNSSECInfo{MX: DNSSECValidated, MTASTS: DNSSECUnvalidated, TLSRPT: DNSSECUnknown}
+	if !reflect.DeepEqual(result.DNSSEC, expected) {
+		t.Errorf("Expected DNSSEC status %+v, got %+v", expected, result.DNSSEC)
+	}
+	if !reflect.DeepEqual(queries, []string{"domain", "_mta-sts.domain", "_smtp._tls.domain"}) {
+		t.Errorf("Unexpected DNSSEC lookups %v", queries)
+	}
+
+	queries = nil
+	c.QuickMode = true
+	result = c.CheckDomain("domain", nil)
+	if result.DNSSEC == nil || result.DNSSEC.MX != DNSSECValidated || result.DNSSEC.MTASTS != "" {
+		t.Errorf("Expected only the MX records' status in QuickMode, got %+v", result.DNSSEC)
+	}
+	if !reflect.DeepEqual(queries, []string{"domain"}) {
+		t.Errorf("Expected only the MX records to be looked up in QuickMode, got %v", queries)
+	}
+}
+
+func TestCheckDNSSECCollectsTLSA(t *testing.T) {
+	c := Checker{lookupDNSSECOverride: func(string, uint16) DNSSECStatus { return DNSSECUnvalidated }}
+	hostnameResults := map[string]HostnameResult{
+		"mx1.example.com": {TLSADNSSEC: DNSSECValidated},
+		"mx2.example.com": {},
+	}
+	info := c.checkDNSSEC(context.Background(), "example.com", DNSSECValidated, hostnameResults)
+	if !reflect.DeepEqual(info.TLSA, map[string]DNSSECStatus{"mx1.example.com": DNSSECValidated}) {
+		t.Errorf("Expected the TLSA status of mx1.example.com only, got %v", info.TLSA)
+	}
+}
+
+func TestLookupMXReportsDNSSEC(t *testing.T) {
+	// The first resolver fails, so the status must come from the second.
+	failing := fakeResolver(t, dnsmessage.RCodeServerFailure, "")
+	defer failing.Close()
+	answering, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer answering.Close()
+	go serveDNS(t, answering, uint16(dnsmessage.TypeMX), []byte("\x00\x0a\x02mx\x00"))
+
+	c := Checker{
+		Timeout:     testTimeout,
+		Resolvers:   MakeResolvers(failing.LocalAddr().String(), answering.LocalAddr().String()),
+		CheckDNSSEC: true,
+	}
+	mxs, resolver, status, err := c.lookupMX(context.Background(), "example.com")
+	if err != nil || len(mxs) != 1 || mxs[0].Host != "mx." || mxs[0].Pref != 10 {
+		t.Fatalf("Expected the MX record from the second resolver, got %v, %v", mxs, err)
+	}
+	if resolver != answering.LocalAddr().String() || status != DNSSECValidated {
+		t.Errorf("Expected the answer of %s to be validated, got %s from %s", answering.LocalAddr(), status, resolver)
+	}
+}
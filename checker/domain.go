@@ -2,11 +2,17 @@ package checker
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"net/mail"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"golang.org/x/net/dns/dnsmessage"
 	"golang.org/x/net/idna"
 )
 
@@ -46,12 +52,246 @@ type DomainResult struct {
 	// The list of hostnames which will impact the Status of this result.
 	// It discards mailboxes that we can't connect to.
 	PreferredHostnames []string `json:"preferred_hostnames"`
+	// MX records of the domain, sorted by preference.
+	MXRecords []MXRecord `json:"mx_records,omitempty"`
+	// Summary of each MX host, in the order of MXRecords.
+	MXHosts []MXHost `json:"mx_hosts,omitempty"`
 	// Expected MX hostnames supplied by the caller of CheckDomain.
 	MxHostnames []string `json:"mx_hostnames,omitempty"`
 	// Result of MTA-STS checks
 	MTASTSResult *MTASTSResult `json:"mta_sts"`
+	// DMARC record of the domain, if it was checked and found.
+	DMARC *DMARCRecord `json:"dmarc,omitempty"`
+	// DKIM keys found at the selectors probed, if they were.
+	DKIM []DKIMKey `json:"dkim,omitempty"`
+	// Whether the DNS answers the results depend on were validated with
+	// DNSSEC, if Checker.CheckDNSSEC is set.
+	DNSSEC *DNSSECInfo `json:"dnssec,omitempty"`
 	// Extra global results
 	ExtraResults map[string]*Result `json:"extra_results,omitempty"`
+	// Source labels where the domain being checked came from, e.g.
+	// TopDomainsSource. It is set from Checker.Source.
+	Source string `json:"source,omitempty"`
+	// Whether the scan's deadline expired before all checks completed.
+	TimedOut bool `json:"timed_out,omitempty"`
+	// Information about the scan that produced this result.
+	Metadata *ScanMetadata `json:"metadata,omitempty"`
+	// scoreWeights are the weights used by Score, if the Checker specified
+	// them. They aren't serialized.
+	scoreWeights ScoreWeights
+	// err is the reason the domain's MX records couldn't be found, if they
+	// weren't (see Err). It isn't serialized.
+	err error
+	// resolver is the resolver that answered the MX lookup (see
+	// ScanMetadata.Resolver).
+	resolver string
+}
+
+// ScannerVersion identifies the checks performed by this version of the
+// checker. Bump it whenever check logic changes, so that stored results
+// can be compared meaningfully.
+const ScannerVersion = "1.0"
+
+// systemResolver labels lookups performed by the system's default resolver.
+const systemResolver = "system"
+
+// ScanMetadata records how and when a DomainResult was produced.
+type ScanMetadata struct {
+	ScannerVersion string    `json:"scanner_version"`
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"`
+	// Resolver that answered the MX lookup: "system" for the system's
+	// resolver, or the address of one of Checker.Resolvers. It's empty if
+	// none of them answered.
+	Resolver string `json:"resolver"`
+}
+
+// worstTLSVersion returns the oldest TLS version negotiated by any of the
+// domain's mailservers, or 0 if none negotiated TLS.
+func (d DomainResult) worstTLSVersion() uint16 {
+	var worst uint16
+	for _, hostnameResult := range d.HostnameResults {
+		version := hostnameResult.TLSVersion
+		if version != 0 && (worst == 0 || version < worst) {
+			worst = version
+		}
+	}
+	return worst
+}
+
+// supportsTLS13 returns whether any of the domain's mailservers negotiated
+// TLS 1.3.
+func (d DomainResult) supportsTLS13() bool {
+	for _, hostnameResult := range d.HostnameResults {
+		if hostnameResult.TLSVersion >= tls.VersionTLS13 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSCTs returns whether any of the domain's mailservers presented a
+// certificate with SCTs.
+func (d DomainResult) hasSCTs() bool {
+	for _, hostnameResult := range d.HostnameResults {
+		if hostnameResult.Certificate != nil && len(hostnameResult.Certificate.SCTs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// certificateIssuers returns the distinct issuers of the certificates
+// presented by the domain's mailservers, identified by organization (or common
+// name, if there isn't one).
+func (d DomainResult) certificateIssuers() []string {
+	seen := make(map[string]bool)
+	issuers := []string{}
+	for _, hostnameResult := range d.HostnameResults {
+		cert := hostnameResult.Certificate
+		if cert == nil {
+			continue
+		}
+		issuer := cert.Issuer
+		if len(cert.IssuerOrganization) > 0 {
+			issuer = strings.Join(cert.IssuerOrganization, ", ")
+		}
+		if issuer != "" && !seen[issuer] {
+			seen[issuer] = true
+			issuers = append(issuers, issuer)
+		}
+	}
+	sort.Strings(issuers)
+	return issuers
+}
+
+// connectionFailures returns the reasons the domain's mailservers couldn't be
+// connected to, one per mailserver that couldn't be.
+func (d DomainResult) connectionFailures() []ConnectionFailure {
+	failures := []ConnectionFailure{}
+	for _, hostnameResult := range d.HostnameResults {
+		if hostnameResult.ConnectionFailure != "" {
+			failures = append(failures, hostnameResult.ConnectionFailure)
+		}
+	}
+	return failures
+}
+
+// Reasons a domain's mailservers failed, besides CertificateFailures (see
+// AggregatedScan.FailureReasons).
+const (
+	// FailureUnreachable means none of the mailservers could be connected to.
+	FailureUnreachable = "unreachable"
+	// FailureNoSTARTTLS means a mailserver that could be connected to didn't
+	// support STARTTLS.
+	FailureNoSTARTTLS = "no_starttls"
+)
+
+// failureReasons returns the distinct reasons any of the domain's mailservers
+// failed, sorted: FailureUnreachable, FailureNoSTARTTLS, or the values of
+// CertificateFailure.
+func (d DomainResult) failureReasons() []string {
+	if len(d.HostnameResults) == 0 {
+		return nil
+	}
+	if !d.Reachable() {
+		return []string{FailureUnreachable}
+	}
+	seen := make(map[string]bool)
+	reasons := []string{}
+	add := func(reason string) {
+		if !seen[reason] {
+			seen[reason] = true
+			reasons = append(reasons, reason)
+		}
+	}
+	for _, hostnameResult := range d.HostnameResults {
+		if hostnameResult.Result == nil || !hostnameResult.couldConnect() {
+			continue
+		}
+		if !hostnameResult.couldSTARTTLS() {
+			add(FailureNoSTARTTLS)
+		}
+		for _, failure := range hostnameResult.CertificateFailures {
+			add(string(failure))
+		}
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+// Errors returned by DomainResult.Err.
+var (
+	// ErrNoMXRecords indicates that the domain's MX records couldn't be found.
+	ErrNoMXRecords = errors.New("no MX records found")
+	// ErrDNSFailure indicates that the domain's MX records couldn't be looked
+	// up, e.g. because the lookup timed out or the nameserver failed. Err
+	// returns errors wrapping it (use errors.Is).
+	ErrDNSFailure = errors.New("DNS lookup failed")
+	// ErrNoReachableMX indicates that none of the domain's mailservers
+	// accepted a connection.
+	ErrNoReachableMX = errors.New("could not connect to any mailserver")
+	// ErrAllHostsUnreachable is an alias of ErrNoReachableMX, so errors.Is
+	// matches either.
+	ErrAllHostsUnreachable = ErrNoReachableMX
+	// ErrTimedOut indicates that the scan's deadline expired before all checks
+	// completed.
+	ErrTimedOut = errors.New("timed out before all checks completed")
+)
+
+// Reachable returns true if at least one of the domain's mailservers accepted
+// a connection.
+func (d DomainResult) Reachable() bool {
+	for _, hostnameResult := range d.HostnameResults {
+		if hostnameResult.Result != nil && hostnameResult.couldConnect() {
+			return true
+		}
+	}
+	return false
+}
+
+// supportsSTARTTLS returns true if every one of the domain's reachable
+// mailservers supports STARTTLS, or if anyMX is set, any of them does. Returns
+// false if none are reachable.
+func (d DomainResult) supportsSTARTTLS(anyMX bool) bool {
+	reachable, supporting := 0, 0
+	for _, hostnameResult := range d.HostnameResults {
+		if hostnameResult.Result == nil || !hostnameResult.couldConnect() {
+			continue
+		}
+		reachable++
+		if hostnameResult.couldSTARTTLS() {
+			supporting++
+		}
+	}
+	if anyMX {
+		return supporting > 0
+	}
+	return reachable > 0 && supporting == reachable
+}
+
+// Err returns an error if the domain couldn't be checked at all, because its
+// name was invalid, its MX records couldn't be found, none of its mailservers
+// were reachable, or the scan timed out. Returns nil if the domain was checked, even if
+// its checks failed: see HostnameResult.Err for why a particular mailserver
+// couldn't be checked.
+func (d DomainResult) Err() error {
+	if d.TimedOut {
+		return ErrTimedOut
+	}
+	if d.Status == DomainError && d.Message != "" {
+		return errors.New(d.Message)
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if d.Reachable() {
+		return nil
+	}
+	if len(d.HostnameResults) == 0 {
+		return ErrNoMXRecords
+	}
+	return ErrNoReachableMX
 }
 
 // Class satisfies raven's Interface interface.
@@ -60,45 +300,235 @@ func (d DomainResult) Class() string {
 	return "extra"
 }
 
+// timedOut marks a result whose checks didn't all complete before the scan's
+// deadline.
+func (d DomainResult) timedOut() DomainResult {
+	d.TimedOut = true
+	d.Message = "Timed out before all checks completed."
+	return d.setStatus(DomainError)
+}
+
 func (d DomainResult) setStatus(status DomainStatus) DomainResult {
-	d.Status = DomainStatus(SetStatus(Status(d.Status), Status(status)))
+	if status > d.Status {
+		d.Status = status
+	}
 	return d
 }
 
-func lookupMXWithTimeout(domain string, timeout time.Duration) ([]*net.MX, error) {
-	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+// domainStatus converts the status of a hostname's checks to a DomainStatus.
+func domainStatus(status Status) DomainStatus {
+	switch status {
+	case Success, Info:
+		return DomainSuccess
+	case Warning:
+		return DomainWarning
+	case Failure:
+		return DomainFailure
+	}
+	return DomainError
+}
+
+// DomainFromInput extracts a mail domain from user input, which may be either a
+// bare domain or an email address (e.g. "user@example.com" or
+// "Name <user@example.com>"). The domain is lowercased and stripped of
+// surrounding whitespace and any trailing dot. Returns an error if the input
+// isn't a well-formed address, or doesn't contain a plausible domain name.
+func DomainFromInput(s string) (string, error) {
+	input := strings.TrimSpace(s)
+	domain := input
+	if strings.Contains(input, "@") {
+		addr, err := mail.ParseAddress(input)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a valid email address: %v", input, err)
+		}
+		domain = addr.Address[strings.LastIndex(addr.Address, "@")+1:]
+	}
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if err := validateDomainName(domain); err != nil {
+		return "", fmt.Errorf("%q is not a valid domain: %v", input, err)
+	}
+	return domain, nil
+}
+
+// validateDomainName returns an error unless domain is a syntactically valid,
+// fully qualified domain name. Internationalized domain names are allowed.
+func validateDomainName(domain string) error {
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return err
+	}
+	if len(ascii) > 253 {
+		return fmt.Errorf("name is longer than 253 characters")
+	}
+	labels := strings.Split(ascii, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("name must contain at least two labels")
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 {
+			return fmt.Errorf("labels must be between 1 and 63 characters")
+		}
+	}
+	return nil
+}
+
+func lookupMXWithTimeout(ctx context.Context, domain string, timeout time.Duration) ([]*net.MX, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	var r net.Resolver
 	return r.LookupMX(ctx, domain)
 }
 
-// lookupHostnames retrieves the MX hostnames associated with a domain.
-func (c *Checker) lookupHostnames(domain string) ([]string, error) {
-	domainASCII, err := idna.ToASCII(domain)
+// lookupMX retrieves the MX records associated with a domain, and returns
+// the resolver that answered (see ScanMetadata.Resolver) and, if
+// c.CheckDNSSEC is set, whether it validated them with DNSSEC.
+// The domain should already be in ASCII (A-label) form.
+func (c *Checker) lookupMX(ctx context.Context, domain string) ([]*net.MX, string, DNSSECStatus, error) {
+	ctx, span := c.tracer().Start(ctx, "dns.mx")
+	defer span.End()
+	mxs, resolver, dnssec, err := c.lookupMXUntraced(ctx, domain)
+	span.SetAttribute("resolver", resolver)
+	span.SetAttribute("mx_count", len(mxs))
 	if err != nil {
-		return nil, fmt.Errorf("domain name %s couldn't be converted to ASCII", domain)
+		span.SetAttribute("error", err.Error())
 	}
-	// Allow the Checker to mock DNS lookup.
+	return mxs, resolver, dnssec, err
+}
+
+// lookupMXUntraced performs lookupMX without recording a span.
+func (c *Checker) lookupMXUntraced(ctx context.Context, domain string) ([]*net.MX, string, DNSSECStatus, error) {
 	var mxs []*net.MX
+	var dnssec DNSSECStatus
+	var err error
+	resolver := systemResolver
+	lookup := func(address string) ([]*net.MX, error) {
+		if !c.CheckDNSSEC {
+			return c.lookupMXAt(ctx, address, domain)
+		}
+		var mxs []*net.MX
+		var err error
+		mxs, dnssec, err = c.queryMX(ctx, address, domain)
+		return mxs, err
+	}
+	// Allow the Checker to mock DNS lookup.
 	if c.lookupMXOverride != nil {
 		mxs, err = c.lookupMXOverride(domain)
+		if c.CheckDNSSEC {
+			dnssec = c.lookupDNSSEC(ctx, domain, uint16(dnsmessage.TypeMX))
+		}
+	} else if c.Resolvers != nil {
+		mxs, resolver, err = c.Resolvers.lookupMX(ctx, lookup)
+	} else if c.CheckDNSSEC {
+		// The system's resolver doesn't say whether it validated its answers,
+		// so its nameservers are queried directly.
+		mxs, _, err = MakeResolvers(systemNameservers()...).lookupMX(ctx, lookup)
 	} else {
-		mxs, err = lookupMXWithTimeout(domainASCII, c.timeout())
+		mxs, err = lookupMXWithTimeout(ctx, domain, c.timeout())
 	}
-	if err != nil || len(mxs) == 0 {
-		return nil, fmt.Errorf("No MX records found")
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		return nil, resolver, dnssec, ErrNoMXRecords
 	}
-	hostnames := make([]string, 0)
-	for _, mx := range mxs {
-		hostnames = append(hostnames, strings.ToLower(mx.Host))
+	if err != nil {
+		return nil, resolver, dnssec, fmt.Errorf("%w: %v", ErrDNSFailure, err)
+	}
+	if len(mxs) == 0 {
+		return nil, resolver, dnssec, ErrNoMXRecords
 	}
-	return hostnames, nil
+	return mxs, resolver, dnssec, nil
+}
+
+// checkHostnames concurrently checks each of a domain's hostnames, with at most
+// c.hostConcurrency() checks in flight. The results are in the same order as
+// hostnames. Hostnames that aren't checked before ctx expires are marked as
+// timed out.
+//
+// When performing the full set of checks, hostnames that resolve to the same
+// addresses are only connected to once, but their certificates are checked
+// against each hostname.
+//
+// With a DomainBudget, each hostname is only given its share of the time left
+// (see hostnameContext). Also returns true if any hostname's share expired
+// before its checks completed.
+func (c *Checker) checkHostnames(ctx context.Context, domain string, hostnames []string) ([]HostnameResult, bool) {
+	var groups [][]int
+	var addrs, cnames [][]string
+	if c.CheckHostname == nil {
+		groups, addrs, cnames = c.groupByEndpoint(ctx, hostnames)
+	} else {
+		// Custom checks may depend on the hostname, so check each one.
+		for i := range hostnames {
+			groups = append(groups, []int{i})
+		}
+	}
+	results := make([]HostnameResult, len(hostnames))
+	sem := make(chan struct{}, c.hostConcurrency())
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	timedOut := false
+	for g, group := range groups {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			for _, i := range group {
+				results[i] = c.timedOutHostnameResult(ctx, domain, hostnames[i])
+			}
+			continue
+		}
+		hostCtx, cancel := c.hostnameContext(ctx, len(groups)-g)
+		wg.Add(1)
+		go func(group []int) {
+			defer wg.Done()
+			defer cancel()
+			first := c.checkHostname(hostCtx, domain, hostnames[group[0]])
+			results[group[0]] = first
+			for _, i := range group[1:] {
+				if result, ok := first.forHostname(hostnames[i], c.RootCAs, c.SelfSignedPolicy); ok {
+					if _, checkedDANE := first.Checks[DANE]; checkedDANE {
+						c.checkDANEHostname(hostCtx, &result)
+					}
+					results[i] = result
+				} else {
+					results[i] = c.checkHostname(hostCtx, domain, hostnames[i])
+				}
+			}
+			if expired(hostCtx) {
+				mu.Lock()
+				timedOut = true
+				mu.Unlock()
+			}
+			<-sem
+		}(group)
+	}
+	wg.Wait()
+	for i := range addrs {
+		results[i].IPs = addrs[i]
+		results[i].CNAMEs = cnames[i]
+	}
+	return results, timedOut
+}
+
+// hostnameContext returns the context for checking the first of remaining
+// groups of hostnames. With a DomainBudget, the time left before ctx expires
+// is shared equally between the rounds of checks still to come (each checking
+// up to c.hostConcurrency() groups), plus one for the domain's checks after
+// them, so that slow hostnames can't use up the whole budget.
+func (c *Checker) hostnameContext(ctx context.Context, remaining int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if c.DomainBudget <= 0 || !ok {
+		return context.WithCancel(ctx)
+	}
+	concurrency := c.hostConcurrency()
+	rounds := (remaining+concurrency-1)/concurrency + 1
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(rounds))
 }
 
 // CheckDomain performs all associated checks for a particular domain.
 // First performs an MX lookup, then performs subchecks on each of the
 // resulting hostnames.
 //
+// Internationalized domain names are converted to their ASCII (punycode) form
+// for lookups, but DomainResult.Domain preserves the domain as it was given.
+//
 // The status of DomainResult is inherited from the check status of the MX
 // records with highest priority. This check succeeds only if the hostname
 // checks on the highest priority mailservers succeed.
@@ -106,30 +536,278 @@ func (c *Checker) lookupHostnames(domain string) ([]string, error) {
 //   `domain` is the mail domain to perform the lookup on.
 //   `expectedHostnames` is the list of expected hostnames.
 //     If `expectedHostnames` is nil, we don't validate the DNS lookup.
+//     Otherwise, the ExpectedMXs check fails on any unexpected or
+//     missing MX hostname (see checkExpectedMXs for how hostnames are
+//     matched), and the domain fails with DomainBadHostnameFailure if we
+//     connected to an unexpected one.
+//
+// If c.DomainCache is set, a cached result is returned if there is one.
 func (c *Checker) CheckDomain(domain string, expectedHostnames []string) DomainResult {
+	return c.CheckDomainContext(context.Background(), domain, expectedHostnames)
+}
+
+// CheckDomainContext is like CheckDomain, but stops checking when ctx expires.
+// The checks that completed are returned, those that didn't are marked as
+// timed out, and DomainResult.TimedOut is set.
+func (c *Checker) CheckDomainContext(ctx context.Context, domain string, expectedHostnames []string) DomainResult {
+	if c.DomainCache != nil {
+		if result, ok := c.DomainCache.Get(domain, expectedHostnames); ok {
+			// The cache may be shared by Checkers with different sources.
+			result.Source = c.Source
+			return result
+		}
+	}
+	return c.checkDomainForce(ctx, domain, expectedHostnames, nil)
+}
+
+// DomainOptions are options for CheckDomainOptions.
+type DomainOptions struct {
+	// ExpectedHostnames is the list of expected hostnames, as for CheckDomain.
+	ExpectedHostnames []string
+	// ExpectedTLSA specifies TLSA associations that each mailserver's
+	// certificate chain is checked against, e.g. to test a DANE deployment
+	// before publishing the records. The ExpectedTLSA check succeeds if every
+	// mailserver that negotiated TLS matches at least one of them. Chains
+	// aren't recorded in QuickMode, or kept by every Cache, so it results in
+	// an Error for mailservers checked that way.
+	// If empty, the check isn't performed.
+	ExpectedTLSA []TLSAAssociation
+	// DKIMSelectors specifies DKIM selectors to probe as well as
+	// Checker.DKIMSelectors, e.g. those the domain's owner knows it uses.
+	// If empty, the DKIM check is only performed if Checker.CheckDKIM is set.
+	DKIMSelectors []string
+}
+
+// CheckDomainOptions is like CheckDomainContext, with the options in opts.
+func (c *Checker) CheckDomainOptions(ctx context.Context, domain string, opts DomainOptions) DomainResult {
+	for _, a := range opts.ExpectedTLSA {
+		if err := a.validate(); err != nil {
+			return DomainResult{Domain: domain, Source: c.Source}.reportError(err)
+		}
+	}
+	result := c.CheckDomainContext(ctx, domain, opts.ExpectedHostnames)
+	if (len(opts.ExpectedTLSA) == 0 && len(opts.DKIMSelectors) == 0) || result.Status == DomainError {
+		return result
+	}
+	// The result may be cached, so its ExtraResults can't be modified.
+	extraResults := make(map[string]*Result, len(result.ExtraResults)+1)
+	for name, r := range result.ExtraResults {
+		extraResults[name] = r
+	}
+	if len(opts.ExpectedTLSA) > 0 {
+		extraResults[ExpectedTLSA] = checkExpectedTLSA(result.HostnameResults, result.PreferredHostnames, opts.ExpectedTLSA).escalate(c.strictChecks()).stamp(c.now())
+	}
+	if len(opts.DKIMSelectors) > 0 {
+		domainASCII, _ := idna.Lookup.ToASCII(domain)
+		dkimResult, keys := c.checkDKIM(ctx, domainASCII, c.dkimSelectors(opts.DKIMSelectors))
+		extraResults[DKIM] = dkimResult.escalate(c.strictChecks()).stamp(c.now())
+		result.DKIM = keys
+	}
+	result.ExtraResults = extraResults
+	return result
+}
+
+// RecheckFailed checks prior.Domain again, but only re-runs the checks that
+// didn't succeed in prior, carrying the others forward. Since the checks of a
+// mailserver share a connection, any mailserver with a check that didn't
+// succeed is checked again in full. If the domain's MX records have changed
+// since prior, the whole domain is checked again. The new result is cached.
+func (c *Checker) RecheckFailed(ctx context.Context, prior DomainResult) DomainResult {
+	return c.checkDomainForce(ctx, prior.Domain, prior.MxHostnames, &prior)
+}
+
+// CheckDomainForce is like CheckDomain, but always checks the domain, even if
+// c.DomainCache has a result for it. The new result is cached.
+func (c *Checker) CheckDomainForce(domain string, expectedHostnames []string) DomainResult {
+	return c.checkDomainForce(context.Background(), domain, expectedHostnames, nil)
+}
+
+func (c *Checker) checkDomainForce(ctx context.Context, domain string, expectedHostnames []string, prior *DomainResult) DomainResult {
+	start := c.now()
+	ctx, span := c.tracer().Start(ctx, "domain")
+	span.SetAttribute("domain", domain)
+	defer span.End()
+	if c.DomainBudget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.DomainBudget)
+		defer cancel()
+	}
+	result := c.checkDomain(ctx, domain, expectedHostnames, prior)
+	result.Metadata = &ScanMetadata{
+		ScannerVersion: ScannerVersion,
+		Start:          start,
+		End:            c.now(),
+		Resolver:       result.resolver,
+	}
+	span.SetAttribute("status", int(result.Status))
+	span.SetAttribute("timed_out", result.TimedOut)
+	if c.DomainCache != nil && !result.TimedOut {
+		c.DomainCache.Put(domain, expectedHostnames, result)
+	}
+	return result
+}
+
+// checkDomain checks domain. If prior is set, the checks that succeeded in it
+// are carried forward rather than run again; see RecheckFailed.
+func (c *Checker) checkDomain(ctx context.Context, domain string, expectedHostnames []string, prior *DomainResult) DomainResult {
 	result := DomainResult{
 		Domain:          domain,
+		Source:          c.Source,
 		MxHostnames:     expectedHostnames,
 		HostnameResults: make(map[string]HostnameResult),
+		scoreWeights:    c.ScoreWeights,
 		ExtraResults:    make(map[string]*Result),
 	}
+	if err := c.Validate(); err != nil {
+		return result.reportError(err)
+	}
+	domainASCII, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return result.reportError(fmt.Errorf("domain name %s is not a valid internationalized domain name: %v", domain, err))
+	}
 	// 1. Look up hostnames
 	// 2. Perform and aggregate checks from those hostnames.
 	// 3. Set a summary message.
-	hostnames, err := c.lookupHostnames(domain)
+	records, resolver, mxDNSSEC, err := c.lookupMXRecords(ctx, domainASCII)
+	result.resolver = resolver
+	if expired(ctx) {
+		return result.timedOut()
+	}
 	if err != nil {
+		result.err = err
 		return result.setStatus(DomainCouldNotConnect)
 	}
+	result.MXRecords = records
+	hostnames := make([]string, 0)
+	for _, record := range records {
+		hostnames = append(hostnames, record.Hostname)
+	}
+	if prior != nil && !prior.hasHostnames(hostnames) {
+		prior = nil
+	}
+	var recheck []string
+	for _, hostname := range hostnames {
+		if h, ok := prior.passedHostname(hostname); ok {
+			result.HostnameResults[hostname] = h
+		} else {
+			recheck = append(recheck, hostname)
+		}
+	}
+	hostnameResults, hostnamesTimedOut := c.checkHostnames(ctx, domainASCII, recheck)
+	strict := c.strictChecks()
+	for i, hostnameResult := range hostnameResults {
+		hostnameResult.Result = hostnameResult.Result.escalate(strict)
+		hostnameResult.stamp(c.now())
+		result.HostnameResults[recheck[i]] = hostnameResult
+	}
 	checkedHostnames := make([]string, 0)
 	for _, hostname := range hostnames {
-		hostnameResult := c.checkHostname(domain, hostname)
-		result.HostnameResults[hostname] = hostnameResult
-		if hostnameResult.couldConnect() {
+		if result.HostnameResults[hostname].couldConnect() {
 			checkedHostnames = append(checkedHostnames, hostname)
 		}
 	}
 	result.PreferredHostnames = checkedHostnames
-	result.MTASTSResult = c.checkMTASTS(domain, result.HostnameResults)
+	result.MXHosts = mxHosts(records, result.HostnameResults)
+	result.ExtraResults[MXRecords] = checkMXRecords(records, result.HostnameResults).stamp(c.now())
+	if expectedHostnames != nil {
+		result.ExtraResults[ExpectedMXs] = checkExpectedMXs(records, expectedHostnames).stamp(c.now())
+	}
+	// In QuickMode, only the mailservers are checked.
+	if !c.QuickMode {
+		if r, ok := prior.passed(CAA); ok {
+			result.ExtraResults[CAA] = r.copy()
+		} else if !expired(ctx) {
+			ctx, span := c.tracer().Start(ctx, "caa")
+			result.ExtraResults[CAA] = c.checkCAA(ctx, domainASCII, result.HostnameResults)
+			span.SetAttribute("status", result.ExtraResults[CAA].StatusText())
+			span.End()
+		} else {
+			result.ExtraResults[CAA] = timedOutResult(CAA)
+		}
+		result.ExtraResults[CAA].stamp(c.now())
+		if prior != nil && prior.MTASTSResult != nil && prior.MTASTSResult.Status.succeeded() {
+			mtaSTSResult := *prior.MTASTSResult
+			mtaSTSResult.Result = mtaSTSResult.Result.copy()
+			result.MTASTSResult = &mtaSTSResult
+		} else if !expired(ctx) {
+			mtaSTSCtx, span := c.tracer().Start(ctx, "mta-sts")
+			result.MTASTSResult = c.checkMTASTS(mtaSTSCtx, domainASCII, result.HostnameResults)
+			span.SetAttribute("status", result.MTASTSResult.StatusText())
+			span.End()
+		} else {
+			result.MTASTSResult = &MTASTSResult{Result: timedOutResult(MTASTS)}
+		}
+		result.MTASTSResult.stamp(c.now())
+		if r, ok := prior.passed(TLSRPT); ok {
+			result.ExtraResults[TLSRPT] = r.copy()
+		} else if !expired(ctx) {
+			tlsRPTCtx, span := c.tracer().Start(ctx, "tls-rpt")
+			result.ExtraResults[TLSRPT] = c.checkTLSRPT(tlsRPTCtx, domainASCII)
+			span.SetAttribute("status", result.ExtraResults[TLSRPT].StatusText())
+			span.End()
+		} else {
+			result.ExtraResults[TLSRPT] = timedOutResult(TLSRPT)
+		}
+		result.ExtraResults[TLSRPT].stamp(c.now())
+		if r, ok := prior.passed(SPF); ok && c.CheckSPF {
+			result.ExtraResults[SPF] = r.copy()
+		} else if c.CheckSPF && !expired(ctx) {
+			spfCtx, span := c.tracer().Start(ctx, "spf")
+			result.ExtraResults[SPF] = c.checkSPF(spfCtx, domainASCII).stamp(c.now())
+			span.SetAttribute("status", result.ExtraResults[SPF].StatusText())
+			span.End()
+		} else if c.CheckSPF {
+			result.ExtraResults[SPF] = timedOutResult(SPF).stamp(c.now())
+		}
+		if r, ok := prior.passed(DMARC); ok && c.CheckDMARC {
+			result.ExtraResults[DMARC] = r.copy()
+			result.DMARC = prior.DMARC
+		} else if c.CheckDMARC && !expired(ctx) {
+			dmarcCtx, span := c.tracer().Start(ctx, "dmarc")
+			dmarcResult, record := c.checkDMARC(dmarcCtx, domainASCII)
+			result.ExtraResults[DMARC] = dmarcResult.stamp(c.now())
+			result.DMARC = record
+			span.SetAttribute("status", dmarcResult.StatusText())
+			span.End()
+		} else if c.CheckDMARC {
+			result.ExtraResults[DMARC] = timedOutResult(DMARC).stamp(c.now())
+		}
+		if r, ok := prior.passed(DKIM); ok && c.CheckDKIM {
+			result.ExtraResults[DKIM] = r.copy()
+			result.DKIM = prior.DKIM
+		} else if c.CheckDKIM && !expired(ctx) {
+			dkimCtx, span := c.tracer().Start(ctx, "dkim")
+			dkimResult, keys := c.checkDKIM(dkimCtx, domainASCII, c.dkimSelectors(nil))
+			result.ExtraResults[DKIM] = dkimResult.stamp(c.now())
+			result.DKIM = keys
+			span.SetAttribute("status", dkimResult.StatusText())
+			span.End()
+		} else if c.CheckDKIM {
+			result.ExtraResults[DKIM] = timedOutResult(DKIM).stamp(c.now())
+		}
+		if r, ok := prior.passed(PolicyList); ok && len(c.PolicyLists) > 0 {
+			result.ExtraResults[PolicyList] = r.copy()
+		} else if len(c.PolicyLists) > 0 {
+			_, span := c.tracer().Start(ctx, "policylist")
+			result.ExtraResults[PolicyList] = checkPolicyLists(domainASCII, c.PolicyLists).stamp(c.now())
+			span.SetAttribute("status", result.ExtraResults[PolicyList].StatusText())
+			span.End()
+		}
+	}
+	if c.CheckDNSSEC && !expired(ctx) {
+		result.DNSSEC = c.checkDNSSEC(ctx, domainASCII, mxDNSSEC, result.HostnameResults)
+	}
+	for name, r := range result.ExtraResults {
+		result.ExtraResults[name] = r.escalate(strict)
+	}
+	if result.MTASTSResult != nil {
+		mtaSTSResult := *result.MTASTSResult
+		mtaSTSResult.Result = mtaSTSResult.Result.escalate(strict)
+		result.MTASTSResult = &mtaSTSResult
+	}
+	if expired(ctx) || hostnamesTimedOut {
+		result = result.timedOut()
+	}
 
 	// Derive Domain code from Hostname results.
 	if len(checkedHostnames) == 0 {
@@ -146,12 +824,42 @@ func (c *Checker) CheckDomain(domain string, expectedHostnames []string) DomainR
 		if expectedHostnames != nil && !PolicyMatches(hostname, expectedHostnames) {
 			return result.setStatus(DomainBadHostnameFailure)
 		}
-		result = result.setStatus(DomainStatus(hostnameResult.Status))
+		result = result.setStatus(domainStatus(hostnameResult.Status))
 	}
 	// result.setStatus(DomainStatus(result.ExtraResults["mta-sts"].Status))
 	return result
 }
 
+// hasHostnames returns whether d checked exactly hostnames.
+func (d *DomainResult) hasHostnames(hostnames []string) bool {
+	if len(d.HostnameResults) != len(hostnames) {
+		return false
+	}
+	for _, hostname := range hostnames {
+		if _, ok := d.HostnameResults[hostname]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// passedHostname returns d's result for hostname if it succeeded. d may be
+// nil.
+func (d *DomainResult) passedHostname(hostname string) (HostnameResult, bool) {
+	if d == nil || d.HostnameResults[hostname].Result == nil || !d.HostnameResults[hostname].Status.succeeded() {
+		return HostnameResult{}, false
+	}
+	return d.HostnameResults[hostname], true
+}
+
+// passed returns d's result for the check name if it succeeded. d may be nil.
+func (d *DomainResult) passed(name string) (*Result, bool) {
+	if d == nil || d.ExtraResults[name] == nil || !d.ExtraResults[name].Status.succeeded() {
+		return nil, false
+	}
+	return d.ExtraResults[name], true
+}
+
 // NewSampleDomainResult returns a sample successful domain result for testing.
 // This is exported so other packages can use it in their integration tests.
 func NewSampleDomainResult(domain string) DomainResult {
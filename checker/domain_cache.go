@@ -0,0 +1,145 @@
+package checker
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DomainCache is a size-bounded, least-recently-used cache of DomainResults,
+// keyed by normalized domain name (and the expected hostnames it was checked
+// against). Entries expire after a fixed TTL.
+// It is safe for concurrent use.
+type DomainCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Most recently used entries are at the front.
+	hits    uint64
+	misses  uint64
+}
+
+type domainCacheEntry struct {
+	key     string
+	result  DomainResult
+	expires time.Time
+}
+
+// MakeDomainCache creates a cache holding up to size results, each for at
+// most ttl.
+func MakeDomainCache(size int, ttl time.Duration) *DomainCache {
+	return &DomainCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// domainCacheKey normalizes domain, and distinguishes checks against different
+// expected hostnames (including none at all).
+func domainCacheKey(domain string, expectedHostnames []string) string {
+	key := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if expectedHostnames == nil {
+		return key
+	}
+	hostnames := append([]string{}, expectedHostnames...)
+	sort.Strings(hostnames)
+	return key + "|" + strings.Join(hostnames, ",")
+}
+
+// Get returns the cached result of checking domain against expectedHostnames,
+// if it hasn't expired.
+func (c *DomainCache) Get(domain string, expectedHostnames []string) (DomainResult, bool) {
+	key := domainCacheKey(domain, expectedHostnames)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if ok && time.Now().After(element.Value.(*domainCacheEntry).expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return DomainResult{}, false
+	}
+	c.hits++
+	c.order.MoveToFront(element)
+	return copyDomainResult(element.Value.(*domainCacheEntry).result), true
+}
+
+// Put caches the result of checking domain against expectedHostnames, evicting
+// the least recently used result if the cache is full.
+func (c *DomainCache) Put(domain string, expectedHostnames []string, result DomainResult) {
+	if c.size <= 0 {
+		return
+	}
+	key := domainCacheKey(domain, expectedHostnames)
+	entry := &domainCacheEntry{
+		key:     key,
+		result:  copyDomainResult(result),
+		expires: time.Now().Add(c.ttl),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*domainCacheEntry).key)
+	}
+}
+
+// Len returns the number of cached results, including expired ones that
+// haven't been evicted yet.
+func (c *DomainCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Hits returns the number of calls to Get that found a result.
+func (c *DomainCache) Hits() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits
+}
+
+// Misses returns the number of calls to Get that didn't find a result.
+func (c *DomainCache) Misses() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.misses
+}
+
+// copyDomainResult copies the maps of r and the Results in them, so that
+// callers can't modify a cached result (e.g. by adding to its ExtraResults, or
+// to the checks of one of its hostnames).
+func copyDomainResult(r DomainResult) DomainResult {
+	if r.HostnameResults != nil {
+		hostnameResults := make(map[string]HostnameResult, len(r.HostnameResults))
+		for hostname, result := range r.HostnameResults {
+			result.Result = result.Result.copy()
+			hostnameResults[hostname] = result
+		}
+		r.HostnameResults = hostnameResults
+	}
+	if r.ExtraResults != nil {
+		extraResults := make(map[string]*Result, len(r.ExtraResults))
+		for id, result := range r.ExtraResults {
+			extraResults[id] = result.copy()
+		}
+		r.ExtraResults = extraResults
+	}
+	return r
+}
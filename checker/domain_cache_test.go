@@ -0,0 +1,111 @@
+package checker
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDomainCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := MakeDomainCache(2, time.Minute)
+	cache.Put("a.com", nil, DomainResult{Domain: "a.com"})
+	cache.Put("b.com", nil, DomainResult{Domain: "b.com"})
+	cache.Get("a.com", nil)
+	cache.Put("c.com", nil, DomainResult{Domain: "c.com"})
+
+	if _, ok := cache.Get("b.com", nil); ok {
+		t.Error("Expected least recently used b.com to be evicted")
+	}
+	for _, domain := range []string{"a.com", "c.com"} {
+		if _, ok := cache.Get(domain, nil); !ok {
+			t.Errorf("Expected %s to be cached", domain)
+		}
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Expected 2 cached results, got %d", cache.Len())
+	}
+	if cache.Hits() != 3 || cache.Misses() != 1 {
+		t.Errorf("Expected 3 hits and 1 miss, got %d and %d", cache.Hits(), cache.Misses())
+	}
+}
+
+func TestDomainCacheExpires(t *testing.T) {
+	cache := MakeDomainCache(10, -time.Second)
+	cache.Put("a.com", nil, DomainResult{Domain: "a.com"})
+	if _, ok := cache.Get("a.com", nil); ok {
+		t.Error("Expected expired result not to be returned")
+	}
+	if cache.Len() != 0 {
+		t.Errorf("Expected expired result to be evicted, got %d cached results", cache.Len())
+	}
+}
+
+func TestDomainCacheKey(t *testing.T) {
+	cache := MakeDomainCache(10, time.Minute)
+	cache.Put("Example.COM.", nil, DomainResult{Domain: "example.com"})
+	if _, ok := cache.Get("example.com", nil); !ok {
+		t.Error("Expected domain names to be normalized")
+	}
+	if _, ok := cache.Get("example.com", []string{"mx.example.com"}); ok {
+		t.Error("Expected results for different expected hostnames to be cached separately")
+	}
+	cache.Put("example.com", []string{"b", "a"}, DomainResult{})
+	if _, ok := cache.Get("example.com", []string{"a", "b"}); !ok {
+		t.Error("Expected order of expected hostnames not to matter")
+	}
+}
+
+func TestDomainCacheCopiesResults(t *testing.T) {
+	cache := MakeDomainCache(10, time.Minute)
+	result := DomainResult{ExtraResults: map[string]*Result{}}
+	cache.Put("a.com", nil, result)
+	result.ExtraResults[PolicyList] = MakeResult(PolicyList)
+	cached, _ := cache.Get("a.com", nil)
+	cached.ExtraResults[CAA] = MakeResult(CAA)
+	cached, _ = cache.Get("a.com", nil)
+	if len(cached.ExtraResults) != 0 {
+		t.Errorf("Expected cached result to be unaffected by modifications, got %v", cached.ExtraResults)
+	}
+
+	result = DomainResult{
+		HostnameResults: map[string]HostnameResult{"mx.a.com": {Result: MakeResult("hostnames")}},
+		ExtraResults:    map[string]*Result{CAA: MakeResult(CAA)},
+	}
+	cache.Put("b.com", nil, result)
+	result.HostnameResults["mx.a.com"].addCheck(MakeResult(STARTTLS).Failure("No STARTTLS."))
+	result.ExtraResults[CAA].Failure("No CAA records.")
+	cached, _ = cache.Get("b.com", nil)
+	cached.HostnameResults["mx.a.com"].Result.Messages = append(cached.HostnameResults["mx.a.com"].Result.Messages, "changed")
+	cached.ExtraResults[CAA].Warning("Changed.")
+	cached, _ = cache.Get("b.com", nil)
+	if h := cached.HostnameResults["mx.a.com"]; h.Status != Success || len(h.Checks) != 0 || len(h.Messages) != 0 {
+		t.Errorf("Expected cached hostname result to be unaffected by modifications, got %v", h.Result)
+	}
+	if caa := cached.ExtraResults[CAA]; caa.Status != Success || len(caa.Messages) != 0 {
+		t.Errorf("Expected cached extra result to be unaffected by modifications, got %v", caa)
+	}
+}
+
+func TestCheckDomainUsesDomainCache(t *testing.T) {
+	var checks int32
+	c := Checker{
+		DomainCache:      MakeDomainCache(10, time.Minute),
+		lookupMXOverride: mockLookupMX,
+		CheckHostname: func(domain string, hostname string, timeout time.Duration) HostnameResult {
+			atomic.AddInt32(&checks, 1)
+			return mockCheckHostname(domain, hostname, timeout)
+		},
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	c.CheckDomain("domain", nil)
+	c.CheckDomain("domain", nil)
+	if checks != 2 {
+		t.Errorf("Expected cached domain's 2 hostnames to be checked once, got %d checks", checks)
+	}
+	c.CheckDomainForce("domain", nil)
+	if checks != 4 {
+		t.Errorf("Expected CheckDomainForce to bypass cache, got %d checks", checks)
+	}
+}
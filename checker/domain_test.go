@@ -1,8 +1,12 @@
 package checker
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -16,6 +20,8 @@ var mxLookup = map[string][]string{
 	"noconnection":  []string{"noconnection", "noconnection"},
 	"noconnection2": []string{"noconnection", "nostarttlsconnect"},
 	"nostarttls":    []string{"nostarttls", "noconnection"},
+	// münchen.example
+	"xn--mnchen-3ya.example": []string{"mx.xn--mnchen-3ya.example"},
 }
 
 // Fake hostname checks :)
@@ -23,21 +29,21 @@ var hostnameResults = map[string]Result{
 	"noconnection": Result{
 		Status: 3,
 		Checks: map[string]*Result{
-			Connectivity: {Connectivity, 3, nil, nil},
+			Connectivity: {Name: Connectivity, Status: 3},
 		},
 	},
 	"nostarttls": Result{
 		Status: 2,
 		Checks: map[string]*Result{
-			Connectivity: {Connectivity, 0, nil, nil},
-			STARTTLS:     {STARTTLS, 2, nil, nil},
+			Connectivity: {Name: Connectivity, Status: 0},
+			STARTTLS:     {Name: STARTTLS, Status: 2},
 		},
 	},
 	"nostarttlsconnect": Result{
 		Status: 3,
 		Checks: map[string]*Result{
-			Connectivity: {Connectivity, 0, nil, nil},
-			STARTTLS:     {STARTTLS, 3, nil, nil},
+			Connectivity: {Name: Connectivity, Status: 0},
+			STARTTLS:     {Name: STARTTLS, Status: 3},
 		},
 	},
 }
@@ -48,6 +54,18 @@ func mockCheckMTASTS(domain string, hostnameResults map[string]HostnameResult) *
 	return r
 }
 
+func mockLookupCAA(domain string) ([]CAARecord, string, error) {
+	return []CAARecord{}, "", nil
+}
+
+func mockLookupDNSSEC(name string, qtype uint16) DNSSECStatus {
+	return DNSSECUnvalidated
+}
+
+func mockLookupTLSA(name string) ([]TLSAAssociation, bool, error) {
+	return []TLSAAssociation{}, false, nil
+}
+
 func mockLookupMX(domain string) ([]*net.MX, error) {
 	if domain == "error" {
 		return nil, fmt.Errorf("No MX records found")
@@ -75,10 +93,10 @@ func mockCheckHostname(domain string, hostname string, _ time.Duration) Hostname
 		Result: &Result{
 			Status: 0,
 			Checks: map[string]*Result{
-				Connectivity: {Connectivity, 0, nil, nil},
-				STARTTLS:     {STARTTLS, 0, nil, nil},
-				Certificate:  {Certificate, 0, nil, nil},
-				Version:      {Version, 0, nil, nil},
+				Connectivity: {Name: Connectivity, Status: 0},
+				STARTTLS:     {Name: STARTTLS, Status: 0},
+				Certificate:  {Name: Certificate, Status: 0},
+				Version:      {Name: Version, Status: 0},
 			},
 		},
 		Timestamp: time.Now(),
@@ -110,11 +128,13 @@ func performTests(t *testing.T, tests []domainTestCase) {
 
 func performTestsWithCacheTimeout(t *testing.T, tests []domainTestCase, cacheExpiry time.Duration) {
 	c := Checker{
-		Timeout:             time.Second,
-		Cache:               MakeSimpleCache(cacheExpiry),
-		lookupMXOverride:    mockLookupMX,
-		CheckHostname:       mockCheckHostname,
-		checkMTASTSOverride: mockCheckMTASTS,
+		Timeout:              time.Second,
+		Cache:                MakeSimpleCache(cacheExpiry),
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
 	}
 	for _, test := range tests {
 		if test.expectedHostnames == nil {
@@ -188,6 +208,427 @@ func TestHostnameScanExpires(t *testing.T) {
 	performTestsWithCacheTimeout(t, tests, 0)
 }
 
+func TestInternationalizedDomain(t *testing.T) {
+	tests := []domainTestCase{
+		{domain: "münchen.example", expectedHostnames: []string{"mx.xn--mnchen-3ya.example"}, expect: DomainSuccess},
+		{domain: "MÜNCHEN.example", expectedHostnames: []string{"mx.xn--mnchen-3ya.example"}, expect: DomainSuccess},
+		{domain: "xn--mnchen-3ya.example", expect: DomainSuccess},
+		{domain: "-invalid.example", expectedHostnames: []string{}, expect: DomainError},
+	}
+	performTests(t, tests)
+
+	c := Checker{
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	if got := c.CheckDomain("münchen.example", nil).Domain; got != "münchen.example" {
+		t.Errorf("Expected DomainResult to preserve the U-label, got %s", got)
+	}
+	if got := c.CheckDomain("xn--a.example", nil); got.Status != DomainError || got.Message == "" {
+		t.Errorf("Expected invalid IDN to produce an error with a message, got %v", got)
+	}
+}
+
+func TestDomainFromInput(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		ok    bool
+	}{
+		{"example.com", "example.com", true},
+		{"  Example.COM.\n", "example.com", true},
+		{"user@example.com", "example.com", true},
+		{"User@Mail.Example.com ", "mail.example.com", true},
+		{"Jane Doe <jane@example.com>", "example.com", true},
+		{"\"weird@local\"@example.com", "example.com", true},
+		{"user@münchen.example", "münchen.example", true},
+		{"user@example@example.com", "", false},
+		{"user@", "", false},
+		{"@example.com", "", false},
+		{"localhost", "", false},
+		{"example..com", "", false},
+		{"exa mple.com", "", false},
+		{"-bad.example.com", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		got, err := DomainFromInput(test.input)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("DomainFromInput(%q) = %q, %v; want %q, ok=%v", test.input, got, err, test.want, test.ok)
+		}
+	}
+}
+
+func TestHostnamesCheckedConcurrently(t *testing.T) {
+	hostnames := []string{"mx1", "mx2", "mx3", "mx4", "mx5", "mx6"}
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	c := Checker{
+		HostConcurrency: 2,
+		lookupMXOverride: func(string) ([]*net.MX, error) {
+			mxs := []*net.MX{}
+			for _, hostname := range hostnames {
+				mxs = append(mxs, &net.MX{Host: hostname})
+			}
+			return mxs, nil
+		},
+		CheckHostname: func(domain, hostname string, timeout time.Duration) HostnameResult {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			return mockCheckHostname(domain, hostname, timeout)
+		},
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	result := c.CheckDomain("domain", nil)
+	if result.Status != DomainSuccess {
+		t.Errorf("Expected domain check to succeed, got %d", result.Status)
+	}
+	if maxInFlight != 2 {
+		t.Errorf("Expected 2 concurrent hostname checks, got %d", maxInFlight)
+	}
+	if len(result.PreferredHostnames) != len(hostnames) {
+		t.Fatalf("Expected %d preferred hostnames, got %v", len(hostnames), result.PreferredHostnames)
+	}
+	for i, hostname := range hostnames {
+		if result.PreferredHostnames[i] != hostname {
+			t.Errorf("Expected preferred hostnames to keep MX order, got %v", result.PreferredHostnames)
+			break
+		}
+	}
+}
+
 func TestNewSampleDomainResult(t *testing.T) {
 	NewSampleDomainResult("example.com")
 }
+
+func TestCheckDomainMetadata(t *testing.T) {
+	c := Checker{
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	before := time.Now()
+	result := c.CheckDomain("domain", nil)
+	m := result.Metadata
+	if m == nil {
+		t.Fatal("Expected CheckDomain to set metadata")
+	}
+	if m.ScannerVersion != ScannerVersion {
+		t.Errorf("Expected scanner version %s, got %s", ScannerVersion, m.ScannerVersion)
+	}
+	if m.Start.Before(before) || m.End.Before(m.Start) {
+		t.Errorf("Expected %v <= start %v <= end %v", before, m.Start, m.End)
+	}
+	if m.Resolver == "" {
+		t.Error("Expected metadata to record the resolver")
+	}
+}
+
+func TestQuickMode(t *testing.T) {
+	c := Checker{
+		QuickMode:            true,
+		PolicyLists:          []PolicyListSource{{"STARTTLS Everywhere", mockDomainSet{"domain": true}}},
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	result := c.CheckDomain("domain", nil)
+	if result.Status != DomainSuccess || len(result.HostnameResults) != 2 {
+		t.Errorf("Expected mailservers to be checked in quick mode, got status %d and %v", result.Status, result.HostnameResults)
+	}
+	if result.MTASTSResult != nil {
+		t.Errorf("Expected MTA-STS not to be checked in quick mode, got %v", result.MTASTSResult)
+	}
+	for _, check := range []string{CAA, PolicyList} {
+		if r, ok := result.ExtraResults[check]; ok {
+			t.Errorf("Expected %s not to be checked in quick mode, got %v", check, r)
+		}
+	}
+	if c.timeout() != quickModeTimeout {
+		t.Errorf("Expected quick mode timeout %v, got %v", quickModeTimeout, c.timeout())
+	}
+	c.Timeout = time.Minute
+	if c.timeout() != time.Minute {
+		t.Errorf("Expected Timeout to override the quick mode timeout, got %v", c.timeout())
+	}
+}
+
+func TestInvalidEHLOName(t *testing.T) {
+	c := Checker{
+		EHLOName:             "not a hostname",
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	result := c.CheckDomain("domain", nil)
+	if result.Status != DomainError || !strings.Contains(result.Message, "invalid EHLO name") {
+		t.Errorf("Expected invalid EHLO name to cause an error, got %v", result)
+	}
+}
+
+func TestReachableAndErr(t *testing.T) {
+	c := Checker{
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	tests := []struct {
+		domain    string
+		reachable bool
+		err       error
+	}{
+		{"empty", false, ErrNoMXRecords},
+		{"error", false, ErrDNSFailure},
+		{"noconnection", false, ErrNoReachableMX},
+		{"nostarttls", true, nil},
+		{"domain", true, nil},
+	}
+	for _, test := range tests {
+		result := c.CheckDomain(test.domain, nil)
+		if result.Reachable() != test.reachable {
+			t.Errorf("Expected %s to be reachable: %t", test.domain, test.reachable)
+		}
+		if err := result.Err(); !errors.Is(err, test.err) {
+			t.Errorf("Expected %s to return error %v, got %v", test.domain, test.err, err)
+		}
+	}
+	if err := c.CheckDomain("noconnection", nil).Err(); !errors.Is(err, ErrAllHostsUnreachable) {
+		t.Errorf("Expected a domain with no reachable MXs to return ErrAllHostsUnreachable, got %v", err)
+	}
+	if err := c.CheckDomain("-invalid-.example", nil).Err(); err == nil {
+		t.Error("Expected invalid domain to return an error")
+	}
+}
+
+func TestCheckDomainContextReturnsPartialResults(t *testing.T) {
+	fast, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fast.Close()
+	go serveSMTPWithoutSTARTTLS(t, fast, "8BITMIME", "502 Command not implemented")
+	// The slow server accepts connections, but never sends a greeting.
+	slow, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	go func() {
+		conn, err := slow.Accept()
+		if err == nil {
+			time.Sleep(5 * time.Second)
+			conn.Close()
+		}
+	}()
+
+	c := Checker{
+		Timeout: 5 * time.Second,
+		lookupMXOverride: func(string) ([]*net.MX, error) {
+			return []*net.MX{
+				{Host: fast.Addr().String(), Pref: 10},
+				{Host: slow.Addr().String(), Pref: 20},
+			}, nil
+		},
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result := c.CheckDomainContext(ctx, "example.com", nil)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected check to stop at the deadline, took %v", elapsed)
+	}
+	if !result.TimedOut || result.Err() != ErrTimedOut {
+		t.Errorf("Expected result to be marked as timed out, got %v", result)
+	}
+
+	fastResult := result.HostnameResults[fast.Addr().String()]
+	if fastResult.Result == nil || fastResult.Checks[Connectivity].Status != Success ||
+		fastResult.Checks[STARTTLS].Status != Failure {
+		t.Errorf("Expected completed checks of fast hostname to be kept, got %v", fastResult)
+	}
+	slowResult := result.HostnameResults[slow.Addr().String()]
+	if slowResult.Result == nil || slowResult.Checks[Connectivity].Status != Error ||
+		!strings.Contains(slowResult.Checks[Connectivity].Messages[0], "Timed out") {
+		t.Errorf("Expected slow hostname's checks to be marked as timed out, got %v", slowResult)
+	}
+	if result.MTASTSResult == nil || result.MTASTSResult.Status != Error {
+		t.Errorf("Expected MTA-STS check to be marked as timed out, got %v", result.MTASTSResult)
+	}
+}
+
+func TestDomainBudget(t *testing.T) {
+	c := Checker{
+		DomainBudget:    600 * time.Millisecond,
+		HostConcurrency: 1,
+		lookupMXOverride: func(string) ([]*net.MX, error) {
+			return []*net.MX{{Host: "slow", Pref: 10}, {Host: "fast", Pref: 20}}, nil
+		},
+		CheckHostname: func(domain, hostname string, timeout time.Duration) HostnameResult {
+			if hostname == "slow" {
+				time.Sleep(2 * time.Second)
+			}
+			return mockCheckHostname(domain, hostname, timeout)
+		},
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	start := time.Now()
+	result := c.CheckDomain("example.com", nil)
+	if elapsed := time.Since(start); elapsed > c.DomainBudget {
+		t.Errorf("Expected check to finish within its budget, took %v", elapsed)
+	}
+	if !result.TimedOut {
+		t.Error("Expected result to be marked as timed out")
+	}
+	// The slow hostname only gets a third of the budget, leaving time to
+	// check the fast one and MTA-STS.
+	if slow := result.HostnameResults["slow"]; slow.Checks[Connectivity].Status != Error {
+		t.Errorf("Expected slow hostname to time out, got %v", slow)
+	}
+	if fast := result.HostnameResults["fast"]; fast.Checks[Connectivity].Status != Success {
+		t.Errorf("Expected fast hostname to be checked, got %v", fast)
+	}
+	if result.MTASTSResult == nil || result.MTASTSResult.Status == Error {
+		t.Errorf("Expected MTA-STS to be checked, got %v", result.MTASTSResult)
+	}
+}
+
+func TestExpectedMXsCheck(t *testing.T) {
+	c := Checker{
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	if _, ok := c.CheckDomain("domain", nil).ExtraResults[ExpectedMXs]; ok {
+		t.Error("Expected MXs not to be checked without expected hostnames")
+	}
+	result := c.CheckDomain("domain", []string{"hostname1", "hostname2", "hostname3"})
+	if check := result.ExtraResults[ExpectedMXs]; check == nil || check.Status != Failure {
+		t.Errorf("Expected missing hostname to fail the expected MXs check, got %v", check)
+	}
+}
+
+func TestRecheckFailed(t *testing.T) {
+	// Hostnames are checked concurrently.
+	var mu sync.Mutex
+	checked := make(map[string]int)
+	fixed := false
+	mtastsChecks := 0
+	c := Checker{
+		lookupMXOverride: mockLookupMX,
+		CheckHostname: func(domain string, hostname string, timeout time.Duration) HostnameResult {
+			mu.Lock()
+			defer mu.Unlock()
+			checked[hostname]++
+			if hostname == "hostname2" && !fixed {
+				return mockCheckHostname(domain, "nostarttls", timeout)
+			}
+			return mockCheckHostname(domain, hostname, timeout)
+		},
+		checkMTASTSOverride: func(domain string, hostnameResults map[string]HostnameResult) *MTASTSResult {
+			mtastsChecks++
+			return mockCheckMTASTS(domain, hostnameResults)
+		},
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	prior := c.CheckDomain("domain", nil)
+	if prior.Status != DomainNoSTARTTLSFailure {
+		t.Fatalf("Expected first check to fail with no STARTTLS, got %v", prior.Status)
+	}
+	fixed = true
+	result := c.RecheckFailed(context.Background(), prior)
+	if result.Status != DomainSuccess {
+		t.Errorf("Expected recheck to succeed, got %v", result.Status)
+	}
+	if checked["hostname1"] != 1 || checked["hostname2"] != 2 {
+		t.Errorf("Expected only the failed hostname to be checked again, got %v", checked)
+	}
+	if mtastsChecks != 1 {
+		t.Errorf("Expected passing MTA-STS result to be carried forward, got %d checks", mtastsChecks)
+	}
+	if result.HostnameResults["hostname1"].Result != prior.HostnameResults["hostname1"].Result {
+		t.Error("Expected passing hostname result to be carried forward")
+	}
+	if len(result.PreferredHostnames) != 2 {
+		t.Errorf("Expected both hostnames to be preferred, got %v", result.PreferredHostnames)
+	}
+	// Carried-forward results are stamped, so they mustn't be shared.
+	if result.ExtraResults[CAA] == prior.ExtraResults[CAA] || result.MTASTSResult.Result == prior.MTASTSResult.Result {
+		t.Error("Expected carried-forward CAA and MTA-STS results to be copied")
+	}
+
+	// The MX records changed, so everything is checked again.
+	prior.HostnameResults = map[string]HostnameResult{"old": prior.HostnameResults["hostname1"]}
+	c.RecheckFailed(context.Background(), prior)
+	if checked["hostname1"] != 2 || mtastsChecks != 2 {
+		t.Errorf("Expected changed MX records to check everything, got %v and %d MTA-STS checks", checked, mtastsChecks)
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	c := Checker{
+		lookupMXOverride: mockLookupMX,
+		CheckHostname: func(domain, hostname string, timeout time.Duration) HostnameResult {
+			result := mockCheckHostname(domain, hostname, timeout)
+			result.addCheck(MakeResult(Version).Warning("Server should support TLSv1.2, but doesn't."))
+			return result
+		},
+		checkMTASTSOverride: func(domain string, hostnameResults map[string]HostnameResult) *MTASTSResult {
+			r := mockCheckMTASTS(domain, hostnameResults)
+			r.Warning("You're still in \"testing\" mode.")
+			return r
+		},
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	result := c.CheckDomain("domain", nil)
+	if result.Status != DomainWarning || result.MTASTSResult.Status != Warning {
+		t.Errorf("Expected warnings by default, got %d and %v", result.Status, result.MTASTSResult.Result)
+	}
+
+	c.StrictMode = true
+	result = c.CheckDomain("domain", nil)
+	if result.Status != DomainFailure {
+		t.Errorf("Expected warnings to be escalated in strict mode, got %d", result.Status)
+	}
+	if version := result.HostnameResults["hostname1"].Checks[Version]; version.Status != Failure {
+		t.Errorf("Expected version warning to be escalated, got %v", version)
+	}
+	if result.MTASTSResult.Status != Failure || result.MTASTSResult.Mode != "testing" {
+		t.Errorf("Expected MTA-STS warning to be escalated, got %+v", result.MTASTSResult)
+	}
+
+	c.StrictMode = false
+	c.StrictChecks = []string{MTASTS}
+	result = c.CheckDomain("domain", nil)
+	if result.Status != DomainWarning || result.MTASTSResult.Status != Failure {
+		t.Errorf("Expected only MTA-STS warnings to be escalated, got %d and %v", result.Status, result.MTASTSResult.Result)
+	}
+}
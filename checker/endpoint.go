@@ -0,0 +1,208 @@
+package checker
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// lookupHost resolves hostname to its IP addresses.
+func (c *Checker) lookupHost(ctx context.Context, hostname string) ([]string, error) {
+	if c.lookupHostOverride != nil {
+		return c.lookupHostOverride(hostname)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	var r net.Resolver
+	return r.LookupHost(ctx, hostname)
+}
+
+// lookupCNAMEs returns the chain of aliases that hostname resolves through:
+// the target of its CNAME record, then the target of that name's CNAME record,
+// and so on. It's empty if hostname isn't an alias.
+func (c *Checker) lookupCNAMEs(ctx context.Context, hostname string) ([]string, error) {
+	if c.lookupCNAMEOverride != nil {
+		return c.lookupCNAMEOverride(hostname)
+	}
+	response, err := c.queryDNS(ctx, hostname, uint16(dnsmessage.TypeA))
+	if err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string)
+	for _, record := range response.Records {
+		if record.Type == dnsTypeCNAME {
+			targets[record.Name] = record.Target
+		}
+	}
+	chain := []string{}
+	name := strings.ToLower(hostname)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	// Each record can only be followed once, in case the chain loops.
+	for len(chain) < len(targets) {
+		target, ok := targets[name]
+		if !ok {
+			break
+		}
+		chain = append(chain, target)
+		name = target
+	}
+	return chain, nil
+}
+
+// resolveCNAMEs returns the chain of aliases that hostname (which may include
+// a port) resolves through, or nil if it isn't an alias or the chain couldn't
+// be looked up.
+// Addresses mocked by lookupHostOverride aren't aliases unless
+// lookupCNAMEOverride is also set.
+func (c *Checker) resolveCNAMEs(ctx context.Context, hostname string) []string {
+	host, _, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host = hostname
+	}
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return nil
+	}
+	if c.lookupHostOverride != nil && c.lookupCNAMEOverride == nil {
+		return nil
+	}
+	chain, err := c.lookupCNAMEs(ctx, host)
+	if err != nil || len(chain) == 0 {
+		return nil
+	}
+	return chain
+}
+
+// resolveHostname returns the sorted IP addresses that hostname (which may
+// include a port) resolves to, or nil if it can't be resolved.
+func (c *Checker) resolveHostname(ctx context.Context, hostname string) []string {
+	host, _, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host = strings.TrimSuffix(hostname, ".")
+	}
+	var addrs []string
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		addrs = []string{ip.String()}
+	} else if addrs, err = c.lookupHost(ctx, host); err != nil || len(addrs) == 0 {
+		return nil
+	}
+	ips := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil {
+			ips = append(ips, ip.String())
+		}
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+// endpointKey identifies the set of addresses ips (and port) that hostname
+// resolves to, or returns "" if it can't be resolved.
+func endpointKey(hostname string, ips []string) string {
+	if len(ips) == 0 {
+		return ""
+	}
+	_, port, err := net.SplitHostPort(hostname)
+	if err != nil {
+		port = "25"
+	}
+	return port + "|" + strings.Join(ips, ",")
+}
+
+// groupByEndpoint groups the indices of hostnames that resolve to the same set
+// of addresses, in order of first appearance. Hostnames that can't be resolved
+// are in groups of their own. Also returns the addresses of each hostname,
+// and the chain of aliases it resolves through.
+// Hostnames are resolved concurrently, at most c.hostConcurrency() at a time.
+func (c *Checker) groupByEndpoint(ctx context.Context, hostnames []string) ([][]int, [][]string, [][]string) {
+	addrs := make([][]string, len(hostnames))
+	cnames := make([][]string, len(hostnames))
+	sem := make(chan struct{}, c.hostConcurrency())
+	var wg sync.WaitGroup
+	for i, hostname := range hostnames {
+		wg.Add(1)
+		go func(i int, hostname string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			addrs[i] = c.resolveHostname(ctx, hostname)
+			if addrs[i] != nil {
+				cnames[i] = c.resolveCNAMEs(ctx, hostname)
+			}
+		}(i, hostname)
+	}
+	wg.Wait()
+	groups := [][]int{}
+	byKey := make(map[string]int)
+	for i, hostname := range hostnames {
+		key := endpointKey(hostname, addrs[i])
+		if group, ok := byKey[key]; ok && key != "" {
+			groups[group] = append(groups[group], i)
+			continue
+		}
+		byKey[key] = len(groups)
+		groups = append(groups, []int{i})
+	}
+	return groups, addrs, cnames
+}
+
+// forHostname attributes h, the result of checking another hostname at the
+// same addresses, to hostname. The certificate, and those presented for
+// different SNI, are checked against hostname, and roots, as of when h was
+// checked.
+// Returns false if that isn't possible, because h's TLS connection state
+// wasn't kept (e.g. because h was cached).
+func (h HostnameResult) forHostname(hostname string, roots *x509.CertPool, selfSigned SelfSignedPolicy) (HostnameResult, bool) {
+	if h.Result == nil {
+		return h, false
+	}
+	_, checkedCert := h.Checks[Certificate]
+	if checkedCert && h.tlsState == nil {
+		return h, false
+	}
+	result := h
+	result.Hostname = hostname
+	result.Result = &Result{
+		Name:     h.Name,
+		Status:   Success,
+		Messages: h.Messages,
+		Checks:   make(map[string]*Result),
+	}
+	for name, check := range h.Checks {
+		// TLSA records are published for each hostname, so the DANE check
+		// is left for the caller to repeat.
+		if name != Certificate && name != DANE {
+			result.addCheck(check)
+		}
+	}
+	if checkedCert {
+		certResult, verification := checkCertState(*h.tlsState, hostname, roots, selfSigned, h.Timestamp)
+		if h.SNICertificates != nil {
+			result.SNICertificates = append([]SNICertificate{}, h.SNICertificates...)
+			reportSNI(h.SNICertificates, h.noSNIState, hostname, roots, selfSigned, h.Timestamp, certResult)
+		}
+		// Revocation, expiry and SCTs don't depend on the hostname.
+		for _, name := range []string{CRL, OCSP, CertExpiry, OCSPStapling, CertTransparency} {
+			if check, ok := h.Checks[Certificate].Checks[name]; ok {
+				certResult.addCheck(check)
+			}
+		}
+		if prior := h.Checks[Certificate].Certificate; prior != nil {
+			certResult.Certificate.OCSPStaple = prior.OCSPStaple
+			certResult.Certificate.SCTs = prior.SCTs
+		}
+		result.addCheck(certResult)
+		if h.Certificate != nil {
+			certificate := *h.Certificate
+			result.Certificate = &certificate
+		}
+		result.setCertVerification(verification)
+	}
+	return result, true
+}
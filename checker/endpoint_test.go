@@ -0,0 +1,198 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mhale/smtpd"
+)
+
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+	return conn, err
+}
+
+func TestHostnamesAtSameAddressesCheckedOnce(t *testing.T) {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := &countingListener{Listener: inner}
+	defer ln.Close()
+	srv := &smtpd.Server{Handler: noopHandler, Hostname: "example.com"}
+	srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	go srv.Serve(ln)
+
+	certRoots, _ = x509.SystemCertPool()
+	certRoots.AppendCertsFromPEM([]byte(certString))
+	defer func() {
+		certRoots = nil
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	// Our test cert is only valid for "localhost".
+	localhost, other := "localhost:"+port, "mx.example.com:"+port
+	c := Checker{
+		Timeout: testTimeout,
+		lookupMXOverride: func(string) ([]*net.MX, error) {
+			return []*net.MX{{Host: localhost, Pref: 10}, {Host: other, Pref: 10}}, nil
+		},
+		lookupHostOverride: func(string) ([]string, error) {
+			return []string{"127.0.0.1"}, nil
+		},
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+		lookupTLSAOverride:   mockLookupTLSA,
+	}
+	result := c.CheckDomain("example.com", nil)
+
+	// One connection for most checks, and another for the version check.
+	if accepted := atomic.LoadInt32(&ln.accepted); accepted != 2 {
+		t.Errorf("Expected hostnames at the same address to be checked once, got %d connections", accepted)
+	}
+	if status := result.HostnameResults[localhost].Checks[Certificate].Status; status != Success {
+		t.Errorf("Expected certificate to be valid for localhost, got %d", status)
+	}
+	otherResult := result.HostnameResults[other]
+	if otherResult.Hostname != other || otherResult.Checks[Certificate].Status != Failure {
+		t.Errorf("Expected certificate not to be valid for %s, got %v", other, otherResult.Result)
+	}
+	if otherResult.Checks[STARTTLS].Status != Success {
+		t.Errorf("Expected STARTTLS result to be shared with %s, got %v", other, otherResult.Result)
+	}
+
+	if len(result.MXHosts) != 2 {
+		t.Fatalf("Expected 2 MX hosts, got %v", result.MXHosts)
+	}
+	for _, host := range result.MXHosts {
+		if !reflect.DeepEqual(host.IPs, []string{"127.0.0.1"}) || !host.STARTTLS || host.TLSVersion == "" {
+			t.Errorf("Expected %s to be summarized with its address and TLS support, got %+v", host.Hostname, host)
+		}
+		if host.ValidCertificate != (host.Hostname == localhost) {
+			t.Errorf("Expected certificate to be valid only for %s, got %+v", localhost, host)
+		}
+	}
+}
+
+func TestMXHostnameCNAMEs(t *testing.T) {
+	c := Checker{
+		lookupHostOverride: func(string) ([]string, error) { return []string{"192.0.2.1"}, nil },
+		lookupCNAMEOverride: func(hostname string) ([]string, error) {
+			if hostname == "mx.example.com" {
+				return []string{"mx.example.net.", "mx.provider.example."}, nil
+			}
+			return []string{}, nil
+		},
+	}
+	_, _, cnames := c.groupByEndpoint(context.Background(), []string{"mx.example.com", "direct.example.com:25", "192.0.2.2"})
+	expected := [][]string{{"mx.example.net.", "mx.provider.example."}, nil, nil}
+	if !reflect.DeepEqual(cnames, expected) {
+		t.Errorf("Expected CNAME chains %v, got %v", expected, cnames)
+	}
+
+	c.lookupCNAMEOverride = nil
+	if _, _, cnames := c.groupByEndpoint(context.Background(), []string{"mx.example.com"}); cnames[0] != nil {
+		t.Errorf("Expected mocked addresses not to be aliases, got %v", cnames)
+	}
+}
+
+func TestHostnamesAtSameAddressesShareSNIProbe(t *testing.T) {
+	now := time.Now()
+	root := issueTestCert(t, "Test Root", true, now.Add(-time.Hour), now.Add(time.Hour), nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	certFor := func(name string) *tls.Certificate {
+		leaf := issueTestCert(t, name, false, now.Add(-time.Hour), now.Add(time.Hour), root)
+		return &tls.Certificate{Certificate: [][]byte{leaf.cert.Raw}, PrivateKey: leaf.key}
+	}
+	localhost, fallback := certFor("localhost"), certFor("fallback.example.com")
+	ln := smtpListenAndServe(t, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName == "" {
+				return fallback, nil
+			}
+			return localhost, nil
+		},
+	})
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	first, other := "localhost:"+port, "mx.example.com:"+port
+
+	c := Checker{
+		Timeout:  testTimeout,
+		RootCAs:  roots,
+		ProbeSNI: true,
+		lookupMXOverride: func(string) ([]*net.MX, error) {
+			return []*net.MX{{Host: first, Pref: 10}, {Host: other, Pref: 10}}, nil
+		},
+		lookupHostOverride:   func(string) ([]string, error) { return []string{"127.0.0.1"}, nil },
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+		lookupTLSAOverride:   mockLookupTLSA,
+	}
+	result := c.CheckDomain("example.com", nil)
+	for _, hostname := range []string{first, other} {
+		h := result.HostnameResults[hostname]
+		if len(h.SNICertificates) != 3 {
+			t.Errorf("Expected %s to share the certificates presented for different SNI, got %v", hostname, h.SNICertificates)
+		}
+		messages := strings.Join(h.Checks[Certificate].Messages, "\n")
+		if !strings.Contains(messages, "isn't valid for "+withoutPort(hostname)) || !strings.Contains(messages, "different certificates") {
+			t.Errorf("Expected invalid certificate without SNI to be noted for %s, got %q", hostname, messages)
+		}
+	}
+}
+
+func TestGroupByEndpointResolvesConcurrently(t *testing.T) {
+	var inFlight, maxInFlight int32
+	c := Checker{
+		HostConcurrency: 2,
+		lookupHostOverride: func(hostname string) ([]string, error) {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			if hostname == "mx3.example.com" {
+				return []string{"192.0.2.2"}, nil
+			}
+			return []string{"192.0.2.1"}, nil
+		},
+	}
+	hostnames := []string{"mx1.example.com", "mx2.example.com", "mx3.example.com", "mx4.example.com", "mx5.example.com"}
+	groups, addrs, _ := c.groupByEndpoint(context.Background(), hostnames)
+	if max := atomic.LoadInt32(&maxInFlight); max != 2 {
+		t.Errorf("Expected hostnames to be resolved 2 at a time, got %d", max)
+	}
+	if expected := [][]int{{0, 1, 3, 4}, {2}}; !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Expected groups %v, got %v", expected, groups)
+	}
+	if !reflect.DeepEqual(addrs[2], []string{"192.0.2.2"}) {
+		t.Errorf("Expected the addresses of each hostname, got %v", addrs)
+	}
+}
@@ -0,0 +1,67 @@
+package checker
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"time"
+)
+
+// defaultSlowGreeting is how long a mailserver can take to send its greeting
+// before the Connectivity check notes that it's slow, if the Checker doesn't
+// specify otherwise.
+const defaultSlowGreeting = 5 * time.Second
+
+// maxGreetingLength caps how much of a greeting is recorded.
+const maxGreetingLength = 1024
+
+// smtpGreeting is the greeting a mailserver sent after accepting a
+// connection, and how long it took to send it.
+type smtpGreeting struct {
+	banner string
+	delay  time.Duration
+}
+
+// greetingRecorder records what is read from a connection until stop is
+// called, so that the greeting read by smtp.NewClient can be recovered.
+type greetingRecorder struct {
+	net.Conn
+	buf     bytes.Buffer
+	stopped bool
+}
+
+func (r *greetingRecorder) Read(p []byte) (int, error) {
+	n, err := r.Conn.Read(p)
+	if !r.stopped && r.buf.Len() < maxGreetingLength {
+		r.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+// stop stops recording, and returns the text of the greeting: the lines of
+// the 220 response, without their reply codes.
+func (r *greetingRecorder) stop() string {
+	r.stopped = true
+	lines := []string{}
+	for _, line := range strings.Split(r.buf.String(), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(line) < 4 || !strings.HasPrefix(line, "220") {
+			break
+		}
+		lines = append(lines, line[4:])
+		if line[3] != '-' {
+			break
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkGreeting notes on the Connectivity result if the greeting took longer
+// than slow to arrive, which may mean the server is tarpitting or greylisting
+// clients.
+func checkGreeting(greeting smtpGreeting, slow time.Duration, result *Result) {
+	if greeting.delay > slow {
+		result.Info("Server took %s to send its greeting. Delaying the greeting is a common anti-spam measure, but some senders may give up first.",
+			greeting.delay.Round(time.Millisecond))
+	}
+}
@@ -1,11 +1,19 @@
 package checker
 
 import (
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net"
 	"net/smtp"
+	"net/textproto"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -16,6 +24,239 @@ type HostnameResult struct {
 	Domain    string    `json:"domain"`
 	Hostname  string    `json:"hostname"`
 	Timestamp time.Time `json:"-"`
+	// IPs are the addresses the hostname resolved to, if they were looked up
+	// (see Checker.CheckHostname).
+	IPs []string `json:"ips,omitempty"`
+	// CNAMEs is the chain of aliases the hostname resolved through before its
+	// addresses, in order, if it's an alias. Its certificate must still be
+	// valid for the hostname itself.
+	CNAMEs []string `json:"cnames,omitempty"`
+	// TLSADNSSEC is whether the hostname's TLSA records were validated with
+	// DNSSEC, if they were looked up for the DANE check.
+	TLSADNSSEC DNSSECStatus `json:"tlsa_dnssec,omitempty"`
+	// Certificate presented by the hostname, if TLS was negotiated.
+	Certificate *CertificateInfo `json:"certificate,omitempty"`
+	// SMTP AUTH mechanisms advertised by the hostname, if any.
+	Auth *AuthInfo `json:"auth,omitempty"`
+	// TLSVersion is the version of TLS negotiated after STARTTLS (e.g.
+	// tls.VersionTLS12), or 0 if TLS wasn't negotiated.
+	TLSVersion uint16 `json:"tls_version,omitempty"`
+	// CipherSuites are the cipher suites the hostname accepted, in the order
+	// it preferred them, if Checker.EnumerateCipherSuites is set.
+	CipherSuites []CipherSuite `json:"cipher_suites,omitempty"`
+	// Greeting is the text of the 220 greeting the hostname sent after
+	// accepting a connection, without reply codes.
+	Greeting string `json:"greeting,omitempty"`
+	// GreetingDelay is how long the hostname took to send its greeting.
+	GreetingDelay time.Duration `json:"greeting_delay,omitempty"`
+	// ConnectionFailure is the reason the connection to the hostname failed,
+	// if it did.
+	ConnectionFailure ConnectionFailure `json:"connection_failure,omitempty"`
+	// SessionResumption is whether the hostname let a TLS session be
+	// resumed on a new connection, if Checker.CheckResumption is set.
+	SessionResumption bool `json:"session_resumption,omitempty"`
+	// TLSRequired is whether the hostname rejected MAIL FROM before STARTTLS,
+	// or nil if it couldn't be determined or Checker.ProbeTLSRequired isn't
+	// set.
+	TLSRequired *bool `json:"tls_required,omitempty"`
+	// TLSMode is how TLS was negotiated, or would have been: with STARTTLS,
+	// or implicitly on connecting (SMTPS).
+	TLSMode TLSMode `json:"tls_mode,omitempty"`
+	// CertificateFailures are the reasons the Certificate check failed, if it
+	// did.
+	CertificateFailures []CertificateFailure `json:"certificate_failures,omitempty"`
+	// SNICertificates are the certificates the hostname presented for
+	// different SNI, if Checker.ProbeSNI is set.
+	SNICertificates []SNICertificate `json:"sni_certificates,omitempty"`
+	// SNI is the server name sent in place of the hostname when TLS was
+	// negotiated, if Checker.SNIOverride is set.
+	SNI string `json:"sni,omitempty"`
+	// QuitAccepted is whether the hostname replied to QUIT with 221 at the
+	// end of the conversation, or nil if TLS wasn't negotiated (in which case
+	// the reply isn't checked).
+	QuitAccepted *bool `json:"quit_accepted,omitempty"`
+	// tlsState is the state of the TLS connection to the hostname, if
+	// STARTTLS succeeded. It isn't cached.
+	tlsState *tls.ConnectionState
+	// noSNIState is the state of the TLS connection to the hostname without
+	// SNI, if Checker.ProbeSNI is set and it succeeded. It isn't cached.
+	noSNIState *tls.ConnectionState
+	// err is the reason the connection to the hostname failed, if it did (see
+	// Err). It isn't serialized.
+	err error
+}
+
+// Errors returned by HostnameResult.Err.
+var (
+	// ErrHostUnreachable indicates that a mailserver didn't accept a
+	// connection, or didn't respond to EHLO.
+	ErrHostUnreachable = errors.New("could not connect to mailserver")
+	// ErrTLSHandshake indicates that a mailserver advertised STARTTLS, but the
+	// TLS handshake failed.
+	ErrTLSHandshake = errors.New("TLS handshake failed")
+)
+
+// Err returns an error if the connection to the hostname failed: either
+// ErrHostUnreachable, or an error wrapping ErrTLSHandshake (use errors.Is).
+// Returns nil if the hostname was checked, even if its checks failed.
+// Results read from JSON don't record handshake errors.
+func (h HostnameResult) Err() error {
+	if h.Result == nil {
+		return nil
+	}
+	if !h.couldConnect() {
+		return ErrHostUnreachable
+	}
+	return h.err
+}
+
+// tlsVersionNames names the versions of SSL and TLS.
+var tlsVersionNames = map[uint16]string{
+	tls.VersionSSL30: "SSLv3",
+	tls.VersionTLS10: "TLSv1.0",
+	tls.VersionTLS11: "TLSv1.1",
+	tls.VersionTLS12: "TLSv1.2",
+	tls.VersionTLS13: "TLSv1.3",
+}
+
+// TLSVersionName returns the name of a TLS version, like "TLSv1.2".
+func TLSVersionName(version uint16) string {
+	if name, ok := tlsVersionNames[version]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// hostnameResultFields has the fields of HostnameResult, but not the JSON
+// methods it inherits from the embedded Result, so that they're encoded in
+// the usual way.
+type hostnameResultFields struct {
+	hostnameResult
+	// These shadow the methods promoted from Result.
+	MarshalJSON, UnmarshalJSON struct{} `json:"-"`
+}
+
+type hostnameResult HostnameResult
+
+// MarshalJSON writes HostnameResult to JSON: the fields of its Result, as
+// written by Result.MarshalJSON, along with its own. Without it, the embedded
+// Result's MarshalJSON would be used, and only the Result would be written.
+func (h HostnameResult) MarshalJSON() ([]byte, error) {
+	merged := make(map[string]json.RawMessage)
+	if h.Result != nil {
+		b, err := json.Marshal(h.Result)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &merged); err != nil {
+			return nil, err
+		}
+	}
+	fields := hostnameResultFields{hostnameResult: hostnameResult(h)}
+	fields.Result = nil
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &merged); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merged)
+}
+
+// UnmarshalJSON reads a HostnameResult written by MarshalJSON. Results
+// written before it existed only have the fields of the Result.
+func (h *HostnameResult) UnmarshalJSON(b []byte) error {
+	var result Result
+	if err := json.Unmarshal(b, &result); err != nil {
+		return err
+	}
+	var fields hostnameResultFields
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	*h = HostnameResult(fields.hostnameResult)
+	h.Result = &result
+	return nil
+}
+
+// CertificateInfo describes the leaf certificate presented by a hostname.
+type CertificateInfo struct {
+	Subject            string   `json:"subject"`
+	Issuer             string   `json:"issuer"`
+	IssuerOrganization []string `json:"issuer_organization,omitempty"`
+	// DNS names and IP addresses the certificate is valid for.
+	Names []string `json:"names,omitempty"`
+	// The name the certificate was checked against: usually the hostname.
+	CheckedName string `json:"checked_name,omitempty"`
+	// The name in the certificate that matched the hostname, if any.
+	MatchedName string `json:"matched_name,omitempty"`
+	// The trusted root the certificate chains to, if it does.
+	Root string `json:"root,omitempty"`
+	// The period the certificate is valid for.
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	// The certificate's serial number, in hexadecimal.
+	SerialNumber string `json:"serial_number,omitempty"`
+	// The SHA-256 digest of the DER-encoded certificate, in hexadecimal.
+	SHA256Fingerprint string `json:"sha256_fingerprint,omitempty"`
+	// The number of certificates the hostname presented, including this
+	// one, if it's known.
+	ChainLength int `json:"chain_length,omitempty"`
+	// The OCSP response the hostname stapled to the certificate, if it did.
+	OCSPStaple *OCSPStaple `json:"ocsp_staple,omitempty"`
+	// The signed certificate timestamps embedded in the certificate, or sent
+	// by the hostname.
+	SCTs []SCT `json:"scts,omitempty"`
+}
+
+func makeCertificateInfo(cert *x509.Certificate) *CertificateInfo {
+	return &CertificateInfo{
+		Subject:            cert.Subject.CommonName,
+		Issuer:             cert.Issuer.CommonName,
+		IssuerOrganization: cert.Issuer.Organization,
+		Names:              certNames(cert),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		SerialNumber:       serialNumber(cert),
+		SHA256Fingerprint:  fmt.Sprintf("%x", sha256.Sum256(cert.Raw)),
+	}
+}
+
+func serialNumber(cert *x509.Certificate) string {
+	if cert.SerialNumber == nil {
+		return ""
+	}
+	return cert.SerialNumber.Text(16)
+}
+
+// certNames returns the subject alternative names of cert.
+func certNames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// matchCertName returns the name in cert which hostname matches, or "" if
+// there isn't one. Wildcard names may only match the left-most label.
+func matchCertName(cert *x509.Certificate, hostname string) string {
+	hostname = withoutPort(strings.TrimSuffix(hostname, "."))
+	if ip := net.ParseIP(strings.Trim(hostname, "[]")); ip != nil {
+		for _, certIP := range cert.IPAddresses {
+			if certIP.Equal(ip) {
+				return certIP.String()
+			}
+		}
+		return ""
+	}
+	for _, name := range cert.DNSNames {
+		if PolicyMatches(hostname, []string{name}) && !strings.HasPrefix(name, ".") {
+			return name
+		}
+	}
+	return ""
 }
 
 func (h HostnameResult) couldConnect() bool {
@@ -68,35 +309,322 @@ func getThisHostname() string {
 	return hostname
 }
 
+// ValidateEHLOName returns an error unless name can be announced in an SMTP
+// EHLO command: either a fully qualified domain name, or an address literal
+// like "[192.0.2.1]" (RFC 5321, section 4.1.3).
+func ValidateEHLOName(name string) error {
+	if strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]") {
+		literal := strings.TrimPrefix(name[1:len(name)-1], "IPv6:")
+		if net.ParseIP(literal) == nil {
+			return fmt.Errorf("%q is not a valid address literal", name)
+		}
+		return nil
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.') {
+			return fmt.Errorf("%q contains invalid character %q", name, r)
+		}
+	}
+	if err := validateDomainName(name); err != nil {
+		return fmt.Errorf("%q is not a valid hostname: %v", name, err)
+	}
+	return nil
+}
+
+// smtpDialer opens SMTP connections to the hostnames being checked.
+type smtpDialer struct {
+	timeout time.Duration
+	// ehloName is the hostname announced in EHLO. If empty, getThisHostname()
+	// is used.
+	ehloName string
+	// localAddr is the local address to dial from. If nil, one is chosen
+	// automatically.
+	localAddr net.Addr
+	// limiter limits the number of open connections. If nil, they aren't
+	// limited.
+	limiter *ConnectionLimiter
+	// slowGreeting is how long the server can take to send its greeting
+	// before it's noted as slow. If zero, defaultSlowGreeting is used.
+	slowGreeting time.Duration
+	// port is dialed for hostnames that don't specify one. If zero,
+	// DefaultSMTPPort is used.
+	port int
+	// portModes overrides defaultPortModes.
+	portModes map[int]TLSMode
+	// probeSNI is whether the certificates presented for different SNI are
+	// compared (see probeSNI).
+	probeSNI bool
+	// probeTLSRequired is whether MAIL FROM is sent before STARTTLS (see
+	// probeTLSRequired).
+	probeTLSRequired bool
+	// clock returns the current time. If nil, time.Now is used.
+	clock func() time.Time
+	// proxyHeader is sent on each connection as soon as it's opened, if it
+	// isn't nil.
+	proxyHeader *ProxyHeader
+	// sniOverride is sent as the SNI in place of the hostname, if it isn't
+	// empty.
+	sniOverride string
+	// quick is whether only quickHostnameChecks are performed.
+	quick bool
+	// tracer records spans of the connections. If nil, none are recorded.
+	tracer Tracer
+	// selfSigned is how the Certificate check treats self-signed
+	// certificates.
+	selfSigned SelfSignedPolicy
+	// enumerateCiphers is whether the Version check also enumerates the
+	// cipher suites accepted (see enumerateCipherSuites).
+	enumerateCiphers bool
+	// checkResumption is whether the Resumption check is performed (see
+	// checkResumption).
+	checkResumption bool
+	// profile is how strictly the Version check treats old TLS versions and
+	// weak cipher suites.
+	profile TLSProfile
+	// certExpiryWarning and certExpiryFailure are how soon before a
+	// certificate expires the CertExpiry check warns or fails. If
+	// certExpiryWarning is zero, defaultCertExpiryWarning is used.
+	certExpiryWarning, certExpiryFailure time.Duration
+	// ctLogs are the CT logs that the CertTransparency check verifies SCTs
+	// with. If empty, the check isn't performed.
+	ctLogs []CTLog
+}
+
+// checks returns the checks that fullCheckHostname performs with d, in order.
+func (d smtpDialer) checks() []string {
+	if d.quick {
+		return quickHostnameChecks
+	}
+	return hostnameChecks
+}
+
+func (d smtpDialer) now() time.Time {
+	if d.clock != nil {
+		return d.clock()
+	}
+	return time.Now()
+}
+
+func (d smtpDialer) certExpiryWarningThreshold() time.Duration {
+	if d.certExpiryWarning > 0 {
+		return d.certExpiryWarning
+	}
+	return defaultCertExpiryWarning
+}
+
+func (d smtpDialer) slowGreetingThreshold() time.Duration {
+	if d.slowGreeting > 0 {
+		return d.slowGreeting
+	}
+	return defaultSlowGreeting
+}
+
 // Performs an SMTP dial with a short timeout.
 // https://github.com/golang/go/issues/16436
-func smtpDialWithTimeout(hostname string, timeout time.Duration) (*smtp.Client, error) {
-	if _, _, err := net.SplitHostPort(hostname); err != nil {
-		hostname += ":25"
+// If ctx has a deadline, the connection is closed at the deadline.
+func (d smtpDialer) dial(ctx context.Context, hostname string) (*smtp.Client, error) {
+	session, err := d.dialSession(ctx, hostname, clientTLSConfig())
+	return session.client, err
+}
+
+// dialWithGreeting performs dial, and also returns the server's greeting.
+func (d smtpDialer) dialWithGreeting(ctx context.Context, hostname string) (*smtp.Client, smtpGreeting, error) {
+	session, err := d.dialSession(ctx, hostname, clientTLSConfig())
+	return session.client, session.greeting, err
+}
+
+// smtpSession is an SMTP connection opened by smtpDialer.
+type smtpSession struct {
+	client *smtp.Client
+	// conn is the underlying connection, used to bound the wait for QUIT.
+	conn     net.Conn
+	greeting smtpGreeting
+	mode     TLSMode
+	// tlsState is the state of the implicit TLS connection, if mode is
+	// ModeImplicitTLS. smtp.Client only reports the state of connections it
+	// upgraded with STARTTLS.
+	tlsState *tls.ConnectionState
+}
+
+// dialSession connects to hostname and sends EHLO. If hostname's port uses
+// implicit TLS, TLS is negotiated with config first, and an error wrapping
+// ErrTLSHandshake is returned if that fails.
+func (d smtpDialer) dialSession(ctx context.Context, hostname string, config *tls.Config) (smtpSession, error) {
+	address, mode := d.address(hostname)
+	session := smtpSession{mode: mode}
+	dialer := net.Dialer{Timeout: d.timeout, LocalAddr: d.localAddr}
+	conn, err := d.limiter.dialContext(ctx, dialer.DialContext, "tcp", address)
+	if err != nil {
+		return session, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
 	}
-	conn, err := net.DialTimeout("tcp", hostname, timeout)
+	if d.proxyHeader != nil {
+		if err := d.proxyHeader.write(conn); err != nil {
+			conn.Close()
+			return session, fmt.Errorf("couldn't send PROXY protocol header: %v", err)
+		}
+	}
+	if mode == ModeImplicitTLS {
+		config = config.Clone()
+		if config.ServerName == "" {
+			config.ServerName = withoutPort(address)
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return session, fmt.Errorf("%w: %v", ErrTLSHandshake, err)
+		}
+		state := tlsConn.ConnectionState()
+		session.tlsState = &state
+		conn = tlsConn
+	}
+	recorder := &greetingRecorder{Conn: conn}
+	start := time.Now()
+	client, err := smtp.NewClient(recorder, address)
+	session.greeting.delay = time.Since(start)
+	session.greeting.banner = recorder.stop()
 	if err != nil {
-		return nil, err
+		conn.Close()
+		return session, fmt.Errorf("connected, but no valid greeting after %s: %v",
+			session.greeting.delay.Round(time.Millisecond), err)
+	}
+	ehloName := d.ehloName
+	if ehloName == "" {
+		ehloName = getThisHostname()
+	}
+	if err := client.Hello(ehloName); err != nil {
+		smtpSession{client: client, conn: conn}.quit(ctx)
+		return session, err
 	}
-	client, err := smtp.NewClient(conn, hostname)
+	session.client = client
+	session.conn = conn
+	return session, nil
+}
+
+// quitTimeout bounds how long quit waits for the server's reply.
+const quitTimeout = 5 * time.Second
+
+// quit ends the session with QUIT and closes the connection. Returns an error
+// if the server didn't reply with 221 before quitTimeout or ctx's deadline.
+func (s smtpSession) quit(ctx context.Context) error {
+	defer s.client.Close()
+	deadline := time.Now().Add(quitTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	s.conn.SetDeadline(deadline)
+	id, err := s.client.Text.Cmd("QUIT")
 	if err != nil {
-		return client, err
+		return err
 	}
-	return client, client.Hello(getThisHostname())
+	s.client.Text.StartResponse(id)
+	defer s.client.Text.EndResponse(id)
+	_, _, err = s.client.Text.ReadResponse(221)
+	return err
 }
 
-// Simply tries to StartTLS with the server.
-func checkStartTLS(client *smtp.Client) *Result {
+// connectionState returns the state of the session's TLS connection, if TLS
+// has been negotiated.
+func (s smtpSession) connectionState() (tls.ConnectionState, bool) {
+	if s.tlsState != nil {
+		return *s.tlsState, true
+	}
+	return s.client.TLSConnectionState()
+}
+
+// Tries to StartTLS with the server.
+//
+// A network attacker can strip STARTTLS from the server's EHLO response, or
+// interfere with the handshake, to downgrade the connection. To detect this,
+// if STARTTLS isn't advertised we send the command anyway: a server that
+// accepts it (or fails with a TLS-specific error) probably does support
+// STARTTLS. Similarly, a failed handshake after STARTTLS was advertised is
+// flagged as suspicious.
+// These are only heuristics. An attacker that also rejects the STARTTLS
+// command, or a server that is simply misconfigured, can't be told apart from
+// a server that lacks STARTTLS support.
+// Also returns an error wrapping ErrTLSHandshake if the handshake failed.
+func checkStartTLS(client *smtp.Client, config *tls.Config) (*Result, error) {
 	result := MakeResult(STARTTLS)
 	ok, _ := client.Extension("StartTLS")
 	if !ok {
-		return result.Failure("Server does not advertise support for STARTTLS.")
+		result.Failure("Server does not advertise support for STARTTLS.")
+		return probeStartTLS(client, result), nil
 	}
-	config := tls.Config{InsecureSkipVerify: true}
-	if err := client.StartTLS(&config); err != nil {
-		return result.Failure("Could not complete a TLS handshake.")
+	if err := client.StartTLS(config); err != nil {
+		result.Failure("Could not complete a TLS handshake.")
+		result.Warning("Server advertised STARTTLS, but the handshake failed. This could be a misconfiguration, or a sign that the connection is being downgraded by a network attacker.")
+		return result, fmt.Errorf("%w: %v", ErrTLSHandshake, err)
 	}
-	return result.Success()
+	return result.Success(), nil
+}
+
+// SMTP reply codes in response to STARTTLS.
+const (
+	smtpReady               = 220
+	smtpTLSNotAvailable     = 454
+	smtpCommandUnrecognized = 500
+	smtpNotImplemented      = 502
+)
+
+// probeStartTLS sends STARTTLS to a server that didn't advertise it, and
+// records on result whether the response suggests that STARTTLS was stripped.
+func probeStartTLS(client *smtp.Client, result *Result) *Result {
+	id, err := client.Text.Cmd("STARTTLS")
+	if err != nil {
+		return result
+	}
+	client.Text.StartResponse(id)
+	code, message, err := client.Text.ReadResponse(0)
+	client.Text.EndResponse(id)
+	if err != nil && code == 0 {
+		return result
+	}
+	switch code {
+	case smtpReady:
+		return result.Warning("Server accepted the STARTTLS command even though it didn't advertise it. STARTTLS may have been stripped from the server's response by a network attacker.")
+	case smtpTLSNotAvailable:
+		return result.Warning("Server responded to STARTTLS with \"%d %s\", suggesting that it supports TLS but it is temporarily unavailable, or STARTTLS was stripped from the server's response.", code, message)
+	case smtpCommandUnrecognized, smtpNotImplemented:
+		return result.Info("Server doesn't recognize the STARTTLS command.")
+	}
+	return result.Info("Server responded to STARTTLS with \"%d %s\".", code, message)
+}
+
+// smtpAuthRequired is the reply code to commands that require STARTTLS (or
+// authentication) first.
+const smtpAuthRequired = 530
+
+// probeTLSRequired sends MAIL FROM before STARTTLS on a connection to a
+// server that advertises STARTTLS, to find out whether the server requires
+// TLS. If the server accepts the command, the transaction is reset, so no
+// mail is sent. Returns nil if the response doesn't say either way, e.g.
+// because the server requires authentication or only rejects the sender.
+func probeTLSRequired(client *smtp.Client) *bool {
+	if ok, _ := client.Extension("StartTLS"); !ok {
+		return nil
+	}
+	required := false
+	id, err := client.Text.Cmd("MAIL FROM:<>")
+	if err != nil {
+		return nil
+	}
+	client.Text.StartResponse(id)
+	code, message, err := client.Text.ReadResponse(250)
+	client.Text.EndResponse(id)
+	if err == nil {
+		if client.Reset() != nil {
+			return nil
+		}
+		return &required
+	}
+	if code == smtpAuthRequired && strings.Contains(strings.ToUpper(message), "TLS") {
+		required = true
+		return &required
+	}
+	return nil
 }
 
 // If no MX matching policy was provided, then we'll default to accepting matches
@@ -110,46 +638,112 @@ func defaultValidMX(domain, hostname string) []string {
 	return []string{domain, hostname}
 }
 
-// Validates that a certificate chain is valid for this system roots.
-func verifyCertChain(state tls.ConnectionState) error {
+// Validates that a certificate chain is valid for roots (or certRoots, if
+// roots is nil) at the given time, or now if at is zero.
+func verifyCertChain(state tls.ConnectionState, roots *x509.CertPool, at time.Time) ([][]*x509.Certificate, error) {
+	if roots == nil {
+		roots = certRoots
+	}
 	pool := x509.NewCertPool()
 	for _, peerCert := range state.PeerCertificates[1:] {
 		pool.AddCert(peerCert)
 	}
-	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
-		Roots:         certRoots,
+	return state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
 		Intermediates: pool,
+		CurrentTime:   at,
 	})
-	return err
 }
 
 // certRoots is the certificate roots to use for verifying
-// a TLS certificate. It is nil by default so that the system
-// root certs are used.
+// a TLS certificate, unless the Checker specifies RootCAs. It is nil by
+// default so that the system root certs are used.
 //
 // It is a global variable because it is used as a test hook.
 var certRoots *x509.CertPool
 
+// LoadRootCAs reads a bundle of PEM-encoded root certificates from path, for
+// use as Checker.RootCAs.
+func LoadRootCAs(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// certVerification is the outcome of checking a certificate for a hostname.
+type certVerification struct {
+	// checkedName is the name the certificate was checked against.
+	checkedName string
+	// matchedName is the name in the certificate that matched the hostname,
+	// if any.
+	matchedName string
+	// failures are the reasons the check failed.
+	failures []CertificateFailure
+	// root is the root the chain ended at, if it verified.
+	root *x509.Certificate
+}
+
+// setCertVerification records v on h.
+func (h *HostnameResult) setCertVerification(v certVerification) {
+	h.CertificateFailures = v.failures
+	if h.Certificate == nil {
+		return
+	}
+	h.Certificate.CheckedName = v.checkedName
+	h.Certificate.MatchedName = v.matchedName
+	h.Certificate.Root = ""
+	if v.root != nil {
+		h.Certificate.Root = rootName(v.root)
+	}
+}
+
 // Checks that the certificate presented is valid for a particular hostname, unexpired,
-// and chains to a trusted root.
-func checkCert(client *smtp.Client, domain, hostname string) *Result {
-	result := MakeResult(Certificate)
-	state, ok := client.TLSConnectionState()
+// and chains to one of roots (or the default roots, if nil).
+func checkCert(state tls.ConnectionState, ok bool, hostname string, roots *x509.CertPool, selfSigned SelfSignedPolicy, now time.Time) (*Result, certVerification) {
 	if !ok {
-		return result.Error("TLS not initiated properly.")
+		return MakeResult(Certificate).Error("TLS not initiated properly."), certVerification{}
 	}
+	return checkCertState(state, hostname, roots, selfSigned, now)
+}
+
+// checkCertState performs checkCert on the certificates of state.
+func checkCertState(state tls.ConnectionState, hostname string, roots *x509.CertPool, selfSigned SelfSignedPolicy, now time.Time) (*Result, certVerification) {
+	result := MakeResult(Certificate)
+	var failures []CertificateFailure
 	cert := state.PeerCertificates[0]
 	// If hostname is an FQDN, it might end with '.'
-	hostname = strings.TrimSuffix(hostname, ".")
-	err := cert.VerifyHostname(withoutPort(hostname))
-	if err != nil {
-		result.Failure("Name in cert doesn't match hostname: %v", err)
+	hostname = withoutPort(strings.TrimSuffix(hostname, "."))
+	matchedName := matchCertName(cert, hostname)
+	if matchedName == "" {
+		if names := certNames(cert); len(names) > 0 {
+			result.Failure("Name in cert doesn't match hostname %s. The certificate is only valid for: %s.",
+				hostname, strings.Join(names, ", "))
+		} else {
+			result.Failure("Name in cert doesn't match hostname %s. The certificate doesn't list any subject alternative names.",
+				hostname)
+		}
+		failures = append(failures, CertHostnameMismatch)
+	} else if strings.HasPrefix(matchedName, "*.") {
+		result.Info("Hostname %s matched the wildcard name %s in the certificate.", hostname, matchedName)
 	}
-	err = verifyCertChain(state)
-	if err != nil {
-		return result.Failure("Certificate root is not trusted: %v", err)
+	chainFailures, root := checkCertChain(result, state, roots, selfSigned, now)
+	failures = append(failures, chainFailures...)
+	failures = append(failures, checkCertAlgorithms(result, state)...)
+	verification := certVerification{checkedName: hostname, matchedName: matchedName, failures: failures, root: root}
+	result.Certificate = makeCertificateInfo(cert)
+	result.Certificate.ChainLength = len(state.PeerCertificates)
+	result.Certificate.CheckedName = hostname
+	result.Certificate.MatchedName = matchedName
+	if root != nil {
+		result.Certificate.Root = rootName(root)
 	}
-	return result.Success()
+	return result.Success(), verification
 }
 
 func tlsConfigForCipher(ciphers []uint16) tls.Config {
@@ -160,76 +754,226 @@ func tlsConfigForCipher(ciphers []uint16) tls.Config {
 }
 
 // Checks to see that insecure ciphers are disabled.
-func checkTLSCipher(hostname string, timeout time.Duration) *Result {
+func checkTLSCipher(ctx context.Context, hostname string, dialer smtpDialer) *Result {
 	result := MakeResult("cipher")
 	badCiphers := []uint16{
 		tls.TLS_RSA_WITH_RC4_128_SHA,
 		tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
 		tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA}
-	client, err := smtpDialWithTimeout(hostname, timeout)
+	config := tlsConfigForCipher(badCiphers)
+	accepted, err := dialer.tlsHandshake(ctx, hostname, &config)
 	if err != nil {
 		return result.Error("Could not establish connection with hostname %s", hostname)
 	}
-	defer client.Close()
-	config := tlsConfigForCipher(badCiphers)
-	err = client.StartTLS(&config)
-	if err == nil {
+	if accepted {
 		return result.Failure("Server should NOT be able to negotiate any ciphers with RC4.")
 	}
 	return result.Success()
 }
 
-func checkTLSVersion(client *smtp.Client, hostname string, timeout time.Duration) *Result {
+func checkTLSVersion(ctx context.Context, tlsConnectionState tls.ConnectionState, ok bool, hostname string, dialer smtpDialer) *Result {
 	result := MakeResult(Version)
 
 	// Check the TLS version of the existing connection.
-	tlsConnectionState, ok := client.TLSConnectionState()
 	if !ok {
 		// We shouldn't end up here because we already checked that STARTTLS succeeded.
 		return result.Error("Could not check TLS connection version.")
 	}
+	rules := dialer.profile.rules()
 	if tlsConnectionState.Version < tls.VersionTLS12 {
-		result = result.Warning("Server should support TLSv1.2, but doesn't.")
+		result = result.report(rules.noTLS12, "Server should support TLSv1.2, but doesn't.")
 	}
+	result.addCheck(checkTLS13(tlsConnectionState, rules))
 
 	// Attempt to connect with an old SSL version.
-	client, err := smtpDialWithTimeout(hostname, timeout)
-	if err != nil {
-		return result.Error("Could not establish connection: %v", err)
-	}
-	defer client.Close()
 	config := tls.Config{
 		InsecureSkipVerify: true,
 		MinVersion:         tls.VersionSSL30,
 		MaxVersion:         tls.VersionSSL30,
 	}
-	err = client.StartTLS(&config)
-	if err == nil {
-		return result.Failure("Server should NOT support SSLv2/3, but does.")
+	accepted, err := dialer.tlsHandshake(ctx, hostname, &config)
+	if err != nil {
+		return result.Error("Could not establish connection: %v", err)
+	}
+	if accepted {
+		return result.report(rules.sslv3, "Server should NOT support SSLv2/3, but does.")
 	}
 	return result.Success()
 }
 
+// checkTLS13 reports whether TLS 1.3 was negotiated. Since the client offers
+// it, that means the server supports it.
+func checkTLS13(tlsConnectionState tls.ConnectionState, rules tlsProfileRules) *Result {
+	result := MakeResult(TLS13)
+	if tlsConnectionState.Version < tls.VersionTLS13 {
+		return result.report(rules.noTLS13, "Server doesn't support TLSv1.3, which is faster and drops legacy cipher suites.")
+	}
+	return result.Success()
+}
+
+// checkResumption reconnects to hostname and tries to resume the TLS session
+// cached in config by the first connection. Returns whether the session was
+// resumed, or a nil result if hostname couldn't be reconnected to.
+// Whether a server accepts TLS 1.3 early data (0-RTT) isn't checked (see
+// Checker.CheckResumption).
+func checkResumption(ctx context.Context, hostname string, dialer smtpDialer, config *tls.Config) (*Result, bool) {
+	state, ok, err := dialer.tlsConnectionState(ctx, hostname, config)
+	if err != nil || !ok {
+		// The version check reports on connection problems.
+		return nil, false
+	}
+	result := MakeResult(Resumption)
+	if !state.DidResume {
+		return result.Info("Server doesn't support TLS session resumption, so each connection requires a full handshake."), false
+	}
+	return result.Success(), true
+}
+
+// dialer returns the smtpDialer configured by c, with timeout.
+func (c *Checker) dialer(timeout time.Duration) smtpDialer {
+	return smtpDialer{
+		timeout:           timeout,
+		ehloName:          c.EHLOName,
+		localAddr:         c.LocalAddr,
+		limiter:           c.ConnectionLimiter,
+		slowGreeting:      c.SlowGreeting,
+		port:              c.Port,
+		portModes:         c.PortModes,
+		probeSNI:          c.ProbeSNI,
+		probeTLSRequired:  c.ProbeTLSRequired,
+		clock:             c.Now,
+		proxyHeader:       c.ProxyHeader,
+		sniOverride:       c.SNIOverride,
+		quick:             c.QuickMode,
+		tracer:            c.Tracer,
+		selfSigned:        c.SelfSignedPolicy,
+		enumerateCiphers:  c.EnumerateCipherSuites,
+		checkResumption:   c.CheckResumption,
+		profile:           c.TLSProfile,
+		certExpiryWarning: c.CertExpiryWarning,
+		certExpiryFailure: c.CertExpiryFailure,
+		ctLogs:            c.CTLogs,
+	}
+}
+
+// CheckHostnameContext performs the full set of hostname checks against the
+// single mailserver hostname on port, without looking up any MX records, and
+// checks its certificate against expectedName. It's meant for debugging a
+// single server, so c.Cache and c.CheckHostname aren't used. Stops checking
+// when ctx expires, marking the checks that didn't complete as timed out.
+// If port is zero, c.Port is used. If expectedName is empty, the certificate
+// is checked against hostname.
+// CheckDomain performs the same checks on each MX hostname, unless
+// c.CheckHostname is set.
+func (c *Checker) CheckHostnameContext(ctx context.Context, hostname string, port int, expectedName string) HostnameResult {
+	address := hostname
+	if port != 0 {
+		address = net.JoinHostPort(hostname, strconv.Itoa(port))
+	}
+	if err := c.Validate(); err != nil {
+		result := HostnameResult{Hostname: address, Result: MakeResult("hostnames"), Timestamp: c.now()}
+		result.addCheck(MakeResult(Connectivity).Error("%v", err))
+		return result
+	}
+	if expectedName == hostname {
+		expectedName = ""
+	}
+	return c.checkHostnameContext(ctx, "", address, expectedName)
+}
+
+// checkHostnameContext performs CheckHostnameContext on address, an MX
+// hostname of domain (which may be empty), without validating c.
+func (c *Checker) checkHostnameContext(ctx context.Context, domain string, address string, expectedName string) HostnameResult {
+	result := fullCheckHostname(ctx, domain, address, c.dialer(c.timeout()), c.RootCAs)
+	c.checkRevocation(ctx, &result)
+	result.stamp(c.now())
+	if expectedName != "" {
+		// Check the certificate against expectedName instead.
+		if renamed, ok := result.forHostname(expectedName, c.RootCAs, c.SelfSignedPolicy); ok {
+			renamed.Hostname = address
+			renamed.stamp(c.now())
+			result = renamed
+		}
+	}
+	c.checkDANEHostname(ctx, &result)
+	result.Result = result.Result.escalate(c.strictChecks())
+	return result
+}
+
 // checkHostname returns the result of c.CheckHostname or FullCheckHostname,
 // using or updating the Checker's cache.
-func (c *Checker) checkHostname(domain string, hostname string) HostnameResult {
-	check := c.CheckHostname
-	if check == nil {
-		// If CheckHostname hasn't been set, default to the full set of checks.
-		check = FullCheckHostname
+// If ctx expires first, the checks that didn't complete are marked as timed
+// out, and the result isn't cached.
+func (c *Checker) checkHostname(ctx context.Context, domain string, hostname string) HostnameResult {
+	ctx, span := c.tracer().Start(ctx, "hostname")
+	span.SetAttribute("hostname", hostname)
+	defer span.End()
+	hostnameResult := c.checkHostnameCached(ctx, domain, hostname, span)
+	span.SetAttribute("status", hostnameResult.StatusText())
+	if hostnameResult.TLSVersion != 0 {
+		span.SetAttribute("tls_version", TLSVersionName(hostnameResult.TLSVersion))
+	}
+	return hostnameResult
+}
+
+// checkHostnameCached performs checkHostname, recording on span whether the
+// result was cached.
+func (c *Checker) checkHostnameCached(ctx context.Context, domain string, hostname string, span Span) HostnameResult {
+	check := func(domain string, hostname string, timeout time.Duration) HostnameResult {
+		if c.CheckHostname == nil {
+			// If CheckHostname hasn't been set, default to the full set of
+			// checks, as performed by CheckHostnameContext.
+			return c.checkHostnameContext(ctx, domain, hostname, "")
+		}
+		result := c.checkHostnameUntil(ctx, c.CheckHostname, domain, hostname, timeout)
+		result.stamp(c.now())
+		return result
 	}
 
 	if c.Cache == nil {
 		return check(domain, hostname, c.timeout())
 	}
 	hostnameResult, err := c.Cache.GetHostnameScan(hostname)
+	span.SetAttribute("cached", err == nil)
 	if err != nil {
 		hostnameResult = check(domain, hostname, c.timeout())
-		c.Cache.PutHostnameScan(hostname, hostnameResult)
+		if !expired(ctx) {
+			c.Cache.PutHostnameScan(hostname, hostnameResult)
+		}
 	}
 	return hostnameResult
 }
 
+// checkHostnameUntil runs check, which doesn't support cancellation, until
+// ctx expires. If it does, the hostname's checks are marked as timed out.
+func (c *Checker) checkHostnameUntil(ctx context.Context, check func(string, string, time.Duration) HostnameResult,
+	domain string, hostname string, timeout time.Duration) HostnameResult {
+	if ctx.Done() == nil {
+		return check(domain, hostname, timeout)
+	}
+	done := make(chan HostnameResult, 1)
+	go func() { done <- check(domain, hostname, timeout) }()
+	select {
+	case result := <-done:
+		return result
+	case <-ctx.Done():
+		return c.timedOutHostnameResult(ctx, domain, hostname)
+	}
+}
+
+// timedOutHostnameResult returns a result for a hostname whose checks didn't
+// complete before ctx expired.
+func (c *Checker) timedOutHostnameResult(ctx context.Context, domain string, hostname string) HostnameResult {
+	result := HostnameResult{
+		Domain:    domain,
+		Hostname:  hostname,
+		Result:    MakeResult("hostnames"),
+		Timestamp: c.now(),
+	}
+	result.timedOut(ctx, c.dialer(0).checks()...)
+	return result
+}
+
 // NoopCheckHostname returns a fake error result containing `domain` and `hostname`.
 func NoopCheckHostname(domain string, hostname string, _ time.Duration) HostnameResult {
 	r := HostnameResult{
@@ -245,32 +989,229 @@ func NoopCheckHostname(domain string, hostname string, _ time.Duration) Hostname
 // `domain` is the mail domain that this server serves email for.
 // `hostname` is the hostname for this server.
 func FullCheckHostname(domain string, hostname string, timeout time.Duration) HostnameResult {
-	result := HostnameResult{
+	return fullCheckHostname(context.Background(), domain, hostname, smtpDialer{timeout: timeout}, nil)
+}
+
+// hostnameChecks lists the checks performed by fullCheckHostname, in order.
+var hostnameChecks = []string{Connectivity, STARTTLS, Certificate, Version}
+
+// quickHostnameChecks lists the checks performed by fullCheckHostname in
+// QuickMode.
+var quickHostnameChecks = hostnameChecks[:2]
+
+// checkQuit records whether the server replied to QUIT with 221, given the
+// error from smtpSession.quit, and notes on connectivityResult if it didn't.
+func (h *HostnameResult) checkQuit(err error, connectivityResult *Result) {
+	accepted := err == nil
+	h.QuitAccepted = &accepted
+	var protoErr *textproto.Error
+	switch {
+	case accepted:
+		return
+	case errors.As(err, &protoErr):
+		connectivityResult.Warning("Server replied to QUIT with \"%d %s\" rather than 221, which suggests it doesn't end SMTP sessions correctly.", protoErr.Code, protoErr.Msg)
+	default:
+		connectivityResult.Info("Server didn't reply to QUIT before the connection closed: %v", err)
+	}
+	h.addCheck(connectivityResult)
+}
+
+// timedOut marks each of checks that hasn't completed as timed out, if ctx
+// has expired. Returns true if it has.
+func (h HostnameResult) timedOut(ctx context.Context, checks ...string) bool {
+	if !expired(ctx) {
+		return false
+	}
+	for _, check := range checks {
+		h.addCheck(timedOutResult(check))
+	}
+	return true
+}
+
+// expired returns true if ctx has been canceled or its deadline has passed.
+// Unlike ctx.Err(), it doesn't lag behind connection deadlines set from ctx.
+func expired(ctx context.Context) bool {
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return true
+	}
+	return ctx.Err() != nil
+}
+
+// timedOutResult is the result of a check that didn't complete before the
+// scan's deadline.
+func timedOutResult(name string) *Result {
+	return MakeResult(name).Error("Timed out before the check completed.")
+}
+
+// fullCheckHostname performs the checks of FullCheckHostname. If ctx expires,
+// the checks that have completed are returned, and the rest are marked as
+// timed out.
+func fullCheckHostname(ctx context.Context, domain string, hostname string, dialer smtpDialer, roots *x509.CertPool) (result HostnameResult) {
+	result = HostnameResult{
 		Domain:    domain,
 		Hostname:  hostname,
 		Result:    MakeResult("hostnames"),
-		Timestamp: time.Now(),
+		Timestamp: dialer.now(),
 	}
 
 	// Connect to the SMTP server and use that connection to perform as many checks as possible.
 	connectivityResult := MakeResult(Connectivity)
-	client, err := smtpDialWithTimeout(hostname, timeout)
+	config := clientTLSConfig()
+	if dialer.checkResumption {
+		// Sessions are cached, so that checkResumption can try to resume them.
+		config.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	}
+	if dialer.sniOverride != "" {
+		// The certificate is still checked against hostname.
+		config.ServerName = dialer.sniOverride
+		result.SNI = dialer.sniOverride
+	}
+	tracer := tracerOrDefault(dialer.tracer)
+	_, dialSpan := tracer.Start(ctx, "dial")
+	session, err := dialer.dialSession(ctx, hostname, config)
+	dialSpan.SetAttribute("tls_mode", string(session.mode))
 	if err != nil {
-		result.addCheck(connectivityResult.Error("Could not establish connection: %v", err))
+		dialSpan.SetAttribute("error", err.Error())
+	}
+	dialSpan.End()
+	client := session.client
+	result.TLSMode = session.mode
+	result.Greeting = session.greeting.banner
+	result.GreetingDelay = session.greeting.delay
+	checks := dialer.checks()
+	if result.timedOut(ctx, checks...) {
+		if client != nil {
+			session.quit(ctx)
+		}
+		return result
+	}
+	if errors.Is(err, ErrTLSHandshake) {
+		// The server accepted the connection, but not the implicit TLS handshake.
+		result.addCheck(connectivityResult.Success())
+		result.addCheck(MakeResult(STARTTLS).Failure("Could not complete an implicit TLS handshake."))
+		result.err = err
+		return result
+	}
+	if err != nil {
+		result.ConnectionFailure = classifyDialError(err)
+		connectivityResult.Error("Could not establish connection: %v", err)
+		if hint, ok := connectionFailureHints[result.ConnectionFailure]; ok {
+			connectivityResult.Error("%s", hint)
+		}
+		result.addCheck(connectivityResult)
 		return result
 	}
-	defer client.Close()
+	defer func() {
+		err := session.quit(ctx)
+		// After a failed handshake, or STARTTLS that wasn't followed through,
+		// the server can't be expected to understand QUIT.
+		if _, ok := session.connectionState(); ok && result.err == nil && !expired(ctx) {
+			result.checkQuit(err, connectivityResult)
+		}
+	}()
+	checkGreeting(session.greeting, dialer.slowGreetingThreshold(), connectivityResult)
 	result.addCheck(connectivityResult.Success())
 
-	result.addCheck(checkStartTLS(client))
-	if result.Status != Success {
+	var auth AuthInfo
+	var starttlsResult *Result
+	_, starttlsSpan := tracer.Start(ctx, "starttls")
+	if session.mode == ModeImplicitTLS {
+		starttlsResult = MakeResult(STARTTLS).Info("Negotiated TLS implicitly on connecting, rather than with STARTTLS.")
+		auth.AfterSTARTTLS = authMechanisms(client)
+	} else if dialer.quick {
+		starttlsResult, err = checkStartTLS(client, config)
+	} else {
+		auth.BeforeSTARTTLS = authMechanisms(client)
+		if dialer.probeTLSRequired {
+			result.TLSRequired = probeTLSRequired(client)
+		}
+		starttlsResult, err = checkStartTLS(client, config)
+		if result.TLSRequired != nil && *result.TLSRequired {
+			starttlsResult.Info("Server requires STARTTLS before accepting mail.")
+		}
+	}
+	starttlsSpan.SetAttribute("status", starttlsResult.StatusText())
+	starttlsSpan.End()
+	if result.timedOut(ctx, checks[1:]...) {
 		return result
 	}
-	result.addCheck(checkCert(client, domain, hostname))
-	// result.addCheck(checkTLSCipher(hostname))
+	result.addCheck(starttlsResult)
+	result.err = err
+	if dialer.quick {
+		return result
+	}
+	state, ok := session.connectionState()
+	if ok && session.mode == ModeSTARTTLS {
+		// The client repeats EHLO after STARTTLS.
+		auth.AfterSTARTTLS = authMechanisms(client)
+	}
+	// AUTH is checked alongside the TLS checks, rather than before them, so
+	// that offering AUTH in the clear doesn't stop the certificate from being
+	// checked.
+	authResult := checkAuth(auth)
+	if authResult != nil {
+		result.Auth = &auth
+	}
+	if !result.Status.succeeded() {
+		if authResult != nil {
+			result.addCheck(authResult)
+		}
+		return result
+	}
+	if ok && len(state.PeerCertificates) > 0 {
+		result.Certificate = makeCertificateInfo(state.PeerCertificates[0])
+		result.tlsState = &state
+		result.TLSVersion = state.Version
+	}
+	certResult, verification := checkCert(state, ok, hostname, roots, dialer.selfSigned, result.Timestamp)
+	if ok && len(state.PeerCertificates) > 0 {
+		certResult.addCheck(checkCertExpiry(state, dialer.certExpiryWarningThreshold(), dialer.certExpiryFailure, result.Timestamp))
+		if ocspResult, staple := checkOCSPStaple(state.OCSPResponse, state.PeerCertificates, result.Timestamp); ocspResult != nil {
+			certResult.addCheck(ocspResult)
+			certResult.Certificate.OCSPStaple = staple
+			result.Certificate.OCSPStaple = staple
+		}
+		// SCTs are recorded for aggregate scans even if they aren't checked.
+		ctResult, scts := checkSCTs(state, verification.root, roots == nil, dialer.ctLogs, result.Timestamp)
+		if ctResult != nil && len(dialer.ctLogs) > 0 {
+			certResult.addCheck(ctResult)
+		}
+		certResult.Certificate.SCTs = scts
+		result.Certificate.SCTs = scts
+	}
+	result.setCertVerification(verification)
+	if ok && dialer.probeSNI && !expired(ctx) {
+		result.SNICertificates, result.noSNIState = probeSNI(ctx, domain, hostname, dialer, session.mode, roots, certResult)
+	}
+	result.addCheck(certResult)
+	// result.addCheck(checkTLSCipher(ctx, hostname, dialer))
 
 	// Creates a new connection to check for SSLv2/3 support because we can't call starttls twice.
-	result.addCheck(checkTLSVersion(client, hostname, timeout))
+	_, versionSpan := tracer.Start(ctx, "version")
+	versionResult := checkTLSVersion(ctx, state, ok, hostname, dialer)
+	versionSpan.SetAttribute("status", versionResult.StatusText())
+	versionSpan.End()
+	var resumptionResult *Result
+	if ok && dialer.checkResumption && !expired(ctx) {
+		resumptionResult, result.SessionResumption = checkResumption(ctx, hostname, dialer, config)
+	}
+	if ok && dialer.enumerateCiphers && !expired(ctx) {
+		_, ciphersSpan := tracer.Start(ctx, "ciphers")
+		result.CipherSuites = enumerateCipherSuites(ctx, hostname, dialer, state)
+		checkCipherSuites(result.CipherSuites, dialer.profile.rules(), versionResult)
+		ciphersSpan.SetAttribute("accepted", len(result.CipherSuites))
+		ciphersSpan.End()
+	}
+	if authResult != nil {
+		result.addCheck(authResult)
+	}
+	if resumptionResult != nil {
+		result.addCheck(resumptionResult)
+	}
+	if result.timedOut(ctx, Version) {
+		return result
+	}
+	result.addCheck(versionResult)
 
 	return result
 }
@@ -0,0 +1,32 @@
+package checker
+
+import "log"
+
+// Logger is the interface used by the checker to log its progress and
+// errors. *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// stdLogger writes to the standard logger, so that it respects log.SetOutput
+// and log.SetFlags.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// nopLogger discards everything logged to it.
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...interface{}) {}
+
+// NopLogger is a Logger that discards all output.
+var NopLogger Logger = nopLogger{}
+
+func loggerOrDefault(l Logger) Logger {
+	if l != nil {
+		return l
+	}
+	return stdLogger{}
+}
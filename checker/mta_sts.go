@@ -2,16 +2,21 @@ package checker
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // MTASTSResult represents the result of a check for inbound MTA-STS support.
@@ -20,6 +25,13 @@ type MTASTSResult struct {
 	Policy string // Text of MTA-STS policy file
 	Mode   string
 	MXs    []string
+	ID     string // id field of the MTA-STS TXT record
+	// InvalidMXs are the mx patterns in the policy that aren't valid (see
+	// validMXPattern).
+	InvalidMXs []string
+	// PolicyCertSubject is the subject of the certificate presented by the
+	// policy host, mta-sts.<domain>, if the policy file was fetched.
+	PolicyCertSubject string
 }
 
 // MakeMTASTSResult constructs a base result object and returns its pointer.
@@ -36,17 +48,57 @@ func (m MTASTSResult) MarshalJSON() ([]byte, error) {
 	type FakeResult Result
 	return json.Marshal(struct {
 		FakeResult
-		Policy string   `json:"policy"`
-		Mode   string   `json:"mode"`
-		MXs    []string `json:"mxs"`
+		SchemaVersion int      `json:"schema_version"`
+		StatusText    string   `json:"status_text,omitempty"`
+		Timestamp     string   `json:"timestamp,omitempty"`
+		Policy        string   `json:"policy"`
+		Mode          string   `json:"mode"`
+		MXs           []string `json:"mxs"`
+		ID            string   `json:"id,omitempty"`
+		InvalidMXs    []string `json:"invalid_mxs,omitempty"`
+		PolicyCert    string   `json:"policy_cert_subject,omitempty"`
 	}{
-		FakeResult: FakeResult(*m.Result),
-		Policy:     m.Policy,
-		Mode:       m.Mode,
-		MXs:        m.MXs,
+		FakeResult:    FakeResult(*m.Result),
+		SchemaVersion: ResultSchemaVersion,
+		StatusText:    m.StatusText(),
+		Timestamp:     formatTimestamp(m.Timestamp),
+		Policy:        m.Policy,
+		Mode:          m.Mode,
+		MXs:           m.MXs,
+		ID:            m.ID,
+		InvalidMXs:    m.InvalidMXs,
+		PolicyCert:    m.PolicyCertSubject,
 	})
 }
 
+// UnmarshalJSON prevents MTASTSResult from inheriting the version of
+// UnmarshalJSON implemented by Result, which would ignore the policy.
+func (m *MTASTSResult) UnmarshalJSON(b []byte) error {
+	var result Result
+	if err := json.Unmarshal(b, &result); err != nil {
+		return err
+	}
+	var policy struct {
+		Policy     string   `json:"policy"`
+		Mode       string   `json:"mode"`
+		MXs        []string `json:"mxs"`
+		ID         string   `json:"id"`
+		InvalidMXs []string `json:"invalid_mxs"`
+		PolicyCert string   `json:"policy_cert_subject"`
+	}
+	if err := json.Unmarshal(b, &policy); err != nil {
+		return err
+	}
+	m.Result = &result
+	m.Policy = policy.Policy
+	m.Mode = policy.Mode
+	m.MXs = policy.MXs
+	m.ID = policy.ID
+	m.InvalidMXs = policy.InvalidMXs
+	m.PolicyCertSubject = policy.PolicyCert
+	return nil
+}
+
 func filterByPrefix(records []string, prefix string) []string {
 	filtered := []string{}
 	for _, elem := range records {
@@ -76,49 +128,148 @@ func getKeyValuePairs(record string, lineDelimiter string,
 	return parsed
 }
 
-func checkMTASTSRecord(domain string, timeout time.Duration) *Result {
-	result := MakeResult(MTASTSText)
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+// lookupTXT retrieves the TXT records of name.
+func (c *Checker) lookupTXT(ctx context.Context, name string) ([]string, error) {
+	if c.lookupTXTOverride != nil {
+		return c.lookupTXTOverride(name)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
 	defer cancel()
 	var r net.Resolver
-	records, err := r.LookupTXT(ctx, fmt.Sprintf("_mta-sts.%s", domain))
+	return r.LookupTXT(ctx, name)
+}
+
+// isTimeout returns true if err is a network error caused by a timeout.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// checkMTASTSRecord checks the MTA-STS TXT record of domain, and returns its
+// id if it has a valid one.
+func (c *Checker) checkMTASTSRecord(ctx context.Context, domain string) (*Result, string) {
+	result := MakeResult(MTASTSText)
+	records, err := c.lookupTXT(ctx, fmt.Sprintf("_mta-sts.%s", domain))
+	if err != nil && (expired(ctx) || isTimeout(err)) {
+		return result.Error("Timed out looking up the MTA-STS TXT record."), ""
+	}
 	if err != nil {
-		return result.Failure("Couldn't find an MTA-STS TXT record: %v.", err)
+		return result.Failure("Couldn't find an MTA-STS TXT record: %v.", err), ""
 	}
 	return validateMTASTSRecord(records, result)
 }
 
-func validateMTASTSRecord(records []string, result *Result) *Result {
+// The id of an MTA-STS TXT record is 1 to 32 alphanumeric characters
+// (RFC 8461, section 3.1).
+var mtaSTSIDPattern = regexp.MustCompile("^[a-zA-Z0-9]{1,32}$")
+
+func validateMTASTSRecord(records []string, result *Result) (*Result, string) {
 	records = filterByPrefix(records, "v=STSv1")
 	if len(records) != 1 {
-		return result.Failure("Exactly 1 MTA-STS TXT record required, found %d.", len(records))
+		return result.Failure("Exactly 1 MTA-STS TXT record required, found %d.", len(records)), ""
 	}
 	record := getKeyValuePairs(records[0], ";", "=")
 
-	idPattern := regexp.MustCompile("^[a-zA-Z0-9]+$")
-	if !idPattern.MatchString(record["id"]) {
-		return result.Failure("Invalid MTA-STS TXT record id %s.", record["id"])
+	id, ok := record["id"]
+	if !ok || id == "" {
+		return result.Failure("MTA-STS TXT record must specify an id."), ""
+	}
+	if !mtaSTSIDPattern.MatchString(id) {
+		return result.Failure("Invalid MTA-STS TXT record id %s. The id must be 1 to 32 letters and digits.", id), ""
+	}
+	return result.Success(), id
+}
+
+// mtaSTSIDTime returns the time encoded in an MTA-STS TXT record id, if it
+// looks like one. Ids are often timestamps, like 20190429T010101, 20190429 or
+// a Unix time, so that they change whenever the policy does.
+func mtaSTSIDTime(id string) (time.Time, bool) {
+	for _, layout := range []string{"20060102T150405", "20060102150405", "200601021504", "2006010215", "20060102"} {
+		if len(id) != len(layout) {
+			continue
+		}
+		if t, err := time.Parse(layout, id); err == nil && t.Year() >= 1990 && t.Year() <= 2100 {
+			return t, true
+		}
+	}
+	if len(id) == 10 {
+		if seconds, err := strconv.ParseInt(id, 10, 64); err == nil {
+			return time.Unix(seconds, 0).UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// checkMTASTSIDFreshness warns if the policy file was modified after the time
+// in the TXT record's id, which suggests the policy changed without the id
+// being updated, so senders with a cached policy won't fetch the new one.
+// Times without a timezone are ambiguous, so a day's leeway is allowed.
+func checkMTASTSIDFreshness(id string, lastModified time.Time, result *Result) {
+	idTime, ok := mtaSTSIDTime(id)
+	if !ok || lastModified.IsZero() {
+		return
 	}
-	return result.Success()
+	if lastModified.After(idTime.Add(24 * time.Hour)) {
+		result.Warning("The MTA-STS policy file was last modified at %s, after the time in the MTA-STS TXT record id %s. If the policy changed, update the id, or senders that cached the old policy won't fetch the new one.",
+			lastModified.UTC().Format(time.RFC3339), id)
+	}
+}
+
+// mtaSTSPolicyFile is an MTA-STS policy file fetched by checkMTASTSPolicyFile.
+type mtaSTSPolicyFile struct {
+	// text is the text of the policy, or "" if it couldn't be fetched.
+	text string
+	// fields are the parsed fields of the policy.
+	fields map[string]string
+	// lastModified is when the server says the policy was last modified, if
+	// it does.
+	lastModified time.Time
+	// certSubject is the subject of the policy host's certificate.
+	certSubject string
 }
 
-func checkMTASTSPolicyFile(domain string, hostnameResults map[string]HostnameResult, timeout time.Duration) (*Result, string, map[string]string) {
+// checkMTASTSPolicyFile fetches and checks the MTA-STS policy file of domain.
+// The policy host's certificate must be valid for mta-sts.<domain>, even if
+// client doesn't verify it, and redirects aren't followed (RFC 8461, section
+// 3.3). Fails if the policy is larger than maxSize bytes, without reading
+// any more of it.
+func checkMTASTSPolicyFile(ctx context.Context, domain string, hostnameResults map[string]HostnameResult, client *http.Client, maxSize int64) (*Result, mtaSTSPolicyFile) {
 	result := MakeResult(MTASTSPolicyFile)
-	client := &http.Client{
-		Timeout: timeout,
-		// Don't follow redirects.
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
-	}
-	policyURL := fmt.Sprintf("https://mta-sts.%s/.well-known/mta-sts.txt", domain)
-	resp, err := client.Get(policyURL)
+	file := mtaSTSPolicyFile{fields: map[string]string{}}
+	policyHost := fmt.Sprintf("mta-sts.%s", domain)
+	policyURL := fmt.Sprintf("https://%s/.well-known/mta-sts.txt", policyHost)
+	req, err := http.NewRequest(http.MethodGet, policyURL, nil)
 	if err != nil {
-		return result.Failure("Couldn't find policy file at %s.", policyURL), "", map[string]string{}
+		return result.Error("Couldn't build request for %s: %v.", policyURL, err), file
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil && (expired(ctx) || isTimeout(err)) {
+		return result.Error("Timed out fetching policy file from %s.", policyURL), file
+	}
+	if err != nil {
+		if reason := policyCertError(err); reason != "" {
+			return result.Failure("Couldn't fetch policy file from %s: %s.", policyURL, reason), file
+		}
+		return result.Failure("Couldn't find policy file at %s.", policyURL), file
+	}
+	defer resp.Body.Close()
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return result.Failure("Policy file at %s wasn't served over TLS.", policyURL), file
+	}
+	cert := resp.TLS.PeerCertificates[0]
+	file.certSubject = cert.Subject.CommonName
+	if err := cert.VerifyHostname(policyHost); err != nil {
+		return result.Failure("The certificate presented by %s isn't valid for it. The certificate is only valid for: %s.",
+			policyHost, strings.Join(certNames(cert), ", ")), file
+	}
+	if location := resp.Header.Get("Location"); resp.StatusCode >= 300 && resp.StatusCode < 400 && location != "" {
+		return result.Failure("Couldn't get policy file: %s redirects to %s, but redirects must not be followed when fetching MTA-STS policies.",
+			policyURL, location), file
 	}
 	if resp.StatusCode != 200 {
-		return result.Failure("Couldn't get policy file: %s returned %s.", policyURL, resp.Status), "", map[string]string{}
+		return result.Failure("Couldn't get policy file: %s returned %s.", policyURL, resp.Status), file
 	}
+	file.lastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
 	// Media type should be text/plain, ignoring other Content-Type parms.
 	// Format: Content-Type := type "/" subtype *[";" parameter]
 	for _, contentType := range resp.Header["Content-Type"] {
@@ -127,16 +278,37 @@ func checkMTASTSPolicyFile(domain string, hostnameResults map[string]HostnameRes
 			result.Warning("The media type specified by your policy file's Content-Type header should be text/plain.")
 		}
 	}
-	defer resp.Body.Close()
-	// Read up to 64,000 bytes of response body.
-	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64000))
+	// Read one byte more than allowed, to tell if the policy is too large.
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
 	if err != nil {
-		return result.Error("Couldn't read policy file: %v.", err), "", map[string]string{}
+		return result.Error("Couldn't read policy file: %v.", err), file
 	}
+	if int64(len(body)) > maxSize {
+		return result.Failure("Policy file at %s is larger than %d bytes. MTA-STS policies should be much smaller.", policyURL, maxSize), file
+	}
+
+	file.text = string(body)
+	file.fields = validateMTASTSPolicyFile(file.text, result)
+	validateMTASTSMXs(policyMXs(file.fields), hostnameResults, result)
+	return result, file
+}
 
-	policy := validateMTASTSPolicyFile(string(body), result)
-	validateMTASTSMXs(strings.Split(policy["mx"], " "), hostnameResults, result)
-	return result, string(body), policy
+// policyCertError describes why the policy host's certificate was rejected,
+// if that's why err, from fetching the policy file, occurred. Otherwise,
+// returns "".
+func policyCertError(err error) string {
+	var hostnameErr x509.HostnameError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &hostnameErr):
+		return fmt.Sprintf("the certificate isn't valid for the policy host (%v)", hostnameErr)
+	case errors.As(err, &authorityErr):
+		return "the certificate isn't signed by a trusted authority"
+	case errors.As(err, &invalidErr):
+		return fmt.Sprintf("the certificate isn't valid (%v)", invalidErr)
+	}
+	return ""
 }
 
 func validateMTASTSPolicyFile(body string, result *Result) map[string]string {
@@ -146,17 +318,24 @@ func validateMTASTSPolicyFile(body string, result *Result) map[string]string {
 		result.Failure("Your MTA-STS policy file version must be STSv1.")
 	}
 
-	if policy["mode"] == "" {
-		result.Failure("Your MTA-STS policy file must specify mode.")
-	}
-	if m := policy["mode"]; m == "testing" {
+	// The modes of RFC 8461, section 5.
+	switch m := policy["mode"]; m {
+	case "enforce":
+	case "testing":
 		result.Warning("You're still in \"testing\" mode; senders won't enforce TLS when connecting to your mailservers. We recommend switching from \"testing\" to \"enforce\" to get the full security benefits of MTA-STS, as long as it hasn't been affecting your deliverability.")
-	} else if m == "none" {
-		result.Failure("MTA-STS policy is in \"none\" mode; senders won't enforce TLS when connecting to your mailservers.")
-	} else if m != "enforce" {
+	case "none":
+		result.Failure("MTA-STS policy is in \"none\" mode, which opts out of MTA-STS; senders won't enforce TLS when connecting to your mailservers.")
+	case "":
+		result.Failure("Your MTA-STS policy file must specify mode.")
+	default:
 		result.Failure("Mode must be one of \"enforce\", \"testing\", or \"none\", got %s", m)
 	}
 
+	if invalid := invalidMXPatterns(policyMXs(policy)); len(invalid) > 0 {
+		result.Failure("These mx patterns in your MTA-STS policy file aren't valid: %s. A pattern may only have a wildcard as its whole left-most label, as in *.example.com.",
+			strings.Join(invalid, ", "))
+	}
+
 	if policy["max_age"] == "" {
 		result.Failure("Your MTA-STS policy file must specify max_age.")
 	}
@@ -167,9 +346,54 @@ func validateMTASTSPolicyFile(body string, result *Result) map[string]string {
 	return policy
 }
 
+// validMXPattern returns true if pattern is a valid mx pattern: a hostname,
+// optionally with a wildcard as its whole left-most label (RFC 8461, section
+// 3.2). Internationalized labels are allowed.
+func validMXPattern(pattern string) bool {
+	labels := strings.Split(strings.TrimSuffix(pattern, "."), ".")
+	if labels[0] == "*" && len(labels) > 1 {
+		labels = labels[1:]
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, r := range label {
+			if r < utf8.RuneSelf && r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// policyMXs returns the mx patterns of a parsed policy, in order.
+func policyMXs(policy map[string]string) []string {
+	return strings.Fields(policy["mx"])
+}
+
+// invalidMXPatterns returns the patterns that aren't valid mx patterns, like
+// mx*.example.com or *.*.example.com.
+func invalidMXPatterns(patterns []string) []string {
+	var invalid []string
+	for _, pattern := range patterns {
+		if !validMXPattern(pattern) {
+			invalid = append(invalid, pattern)
+		}
+	}
+	return invalid
+}
+
 func validateMTASTSMXs(policyFileMXs []string, dnsMXs map[string]HostnameResult,
 	result *Result) {
-	for dnsMX, dnsMXResult := range dnsMXs {
+	// Check hostnames in order, so that the messages are too.
+	hostnames := []string{}
+	for dnsMX := range dnsMXs {
+		hostnames = append(hostnames, dnsMX)
+	}
+	sort.Strings(hostnames)
+	for _, dnsMX := range hostnames {
+		dnsMXResult := dnsMXs[dnsMX]
 		if !dnsMXResult.couldConnect() {
 			// Ignore hostnames we couldn't connect to, they may be spam traps.
 			continue
@@ -184,17 +408,40 @@ func validateMTASTSMXs(policyFileMXs []string, dnsMXs map[string]HostnameResult,
 	}
 }
 
-func (c Checker) checkMTASTS(domain string, hostnameResults map[string]HostnameResult) *MTASTSResult {
+// checkMTASTS checks the MTA-STS record and policy file of domain. Each of them
+// gets its own timeout, so if one times out, the other is still reported.
+func (c Checker) checkMTASTS(ctx context.Context, domain string, hostnameResults map[string]HostnameResult) *MTASTSResult {
 	if c.checkMTASTSOverride != nil {
 		// Allow the Checker to mock this function.
 		return c.checkMTASTSOverride(domain, hostnameResults)
 	}
 	result := MakeMTASTSResult()
-	result.addCheck(checkMTASTSRecord(domain, c.timeout()))
-	policyResult, policy, policyMap := checkMTASTSPolicyFile(domain, hostnameResults, c.timeout())
+	recordCtx, cancel := context.WithTimeout(ctx, c.timeout())
+	recordCtx, span := c.tracer().Start(recordCtx, "mta-sts.record")
+	recordResult, id := c.checkMTASTSRecord(recordCtx, domain)
+	span.SetAttribute("status", recordResult.StatusText())
+	span.End()
+	cancel()
+	result.ID = id
+	if c.SkipMTASTSPolicyFile {
+		result.addCheck(recordResult)
+		return result
+	}
+	policyCtx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	policyCtx, span = c.tracer().Start(policyCtx, "mta-sts.policy-fetch")
+	policyResult, file := checkMTASTSPolicyFile(policyCtx, domain, hostnameResults, c.httpClient(), c.maxPolicyFileSize())
+	span.SetAttribute("status", policyResult.StatusText())
+	span.End()
+	if file.text != "" {
+		checkMTASTSIDFreshness(id, file.lastModified, recordResult)
+	}
+	result.addCheck(recordResult)
 	result.addCheck(policyResult)
-	result.Policy = policy
-	result.Mode = policyMap["mode"]
-	result.MXs = strings.Split(policyMap["mx"], " ")
+	result.Policy = file.text
+	result.Mode = file.fields["mode"]
+	result.MXs = policyMXs(file.fields)
+	result.InvalidMXs = invalidMXPatterns(result.MXs)
+	result.PolicyCertSubject = file.certSubject
 	return result
 }
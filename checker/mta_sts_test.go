@@ -2,9 +2,19 @@ package checker
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestMarshalMTASTSJSON(t *testing.T) {
@@ -56,17 +66,52 @@ func TestValidateMTASTSRecord(t *testing.T) {
 		{[]string{"v=STSv1; id=1234", "v=STSv1; id=5678"}, Failure},
 		{[]string{"v=STSv1; id=20171114T070707;"}, Success},
 		{[]string{"v=STSv1; id=;"}, Failure},
+		{[]string{"v=STSv1;"}, Failure},
+		{[]string{"v=STSv1; id=123456789012345678901234567890123;"}, Failure},
 		{[]string{"v=STSv1; id=###;"}, Failure},
 		{[]string{"v=spf1 a -all"}, Failure},
 	}
 	for _, test := range tests {
-		result := validateMTASTSRecord(test.txt, &Result{})
+		result, _ := validateMTASTSRecord(test.txt, &Result{})
 		if result.Status != test.status {
 			t.Errorf("validateMTASTSRecord(%v) = %v", test.txt, result)
 		}
 	}
 }
 
+func TestValidateMTASTSRecordID(t *testing.T) {
+	_, id := validateMTASTSRecord([]string{"v=STSv1; id=20171114T070707;"}, MakeResult(MTASTSText))
+	if id != "20171114T070707" {
+		t.Errorf("Expected id 20171114T070707, got %q", id)
+	}
+}
+
+func TestCheckMTASTSIDFreshness(t *testing.T) {
+	modified := time.Date(2019, 4, 29, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		id           string
+		lastModified time.Time
+		status       Status
+	}{
+		{"20190429T010101", modified, Success},
+		{"20190429", modified, Success},
+		{"20190401", modified, Warning},
+		{"20190401120000", modified, Warning},
+		{"1556539200", modified, Success},
+		{"1554120000", modified, Warning},
+		// The id isn't a time, or the modification time is unknown.
+		{"abc123", modified, Success},
+		{"20190401", time.Time{}, Success},
+	}
+	for _, test := range tests {
+		result := MakeResult(MTASTSText)
+		checkMTASTSIDFreshness(test.id, test.lastModified, result)
+		if result.Status != test.status {
+			t.Errorf("checkMTASTSIDFreshness(%s, %v) = %v, want status %d", test.id, test.lastModified, result, test.status)
+		}
+	}
+}
+
 func TestValidateMTASTSPolicyFile(t *testing.T) {
 	tests := []struct {
 		txt    string
@@ -78,6 +123,9 @@ func TestValidateMTASTSPolicyFile(t *testing.T) {
 		{"\nmx: foo.example.com\nmx: bar.example.com\n", Failure},
 		{"version: STSv1\nmode: enforce\nmax_age:0\nmx: foo.example.com\nmx: bar.example.com\n", Failure},
 		{"version: STSv1\nmode: start_turtles\nmax_age:100000\nmx: foo.example.com\nmx: bar.example.com\n", Failure},
+		{"version: STSv1\nmode: testing\nmax_age:100000\nmx: foo.example.com\n", Warning},
+		{"version: STSv1\nmode: none\nmax_age:100000\nmx: foo.example.com\n", Failure},
+		{"version: STSv1\nmode: enforce\nmax_age:100000\nmx: *.example.com\nmx: mx*.example.com\n", Failure},
 	}
 	for _, test := range tests {
 		result := &Result{}
@@ -86,6 +134,12 @@ func TestValidateMTASTSPolicyFile(t *testing.T) {
 			t.Errorf("validateMTASTSPolicyFile(%v) = %v", test.txt, result)
 		}
 	}
+
+	result := MakeResult(MTASTSPolicyFile)
+	validateMTASTSPolicyFile("version: STSv1\nmax_age:100000\nmx: foo.example.com\n", result)
+	if len(result.Messages) != 1 || !strings.Contains(result.Messages[0], "must specify mode") {
+		t.Errorf("Expected missing mode to be reported once, got %v", result.Messages)
+	}
 }
 
 func TestValidateMTASTSMXs(t *testing.T) {
@@ -93,8 +147,8 @@ func TestValidateMTASTSMXs(t *testing.T) {
 		Result: &Result{
 			Status: 3,
 			Checks: map[string]*Result{
-				"connectivity": {Connectivity, 0, nil, nil},
-				"starttls":     {STARTTLS, 0, nil, nil},
+				"connectivity": {Name: Connectivity, Status: 0},
+				"starttls":     {Name: STARTTLS, Status: 0},
 			},
 		},
 	}
@@ -102,8 +156,8 @@ func TestValidateMTASTSMXs(t *testing.T) {
 		Result: &Result{
 			Status: 3,
 			Checks: map[string]*Result{
-				"connectivity": {Connectivity, 0, nil, nil},
-				"starttls":     {STARTTLS, 3, nil, nil},
+				"connectivity": {Name: Connectivity, Status: 0},
+				"starttls":     {Name: STARTTLS, Status: 3},
 			},
 		},
 	}
@@ -146,3 +200,278 @@ func TestValidateMTASTSMXs(t *testing.T) {
 		}
 	}
 }
+
+func TestPolicyFileFetchUsesProxy(t *testing.T) {
+	var tunneled string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			tunneled = r.Host
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Checker{Proxy: proxyURL}
+	result, _ := checkMTASTSPolicyFile(context.Background(), "example.com", map[string]HostnameResult{}, c.httpClient(), defaultMaxPolicyFileSize)
+	if result.Status != Failure {
+		t.Errorf("Expected policy fetch through a refusing proxy to fail, got %v", result)
+	}
+	if tunneled != "mta-sts.example.com:443" {
+		t.Errorf("Expected proxy to tunnel to mta-sts.example.com:443, got %q", tunneled)
+	}
+}
+
+func TestPolicyFileFetchUsesLocalAddr(t *testing.T) {
+	var remote string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote, _, _ = net.SplitHostPort(r.RemoteAddr)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Checker{Proxy: proxyURL, LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}}
+	checkMTASTSPolicyFile(context.Background(), "example.com", map[string]HostnameResult{}, c.httpClient(), defaultMaxPolicyFileSize)
+	if remote != "127.0.0.2" {
+		t.Errorf("Expected policy fetch from 127.0.0.2, got %q", remote)
+	}
+}
+
+func TestCustomHTTPClientDoesNotFollowRedirects(t *testing.T) {
+	c := Checker{HTTPClient: &http.Client{}}
+	client := c.httpClient()
+	if client.CheckRedirect == nil {
+		t.Fatal("Expected client to refuse redirects")
+	}
+	if err := client.CheckRedirect(nil, nil); err != http.ErrUseLastResponse {
+		t.Errorf("Expected CheckRedirect to return ErrUseLastResponse, got %v", err)
+	}
+	if c.HTTPClient.CheckRedirect != nil {
+		t.Error("httpClient should not modify the configured HTTPClient")
+	}
+}
+
+type countingTransport struct {
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.requests++
+	return nil, fmt.Errorf("unexpected request to %s", r.URL)
+}
+
+func TestSkipMTASTSPolicyFile(t *testing.T) {
+	transport := &countingTransport{}
+	c := Checker{
+		Timeout:              testTimeout,
+		HTTPClient:           &http.Client{Transport: transport},
+		SkipMTASTSPolicyFile: true,
+	}
+	result := c.checkMTASTS(context.Background(), "example.com", map[string]HostnameResult{})
+	if transport.requests != 0 {
+		t.Errorf("Expected policy file not to be fetched, got %d requests", transport.requests)
+	}
+	if _, ok := result.Checks[MTASTSText]; !ok {
+		t.Errorf("Expected TXT record to be checked, got %v", result.Checks)
+	}
+	if _, ok := result.Checks[MTASTSPolicyFile]; ok {
+		t.Errorf("Expected policy file check to be omitted, got %v", result.Checks[MTASTSPolicyFile])
+	}
+}
+
+// fakeMTASTSDomain serves policy as the MTA-STS policy file of every domain,
+// and returns a Checker whose policy fetches go to it, and whose TXT lookups
+// return txt as the MTA-STS record of domain. Other lookups and the hostname
+// checks are mocked as usual. Call the returned function to stop the server.
+func fakeMTASTSDomain(t *testing.T, domain, txt, policy string) (Checker, func()) {
+	return fakeMTASTSServer(t, domain, txt, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/mta-sts.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, policy)
+	}))
+}
+
+// fakeMTASTSServer is like fakeMTASTSDomain, but policy fetches are handled by
+// handler.
+func fakeMTASTSServer(t *testing.T, domain, txt string, handler http.Handler) (Checker, func()) {
+	return fakeMTASTSServerWithCert(t, "mta-sts."+domain, domain, txt, handler)
+}
+
+// fakeMTASTSServerWithCert is like fakeMTASTSServer, but the server presents
+// a certificate for certName, which the Checker's client trusts.
+func fakeMTASTSServerWithCert(t *testing.T, certName, domain, txt string, handler http.Handler) (Checker, func()) {
+	now := time.Now()
+	root := issueTestCert(t, "Test Root", true, now.Add(-time.Hour), now.Add(time.Hour), nil)
+	leaf := issueTestCert(t, certName, false, now.Add(-time.Hour), now.Add(time.Hour), root)
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{
+		{Certificate: [][]byte{leaf.cert.Raw}, PrivateKey: leaf.key},
+	}}
+	server.StartTLS()
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, server.Listener.Addr().String())
+		},
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}
+	c := Checker{
+		HTTPClient: &http.Client{Transport: transport},
+		lookupTXTOverride: func(name string) ([]string, error) {
+			if name != "_mta-sts."+domain {
+				return nil, fmt.Errorf("no TXT records for %s", name)
+			}
+			return []string{txt}, nil
+		},
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	return c, server.Close
+}
+
+func TestInvalidMXPatterns(t *testing.T) {
+	patterns := []string{
+		"mx.example.com", "*.example.com", "mx.example.com.", "mx-1.example.com",
+		"mx*.example.com", "*.*.example.com", "mx.*.example.com", "*", "-mx.example.com", "mx..example.com",
+	}
+	expected := []string{"mx*.example.com", "*.*.example.com", "mx.*.example.com", "*", "-mx.example.com", "mx..example.com"}
+	if invalid := invalidMXPatterns(patterns); !reflect.DeepEqual(invalid, expected) {
+		t.Errorf("Expected invalid patterns %q, got %q", expected, invalid)
+	}
+
+	policy := "version: STSv1\nmode: enforce\nmx: mx*.example.com\nmx: hostname1\nmax_age: 86400\n"
+	c, stop := fakeMTASTSDomain(t, "domain", "v=STSv1; id=1", policy)
+	defer stop()
+	result := c.CheckDomain("domain", nil).MTASTSResult
+	if !reflect.DeepEqual(result.InvalidMXs, []string{"mx*.example.com"}) {
+		t.Errorf("Expected invalid pattern to be reported, got %q", result.InvalidMXs)
+	}
+	if messages := strings.Join(result.Checks[MTASTSPolicyFile].Messages, "\n"); !strings.Contains(messages, "mx*.example.com") {
+		t.Errorf("Expected invalid pattern to fail the policy file check, got %q", messages)
+	}
+}
+
+func TestMTASTSEndToEnd(t *testing.T) {
+	policy := "version: STSv1\nmode: enforce\nmx: hostname1\nmx: hostname2\nmax_age: 86400\n"
+	c, stop := fakeMTASTSDomain(t, "domain", "v=STSv1; id=20190429T010101", policy)
+	defer stop()
+
+	result := c.CheckDomain("domain", nil).MTASTSResult
+	if result.Status != Success {
+		t.Errorf("Expected MTA-STS check to succeed, got %v", result.Result)
+	}
+	if result.Mode != "enforce" || result.ID != "20190429T010101" || result.Policy != policy {
+		t.Errorf("Expected policy to be recorded, got mode %q, id %q and policy %q", result.Mode, result.ID, result.Policy)
+	}
+
+	c, stop = fakeMTASTSDomain(t, "domain", "v=STSv1; id=1", "version: STSv1\nmode: enforce\nmx: hostname1\nmax_age: 86400\n")
+	defer stop()
+	result = c.CheckDomain("domain", nil).MTASTSResult
+	if result.Checks[MTASTSText].Status != Success || result.Checks[MTASTSPolicyFile].Status != Failure {
+		t.Errorf("Expected policy missing an MX to fail, got %v", result.Result)
+	}
+
+	c, stop = fakeMTASTSDomain(t, "domain", "v=STSv1; id=1", "version: STSv1\nmode: none\nmax_age: 86400\n")
+	defer stop()
+	if result = c.CheckDomain("domain", nil).MTASTSResult; len(result.MXs) != 0 {
+		t.Errorf("Expected a policy without MXs to record none, got %q", result.MXs)
+	}
+}
+
+func TestMTASTSPolicyFileTimeout(t *testing.T) {
+	release := make(chan struct{})
+	c, stop := fakeMTASTSServer(t, "domain", "v=STSv1; id=1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer stop()
+	defer close(release)
+	c.Timeout = testTimeout
+
+	result := c.CheckDomain("domain", nil)
+	policyResult := result.MTASTSResult.Checks[MTASTSPolicyFile]
+	if policyResult.Status != Error || !strings.Contains(policyResult.Messages[0], "Timed out") {
+		t.Errorf("Expected hanging policy fetch to time out, got %v", policyResult)
+	}
+	if result.MTASTSResult.Status != Error || result.MTASTSResult.Checks[MTASTSText].Status != Success {
+		t.Errorf("Expected only the policy file check to time out, got %v", result.MTASTSResult.Result)
+	}
+	if result.Status != DomainSuccess || len(result.HostnameResults) != 2 {
+		t.Errorf("Expected hostname results to be unaffected, got status %d and %v", result.Status, result.HostnameResults)
+	}
+}
+
+func TestMTASTSPolicyHostCertificate(t *testing.T) {
+	policy := "version: STSv1\nmode: enforce\nmx: hostname1\nmx: hostname2\nmax_age: 86400\n"
+	serve := func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, policy) }
+	c, stop := fakeMTASTSServer(t, "domain", "v=STSv1; id=1", http.HandlerFunc(serve))
+	defer stop()
+	result := c.CheckDomain("domain", nil).MTASTSResult
+	if result.Status != Success || result.PolicyCertSubject != "mta-sts.domain" {
+		t.Errorf("Expected policy host's certificate to be recorded, got %q and %v", result.PolicyCertSubject, result.Result)
+	}
+
+	c, stop = fakeMTASTSServerWithCert(t, "other.domain", "domain", "v=STSv1; id=1", http.HandlerFunc(serve))
+	defer stop()
+	policyResult := c.CheckDomain("domain", nil).MTASTSResult.Checks[MTASTSPolicyFile]
+	if policyResult.Status != Failure || !strings.Contains(policyResult.Messages[0], "isn't valid for the policy host") {
+		t.Errorf("Expected certificate for another host to fail, got %v", policyResult)
+	}
+
+	// Even a client that doesn't verify certificates mustn't accept one for
+	// another host.
+	c.HTTPClient.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify = true
+	result = c.CheckDomain("domain", nil).MTASTSResult
+	policyResult = result.Checks[MTASTSPolicyFile]
+	if policyResult.Status != Failure || !strings.Contains(policyResult.Messages[0], "isn't valid for it") {
+		t.Errorf("Expected certificate for another host to fail without verification, got %v", policyResult)
+	}
+	if result.PolicyCertSubject != "other.domain" {
+		t.Errorf("Expected policy host's certificate to be recorded, got %q", result.PolicyCertSubject)
+	}
+}
+
+func TestMTASTSPolicyFileRedirect(t *testing.T) {
+	c, stop := fakeMTASTSServer(t, "domain", "v=STSv1; id=1", http.RedirectHandler("https://elsewhere.example.com/mta-sts.txt", http.StatusFound))
+	defer stop()
+	policyResult := c.CheckDomain("domain", nil).MTASTSResult.Checks[MTASTSPolicyFile]
+	if policyResult.Status != Failure || !strings.Contains(policyResult.Messages[0], "redirects to https://elsewhere.example.com/mta-sts.txt") {
+		t.Errorf("Expected redirect not to be followed, got %v", policyResult)
+	}
+}
+
+func TestMaxPolicyFileSize(t *testing.T) {
+	policy := "version: STSv1\nmode: enforce\nmx: hostname1\nmx: hostname2\nmax_age: 86400\n"
+	c, stop := fakeMTASTSDomain(t, "domain", "v=STSv1; id=1", policy+strings.Repeat("\n", defaultMaxPolicyFileSize))
+	defer stop()
+	result := c.CheckDomain("domain", nil).MTASTSResult
+	if policyResult := result.Checks[MTASTSPolicyFile]; policyResult.Status != Failure || !strings.Contains(policyResult.Messages[0], "larger than 65536 bytes") {
+		t.Errorf("Expected oversized policy file to fail, got %v", policyResult)
+	}
+	if result.Policy != "" {
+		t.Errorf("Expected oversized policy not to be recorded, got %d bytes", len(result.Policy))
+	}
+
+	c, stop = fakeMTASTSDomain(t, "domain", "v=STSv1; id=1", policy)
+	defer stop()
+	c.MaxPolicyFileSize = int64(len(policy))
+	if result := c.CheckDomain("domain", nil).MTASTSResult; result.Status != Success {
+		t.Errorf("Expected policy file within the limit to be read, got %v", result.Result)
+	}
+}
@@ -0,0 +1,159 @@
+package checker
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// MXRecord is a single MX record of a mail domain.
+type MXRecord struct {
+	Hostname   string `json:"hostname"`
+	Preference uint16 `json:"preference"`
+}
+
+// MXHost summarizes the checks of a single MX host.
+type MXHost struct {
+	Hostname   string `json:"hostname"`
+	Preference uint16 `json:"preference"`
+	// Addresses the hostname resolved to, if they were looked up.
+	IPs []string `json:"ips,omitempty"`
+	// Aliases the hostname resolved through, if it's an alias.
+	CNAMEs []string `json:"cnames,omitempty"`
+	// Name of the TLS version negotiated, e.g. "TLSv1.2", if TLS was.
+	TLSVersion string `json:"tls_version,omitempty"`
+	// Whether the host supports STARTTLS (or implicit TLS).
+	STARTTLS bool `json:"starttls"`
+	// Whether the host's certificate passed the Certificate check.
+	ValidCertificate bool `json:"valid_certificate"`
+}
+
+// mxHosts summarizes the results of checking each of records.
+func mxHosts(records []MXRecord, hostnameResults map[string]HostnameResult) []MXHost {
+	hosts := make([]MXHost, 0, len(records))
+	for _, record := range records {
+		host := MXHost{Hostname: record.Hostname, Preference: record.Preference}
+		if result, ok := hostnameResults[record.Hostname]; ok && result.Result != nil {
+			host.IPs = result.IPs
+			host.CNAMEs = result.CNAMEs
+			if result.TLSVersion != 0 {
+				host.TLSVersion = TLSVersionName(result.TLSVersion)
+			}
+			host.STARTTLS = result.couldSTARTTLS()
+			host.ValidCertificate = result.subcheckSucceeded(Certificate)
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// lookupMXRecords retrieves the MX records associated with a domain, sorted
+// by preference, the resolver that answered, and their DNSSEC status (see
+// lookupMX). The domain should already be in ASCII (A-label) form.
+func (c *Checker) lookupMXRecords(ctx context.Context, domain string) ([]MXRecord, string, DNSSECStatus, error) {
+	mxs, resolver, dnssec, err := c.lookupMX(ctx, domain)
+	if err != nil {
+		return nil, resolver, dnssec, err
+	}
+	records := make([]MXRecord, 0)
+	for _, mx := range mxs {
+		records = append(records, MXRecord{
+			Hostname:   strings.ToLower(mx.Host),
+			Preference: mx.Pref,
+		})
+	}
+	// Stable, so that records with equal preference keep their DNS order.
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Preference < records[j].Preference
+	})
+	return records, resolver, dnssec, nil
+}
+
+// checkMXRecords reports on the MX topology of a domain. It warns if several
+// MX records share a preference, or if a backup MX doesn't support STARTTLS
+// while a primary MX does. It notes any MX hostname that is an alias, which RFC
+// 2181 doesn't allow.
+// `records` must be sorted by preference.
+func checkMXRecords(records []MXRecord, hostnameResults map[string]HostnameResult) *Result {
+	result := MakeResult(MXRecords)
+	byPreference := make(map[uint16][]string)
+	preferences := []uint16{}
+	for _, record := range records {
+		if _, ok := byPreference[record.Preference]; !ok {
+			preferences = append(preferences, record.Preference)
+		}
+		byPreference[record.Preference] = append(byPreference[record.Preference], record.Hostname)
+		if cnames := hostnameResults[record.Hostname].CNAMEs; len(cnames) > 0 {
+			result.Info("MX hostname %s is an alias (CNAME) for %s. RFC 2181 (section 10.3) doesn't allow MX records to point to aliases, "+
+				"and the mailserver's certificate must still be valid for %s, not just %s.",
+				record.Hostname, strings.Join(cnames, " -> "), record.Hostname, cnames[len(cnames)-1])
+		}
+	}
+	for _, preference := range preferences {
+		if hostnames := byPreference[preference]; len(hostnames) > 1 {
+			result.Warning("MX records %s share preference %d. This may be intentional, to balance load between them.",
+				strings.Join(hostnames, ", "), preference)
+		}
+	}
+	if len(preferences) < 2 {
+		return result.Success()
+	}
+	primary := ""
+	for _, hostname := range byPreference[preferences[0]] {
+		if hostnameResults[hostname].Result != nil && hostnameResults[hostname].couldSTARTTLS() {
+			primary = hostname
+			break
+		}
+	}
+	if primary == "" {
+		return result.Success()
+	}
+	for _, preference := range preferences[1:] {
+		for _, hostname := range byPreference[preference] {
+			hostnameResult := hostnameResults[hostname]
+			if hostnameResult.Result == nil || !hostnameResult.couldConnect() {
+				// Ignore backups we couldn't connect to, they may be spam traps.
+				continue
+			}
+			if !hostnameResult.couldSTARTTLS() {
+				result.Warning("Backup MX %s (preference %d) doesn't support STARTTLS, but primary MX %s does.",
+					hostname, preference, primary)
+			}
+		}
+	}
+	return result.Success()
+}
+
+// checkExpectedMXs reports whether the MX records of a domain match
+// expectedHostnames, e.g. the MXs for the domain on a policy list. It fails
+// on any MX hostname that isn't expected, and on any expected hostname that
+// no MX hostname matches.
+//
+// Hostnames are matched with PolicyMatches: case-insensitively, ignoring a
+// trailing dot on the MX hostname, and with patterns like "*.example.com" (or ".example.com")
+// matching any hostname with exactly one more label. The order of records, and
+// their preferences, don't matter.
+func checkExpectedMXs(records []MXRecord, expectedHostnames []string) *Result {
+	result := MakeResult(ExpectedMXs)
+	hostnames := make([]string, 0)
+	for _, record := range records {
+		if !PolicyMatches(record.Hostname, expectedHostnames) {
+			result.Failure("MX hostname %s isn't one of the expected hostnames: %s.",
+				record.Hostname, strings.Join(expectedHostnames, ", "))
+		}
+		hostnames = append(hostnames, record.Hostname)
+	}
+	for _, pattern := range expectedHostnames {
+		matched := false
+		for _, hostname := range hostnames {
+			if PolicyMatches(hostname, []string{pattern}) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			result.Failure("Expected hostname %s doesn't match any MX hostname.", pattern)
+		}
+	}
+	return result.Success()
+}
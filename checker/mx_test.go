@@ -0,0 +1,153 @@
+package checker
+
+import (
+	"crypto/tls"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestLookupMXRecordsSorted(t *testing.T) {
+	c := Checker{
+		lookupMXOverride: func(string) ([]*net.MX, error) {
+			return []*net.MX{
+				{Host: "backup.example.com", Pref: 20},
+				{Host: "MX2.example.com", Pref: 10},
+				{Host: "mx1.example.com", Pref: 10},
+			}, nil
+		},
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	result := c.CheckDomain("example.com", nil)
+	expected := []MXRecord{
+		{"mx2.example.com", 10},
+		{"mx1.example.com", 10},
+		{"backup.example.com", 20},
+	}
+	if len(result.MXRecords) != len(expected) {
+		t.Fatalf("Expected MX records %v, got %v", expected, result.MXRecords)
+	}
+	for i, record := range expected {
+		if result.MXRecords[i] != record {
+			t.Errorf("Expected MX records %v, got %v", expected, result.MXRecords)
+			break
+		}
+	}
+	if got := result.ExtraResults[MXRecords].Status; got != Warning {
+		t.Errorf("Expected shared MX preference to produce a warning, got %d", got)
+	}
+}
+
+func TestCheckMXRecords(t *testing.T) {
+	good := HostnameResult{Result: &Result{Checks: map[string]*Result{
+		Connectivity: {Name: Connectivity, Status: Success},
+		STARTTLS:     {Name: STARTTLS, Status: Success},
+	}}}
+	noSTARTTLS := HostnameResult{Result: &Result{Checks: map[string]*Result{
+		Connectivity: {Name: Connectivity, Status: Success},
+		STARTTLS:     {Name: STARTTLS, Status: Failure},
+	}}}
+	noConnection := HostnameResult{Result: &Result{Checks: map[string]*Result{
+		Connectivity: {Name: Connectivity, Status: Error},
+	}}}
+	aliased := good
+	aliased.CNAMEs = []string{"mx.provider.example."}
+	tests := []struct {
+		records []MXRecord
+		results map[string]HostnameResult
+		status  Status
+	}{
+		{
+			[]MXRecord{{"mx1", 10}, {"mx2", 20}},
+			map[string]HostnameResult{"mx1": good, "mx2": good},
+			Success,
+		},
+		{
+			[]MXRecord{{"mx1", 10}, {"mx2", 10}},
+			map[string]HostnameResult{"mx1": good, "mx2": good},
+			Warning,
+		},
+		// Backup MX doesn't support STARTTLS.
+		{
+			[]MXRecord{{"mx1", 10}, {"mx2", 20}},
+			map[string]HostnameResult{"mx1": good, "mx2": noSTARTTLS},
+			Warning,
+		},
+		// Neither supports STARTTLS, so the backup isn't singled out.
+		{
+			[]MXRecord{{"mx1", 10}, {"mx2", 20}},
+			map[string]HostnameResult{"mx1": noSTARTTLS, "mx2": noSTARTTLS},
+			Success,
+		},
+		// Unreachable backups may be spam traps.
+		{
+			[]MXRecord{{"mx1", 10}, {"mx2", 20}},
+			map[string]HostnameResult{"mx1": good, "mx2": noConnection},
+			Success,
+		},
+		// MX hostnames shouldn't be aliases.
+		{
+			[]MXRecord{{"mx1", 10}},
+			map[string]HostnameResult{"mx1": aliased},
+			Info,
+		},
+	}
+	for _, test := range tests {
+		result := checkMXRecords(test.records, test.results)
+		if result.Status != test.status {
+			t.Errorf("checkMXRecords(%v) = %v, want status %d", test.records, result, test.status)
+		}
+	}
+}
+
+func TestMXHosts(t *testing.T) {
+	records := []MXRecord{{"mx1.example.com", 10}, {"mx2.example.com", 20}, {"unchecked.example.com", 30}}
+	results := map[string]HostnameResult{
+		"mx1.example.com": {
+			Result:     &Result{Checks: map[string]*Result{STARTTLS: MakeResult(STARTTLS), Certificate: MakeResult(Certificate)}},
+			IPs:        []string{"192.0.2.1"},
+			TLSVersion: tls.VersionTLS12,
+		},
+		"mx2.example.com": {
+			Result: &Result{Checks: map[string]*Result{STARTTLS: MakeResult(STARTTLS).Failure("Server does not advertise support for STARTTLS.")}},
+		},
+	}
+	expected := []MXHost{
+		{Hostname: "mx1.example.com", Preference: 10, IPs: []string{"192.0.2.1"}, TLSVersion: "TLSv1.2", STARTTLS: true, ValidCertificate: true},
+		{Hostname: "mx2.example.com", Preference: 20},
+		{Hostname: "unchecked.example.com", Preference: 30},
+	}
+	if hosts := mxHosts(records, results); !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("Expected MX hosts %+v, got %+v", expected, hosts)
+	}
+}
+
+func TestCheckExpectedMXs(t *testing.T) {
+	records := []MXRecord{{"mx1.example.com", 10}, {"mx2.example.com", 20}}
+	tests := []struct {
+		expected []string
+		status   Status
+	}{
+		{[]string{"mx2.example.com", "mx1.example.com"}, Success},
+		{[]string{"MX1.EXAMPLE.com", "mx2.example.com"}, Success},
+		{[]string{"*.example.com"}, Success},
+		{[]string{".example.com"}, Success},
+		// mx2.example.com is unexpected.
+		{[]string{"mx1.example.com"}, Failure},
+		// mx3.example.com is missing.
+		{[]string{"mx1.example.com", "mx2.example.com", "mx3.example.com"}, Failure},
+		{[]string{"*.example.com", "*.other.com"}, Failure},
+		// Wildcards only match one label.
+		{[]string{"*.com"}, Failure},
+		{[]string{}, Failure},
+	}
+	for _, test := range tests {
+		result := checkExpectedMXs(records, test.expected)
+		if result.Status != test.status {
+			t.Errorf("checkExpectedMXs(%v) = %v, want status %d", test.expected, result, test.status)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OCSPStaple describes the OCSP (RFC 6960) response a mailserver stapled to
+// its certificate during the TLS handshake.
+type OCSPStaple struct {
+	// Status is the certificate's status according to the response: "good",
+	// "revoked" or "unknown". It's empty if the response couldn't be parsed,
+	// and only to be trusted if Verified is set.
+	Status string `json:"status,omitempty"`
+	// ThisUpdate and NextUpdate are when the response was produced, and when
+	// a newer one will be, if it says (otherwise NextUpdate is zero).
+	ThisUpdate time.Time `json:"this_update"`
+	NextUpdate time.Time `json:"next_update"`
+	// RevokedAt is when the certificate was revoked, if it was.
+	RevokedAt time.Time `json:"revoked_at"`
+	// Verified is whether the response's signature was verified with the
+	// certificate's issuer, or a responder it delegated to.
+	Verified bool `json:"verified"`
+}
+
+// The ASN.1 structures of an OCSP response (RFC 6960, section 4.2.1).
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Version        int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []ocspSingleResponse
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag       `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag       `asn1:"tag:2,optional"`
+	ThisUpdate time.Time       `asn1:"generalized"`
+	NextUpdate time.Time       `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+// ocspBasicResponseType is the id-pkix-ocsp-basic response type.
+var ocspBasicResponseType = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+// ocspSignatureAlgorithms maps the OIDs of the signature algorithms that OCSP
+// responders use to crypto/x509's.
+var ocspSignatureAlgorithms = []struct {
+	oid       asn1.ObjectIdentifier
+	algorithm x509.SignatureAlgorithm
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}, x509.SHA1WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, x509.SHA256WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, x509.SHA384WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, x509.SHA512WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}, x509.ECDSAWithSHA1},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, x509.ECDSAWithSHA256},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, x509.ECDSAWithSHA384},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, x509.ECDSAWithSHA512},
+	{asn1.ObjectIdentifier{1, 3, 101, 112}, x509.PureEd25519},
+}
+
+// ocspHashes maps the OIDs of the hash algorithms that identify certificates
+// in OCSP requests and responses to their implementations.
+var ocspHashes = []struct {
+	oid  asn1.ObjectIdentifier
+	hash func() hash.Hash
+}{
+	{ocspSHA1, sha1.New},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}, sha256.New},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}, sha512.New384},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}, sha512.New},
+}
+
+// ocspHash returns the hash algorithm identified by oid, or nil if it isn't
+// supported.
+func ocspHash(oid asn1.ObjectIdentifier) func() hash.Hash {
+	for _, known := range ocspHashes {
+		if known.oid.Equal(oid) {
+			return known.hash
+		}
+	}
+	return nil
+}
+
+// digest returns the hash of data with newHash.
+func digest(newHash func() hash.Hash, data []byte) []byte {
+	h := newHash()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// issuerKey returns the bits of issuer's public key, which CertIDs hash.
+func issuerKey(issuer *x509.Certificate) ([]byte, error) {
+	var publicKeyInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &publicKeyInfo); err != nil {
+		return nil, err
+	}
+	return publicKeyInfo.PublicKey.RightAlign(), nil
+}
+
+// matches returns whether id identifies cert, which was issued by issuer: by
+// its serial number, and the hashes of its issuer's name and key. The key's
+// hash is only compared if issuer isn't nil.
+func (id ocspCertID) matches(cert, issuer *x509.Certificate) bool {
+	if id.SerialNumber == nil || id.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		return false
+	}
+	newHash := ocspHash(id.HashAlgorithm.Algorithm)
+	if newHash == nil || !bytes.Equal(digest(newHash, cert.RawIssuer), id.NameHash) {
+		return false
+	}
+	if issuer == nil {
+		return true
+	}
+	key, err := issuerKey(issuer)
+	return err == nil && bytes.Equal(digest(newHash, key), id.IssuerKeyHash)
+}
+
+// parsedOCSPResponse is a stapled OCSP response about leaf.
+type parsedOCSPResponse struct {
+	staple     OCSPStaple
+	tbs        []byte
+	algorithm  pkix.AlgorithmIdentifier
+	signature  []byte
+	responders []*x509.Certificate
+}
+
+// parseOCSPResponse parses the OCSP response der, and finds the status of
+// leaf, which was issued by issuer, in it. If issuer is nil, the status is
+// found without comparing the hash of the issuer's key.
+func parseOCSPResponse(der []byte, leaf, issuer *x509.Certificate) (*parsedOCSPResponse, error) {
+	var resp ocspResponse
+	if rest, err := asn1.Unmarshal(der, &resp); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("it isn't a valid OCSP response")
+	}
+	if resp.Status != 0 {
+		return nil, fmt.Errorf("its status is %d, rather than successful (0)", resp.Status)
+	}
+	if !resp.Response.ResponseType.Equal(ocspBasicResponseType) {
+		return nil, fmt.Errorf("it isn't a basic OCSP response")
+	}
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return nil, fmt.Errorf("it isn't a valid basic OCSP response")
+	}
+	var data ocspResponseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		return nil, fmt.Errorf("its response data isn't valid")
+	}
+	parsed := &parsedOCSPResponse{
+		tbs:       basic.TBSResponseData.FullBytes,
+		algorithm: basic.SignatureAlgorithm,
+		signature: basic.Signature.RightAlign(),
+	}
+	for _, raw := range basic.Certificates {
+		if cert, err := x509.ParseCertificate(raw.FullBytes); err == nil {
+			parsed.responders = append(parsed.responders, cert)
+		}
+	}
+	for _, single := range data.Responses {
+		if !single.CertID.matches(leaf, issuer) {
+			continue
+		}
+		parsed.staple.ThisUpdate = single.ThisUpdate
+		parsed.staple.NextUpdate = single.NextUpdate
+		switch {
+		case bool(single.Good):
+			parsed.staple.Status = "good"
+		case bool(single.Unknown):
+			parsed.staple.Status = "unknown"
+		default:
+			parsed.staple.Status = "revoked"
+			parsed.staple.RevokedAt = single.Revoked.RevocationTime
+		}
+		return parsed, nil
+	}
+	return nil, fmt.Errorf("it doesn't cover the certificate")
+}
+
+// verify checks the response's signature with issuer, or with a responder
+// certificate included in the response that issuer delegated OCSP signing to,
+// and which is valid at now.
+func (p *parsedOCSPResponse) verify(issuer *x509.Certificate, now time.Time) error {
+	algorithm := x509.UnknownSignatureAlgorithm
+	for _, known := range ocspSignatureAlgorithms {
+		if known.oid.Equal(p.algorithm.Algorithm) {
+			algorithm = known.algorithm
+		}
+	}
+	if algorithm == x509.UnknownSignatureAlgorithm {
+		return fmt.Errorf("unsupported signature algorithm %v", p.algorithm.Algorithm)
+	}
+	signer := issuer
+	for _, responder := range p.responders {
+		if bytes.Equal(responder.Raw, issuer.Raw) {
+			continue
+		}
+		if err := responder.CheckSignatureFrom(issuer); err != nil {
+			return fmt.Errorf("the responder's certificate isn't issued by the certificate's issuer: %v", err)
+		}
+		delegated := false
+		for _, usage := range responder.ExtKeyUsage {
+			delegated = delegated || usage == x509.ExtKeyUsageOCSPSigning
+		}
+		if !delegated {
+			return fmt.Errorf("the responder's certificate isn't authorized to sign OCSP responses")
+		}
+		if now.Before(responder.NotBefore) || now.After(responder.NotAfter) {
+			return fmt.Errorf("the responder's certificate is only valid from %s to %s",
+				responder.NotBefore.Format(time.RFC3339), responder.NotAfter.Format(time.RFC3339))
+		}
+		signer = responder
+		break
+	}
+	return signer.CheckSignature(algorithm, p.tbs, p.signature)
+}
+
+// checkOCSPStaple checks response, the OCSP response stapled to the leaf
+// certificate in chain: that it's signed by the certificate's issuer, current
+// at now, and says the certificate is good. A response that can't be verified,
+// because chain doesn't include the issuer, isn't trusted to say anything.
+// Returns nil if there's no stapled response, since mailservers rarely staple
+// one.
+func checkOCSPStaple(response []byte, chain []*x509.Certificate, now time.Time) (*Result, *OCSPStaple) {
+	if len(response) == 0 || len(chain) == 0 {
+		return nil, nil
+	}
+	result := MakeResult(OCSPStapling)
+	leaf := chain[0]
+	var issuer *x509.Certificate
+	for _, cert := range chain[1:] {
+		if bytes.Equal(cert.RawSubject, leaf.RawIssuer) {
+			issuer = cert
+			break
+		}
+	}
+	parsed, err := parseOCSPResponse(response, leaf, issuer)
+	if err != nil {
+		return result.Warning("Server stapled an OCSP response, but %v.", err), &OCSPStaple{}
+	}
+	if issuer == nil {
+		return result.Warning("The certificate's issuer wasn't presented, so the stapled OCSP response couldn't be verified, and clients can't rely on it."), &parsed.staple
+	}
+	if err := parsed.verify(issuer, now); err != nil {
+		return result.Warning("The stapled OCSP response isn't signed by the certificate's issuer: %v.", err), &parsed.staple
+	}
+	parsed.staple.Verified = true
+	reportOCSPStatus(result, "Certificate", "the stapled OCSP response", &parsed.staple, now)
+	return result.Success(), &parsed.staple
+}
+
+// reportOCSPStatus adds to result the problems with staple, an OCSP response
+// from source about the certificate described by subject: that it's revoked,
+// its status is unknown, or the response isn't current at now.
+func reportOCSPStatus(result *Result, subject, source string, staple *OCSPStaple, now time.Time) {
+	switch staple.Status {
+	case "revoked":
+		result.Failure("%s was revoked at %s, according to %s.", subject, staple.RevokedAt.Format(time.RFC3339), source)
+		return
+	case "unknown":
+		result.Warning("%s's status is unknown, according to %s.", subject, source)
+		return
+	}
+	source = capitalize(source)
+	if now.Before(staple.ThisUpdate) {
+		result.Warning("%s isn't valid until %s.", source, staple.ThisUpdate.Format(time.RFC3339))
+	} else if !staple.NextUpdate.IsZero() && now.After(staple.NextUpdate) {
+		result.Warning("%s is out of date: it should have been updated by %s.", source, staple.NextUpdate.Format(time.RFC3339))
+	}
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// The ASN.1 structures of an OCSP request (RFC 6960, section 4.1.1).
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+type ocspTBSRequest struct {
+	RequestList []ocspSingleRequest
+}
+
+type ocspSingleRequest struct {
+	CertID ocspCertID
+}
+
+// maxOCSPResponseSize caps how much of an OCSP responder's response is read.
+const maxOCSPResponseSize = 1024 * 1024
+
+// createOCSPRequest creates a request for the status of cert, which was issued
+// by issuer. Certificates are identified by SHA-1 hashes, which all responders
+// support.
+func createOCSPRequest(cert, issuer *x509.Certificate) ([]byte, error) {
+	certID, err := makeOCSPCertID(cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ocspRequest{ocspTBSRequest{[]ocspSingleRequest{{certID}}}})
+}
+
+// makeOCSPCertID identifies cert, which was issued by issuer, with SHA-1
+// hashes.
+func makeOCSPCertID(cert, issuer *x509.Certificate) (ocspCertID, error) {
+	key, err := issuerKey(issuer)
+	if err != nil {
+		return ocspCertID{}, err
+	}
+	return ocspCertID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: ocspSHA1, Parameters: asn1.NullRawValue},
+		NameHash:      digest(sha1.New, issuer.RawSubject),
+		IssuerKeyHash: digest(sha1.New, key),
+		SerialNumber:  cert.SerialNumber,
+	}, nil
+}
+
+// ocspSHA1 is the OID of SHA-1.
+var ocspSHA1 = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+
+// queryOCSP asks the OCSP responder at url for the status of cert, which was
+// issued by issuer.
+func queryOCSP(ctx context.Context, url string, cert, issuer *x509.Certificate, client *http.Client) (*parsedOCSPResponse, error) {
+	request, err := createOCSPRequest(cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxOCSPResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxOCSPResponseSize {
+		return nil, fmt.Errorf("the response is larger than %d bytes", maxOCSPResponseSize)
+	}
+	parsed, err := parseOCSPResponse(body, cert, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("the response is invalid: %v", err)
+	}
+	return parsed, nil
+}
+
+// checkOCSP queries the OCSP responder of each certificate in chain whose
+// issuer is also in chain, and fails if any of them is revoked. Returns
+// whether the leaf certificate's status was found, so that its CRL can be
+// checked instead if it wasn't.
+func checkOCSP(ctx context.Context, chain []*x509.Certificate, client *http.Client, now time.Time) (*Result, bool) {
+	result := MakeResult(OCSP)
+	leafChecked := false
+	for i, cert := range chain {
+		name := "the certificate"
+		if i > 0 {
+			name = fmt.Sprintf("intermediate certificate %s", cert.Subject.CommonName)
+		}
+		var issuer *x509.Certificate
+		for j, candidate := range chain {
+			if j != i && bytes.Equal(candidate.RawSubject, cert.RawIssuer) {
+				issuer = candidate
+				break
+			}
+		}
+		if issuer == nil || len(cert.OCSPServer) == 0 {
+			// Roots aren't checked, and intermediates don't need to be.
+			if i == 0 && issuer == nil {
+				result.Info("The certificate's issuer wasn't presented, so its OCSP responder couldn't be queried.")
+			} else if i == 0 {
+				result.Info("Certificate doesn't list an OCSP responder.")
+			}
+			continue
+		}
+		url := cert.OCSPServer[0]
+		parsed, err := queryOCSP(ctx, url, cert, issuer, client)
+		if err != nil {
+			result.Info("Couldn't get the status of %s from its OCSP responder at %s: %v.", name, url, err)
+			continue
+		}
+		if err := parsed.verify(issuer, now); err != nil {
+			result.Warning("The OCSP response from %s isn't signed by the issuer of %s: %v.", url, name, err)
+			continue
+		}
+		leafChecked = leafChecked || i == 0
+		reportOCSPStatus(result, capitalize(name), fmt.Sprintf("the OCSP response from %s", url), &parsed.staple, now)
+	}
+	return result.Success(), leafChecked
+}
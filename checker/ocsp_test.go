@@ -0,0 +1,240 @@
+package checker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// createOCSPResponse creates an OCSP response with status ("good", "revoked"
+// or "unknown") for leaf, which was issued by issuer, signed by signer. The
+// response includes the responders' certificates.
+func createOCSPResponse(t *testing.T, leaf, issuer *x509.Certificate, signer *ecdsa.PrivateKey, status string, thisUpdate, nextUpdate time.Time, responders ...*x509.Certificate) []byte {
+	t.Helper()
+	keyHash, err := asn1.Marshal([]byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certID, err := makeOCSPCertID(leaf, issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	single := ocspSingleResponse{
+		CertID:     certID,
+		ThisUpdate: thisUpdate.UTC().Truncate(time.Second),
+		NextUpdate: nextUpdate.UTC().Truncate(time.Second),
+	}
+	switch status {
+	case "good":
+		single.Good = true
+	case "unknown":
+		single.Unknown = true
+	default:
+		single.Revoked = ocspRevokedInfo{RevocationTime: thisUpdate.UTC().Truncate(time.Second)}
+	}
+	tbs, err := asn1.Marshal(ocspResponseData{
+		RawResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyHash},
+		ProducedAt:     thisUpdate.UTC().Truncate(time.Second),
+		Responses:      []ocspSingleResponse{single},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(tbs)
+	signature, err := ecdsa.SignASN1(rand.Reader, signer, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certificates []asn1.RawValue
+	for _, responder := range responders {
+		certificates = append(certificates, asn1.RawValue{FullBytes: responder.Raw})
+	}
+	basic, err := asn1.Marshal(ocspBasicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+		Certificates:       certificates,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := asn1.Marshal(ocspResponse{Response: ocspResponseBytes{ResponseType: ocspBasicResponseType, Response: basic}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestCheckOCSPStaple(t *testing.T) {
+	now := time.Now()
+	root := issueTestCert(t, "Test Root", true, now.Add(-time.Hour), now.Add(30*24*time.Hour), nil)
+	leaf := issueTestCert(t, "localhost", false, now.Add(-time.Hour), now.Add(30*24*time.Hour), root)
+	chain := []*x509.Certificate{leaf.cert, root.cert}
+	fresh := func(status string, signer *ecdsa.PrivateKey, responders ...*x509.Certificate) []byte {
+		return createOCSPResponse(t, leaf.cert, root.cert, signer, status, now.Add(-time.Hour), now.Add(time.Hour), responders...)
+	}
+	other := issueTestCert(t, "Other Root", true, now.Add(-time.Hour), now.Add(30*24*time.Hour), nil)
+	// responder issues a certificate delegating OCSP signing, valid until
+	// notAfter.
+	responder := func(notAfter time.Time) *testCert {
+		delegated := issueTestCert(t, "Test Responder", false, now.Add(-48*time.Hour), notAfter, root)
+		template := *delegated.cert
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}
+		der, err := x509.CreateCertificate(rand.Reader, &template, root.cert, delegated.cert.PublicKey, root.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delegated.cert, err = x509.ParseCertificate(der); err != nil {
+			t.Fatal(err)
+		}
+		return delegated
+	}
+	current := responder(now.Add(time.Hour))
+	expired := responder(now.Add(-time.Hour))
+	tests := []struct {
+		name     string
+		response []byte
+		chain    []*x509.Certificate
+		status   Status
+		verified bool
+	}{
+		{"good", fresh("good", root.key), chain, Success, true},
+		{"without issuer", fresh("good", root.key), chain[:1], Warning, false},
+		{"delegated responder", fresh("good", current.key, current.cert), chain, Success, true},
+		{"expired responder", fresh("good", expired.key, expired.cert), chain, Warning, false},
+		{"revoked", fresh("revoked", root.key), chain, Failure, true},
+		{"unknown", fresh("unknown", root.key), chain, Warning, true},
+		{"stale", createOCSPResponse(t, leaf.cert, root.cert, root.key, "good", now.Add(-48*time.Hour), now.Add(-24*time.Hour)), chain, Warning, true},
+		{"wrong signer", fresh("good", leaf.key), chain, Warning, false},
+		{"other certificate", createOCSPResponse(t, &x509.Certificate{SerialNumber: big.NewInt(2)}, root.cert, root.key, "good", now, now), chain, Warning, false},
+		{"other issuer", createOCSPResponse(t, leaf.cert, other.cert, root.key, "good", now, now), chain, Warning, false},
+		{"invalid", []byte("not an OCSP response"), chain, Warning, false},
+	}
+	for _, test := range tests {
+		result, staple := checkOCSPStaple(test.response, test.chain, now)
+		if result == nil || result.Status != test.status || staple == nil || staple.Verified != test.verified {
+			t.Errorf("%s: expected %v (verified: %v), got %v and %+v", test.name, test.status, test.verified, result, staple)
+		}
+	}
+	if result, staple := checkOCSPStaple(nil, chain, now); result != nil || staple != nil {
+		t.Errorf("Expected no result without a stapled response, got %v and %+v", result, staple)
+	}
+}
+
+func TestOCSPStapling(t *testing.T) {
+	now := time.Now()
+	root := issueTestCert(t, "Test Root", true, now.Add(-time.Hour), now.Add(30*24*time.Hour), nil)
+	leaf := issueTestCert(t, "localhost", false, now.Add(-time.Hour), now.Add(30*24*time.Hour), root)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	ln := smtpListenAndServe(t, &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{leaf.cert.Raw, root.cert.Raw},
+		PrivateKey:  leaf.key,
+		OCSPStaple:  createOCSPResponse(t, leaf.cert, root.cert, root.key, "good", now.Add(-time.Hour), now.Add(time.Hour)),
+	}}})
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	result := fullCheckHostname(context.Background(), "", net.JoinHostPort("localhost", port), smtpDialer{timeout: testTimeout}, roots)
+	certResult := result.Checks[Certificate]
+	if stapling := certResult.Checks[OCSPStapling]; stapling == nil || stapling.Status != Success {
+		t.Errorf("Expected a valid stapled OCSP response, got %v", stapling)
+	}
+	if staple := certResult.Certificate.OCSPStaple; staple == nil || staple.Status != "good" || !staple.Verified {
+		t.Errorf("Expected the stapled OCSP response to be described, got %+v", staple)
+	}
+}
+
+// withOCSPServer reissues cert with url as its OCSP responder.
+func withOCSPServer(t *testing.T, cert *x509.Certificate, ca *testCert, url string) *x509.Certificate {
+	t.Helper()
+	template := *cert
+	template.OCSPServer = []string{url}
+	der, err := x509.CreateCertificate(rand.Reader, &template, ca.cert, cert.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reissued, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reissued
+}
+
+func TestCheckOCSP(t *testing.T) {
+	now := time.Now()
+	responses := make(map[int64][]byte)
+	var crl []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ca.crl" {
+			w.Write(crl)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var request ocspRequest
+		if _, err := asn1.Unmarshal(body, &request); err != nil || r.URL.Path != "/ocsp" || len(request.TBSRequest.RequestList) != 1 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Write(responses[request.TBSRequest.RequestList[0].CertID.SerialNumber.Int64()])
+	}))
+	defer server.Close()
+	var ca *testCert
+	var leaves []*x509.Certificate
+	ca, leaves, crl = issueCRLTestChain(t, server.URL+"/ca.crl")
+	good := withOCSPServer(t, leaves[0], ca, server.URL+"/ocsp")
+	revoked := withOCSPServer(t, leaves[1], ca, server.URL+"/ocsp")
+	broken := withOCSPServer(t, leaves[1], ca, server.URL+"/broken")
+	responses[1] = createOCSPResponse(t, good, ca.cert, ca.key, "good", now.Add(-time.Hour), now.Add(time.Hour))
+	responses[2] = createOCSPResponse(t, revoked, ca.cert, ca.key, "revoked", now.Add(-time.Hour), now.Add(time.Hour))
+
+	tests := []struct {
+		name    string
+		chain   []*x509.Certificate
+		status  Status
+		checked bool
+	}{
+		{"good", []*x509.Certificate{good, ca.cert}, Success, true},
+		{"revoked", []*x509.Certificate{revoked, ca.cert}, Failure, true},
+		{"responder error", []*x509.Certificate{broken, ca.cert}, Info, false},
+		{"no responder", []*x509.Certificate{leaves[0], ca.cert}, Info, false},
+		{"no issuer", []*x509.Certificate{good}, Info, false},
+	}
+	for _, test := range tests {
+		result, checked := checkOCSP(context.Background(), test.chain, server.Client(), now)
+		if result.Status != test.status || checked != test.checked {
+			t.Errorf("%s: expected %v (checked: %v), got %v (checked: %v)", test.name, test.status, test.checked, result, checked)
+		}
+	}
+
+	// Without an answer from the OCSP responder, the CRL is checked instead.
+	c := Checker{CheckOCSP: true, HTTPClient: server.Client()}
+	for _, chain := range [][]*x509.Certificate{{revoked, ca.cert}, {broken, ca.cert}} {
+		certResult := MakeResult(Certificate)
+		h := HostnameResult{Result: MakeResult("hostnames"), tlsState: &tls.ConnectionState{PeerCertificates: chain}}
+		h.addCheck(certResult)
+		c.checkRevocation(context.Background(), &h)
+		if h.Checks[Certificate].Status != Failure || h.Checks[Certificate].Checks[OCSP] == nil {
+			t.Errorf("Expected revoked certificate to fail the Certificate check, got %v", h.Checks[Certificate])
+		}
+		if _, crlChecked := h.Checks[Certificate].Checks[CRL]; crlChecked != (chain[0] == broken) {
+			t.Errorf("Expected the CRL to be checked only if the OCSP responder didn't answer, got %v", h.Checks[Certificate].Checks)
+		}
+	}
+	// The CRL is kept for the Checker's later checks.
+	if cache := c.crlCache(); cache != c.crlCache() || cache.entries[server.URL+"/ca.crl"] == nil {
+		t.Errorf("Expected the Checker to keep its own CRL cache, got %v", cache.entries)
+	}
+}
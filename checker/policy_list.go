@@ -0,0 +1,44 @@
+package checker
+
+import (
+	"strings"
+)
+
+// DomainSet reports whether a domain is on a list.
+// *policy.List and *policy.UpdatedList implement it.
+type DomainSet interface {
+	HasDomain(domain string) bool
+}
+
+// PolicyListSource is a named policy list, like EFF's STARTTLS Everywhere
+// policy list or an organization's internal list of partner domains.
+// Lists in the same JSON format as EFF's can be read with policy.LoadList or
+// policy.LoadListFile. EFF's hosted list can be fetched once and kept fresh
+// with policy.MakeUpdatedList, and shared by every Checker.
+type PolicyListSource struct {
+	// Name identifies the list in results, e.g. "STARTTLS Everywhere".
+	Name string
+	List DomainSet
+}
+
+// checkPolicyLists reports which of sources domain is on.
+func checkPolicyLists(domain string, sources []PolicyListSource) *Result {
+	result := MakeResult(PolicyList)
+	domain = strings.ToLower(domain)
+	matched := []string{}
+	names := []string{}
+	for _, source := range sources {
+		names = append(names, source.Name)
+		if source.List.HasDomain(domain) {
+			matched = append(matched, source.Name)
+		}
+	}
+	if len(matched) == 0 {
+		return result.Failure("Domain %s is not on the %s policy list.", domain, strings.Join(names, " or "))
+	}
+	result.Success()
+	for _, name := range matched {
+		result.Info("Domain %s is on the %s policy list.", domain, name)
+	}
+	return result
+}
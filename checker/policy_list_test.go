@@ -0,0 +1,60 @@
+package checker
+
+import (
+	"strings"
+	"testing"
+)
+
+type mockDomainSet map[string]bool
+
+func (s mockDomainSet) HasDomain(domain string) bool {
+	return s[domain]
+}
+
+func TestCheckPolicyLists(t *testing.T) {
+	sources := []PolicyListSource{
+		{Name: "STARTTLS Everywhere", List: mockDomainSet{"eff.org": true, "example.com": true}},
+		{Name: "partners", List: mockDomainSet{"example.com": true}},
+	}
+	tests := []struct {
+		domain  string
+		status  Status
+		matches []string
+	}{
+		{"example.com", Info, []string{"STARTTLS Everywhere", "partners"}},
+		{"EFF.org", Info, []string{"STARTTLS Everywhere"}},
+		{"unlisted.com", Failure, nil},
+	}
+	for _, test := range tests {
+		result := checkPolicyLists(test.domain, sources)
+		if result.Status != test.status {
+			t.Errorf("Expected %s to have status %d, got %v", test.domain, test.status, result)
+		}
+		if len(result.Messages) != len(test.matches) && test.matches != nil {
+			t.Errorf("Expected %s to match %v, got %v", test.domain, test.matches, result.Messages)
+			continue
+		}
+		for i, name := range test.matches {
+			if !strings.Contains(result.Messages[i], "the "+name+" policy list") {
+				t.Errorf("Expected %s to match %s, got %v", test.domain, name, result.Messages)
+			}
+		}
+	}
+}
+
+func TestCheckDomainPolicyLists(t *testing.T) {
+	c := Checker{
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	if _, ok := c.CheckDomain("domain", nil).ExtraResults[PolicyList]; ok {
+		t.Error("Expected no PolicyList check without any policy lists")
+	}
+	c.PolicyLists = []PolicyListSource{{Name: "local", List: mockDomainSet{"domain": true}}}
+	if result := c.CheckDomain("domain", nil).ExtraResults[PolicyList]; result == nil || result.Status != Info {
+		t.Errorf("Expected domain to be found on local policy list, got %v", result)
+	}
+}
@@ -0,0 +1,94 @@
+package checker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// ProxyHeader configures the PROXY protocol header sent at the start of each
+// connection to a mailserver, for scanners whose connections pass through a
+// load balancer or TCP proxy that expects one.
+// See https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+type ProxyHeader struct {
+	// Version is the version of the PROXY protocol: 1 for the text header, or
+	// 2 for the binary one.
+	Version int
+
+	// Source specifies the source address announced in the header.
+	// If nil, the local address of the connection is used.
+	Source *net.TCPAddr
+
+	// Destination specifies the destination address announced in the header.
+	// If nil, the address of the mailserver is used.
+	Destination *net.TCPAddr
+}
+
+// validate checks that h can be written.
+func (h *ProxyHeader) validate() error {
+	if h.Version != 1 && h.Version != 2 {
+		return fmt.Errorf("invalid PROXY protocol version %d: must be 1 or 2", h.Version)
+	}
+	if h.Source != nil && h.Destination != nil && (h.Source.IP.To4() == nil) != (h.Destination.IP.To4() == nil) {
+		return fmt.Errorf("invalid PROXY protocol addresses %s and %s: must both be IPv4 or IPv6", h.Source, h.Destination)
+	}
+	return nil
+}
+
+// proxyV2Signature begins each version 2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// header returns the header announcing a connection from src to dst.
+func (h *ProxyHeader) header(src, dst *net.TCPAddr) ([]byte, error) {
+	src4, dst4 := src.IP.To4(), dst.IP.To4()
+	if (src4 == nil) != (dst4 == nil) {
+		return nil, fmt.Errorf("can't announce a connection from %s to %s in a PROXY protocol header", src, dst)
+	}
+	if h.Version == 1 {
+		family := "TCP6"
+		if src4 != nil {
+			family = "TCP4"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, src.IP, dst.IP, src.Port, dst.Port)), nil
+	}
+	var buf bytes.Buffer
+	buf.Write(proxyV2Signature)
+	// Version 2, PROXY command.
+	buf.WriteByte(0x21)
+	srcIP, dstIP := src.IP.To16(), dst.IP.To16()
+	if src4 != nil {
+		// TCP over IPv4.
+		buf.WriteByte(0x11)
+		srcIP, dstIP = src4, dst4
+	} else {
+		// TCP over IPv6.
+		buf.WriteByte(0x21)
+	}
+	binary.Write(&buf, binary.BigEndian, uint16(2*len(srcIP)+4))
+	buf.Write(srcIP)
+	buf.Write(dstIP)
+	binary.Write(&buf, binary.BigEndian, uint16(src.Port))
+	binary.Write(&buf, binary.BigEndian, uint16(dst.Port))
+	return buf.Bytes(), nil
+}
+
+// write sends the header for conn on conn.
+func (h *ProxyHeader) write(conn net.Conn) error {
+	src, dst := h.Source, h.Destination
+	if src == nil {
+		src, _ = conn.LocalAddr().(*net.TCPAddr)
+	}
+	if dst == nil {
+		dst, _ = conn.RemoteAddr().(*net.TCPAddr)
+	}
+	if src == nil || dst == nil {
+		return fmt.Errorf("can't send a PROXY protocol header on a connection from %s to %s", conn.LocalAddr(), conn.RemoteAddr())
+	}
+	header, err := h.header(src, dst)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(header)
+	return err
+}
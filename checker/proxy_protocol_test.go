@@ -0,0 +1,76 @@
+package checker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"testing"
+)
+
+func TestProxyHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}
+	dst := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 25}
+	header, err := (&ProxyHeader{Version: 1}).header(src, dst)
+	if err != nil || string(header) != "PROXY TCP4 192.0.2.1 198.51.100.1 56324 25\r\n" {
+		t.Errorf("Expected version 1 header for TCP4, got %q, %v", header, err)
+	}
+	header, err = (&ProxyHeader{Version: 2}).header(src, dst)
+	expected := append(append([]byte{}, proxyV2Signature...),
+		0x21, 0x11, 0, 12, 192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0, 25)
+	if err != nil || !bytes.Equal(header, expected) {
+		t.Errorf("Expected version 2 header %x, got %x, %v", expected, header, err)
+	}
+
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324}
+	header, err = (&ProxyHeader{Version: 1}).header(src6, &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 25})
+	if err != nil || string(header) != "PROXY TCP6 2001:db8::1 2001:db8::2 56324 25\r\n" {
+		t.Errorf("Expected version 1 header for TCP6, got %q, %v", header, err)
+	}
+	if _, err := (&ProxyHeader{Version: 1}).header(src6, dst); err == nil {
+		t.Error("Expected header announcing IPv6 to IPv4 to fail")
+	}
+
+	for _, h := range []ProxyHeader{{Version: 3}, {Version: 1, Source: src6, Destination: dst}} {
+		c := Checker{ProxyHeader: &h}
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected Validate to reject %+v", h)
+		}
+	}
+}
+
+func TestProxyHeaderSentBeforeGreeting(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		// The header must arrive before the server sends its greeting.
+		line, _ := r.ReadString('\n')
+		received <- line
+		conn.Write([]byte("220 localhost ESMTP\r\n"))
+		r.ReadString('\n')
+		conn.Write([]byte("250 localhost\r\n"))
+	}()
+
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+	dialer := smtpDialer{timeout: testTimeout, proxyHeader: &ProxyHeader{Version: 1, Source: src}}
+	client, err := dialer.dial(context.Background(), ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	if line := <-received; line != "PROXY TCP4 192.0.2.1 127.0.0.1 1234 "+port+"\r\n" {
+		t.Errorf("Expected PROXY header for the connection, got %q", line)
+	}
+}
@@ -0,0 +1,37 @@
+// Package report renders the results of the checker as HTML, so that
+// frontends don't each need to reimplement how checks are presented.
+package report
+
+import (
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/EFForg/starttls-backend/checker"
+)
+
+// Funcs are the functions available to Template, and to templates that
+// replace it.
+var Funcs = template.FuncMap{
+	"statusClass": StatusClass,
+}
+
+// Template renders a checker.DomainResult as a self-contained HTML fragment.
+// Callers can replace it (or its "check" template, which renders a single
+// Result and its sub-checks) to change the presentation, using Funcs.
+var Template = template.Must(template.New("report").Funcs(Funcs).Parse(defaultTemplate))
+
+// Render writes the HTML report of result to w, using Template.
+func Render(w io.Writer, result checker.DomainResult) error {
+	return Template.Execute(w, result)
+}
+
+// StatusClass returns the CSS class for a check's status, e.g.
+// "status-warning".
+func StatusClass(status checker.Status) string {
+	text := checker.Result{Status: status}.StatusText()
+	if text == "" {
+		return "status-unknown"
+	}
+	return "status-" + strings.ToLower(text)
+}
@@ -0,0 +1,56 @@
+package report
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"testing"
+
+	"github.com/EFForg/starttls-backend/checker"
+)
+
+func TestRender(t *testing.T) {
+	result := checker.NewSampleDomainResult("example.com")
+	result.HostnameResults["mx.example.com"].Checks[checker.Certificate].Failure("Certificate for <mx.example.com> expired.")
+	var b bytes.Buffer
+	if err := Render(&b, result); err != nil {
+		t.Fatal(err)
+	}
+	html := b.String()
+	for _, want := range []string{
+		"<h2>example.com</h2>",
+		"<h3>mx.example.com</h3>",
+		`<li class="check status-failure">`,
+		"Valid certificate",
+		"<strong class=\"status\">Failure</strong>",
+		"Certificate for &lt;mx.example.com&gt; expired.",
+		`<li class="check status-success">`,
+		"Correct MTA-STS policy file",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("Expected report to contain %q, got:\n%s", want, html)
+		}
+	}
+}
+
+func TestOverrideTemplate(t *testing.T) {
+	defer func(original *template.Template) { Template = original }(Template)
+	Template = template.Must(template.New("report").Funcs(Funcs).Parse(
+		`{{ range $_, $h := .HostnameResults }}{{ range $_, $c := $h.Checks }}{{ statusClass $c.Status }} {{ end }}{{ end }}`))
+	var b bytes.Buffer
+	if err := Render(&b, checker.NewSampleDomainResult("example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(b.String()); got != "status-success status-success status-success status-success" {
+		t.Errorf("Expected overridden template to be used, got %q", got)
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	if class := StatusClass(checker.Warning); class != "status-warning" {
+		t.Errorf("Expected status-warning, got %s", class)
+	}
+	if class := StatusClass(checker.Status(42)); class != "status-unknown" {
+		t.Errorf("Expected status-unknown, got %s", class)
+	}
+}
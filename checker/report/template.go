@@ -0,0 +1,54 @@
+package report
+
+const defaultTemplate = `
+<div class="starttls-report">
+  <style>
+    .starttls-report .status-success { color: #1b7a2f; }
+    .starttls-report .status-info { color: #1d5fa8; }
+    .starttls-report .status-warning { color: #a86a00; }
+    .starttls-report .status-failure { color: #b3261e; }
+    .starttls-report .status-error, .starttls-report .status-unknown { color: #6b6b6b; }
+    .starttls-report .messages { color: #333; }
+  </style>
+  <h2>{{ .Domain }}</h2>
+  <p class="summary">{{ .Summary }}</p>
+  {{ range $hostname, $hostnameResult := .HostnameResults }}
+    <section class="hostname">
+      <h3>{{ $hostname }}</h3>
+      {{ with $hostnameResult.Result }}
+        <ul class="checks">
+          {{ range $_, $check := .Checks }}{{ template "check" $check }}{{ end }}
+        </ul>
+      {{ end }}
+    </section>
+  {{ end }}
+  {{ with .MTASTSResult }}{{ with .Result }}
+    <section class="mta-sts">
+      <ul class="checks">{{ template "check" . }}</ul>
+    </section>
+  {{ end }}{{ end }}
+  {{ with .ExtraResults }}
+    <section class="extra">
+      <ul class="checks">
+        {{ range $_, $check := . }}{{ template "check" $check }}{{ end }}
+      </ul>
+    </section>
+  {{ end }}
+</div>
+{{ define "check" }}{{ if . }}
+  <li class="check {{ statusClass .Status }}">
+    <span class="description">{{ or .Description .Name }}</span>:
+    <strong class="status">{{ .StatusText }}</strong>
+    {{ if .Messages }}
+      <ul class="messages">
+        {{ range $_, $message := .Messages }}<li>{{ $message }}</li>{{ end }}
+      </ul>
+    {{ end }}
+    {{ if .Checks }}
+      <ul class="checks">
+        {{ range $_, $check := .Checks }}{{ template "check" $check }}{{ end }}
+      </ul>
+    {{ end }}
+  </li>
+{{ end }}{{ end }}
+`
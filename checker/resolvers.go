@@ -0,0 +1,123 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Resolvers is an ordered list of DNS resolvers used for MX lookups (and the
+// CAA, TLSA and CNAME lookups described by Checker.Resolvers). A lookup that
+// fails on one resolver (other than with NXDOMAIN, which is authoritative)
+// falls through to the next, so that a flaky or filtered resolver doesn't make
+// domains look like they have no MX records. Each resolver's successes and
+// failures at MX lookups are counted.
+// It is safe for concurrent use, and can be shared by several Checkers.
+type Resolvers struct {
+	resolvers []*resolver
+}
+
+// resolver is a single DNS resolver in Resolvers.
+type resolver struct {
+	successes int64 // First, so that they're aligned for atomic operations.
+	failures  int64
+	address   string
+}
+
+// ResolverCount is the number of lookups a resolver has answered and failed.
+type ResolverCount struct {
+	// Resolver is the address of the resolver, e.g. "8.8.8.8:53".
+	Resolver string
+	// Successes counts lookups the resolver answered, including with NXDOMAIN.
+	Successes int
+	// Failures counts lookups the resolver failed, e.g. with SERVFAIL or by
+	// timing out.
+	Failures int
+}
+
+// MakeResolvers creates a list of resolvers, tried in the order given. Each
+// address is an IP address, optionally with a port. If it has none, port 53
+// is used.
+func MakeResolvers(addresses ...string) *Resolvers {
+	r := &Resolvers{}
+	for _, address := range addresses {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, "53")
+		}
+		r.resolvers = append(r.resolvers, &resolver{address: address})
+	}
+	return r
+}
+
+// Counts returns the number of lookups each resolver has answered and failed,
+// in the order the resolvers are tried.
+func (r *Resolvers) Counts() []ResolverCount {
+	counts := make([]ResolverCount, 0, len(r.resolvers))
+	for _, res := range r.resolvers {
+		counts = append(counts, ResolverCount{
+			Resolver:  res.address,
+			Successes: int(atomic.LoadInt64(&res.successes)),
+			Failures:  int(atomic.LoadInt64(&res.failures)),
+		})
+	}
+	return counts
+}
+
+// lookupMX looks up MX records with lookup, passing it the address of each
+// resolver in turn, until one answers. Returns the address of the resolver that
+// answered, or the error from the last one if none did.
+func (r *Resolvers) lookupMX(ctx context.Context, lookup func(address string) ([]*net.MX, error)) ([]*net.MX, string, error) {
+	err := errors.New("no resolvers")
+	for _, res := range r.resolvers {
+		var mxs []*net.MX
+		mxs, err = lookup(res.address)
+		if dnsErr, ok := err.(*net.DNSError); err == nil || ok && dnsErr.IsNotFound {
+			atomic.AddInt64(&res.successes, 1)
+			return mxs, res.address, err
+		}
+		atomic.AddInt64(&res.failures, 1)
+		if expired(ctx) {
+			break
+		}
+	}
+	return nil, "", err
+}
+
+// lookupMXAt looks up the MX records of domain with the resolver at address,
+// giving it c's timeout. Like queryDNS, it connects with dialDNS.
+func (c *Checker) lookupMXAt(ctx context.Context, address, domain string) ([]*net.MX, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	r := net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return c.dialDNS(ctx, network, address)
+		},
+	}
+	return r.LookupMX(ctx, domain)
+}
+
+// queryMX looks up the MX records of domain by querying the resolver at
+// address directly, like queryNameserver, so that whether it validated them
+// with DNSSEC is known. NXDOMAIN is returned as a *net.DNSError, as by
+// lookupMXAt.
+func (c *Checker) queryMX(ctx context.Context, address, domain string) ([]*net.MX, DNSSECStatus, error) {
+	response, err := c.queryNameserver(ctx, address, domain, uint16(dnsmessage.TypeMX))
+	if err != nil {
+		return nil, DNSSECUnknown, err
+	}
+	status := dnssecStatus(response.Authenticated, nil)
+	if response.RCode == dnsRCodeNameError {
+		return nil, status, &net.DNSError{Err: "no such host", Name: domain, Server: address, IsNotFound: true}
+	}
+	mxs := []*net.MX{}
+	for _, record := range response.Records {
+		if record.Type == uint16(dnsmessage.TypeMX) {
+			mxs = append(mxs, &net.MX{Host: record.Target, Pref: record.Preference})
+		}
+	}
+	return mxs, status, nil
+}
@@ -0,0 +1,134 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeResolver answers MX queries on a local UDP port with rcode, and the MX
+// record mx if rcode is RCodeSuccess, until it's closed.
+func fakeResolver(t *testing.T, rcode dnsmessage.RCode, mx string) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if err := query.Unpack(buf[:n]); err != nil || len(query.Questions) != 1 {
+				continue
+			}
+			response := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, RecursionAvailable: true, RCode: rcode},
+				Questions: query.Questions,
+			}
+			question := query.Questions[0]
+			if rcode == dnsmessage.RCodeSuccess && question.Type == dnsmessage.TypeMX {
+				response.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeMX, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName(mx)},
+				}}
+			}
+			packed, err := response.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(packed, addr)
+		}
+	}()
+	return conn
+}
+
+func TestResolversFallBack(t *testing.T) {
+	failing := fakeResolver(t, dnsmessage.RCodeServerFailure, "")
+	defer failing.Close()
+	answering := fakeResolver(t, dnsmessage.RCodeSuccess, "mx.example.com.")
+	defer answering.Close()
+
+	resolvers := MakeResolvers(failing.LocalAddr().String(), answering.LocalAddr().String())
+	c := Checker{Timeout: testTimeout, Resolvers: resolvers}
+	mxs, resolver, _, err := c.lookupMX(context.Background(), "example.com")
+	if err != nil || len(mxs) != 1 || mxs[0].Host != "mx.example.com." {
+		t.Fatalf("Expected MX record from the second resolver, got %v, %v", mxs, err)
+	}
+	if resolver != answering.LocalAddr().String() {
+		t.Errorf("Expected answering resolver %s to be recorded, got %s", answering.LocalAddr(), resolver)
+	}
+	expected := []ResolverCount{
+		{Resolver: failing.LocalAddr().String(), Failures: 1},
+		{Resolver: answering.LocalAddr().String(), Successes: 1},
+	}
+	if counts := resolvers.Counts(); !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Expected counts %v, got %v", expected, counts)
+	}
+}
+
+func TestResolversNXDOMAINIsAuthoritative(t *testing.T) {
+	nxdomain := fakeResolver(t, dnsmessage.RCodeNameError, "")
+	defer nxdomain.Close()
+	answering := fakeResolver(t, dnsmessage.RCodeSuccess, "mx.example.com.")
+	defer answering.Close()
+
+	resolvers := MakeResolvers(nxdomain.LocalAddr().String(), answering.LocalAddr().String())
+	c := Checker{Timeout: testTimeout, Resolvers: resolvers}
+	_, resolver, _, err := c.lookupMX(context.Background(), "example.com")
+	if !errors.Is(err, ErrNoMXRecords) || resolver != nxdomain.LocalAddr().String() {
+		t.Errorf("Expected NXDOMAIN from the first resolver, got %s, %v", resolver, err)
+	}
+	if counts := resolvers.Counts(); counts[0].Successes != 1 || counts[1].Successes+counts[1].Failures != 0 {
+		t.Errorf("Expected only the first resolver to be asked, got %v", counts)
+	}
+}
+
+func TestResolversUseConnectionLimiter(t *testing.T) {
+	answering := fakeResolver(t, dnsmessage.RCodeSuccess, "mx.example.com.")
+	defer answering.Close()
+
+	// Lookups go from c.LocalAddr, and wait for c.ConnectionLimiter.
+	limiter := MakeConnectionLimiter(1)
+	c := Checker{
+		Timeout:           testTimeout,
+		Resolvers:         MakeResolvers(answering.LocalAddr().String()),
+		LocalAddr:         &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		ConnectionLimiter: limiter,
+	}
+	if err := limiter.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, _, _, err := c.lookupMX(ctx, "example.com"); !errors.Is(err, ErrDNSFailure) {
+		t.Errorf("Expected the lookup to wait for a connection until it timed out, got %v", err)
+	}
+	limiter.release()
+	mxs, _, _, err := c.lookupMX(context.Background(), "example.com")
+	if err != nil || len(mxs) != 1 {
+		t.Fatalf("Expected MX record once a connection was available, got %v, %v", mxs, err)
+	}
+	if limiter.InFlight() != 0 {
+		t.Errorf("Expected the lookup's connection to be released, got %d in flight", limiter.InFlight())
+	}
+}
+
+func TestMakeResolversDefaultPort(t *testing.T) {
+	resolvers := MakeResolvers("192.0.2.1", "192.0.2.2:5353", "2001:db8::1")
+	var addresses []string
+	for _, count := range resolvers.Counts() {
+		addresses = append(addresses, count.Resolver)
+	}
+	expected := []string{"192.0.2.1:53", "192.0.2.2:5353", "[2001:db8::1]:53"}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("Expected resolvers %q, got %q", expected, addresses)
+	}
+}
@@ -3,17 +3,22 @@ package checker
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 )
 
 // Status is an enum encoding the status of the overall check.
 type Status int32
 
-// Values for Result Status
+// Values for Result Status. These values are persisted, so new statuses are
+// appended here and ranked in statusSeverity. Results are written with the
+// text of their status too, which takes precedence when they're read back.
 const (
 	Success Status = 0
 	Warning Status = 1
 	Failure Status = 2
 	Error   Status = 3
+	Info    Status = 4
 )
 
 var statusText = map[Status]string{
@@ -21,6 +26,30 @@ var statusText = map[Status]string{
 	Warning: "Warning",
 	Failure: "Failure",
 	Error:   "Error",
+	Info:    "Info",
+}
+
+// statusSeverity orders statuses from least to most severe.
+var statusSeverity = map[Status]int{
+	Success: 0,
+	Info:    1,
+	Warning: 2,
+	Failure: 3,
+	Error:   4,
+}
+
+// severity returns the rank of s in statusSeverity. Unrecognized statuses are
+// more severe than any recognized one.
+func (s Status) severity() int {
+	if rank, ok := statusSeverity[s]; ok {
+		return rank
+	}
+	return len(statusSeverity) + int(s)
+}
+
+// succeeded returns true for statuses that don't indicate a problem.
+func (s Status) succeeded() bool {
+	return s == Success || s == Info
 }
 
 // StatusText returns the text version of the Result Status
@@ -28,10 +57,32 @@ func (r Result) StatusText() string {
 	return statusText[r.Status]
 }
 
+// ParseStatus returns the Status whose text (as returned by StatusText) is
+// text.
+func ParseStatus(text string) (Status, error) {
+	for status, t := range statusText {
+		if t == text {
+			return status, nil
+		}
+	}
+	return Success, fmt.Errorf("unknown status %q", text)
+}
+
+// LegacyStatus returns the Status of a numeric status persisted without its
+// text, e.g. in results stored before status_text was written. Those numbers
+// are the values of the Status constants, which are never renumbered.
+func LegacyStatus(n int) (Status, error) {
+	status := Status(n)
+	if _, ok := statusText[status]; !ok {
+		return Success, fmt.Errorf("unknown status %d", n)
+	}
+	return status, nil
+}
+
 // SetStatus the resulting status of combining old & new. The order of priority
-// for CheckStatus goes: Error > Failure > Warning > Success
+// for CheckStatus goes: Error > Failure > Warning > Info > Success
 func SetStatus(oldStatus Status, newStatus Status) Status {
-	if newStatus > oldStatus {
+	if newStatus.severity() > oldStatus.severity() {
 		return newStatus
 	}
 	return oldStatus
@@ -46,6 +97,13 @@ type Result struct {
 	Status   Status             `json:"status"`
 	Messages []string           `json:"messages,omitempty"`
 	Checks   map[string]*Result `json:"checks,omitempty"`
+	// Certificate describes the certificate the check examined, if it's a
+	// Certificate check that got that far.
+	Certificate *CertificateInfo `json:"certificate,omitempty"`
+	// Timestamp is when the check completed, if it's known. The Checker sets
+	// it using Checker.Now. It's written in RFC 3339 format as "timestamp",
+	// unless it's zero.
+	Timestamp time.Time `json:"-"`
 }
 
 // MakeResult constructs a base result object and returns its pointer.
@@ -84,6 +142,31 @@ func (r *Result) Warning(format string, a ...interface{}) *Result {
 	return r
 }
 
+// report adds a message to this check result with status, like the method
+// of the same name, e.g. for statuses that depend on configuration.
+func (r *Result) report(status Status, format string, a ...interface{}) *Result {
+	switch status {
+	case Error:
+		return r.Error(format, a...)
+	case Failure:
+		return r.Failure(format, a...)
+	case Warning:
+		return r.Warning(format, a...)
+	case Info:
+		return r.Info(format, a...)
+	}
+	return r.Success()
+}
+
+// Info adds an informational message to this check result.
+// The Info status only supercedes the Success status, and doesn't indicate
+// a problem with the check.
+func (r *Result) Info(format string, a ...interface{}) *Result {
+	r.Status = SetStatus(r.Status, Info)
+	r.Messages = append(r.Messages, fmt.Sprintf("Info: "+format, a...))
+	return r
+}
+
 // Success simply sets the status of Result to a Success.
 // Status is set if no other status has been declared on this check.
 func (r *Result) Success() *Result {
@@ -91,15 +174,142 @@ func (r *Result) Success() *Result {
 	return r
 }
 
+// escalate returns a copy of r in which the warnings of the checks named in
+// strict (at any depth), and of their sub-checks, are failures, and the
+// statuses of the checks containing them are raised to match. r isn't
+// modified, since it may be shared, e.g. by cached results.
+func (r *Result) escalate(strict map[string]bool) *Result {
+	if r == nil || len(strict) == 0 {
+		return r
+	}
+	return r.escalateWithin(strict, false)
+}
+
+func (r *Result) escalateWithin(strict map[string]bool, within bool) *Result {
+	within = within || strict[r.Name]
+	result := *r
+	if r.Checks != nil {
+		result.Checks = make(map[string]*Result, len(r.Checks))
+		for name, check := range r.Checks {
+			if check == nil {
+				result.Checks[name] = nil
+				continue
+			}
+			result.Checks[name] = check.escalateWithin(strict, within)
+			result.Status = SetStatus(result.Status, result.Checks[name].Status)
+		}
+	}
+	if !within {
+		return &result
+	}
+	if result.Status == Warning {
+		result.Status = Failure
+	}
+	result.Messages = make([]string, len(r.Messages))
+	for i, message := range r.Messages {
+		if strings.HasPrefix(message, "Warning: ") {
+			message = "Failure: " + strings.TrimPrefix(message, "Warning: ")
+			result.Status = SetStatus(result.Status, Failure)
+		}
+		result.Messages[i] = message
+	}
+	return &result
+}
+
+// Filter returns a copy of this result which only contains sub-checks (at any
+// depth) whose status is at least as severe as min. The status and messages of
+// this result are preserved.
+func (r Result) Filter(min Status) Result {
+	filtered := r
+	filtered.Checks = make(map[string]*Result)
+	for name, check := range r.Checks {
+		if check == nil || SetStatus(check.Status, min) != check.Status {
+			// check.Status is less severe than min.
+			continue
+		}
+		filteredCheck := check.Filter(min)
+		filtered.Checks[name] = &filteredCheck
+	}
+	return filtered
+}
+
+// HasStatus returns true if this result or any of its sub-checks (at any
+// depth) has exactly the status s.
+func (r Result) HasStatus(s Status) bool {
+	if r.Status == s {
+		return true
+	}
+	for _, check := range r.Checks {
+		if check != nil && check.HasStatus(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Worst returns the most severe status of this result and all of its
+// sub-checks (at any depth).
+func (r Result) Worst() Status {
+	worst := r.Status
+	for _, check := range r.Checks {
+		if check != nil {
+			worst = SetStatus(worst, check.Worst())
+		}
+	}
+	return worst
+}
+
 // Returns result of specified check.
 // If called before that check occurs, returns false.
 func (r *Result) subcheckSucceeded(checkName string) bool {
 	if result, ok := r.Checks[checkName]; ok {
-		return result.Status == Success
+		return result.Status.succeeded()
 	}
 	return false
 }
 
+// stamp sets the Timestamp of r, and of any of its sub-checks (at any depth),
+// to now, unless they already have one. Returns r.
+func (r *Result) stamp(now time.Time) *Result {
+	if r == nil {
+		return r
+	}
+	if r.Timestamp.IsZero() {
+		r.Timestamp = now
+	}
+	for _, check := range r.Checks {
+		check.stamp(now)
+	}
+	return r
+}
+
+// copy returns a copy of r, and of its sub-checks (at any depth), that can be
+// modified (e.g. by stamp) without modifying r.
+func (r *Result) copy() *Result {
+	if r == nil {
+		return nil
+	}
+	result := *r
+	if r.Messages != nil {
+		result.Messages = append([]string{}, r.Messages...)
+	}
+	if r.Checks != nil {
+		result.Checks = make(map[string]*Result, len(r.Checks))
+		for name, check := range r.Checks {
+			result.Checks[name] = check.copy()
+		}
+	}
+	return &result
+}
+
+// formatTimestamp formats t for JSON, or returns "" if it's zero.
+func formatTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
 // Wrapping helper function to set the status of this hostname.
 func (r *Result) addCheck(checkResult *Result) {
 	r.Checks[checkResult.Name] = checkResult
@@ -112,11 +322,31 @@ const (
 	Connectivity     = "connectivity"
 	STARTTLS         = "starttls"
 	Version          = "version"
+	TLS13            = "tls13"
 	Certificate      = "certificate"
 	MTASTS           = "mta-sts"
 	MTASTSText       = "mta-sts-text"
 	MTASTSPolicyFile = "mta-sts-policy-file"
 	PolicyList       = "policylist"
+	MXRecords        = "mx-records"
+	CAA              = "caa"
+	Auth             = "auth"
+	ExpectedMXs      = "expected-mxs"
+	ExpectedTLSA     = "expected-tlsa"
+	CRL              = "crl"
+	CertExpiry       = "cert-expiry"
+	OCSPStapling     = "ocsp-stapling"
+	OCSP             = "ocsp"
+	CertTransparency = "cert-transparency"
+	Resumption       = "session-resumption"
+	DANE             = "dane"
+	TLSRPT           = "tls-rpt"
+	SPF              = "spf"
+	DMARC            = "dmarc"
+	DMARCPolicy      = "dmarc-policy"
+	DMARCAlignment   = "dmarc-alignment"
+	DMARCReporting   = "dmarc-reporting"
+	DKIM             = "dkim"
 )
 
 // Text descriptions of checks that can be run
@@ -124,11 +354,31 @@ var checkNames = map[string]string{
 	Connectivity:     "Server connectivity",
 	STARTTLS:         "Support for inbound STARTTLS",
 	Version:          "Secure version of TLS",
+	TLS13:            "Supports TLS 1.3",
 	Certificate:      "Valid certificate",
 	MTASTS:           "Inbound MTA-STS support",
 	MTASTSText:       "Correct MTA-STS DNS record",
 	MTASTSPolicyFile: "Correct MTA-STS policy file",
 	PolicyList:       "Status on EFF's STARTTLS Everywhere policy list",
+	MXRecords:        "MX record configuration",
+	CAA:              "Certificate authorities permitted by CAA records",
+	Auth:             "No SMTP AUTH before STARTTLS",
+	ExpectedMXs:      "MX records match the expected hostnames",
+	ExpectedTLSA:     "Certificates match the expected TLSA associations",
+	CRL:              "Certificate not revoked according to its CRL",
+	CertExpiry:       "Certificates not about to expire",
+	OCSPStapling:     "Stapled OCSP response is valid",
+	OCSP:             "Certificates not revoked according to their OCSP responders",
+	CertTransparency: "Certificate logged for Certificate Transparency",
+	Resumption:       "Supports TLS session resumption",
+	DANE:             "Certificate matches the DANE TLSA records",
+	TLSRPT:           "Correct TLS-RPT DNS record",
+	SPF:              "Correct SPF DNS record",
+	DMARC:            "Correct DMARC DNS record",
+	DMARCPolicy:      "DMARC policy quarantines or rejects failing mail",
+	DMARCAlignment:   "Valid DMARC alignment modes",
+	DMARCReporting:   "Valid DMARC report URIs",
+	DKIM:             "Strong DKIM keys",
 }
 
 // Description returns the full-text name of a check.
@@ -136,18 +386,61 @@ func (r Result) Description() string {
 	return checkNames[r.Name]
 }
 
-// MarshalJSON writes Result to JSON. It adds status_text and description to
-// the output.
+// ResultSchemaVersion is the version of the JSON encoding of Result, which is
+// written as "schema_version". Bump it whenever the shape of the output
+// changes, and handle the older shape in UnmarshalJSON.
+// Results encoded before the schema was versioned are read as version 0.
+const ResultSchemaVersion = 2
+
+// MarshalJSON writes Result to JSON. It adds schema_version, status_text,
+// description and timestamp to the output. Checks are written in order of name (as are the
+// keys of any map), so the same Result is always written the same way.
 func (r Result) MarshalJSON() ([]byte, error) {
 	// FakeResult lets us access the default json.Marshall result for Result.
 	type FakeResult Result
 	return json.Marshal(struct {
 		FakeResult
-		StatusText  string `json:"status_text,omitempty"`
-		Description string `json:"description,omitempty"`
+		SchemaVersion int    `json:"schema_version"`
+		StatusText    string `json:"status_text,omitempty"`
+		Description   string `json:"description,omitempty"`
+		Timestamp     string `json:"timestamp,omitempty"`
 	}{
-		Description: r.Description(),
-		FakeResult:  FakeResult(r),
-		StatusText:  r.StatusText(),
+		Description:   r.Description(),
+		FakeResult:    FakeResult(r),
+		SchemaVersion: ResultSchemaVersion,
+		StatusText:    r.StatusText(),
+		Timestamp:     formatTimestamp(r.Timestamp),
 	})
 }
+
+// UnmarshalJSON reads a Result written by MarshalJSON, with any schema
+// version. Versions 0 and 1 have the same fields, version 1 just labels them.
+// Version 2 adds certificate, which older versions don't have.
+// Results from newer versions are read on a best-effort basis: unknown fields
+// are ignored.
+// The status is read from status_text if it's recognized, and otherwise from
+// the numeric status.
+func (r *Result) UnmarshalJSON(b []byte) error {
+	type FakeResult Result
+	var decoded struct {
+		FakeResult
+		SchemaVersion int    `json:"schema_version"`
+		StatusText    string `json:"status_text"`
+		Timestamp     string `json:"timestamp"`
+	}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return err
+	}
+	*r = Result(decoded.FakeResult)
+	if decoded.Timestamp != "" {
+		timestamp, err := time.Parse(time.RFC3339, decoded.Timestamp)
+		if err != nil {
+			return err
+		}
+		r.Timestamp = timestamp
+	}
+	if status, err := ParseStatus(decoded.StatusText); err == nil {
+		r.Status = status
+	}
+	return nil
+}
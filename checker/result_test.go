@@ -3,7 +3,10 @@ package checker
 import (
 	"bytes"
 	"encoding/json"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestMarshalResultJSON(t *testing.T) {
@@ -39,3 +42,269 @@ func TestMarshalResultJSON(t *testing.T) {
 		t.Errorf("Result with unrecognized keys shouldn't output status_text, got %s", string(marshalled))
 	}
 }
+
+func TestFilterResult(t *testing.T) {
+	result := MakeResult("hostnames")
+	result.addCheck(MakeResult(Connectivity).Success())
+	starttls := MakeResult(STARTTLS)
+	starttls.addCheck(MakeResult("nested-success").Success())
+	starttls.addCheck(MakeResult("nested-warning").Warning("warning"))
+	result.addCheck(starttls)
+	result.addCheck(MakeResult(Certificate).Failure("failure"))
+
+	filtered := result.Filter(Warning)
+	if filtered.Status != Failure {
+		t.Errorf("Filter should preserve top-level status, got %d", filtered.Status)
+	}
+	if _, ok := filtered.Checks[Connectivity]; ok {
+		t.Errorf("Filter(Warning) should drop successful checks, got %v", filtered.Checks)
+	}
+	if _, ok := filtered.Checks[Certificate]; !ok {
+		t.Errorf("Filter(Warning) should keep failed checks, got %v", filtered.Checks)
+	}
+	nested := filtered.Checks[STARTTLS]
+	if nested == nil || len(nested.Checks) != 1 || nested.Checks["nested-warning"] == nil {
+		t.Errorf("Filter(Warning) should filter nested checks, got %v", nested)
+	}
+	if len(result.Checks) != 3 || len(result.Checks[STARTTLS].Checks) != 2 {
+		t.Error("Filter should not modify the original result")
+	}
+	if got := result.Filter(Success); len(got.Checks) != 3 {
+		t.Errorf("Filter(Success) should keep all checks, got %v", got.Checks)
+	}
+}
+
+func TestEscalateResult(t *testing.T) {
+	result := MakeResult("hostnames")
+	version := MakeResult(Version).Warning("Server should support TLSv1.2, but doesn't.")
+	result.addCheck(version)
+	certificate := MakeResult(Certificate)
+	certificate.addCheck(MakeResult(CRL).Warning("stale"))
+	result.addCheck(certificate)
+	result.addCheck(MakeResult(Auth).Warning("cleartext"))
+
+	escalated := result.escalate(map[string]bool{Version: true, Certificate: true})
+	if escalated.Status != Failure {
+		t.Errorf("Expected escalated warnings to fail the result, got %s", escalated.StatusText())
+	}
+	if v := escalated.Checks[Version]; v.Status != Failure || v.Messages[0] != "Failure: Server should support TLSv1.2, but doesn't." {
+		t.Errorf("Expected version warning to be escalated, got %v", v)
+	}
+	if crl := escalated.Checks[Certificate].Checks[CRL]; crl.Status != Failure || escalated.Checks[Certificate].Status != Failure {
+		t.Errorf("Expected sub-checks of a strict check to be escalated, got %v", escalated.Checks[Certificate])
+	}
+	if escalated.Checks[Auth].Status != Warning {
+		t.Errorf("Expected other checks to keep their status, got %v", escalated.Checks[Auth])
+	}
+	if result.Status != Warning || version.Status != Warning || version.Messages[0] != "Warning: Server should support TLSv1.2, but doesn't." {
+		t.Error("escalate should not modify the original result")
+	}
+	if result.escalate(nil) != result {
+		t.Error("Expected no escalation without strict checks")
+	}
+}
+
+func TestHasStatusAndWorst(t *testing.T) {
+	// Statuses are set directly so that the parent doesn't inherit them.
+	result := Result{
+		Name:   "hostnames",
+		Status: Success,
+		Checks: map[string]*Result{
+			Connectivity: {Name: Connectivity, Status: Success},
+			STARTTLS: {Name: STARTTLS, Status: Warning, Checks: map[string]*Result{
+				"nested": {Name: "nested", Status: Failure},
+			}},
+		},
+	}
+	for _, s := range []Status{Success, Warning, Failure} {
+		if !result.HasStatus(s) {
+			t.Errorf("HasStatus(%d) = false, want true", s)
+		}
+	}
+	if result.HasStatus(Error) {
+		t.Error("HasStatus(Error) = true, want false")
+	}
+	if got := result.Worst(); got != Failure {
+		t.Errorf("Worst() = %d, want %d", got, Failure)
+	}
+	if got := (Result{Status: Warning}).Worst(); got != Warning {
+		t.Errorf("Worst() = %d, want %d", got, Warning)
+	}
+}
+
+func TestResultJSONSchemaVersion(t *testing.T) {
+	result := MakeResult(STARTTLS)
+	result.addCheck(MakeResult("nested").Warning("warning"))
+	marshalled, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(marshalled, []byte(`"schema_version":2`)) {
+		t.Errorf("Marshalled result should contain schema_version, got %s", string(marshalled))
+	}
+	var unmarshalled Result
+	if err := json.Unmarshal(marshalled, &unmarshalled); err != nil {
+		t.Fatal(err)
+	}
+	if unmarshalled.Status != Warning || unmarshalled.Checks["nested"].Messages[0] != "Warning: warning" {
+		t.Errorf("Expected result to survive a round trip, got %v", unmarshalled)
+	}
+
+	// Results stored before schema_version was added.
+	legacy := `{"name":"starttls","status":2,"messages":["Failure: failure"],"status_text":"Failure"}`
+	if err := json.Unmarshal([]byte(legacy), &unmarshalled); err != nil {
+		t.Fatal(err)
+	}
+	if unmarshalled.Status != Failure || len(unmarshalled.Messages) != 1 {
+		t.Errorf("Expected unversioned result to be read, got %v", unmarshalled)
+	}
+}
+
+func TestStatusTextTakesPrecedence(t *testing.T) {
+	var result Result
+	if err := json.Unmarshal([]byte(`{"name":"starttls","status":1,"status_text":"Info"}`), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != Info {
+		t.Errorf("Expected status to be read from status_text, got %s", result.StatusText())
+	}
+	if err := json.Unmarshal([]byte(`{"name":"starttls","status":3,"status_text":"Unheard of"}`), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != Error {
+		t.Errorf("Expected unknown status_text to fall back to status, got %s", result.StatusText())
+	}
+	for status := range statusText {
+		if parsed, err := ParseStatus(Result{Status: status}.StatusText()); err != nil || parsed != status {
+			t.Errorf("ParseStatus(%s) = %d, %v", Result{Status: status}.StatusText(), parsed, err)
+		}
+	}
+	if status, err := LegacyStatus(2); err != nil || status != Failure {
+		t.Errorf("LegacyStatus(2) = %d, %v", status, err)
+	}
+	if _, err := LegacyStatus(42); err == nil {
+		t.Error("Expected unknown numeric status to be rejected")
+	}
+}
+
+func TestResultTimestamps(t *testing.T) {
+	start := time.Date(2019, 4, 29, 1, 1, 1, 0, time.UTC)
+	now := start
+	var mu sync.Mutex
+	c := Checker{
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+		// Each check completes a minute after the last.
+		Now: func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			now = now.Add(time.Minute)
+			return now
+		},
+	}
+	result := c.CheckDomain("domain", nil)
+	// HostnameResult.Timestamp is set by mockCheckHostname, so check the
+	// embedded Result's.
+	hostnameResult := result.HostnameResults["hostname1"].Result
+	if hostnameResult.Timestamp.IsZero() || hostnameResult.Checks[Connectivity].Timestamp != hostnameResult.Timestamp {
+		t.Errorf("Expected hostname checks to be timestamped, got %v", hostnameResult.Timestamp)
+	}
+	if !result.MTASTSResult.Timestamp.After(hostnameResult.Timestamp) || !result.MTASTSResult.Timestamp.After(result.ExtraResults[CAA].Timestamp) {
+		t.Errorf("Expected MTA-STS check to be timestamped after the hostname and CAA checks, got %v", result.MTASTSResult.Timestamp)
+	}
+
+	marshalled, err := json.Marshal(result.MTASTSResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(marshalled, []byte(`"timestamp":"2019-04-29T01:`)) {
+		t.Errorf("Expected timestamp to be written in RFC 3339 format, got %s", marshalled)
+	}
+	var unmarshalled MTASTSResult
+	if err := json.Unmarshal(marshalled, &unmarshalled); err != nil {
+		t.Fatal(err)
+	}
+	if !unmarshalled.Timestamp.Equal(result.MTASTSResult.Timestamp) {
+		t.Errorf("Expected timestamp %v to survive a round trip, got %v", result.MTASTSResult.Timestamp, unmarshalled.Timestamp)
+	}
+	if marshalled, _ := json.Marshal(MakeResult(STARTTLS)); bytes.Contains(marshalled, []byte("timestamp")) {
+		t.Errorf("Expected results without a timestamp not to write one, got %s", marshalled)
+	}
+}
+
+func TestDomainResultJSONRoundTrip(t *testing.T) {
+	result := NewSampleDomainResult("example.com")
+	result.MTASTSResult = MakeMTASTSResult()
+	result.MTASTSResult.Mode = "enforce"
+	result.MTASTSResult.MXs = []string{"mx.example.com"}
+	result.MTASTSResult.ID = "20190101"
+	marshalled, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unmarshalled DomainResult
+	if err := json.Unmarshal(marshalled, &unmarshalled); err != nil {
+		t.Fatal(err)
+	}
+	hostnameResult := unmarshalled.HostnameResults["mx.example.com"]
+	if hostnameResult.Result == nil || len(hostnameResult.Checks) != 4 {
+		t.Errorf("Expected hostname results to survive a round trip, got %v", hostnameResult)
+	}
+	if unmarshalled.MTASTSResult == nil || unmarshalled.MTASTSResult.Mode != "enforce" ||
+		unmarshalled.MTASTSResult.ID != "20190101" || unmarshalled.MTASTSResult.Result == nil {
+		t.Errorf("Expected MTA-STS result to survive a round trip, got %v", unmarshalled.MTASTSResult)
+	}
+}
+
+func TestJSONIsStable(t *testing.T) {
+	c := Checker{
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+		// Results are timestamped.
+		Now: func() time.Time { return time.Date(2019, 4, 29, 1, 1, 1, 0, time.UTC) },
+	}
+	marshal := func() []byte {
+		result := c.CheckDomain("domain", nil)
+		// The scan times do change.
+		result.Metadata = nil
+		b, err := json.Marshal(result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	expected := marshal()
+	for i := 0; i < 20; i++ {
+		if got := marshal(); !bytes.Equal(got, expected) {
+			t.Fatalf("Expected identical results to be written identically, got:\n%s\nand:\n%s", expected, got)
+		}
+	}
+
+	a := AggregatedScan{MTASTSTestingList: []string{"b.com", "a.com", "c.com"}}
+	b := AggregatedScan{MTASTSTestingList: []string{"c.com", "b.com", "a.com"}}
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+	if !bytes.Equal(aJSON, bJSON) {
+		t.Errorf("Expected aggregated scans of the same domains to be written identically, got:\n%s\nand:\n%s", aJSON, bJSON)
+	}
+
+	mxResult := MakeResult(MTASTSPolicyFile)
+	reachable := HostnameResult{Result: &Result{Checks: map[string]*Result{
+		Connectivity: {Name: Connectivity, Status: Success},
+	}}}
+	mxs := map[string]HostnameResult{"a": reachable, "b": reachable, "c": reachable, "d": reachable}
+	validateMTASTSMXs([]string{}, mxs, mxResult)
+	for i := 0; i < 20; i++ {
+		again := MakeResult(MTASTSPolicyFile)
+		validateMTASTSMXs([]string{}, mxs, again)
+		if !reflect.DeepEqual(mxResult.Messages, again.Messages) {
+			t.Fatalf("Expected messages in a stable order, got %v and %v", mxResult.Messages, again.Messages)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package checker
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// RingBufferHandler keeps the most recent domain results handled, e.g. for a
+// feed of recently scanned domains, without growing as more are handled.
+// Reads never wait for results being handled.
+// Implements ResultHandler, and is safe for concurrent use.
+type RingBufferHandler struct {
+	size int
+	// mu serializes HandleDomain.
+	mu sync.Mutex
+	// recent holds the most recent results, newest first, as a
+	// []DomainResult which is replaced rather than modified.
+	recent atomic.Value
+}
+
+// MakeRingBufferHandler creates a handler keeping the size most recent results.
+// If size isn't positive, no results are kept.
+func MakeRingBufferHandler(size int) *RingBufferHandler {
+	h := &RingBufferHandler{size: size}
+	h.recent.Store([]DomainResult{})
+	return h
+}
+
+// HandleDomain adds a single domain's result, dropping the oldest result if
+// the buffer is full.
+func (h *RingBufferHandler) HandleDomain(r DomainResult) {
+	if h.size <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	old := h.recent.Load().([]DomainResult)
+	n := len(old) + 1
+	if n > h.size {
+		n = h.size
+	}
+	recent := make([]DomainResult, n)
+	recent[0] = r
+	copy(recent[1:], old)
+	h.recent.Store(recent)
+}
+
+// Recent returns a copy of the most recent results, newest first.
+func (h *RingBufferHandler) Recent() []DomainResult {
+	return append([]DomainResult{}, h.recent.Load().([]DomainResult)...)
+}
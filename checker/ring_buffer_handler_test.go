@@ -0,0 +1,46 @@
+package checker
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestRingBufferHandler(t *testing.T) {
+	handler := MakeRingBufferHandler(2)
+	if recent := handler.Recent(); len(recent) != 0 {
+		t.Errorf("Expected no results yet, got %v", recent)
+	}
+	for _, domain := range []string{"a", "b", "c"} {
+		handler.HandleDomain(DomainResult{Domain: domain})
+	}
+	recent := handler.Recent()
+	if len(recent) != 2 || recent[0].Domain != "c" || recent[1].Domain != "b" {
+		t.Errorf("Expected the 2 most recent results, newest first, got %v", recent)
+	}
+	recent[0].Domain = "changed"
+	if handler.Recent()[0].Domain != "c" {
+		t.Error("Expected Recent to return a copy")
+	}
+}
+
+func TestRingBufferHandlerConcurrent(t *testing.T) {
+	handler := MakeRingBufferHandler(10)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				handler.HandleDomain(DomainResult{Domain: fmt.Sprintf("%d-%d.example.com", i, j)})
+				if recent := handler.Recent(); len(recent) > 10 {
+					t.Errorf("Expected at most 10 results, got %d", len(recent))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if recent := handler.Recent(); len(recent) != 10 {
+		t.Errorf("Expected 10 results, got %d", len(recent))
+	}
+}
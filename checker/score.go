@@ -0,0 +1,91 @@
+package checker
+
+import "math"
+
+// ScoreWeights weights the checks that contribute to a DomainResult's Score,
+// by check ID. Checks that aren't listed don't contribute.
+type ScoreWeights map[string]int
+
+// DefaultScoreWeights are the weights used by Score unless the Checker
+// specifies otherwise. They add up to 100: 40 for supporting STARTTLS, 30 for
+// valid certificates, 15 for not supporting insecure versions of TLS, and 15
+// for an MTA-STS policy in enforce mode (testing mode earns half).
+// Hostname checks earn their weight in proportion to the number of preferred
+// hostnames that pass them, with warnings earning half.
+var DefaultScoreWeights = ScoreWeights{
+	STARTTLS:    40,
+	Certificate: 30,
+	Version:     15,
+	MTASTS:      15,
+}
+
+// Score rates the domain from 0 to 100 by weighting the results of its
+// checks, with Checker.ScoreWeights if the result came from CheckDomain, or
+// DefaultScoreWeights otherwise. Domains whose mailservers couldn't be
+// connected to score 0.
+func (d DomainResult) Score() int {
+	weights := d.scoreWeights
+	if weights == nil {
+		weights = DefaultScoreWeights
+	}
+	return d.ScoreWith(weights)
+}
+
+// ScoreWith performs Score with the given weights.
+func (d DomainResult) ScoreWith(weights ScoreWeights) int {
+	if len(d.PreferredHostnames) == 0 {
+		return 0
+	}
+	var earned, total float64
+	for check, weight := range weights {
+		if weight <= 0 {
+			continue
+		}
+		total += float64(weight)
+		earned += float64(weight) * d.checkCredit(check)
+	}
+	if total == 0 {
+		return 0
+	}
+	return int(math.Round(100 * earned / total))
+}
+
+// checkCredit returns the proportion of a check's weight that the domain
+// earns, from 0 to 1.
+func (d DomainResult) checkCredit(check string) float64 {
+	if check == MTASTS {
+		if d.MTASTSResult == nil || !d.MTASTSResult.Status.succeeded() && d.MTASTSResult.Status != Warning {
+			return 0
+		}
+		switch d.MTASTSResult.Mode {
+		case "enforce":
+			return 1
+		case "testing":
+			return 0.5
+		}
+		return 0
+	}
+	var credit float64
+	for _, hostname := range d.PreferredHostnames {
+		hostnameResult, ok := d.HostnameResults[hostname]
+		if !ok || hostnameResult.Result == nil {
+			continue
+		}
+		credit += statusCredit(hostnameResult.Checks[check])
+	}
+	return credit / float64(len(d.PreferredHostnames))
+}
+
+// statusCredit returns the proportion of its weight that a check earns.
+func statusCredit(result *Result) float64 {
+	if result == nil {
+		return 0
+	}
+	if result.Status.succeeded() {
+		return 1
+	}
+	if result.Status == Warning {
+		return 0.5
+	}
+	return 0
+}
@@ -0,0 +1,71 @@
+package checker
+
+import "testing"
+
+func TestScore(t *testing.T) {
+	perfect := NewSampleDomainResult("example.com")
+	if score := perfect.Score(); score != 100 {
+		t.Errorf("Expected sample result to score 100, got %d", score)
+	}
+
+	testingMode := NewSampleDomainResult("example.com")
+	testingMode.MTASTSResult.Mode = "testing"
+	if score := testingMode.Score(); score != 93 {
+		t.Errorf("Expected MTA-STS testing mode to earn half its weight, got %d", score)
+	}
+
+	badCert := NewSampleDomainResult("example.com")
+	badCert.HostnameResults["mx.example.com"].Checks[Certificate].Failure("Certificate is self-signed.")
+	badCert.HostnameResults["mx.other.com"] = HostnameResult{Result: &Result{
+		Checks: map[string]*Result{
+			STARTTLS:    MakeResult(STARTTLS),
+			Certificate: MakeResult(Certificate),
+			Version:     MakeResult(Version).Warning("Server should support TLSv1.2, but doesn't."),
+		},
+	}}
+	badCert.PreferredHostnames = append(badCert.PreferredHostnames, "mx.other.com")
+	// STARTTLS: 40, Certificate: 30 / 2, Version: 15 * 3/4, MTA-STS: 15.
+	if score := badCert.Score(); score != 81 {
+		t.Errorf("Expected hostname checks to be weighted by hostname, got %d", score)
+	}
+
+	unreachable := NewSampleDomainResult("example.com")
+	unreachable.PreferredHostnames = nil
+	if score := unreachable.Score(); score != 0 {
+		t.Errorf("Expected domain without reachable mailservers to score 0, got %d", score)
+	}
+}
+
+func TestCheckerScoreWeights(t *testing.T) {
+	c := Checker{
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	result := c.CheckDomain("domain", nil)
+	if score := result.Score(); score != 93 {
+		t.Errorf("Expected default weights to score 93, got %d", score)
+	}
+	c.ScoreWeights = ScoreWeights{STARTTLS: 1, MTASTS: 1}
+	result = c.CheckDomain("domain", nil)
+	if score := result.Score(); score != 75 {
+		t.Errorf("Expected Checker's weights to score 75, got %d", score)
+	}
+	if score := result.ScoreWith(DefaultScoreWeights); score != 93 {
+		t.Errorf("Expected ScoreWith to use the given weights, got %d", score)
+	}
+}
+
+func TestAverageScore(t *testing.T) {
+	totals := AggregatedScan{Logger: NopLogger}
+	totals.HandleDomain(NewSampleDomainResult("a.example.com"))
+	unreachable := NewSampleDomainResult("b.example.com")
+	unreachable.PreferredHostnames = nil
+	totals.HandleDomain(unreachable)
+	totals.HandleDomain(DomainResult{Domain: "no-mx.example.com"})
+	if average := totals.AverageScore(); average != 50 {
+		t.Errorf("Expected average score of domains with MXs to be 50, got %v", average)
+	}
+}
@@ -0,0 +1,43 @@
+package checker
+
+import "sync"
+
+// SliceHandler collects domain results in memory, for callers checking a
+// modest number of domains that just want the results back.
+// Implements ResultHandler, and is safe for concurrent use.
+type SliceHandler struct {
+	// Max caps the number of results collected. Results handled once the cap
+	// is reached are dropped, and counted by Dropped.
+	// If zero, every result is collected.
+	Max int
+
+	mu      sync.Mutex
+	results []DomainResult
+	dropped int
+}
+
+// HandleDomain collects a single domain's result, unless the cap is reached.
+func (h *SliceHandler) HandleDomain(r DomainResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.Max > 0 && len(h.results) >= h.Max {
+		h.dropped++
+		return
+	}
+	h.results = append(h.results, r)
+}
+
+// Results returns a copy of the results collected so far, in the order they
+// were handled.
+func (h *SliceHandler) Results() []DomainResult {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]DomainResult{}, h.results...)
+}
+
+// Dropped returns the number of results dropped because the cap was reached.
+func (h *SliceHandler) Dropped() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.dropped
+}
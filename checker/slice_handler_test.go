@@ -0,0 +1,51 @@
+package checker
+
+import (
+	"encoding/csv"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSliceHandler(t *testing.T) {
+	in := "domain\na.example.com\nb.example.com\nc.example.com\n"
+	c := Checker{
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	handler := SliceHandler{}
+	c.CheckCSV(csv.NewReader(strings.NewReader(in)), &handler, 0)
+
+	domains := []string{}
+	for _, result := range handler.Results() {
+		domains = append(domains, result.Domain)
+	}
+	sort.Strings(domains)
+	if strings.Join(domains, " ") != "a.example.com b.example.com c.example.com domain" {
+		t.Errorf("Expected every result to be collected, got %v", domains)
+	}
+	if handler.Dropped() != 0 {
+		t.Errorf("Expected no results to be dropped, got %d", handler.Dropped())
+	}
+}
+
+func TestSliceHandlerMax(t *testing.T) {
+	handler := SliceHandler{Max: 2}
+	for _, domain := range []string{"a", "b", "c"} {
+		handler.HandleDomain(DomainResult{Domain: domain})
+	}
+	results := handler.Results()
+	if len(results) != 2 || results[0].Domain != "a" || results[1].Domain != "b" {
+		t.Errorf("Expected the first 2 results to be collected, got %v", results)
+	}
+	if handler.Dropped() != 1 {
+		t.Errorf("Expected 1 result to be dropped, got %d", handler.Dropped())
+	}
+	results[0].Domain = "changed"
+	if handler.Results()[0].Domain != "a" {
+		t.Error("Expected Results to return a copy")
+	}
+}
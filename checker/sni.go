@@ -0,0 +1,75 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+	"time"
+)
+
+// SNICertificate is the certificate a mailserver presented when TLS was
+// negotiated with a particular server name indication.
+type SNICertificate struct {
+	// ServerName is the SNI sent, or "" if none was.
+	ServerName  string           `json:"server_name"`
+	Certificate *CertificateInfo `json:"certificate"`
+}
+
+// sniServerNames returns the server names that probeSNI negotiates TLS with:
+// the hostname, none at all, and the domain. Servers on implicit TLS ports
+// are always sent the hostname (see dialSession), so they aren't probed
+// without SNI.
+func sniServerNames(domain string, hostname string, mode TLSMode) []string {
+	hostname = withoutPort(strings.TrimSuffix(hostname, "."))
+	names := []string{hostname}
+	if mode != ModeImplicitTLS {
+		names = append(names, "")
+	}
+	if domain = strings.TrimSuffix(domain, "."); domain != "" && domain != hostname {
+		names = append(names, domain)
+	}
+	return names
+}
+
+// probeSNI negotiates TLS with hostname once for each of sniServerNames, and
+// returns the certificate presented each time, and the state of the
+// connection without SNI, if there was one. Adds the messages described by
+// reportSNI to result.
+func probeSNI(ctx context.Context, domain string, hostname string, dialer smtpDialer, mode TLSMode, roots *x509.CertPool, result *Result) ([]SNICertificate, *tls.ConnectionState) {
+	var presented []SNICertificate
+	var noSNI *tls.ConnectionState
+	for _, name := range sniServerNames(domain, hostname, mode) {
+		config := clientTLSConfig()
+		config.ServerName = name
+		state, ok, err := dialer.tlsConnectionState(ctx, hostname, config)
+		if err != nil || !ok || len(state.PeerCertificates) == 0 {
+			continue
+		}
+		presented = append(presented, SNICertificate{ServerName: name, Certificate: makeCertificateInfo(state.PeerCertificates[0])})
+		if name == "" {
+			noSNI = &state
+		}
+	}
+	reportSNI(presented, noSNI, hostname, roots, dialer.selfSigned, dialer.now(), result)
+	return presented, noSNI
+}
+
+// reportSNI adds a Warning to result if the certificate presented without SNI,
+// in the connection state noSNI, isn't valid for hostname, since clients that
+// don't send SNI will reject it, and an Info if the certificates presented
+// differ.
+func reportSNI(presented []SNICertificate, noSNI *tls.ConnectionState, hostname string, roots *x509.CertPool, selfSigned SelfSignedPolicy, now time.Time, result *Result) {
+	if noSNI != nil {
+		if certResult, _ := checkCertState(*noSNI, hostname, roots, selfSigned, now); certResult.Status == Failure {
+			result.Warning("Without SNI, the server presents a certificate that isn't valid for %s, which clients that don't send SNI will reject.",
+				withoutPort(hostname))
+		}
+	}
+	for _, sni := range presented {
+		if sni.Certificate.SHA256Fingerprint != presented[0].Certificate.SHA256Fingerprint {
+			result.Info("The server presents different certificates depending on the SNI sent.")
+			return
+		}
+	}
+}
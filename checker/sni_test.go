@@ -0,0 +1,115 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSNIServerNames(t *testing.T) {
+	tests := []struct {
+		domain   string
+		hostname string
+		mode     TLSMode
+		want     []string
+	}{
+		{"example.com", "mx.example.com.", ModeSTARTTLS, []string{"mx.example.com", "", "example.com"}},
+		{"example.com", "mx.example.com:465", ModeImplicitTLS, []string{"mx.example.com", "example.com"}},
+		{"example.com", "example.com", ModeSTARTTLS, []string{"example.com", ""}},
+	}
+	for _, test := range tests {
+		if got := sniServerNames(test.domain, test.hostname, test.mode); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("sniServerNames(%q, %q, %s) = %q, want %q", test.domain, test.hostname, test.mode, got, test.want)
+		}
+	}
+}
+
+func TestProbeSNI(t *testing.T) {
+	now := time.Now()
+	root := issueTestCert(t, "Test Root", true, now.Add(-time.Hour), now.Add(time.Hour), nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	certFor := func(name string) *tls.Certificate {
+		leaf := issueTestCert(t, name, false, now.Add(-time.Hour), now.Add(time.Hour), root)
+		return &tls.Certificate{Certificate: [][]byte{leaf.cert.Raw}, PrivateKey: leaf.key}
+	}
+	localhost, fallback := certFor("localhost"), certFor("fallback.example.com")
+	ln := smtpListenAndServe(t, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName == "" {
+				return fallback, nil
+			}
+			return localhost, nil
+		},
+	})
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	hostname := net.JoinHostPort("localhost", port)
+
+	dialer := smtpDialer{timeout: testTimeout, probeSNI: true}
+	result := fullCheckHostname(context.Background(), "example.com", hostname, dialer, roots)
+	var presented []string
+	for _, c := range result.SNICertificates {
+		presented = append(presented, c.ServerName+"="+c.Certificate.Subject)
+	}
+	expected := []string{"localhost=localhost", "=fallback.example.com", "example.com=localhost"}
+	if !reflect.DeepEqual(presented, expected) {
+		t.Errorf("Expected certificates %q, got %q", expected, presented)
+	}
+	messages := strings.Join(result.Checks[Certificate].Messages, "\n")
+	if !strings.Contains(messages, "Without SNI") || !strings.Contains(messages, "different certificates") {
+		t.Errorf("Expected invalid certificate without SNI to be noted, got %q", messages)
+	}
+
+	dialer.probeSNI = false
+	result = fullCheckHostname(context.Background(), "example.com", hostname, dialer, roots)
+	if result.SNICertificates != nil {
+		t.Errorf("Expected SNI not to be probed by default, got %v", result.SNICertificates)
+	}
+}
+
+func TestSNIOverride(t *testing.T) {
+	now := time.Now()
+	root := issueTestCert(t, "Test Root", true, now.Add(-time.Hour), now.Add(30*24*time.Hour), nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	certFor := func(name string) *tls.Certificate {
+		leaf := issueTestCert(t, name, false, now.Add(-time.Hour), now.Add(30*24*time.Hour), root)
+		return &tls.Certificate{Certificate: [][]byte{leaf.cert.Raw}, PrivateKey: leaf.key}
+	}
+	localhost, override := certFor("localhost"), certFor("override.example.com")
+	ln := smtpListenAndServe(t, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName == "override.example.com" {
+				return override, nil
+			}
+			return localhost, nil
+		},
+	})
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	hostname := net.JoinHostPort("localhost", port)
+
+	dialer := smtpDialer{timeout: testTimeout, sniOverride: "override.example.com"}
+	result := fullCheckHostname(context.Background(), "example.com", hostname, dialer, roots)
+	if result.SNI != "override.example.com" {
+		t.Errorf("Expected SNI override to be recorded, got %q", result.SNI)
+	}
+	if result.Certificate == nil || result.Certificate.Subject != "override.example.com" || result.Certificate.CheckedName != "localhost" {
+		t.Fatalf("Expected certificate for the SNI override to be checked against localhost, got %+v", result.Certificate)
+	}
+	if result.Checks[Certificate].Status != Failure {
+		t.Errorf("Expected certificate not to be valid for localhost, got %v", result.Checks[Certificate])
+	}
+
+	dialer.sniOverride = ""
+	result = fullCheckHostname(context.Background(), "example.com", hostname, dialer, roots)
+	if result.SNI != "" || result.Checks[Certificate].Status != Success {
+		t.Errorf("Expected certificate for localhost without an SNI override, got %q and %v", result.SNI, result.Checks[Certificate])
+	}
+}
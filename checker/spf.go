@@ -0,0 +1,262 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// spfMaxLookups is the most DNS lookups that evaluating an SPF record may
+// take (RFC 7208, section 4.6.4).
+const spfMaxLookups = 10
+
+// spfRecords returns the SPF records among records.
+func spfRecords(records []string) []string {
+	spf := []string{}
+	for _, record := range records {
+		fields := strings.Fields(record)
+		if len(fields) > 0 && strings.EqualFold(fields[0], "v=spf1") {
+			spf = append(spf, record)
+		}
+	}
+	return spf
+}
+
+// spfModifierPattern matches the name of a modifier, like redirect=.
+var spfModifierPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]*=`)
+
+// spfTempError is an error looking up a record, rather than in the records.
+type spfTempError struct {
+	err error
+}
+
+func (e spfTempError) Error() string {
+	return e.err.Error()
+}
+
+// spfEvaluation parses an SPF record, and those it includes, counting the
+// DNS lookups that evaluating it takes.
+type spfEvaluation struct {
+	ctx     context.Context
+	c       *Checker
+	lookups int
+	// allowsAll is whether the top-level record passes any sender.
+	allowsAll bool
+}
+
+// lookup returns the SPF record of domain, which an include or redirect
+// points to.
+func (e *spfEvaluation) lookup(domain string) (string, error) {
+	records, err := e.c.lookupTXT(e.ctx, domain)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		records, err = nil, nil
+	}
+	if err != nil {
+		return "", spfTempError{fmt.Errorf("couldn't look up the SPF record of %s: %v", domain, err)}
+	}
+	spf := spfRecords(records)
+	if len(spf) != 1 {
+		return "", fmt.Errorf("%s has %d SPF records, rather than exactly 1", domain, len(spf))
+	}
+	return spf[0], nil
+}
+
+// evaluate parses record, then the records it includes or redirects to,
+// unless they use macros. Stops once more than spfMaxLookups lookups are
+// needed.
+func (e *spfEvaluation) evaluate(record string, top bool) error {
+	var targets []string
+	var redirect string
+	hasAll := false
+	for _, term := range strings.Fields(record)[1:] {
+		if spfModifierPattern.MatchString(term) {
+			pair := strings.SplitN(term, "=", 2)
+			name, value := strings.ToLower(pair[0]), pair[1]
+			if (name == "redirect" || name == "exp") && value == "" {
+				return fmt.Errorf("%s= requires a domain", name)
+			}
+			if name == "redirect" {
+				if redirect != "" {
+					return fmt.Errorf("redirect= appears more than once")
+				}
+				redirect = value
+			}
+			continue
+		}
+		mechanism := term
+		qualifier := byte('+')
+		if strings.IndexByte("+-~?", mechanism[0]) >= 0 {
+			qualifier, mechanism = mechanism[0], mechanism[1:]
+		}
+		name, arg := mechanism, ""
+		if i := strings.IndexAny(mechanism, ":/"); i >= 0 {
+			name, arg = mechanism[:i], mechanism[i:]
+		}
+		var err error
+		switch strings.ToLower(name) {
+		case "all":
+			if arg != "" {
+				err = fmt.Errorf("all doesn't take a domain")
+			}
+			hasAll = true
+			if top && qualifier == '+' {
+				e.allowsAll = true
+			}
+		case "include", "exists":
+			if len(arg) < 2 || arg[0] != ':' {
+				err = fmt.Errorf("%s requires a domain", name)
+			}
+			e.lookups++
+			if strings.ToLower(name) == "include" {
+				targets = append(targets, arg[1:])
+			}
+		case "a", "mx":
+			err = validateSPFDomainAndCIDR(arg, true)
+			e.lookups++
+		case "ptr":
+			err = validateSPFDomainAndCIDR(arg, false)
+			e.lookups++
+		case "ip4", "ip6":
+			err = validateSPFNetwork(strings.ToLower(name), arg)
+		default:
+			err = fmt.Errorf("unknown mechanism")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid term %q: %v", term, err)
+		}
+	}
+	// redirect= is ignored if there's an all mechanism.
+	if redirect != "" && !hasAll {
+		e.lookups++
+		targets = append(targets, redirect)
+	}
+	for _, target := range targets {
+		if e.lookups > spfMaxLookups {
+			return nil
+		}
+		if strings.Contains(target, "%") {
+			// Macros are only expanded when a message is received.
+			continue
+		}
+		included, err := e.lookup(target)
+		if err != nil {
+			return err
+		}
+		if err := e.evaluate(included, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateSPFDomainAndCIDR validates the optional domain and, if cidr is
+// set, the optional CIDR lengths that follow an a, mx or ptr mechanism, like
+// ":example.com/24//64".
+func validateSPFDomainAndCIDR(arg string, cidr bool) error {
+	domain := arg
+	lengths := ""
+	if i := strings.Index(arg, "/"); i >= 0 {
+		if !cidr {
+			return fmt.Errorf("CIDR lengths aren't allowed")
+		}
+		domain, lengths = arg[:i], arg[i:]
+	}
+	if domain == ":" {
+		return fmt.Errorf("empty domain")
+	}
+	if lengths == "" {
+		return nil
+	}
+	ip4, ip6 := lengths, ""
+	if i := strings.Index(lengths, "//"); i >= 0 {
+		ip4, ip6 = lengths[:i], lengths[i+1:]
+	}
+	if ip4 != "" && !validCIDRLength(ip4[1:], 32) {
+		return fmt.Errorf("invalid IPv4 CIDR length %q", ip4)
+	}
+	if ip6 != "" && !validCIDRLength(ip6[1:], 128) {
+		return fmt.Errorf("invalid IPv6 CIDR length %q", ip6)
+	}
+	return nil
+}
+
+// validateSPFNetwork validates the network that follows an ip4 or ip6
+// mechanism, like ":192.0.2.0/24".
+func validateSPFNetwork(mechanism, arg string) error {
+	if len(arg) < 2 || arg[0] != ':' {
+		return fmt.Errorf("%s requires an address", mechanism)
+	}
+	address, length := arg[1:], ""
+	if i := strings.Index(address, "/"); i >= 0 {
+		address, length = address[:i], address[i+1:]
+	}
+	ip := net.ParseIP(address)
+	max := 128
+	if mechanism == "ip4" {
+		max = 32
+		if ip != nil {
+			ip = ip.To4()
+		}
+	} else if ip != nil && ip.To4() != nil {
+		ip = nil
+	}
+	if ip == nil {
+		return fmt.Errorf("invalid address %q", address)
+	}
+	if length != "" && !validCIDRLength(length, max) {
+		return fmt.Errorf("invalid CIDR length %q", length)
+	}
+	return nil
+}
+
+func validCIDRLength(s string, max int) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n >= 0 && n <= max && strconv.Itoa(n) == s
+}
+
+// checkSPF checks the SPF (RFC 7208) record of domain: that there's exactly
+// one, that it's valid, and that evaluating it doesn't take more than 10 DNS
+// lookups, counting those of the records it includes.
+func (c *Checker) checkSPF(ctx context.Context, domain string) *Result {
+	result := MakeResult(SPF)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	records, err := c.lookupTXT(ctx, domain)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		records, err = nil, nil
+	}
+	if err != nil && (expired(ctx) || isTimeout(err)) {
+		return result.Error("Timed out looking up the SPF record.")
+	}
+	if err != nil {
+		return result.Error("Couldn't look up the SPF record of %s: %v.", domain, err)
+	}
+	spf := spfRecords(records)
+	if len(spf) == 0 {
+		return result.Warning("No SPF record found for %s, so receivers can't tell which servers may send mail from it.", domain)
+	}
+	if len(spf) > 1 {
+		return result.Failure("Exactly 1 SPF record required, found %d.", len(spf))
+	}
+	e := spfEvaluation{ctx: ctx, c: c}
+	err = e.evaluate(spf[0], true)
+	if _, ok := err.(spfTempError); ok {
+		if expired(ctx) {
+			return result.Error("Timed out looking up the records included by the SPF record.")
+		}
+		return result.Error("Couldn't check the SPF record: %v.", err)
+	}
+	if err != nil {
+		return result.Failure("Invalid SPF record: %v.", err)
+	}
+	if e.lookups > spfMaxLookups {
+		return result.Failure("Evaluating the SPF record takes more than %d DNS lookups, so receivers will treat it as an error.", spfMaxLookups)
+	}
+	if e.allowsAll {
+		result.Warning("The SPF record passes any server with +all, so it allows any server to send mail from %s.", domain)
+	}
+	return result.Success()
+}
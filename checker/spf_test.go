@@ -0,0 +1,94 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// spfChecker returns a Checker whose TXT records are looked up in records.
+func spfChecker(records map[string][]string) Checker {
+	return Checker{
+		lookupTXTOverride: func(name string) ([]string, error) {
+			if name == "servfail.example" {
+				return nil, errors.New("SERVFAIL")
+			}
+			if r, ok := records[name]; ok {
+				return r, nil
+			}
+			return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+		},
+	}
+}
+
+func TestCheckSPF(t *testing.T) {
+	includes := []string{}
+	for i := 0; i < 10; i++ {
+		includes = append(includes, fmt.Sprintf("include:spf%d.example", i))
+	}
+	records := map[string][]string{
+		"example.com":        {"v=spf1 mx a:mail.example.com/24 ip4:192.0.2.0/24 ip6:2001:db8::/32 include:spf.example -all"},
+		"spf.example":        {"v=spf1 ip4:198.51.100.1 ~all"},
+		"redirect.example":   {"v=spf1 redirect=spf.example"},
+		"macro.example":      {"v=spf1 exists:%{i}._spf.example -all"},
+		"none.example":       {"google-site-verification=abc"},
+		"multiple.example":   {"v=spf1 -all", "v=spf1 mx -all"},
+		"syntax.example":     {"v=spf1 ip4:192.0.2.300 -all"},
+		"unknown.example":    {"v=spf1 mx foo -all"},
+		"cidr.example":       {"v=spf1 a//129 -all"},
+		"missing.example":    {"v=spf1 include:nonexistent.example -all"},
+		"temp.example":       {"v=spf1 include:servfail.example -all"},
+		"permissive.example": {"v=spf1 mx +all"},
+		"toomany.example":    {"v=spf1 " + strings.Join(includes, " ") + " -all"},
+		"nested.example":     {"v=spf1 a mx include:toomany.example -all"},
+	}
+	for i := 0; i < 10; i++ {
+		records[fmt.Sprintf("spf%d.example", i)] = []string{"v=spf1 ip4:192.0.2.1 -all"}
+	}
+	c := spfChecker(records)
+	tests := []struct {
+		domain string
+		status Status
+	}{
+		{"example.com", Success},
+		{"redirect.example", Success},
+		{"macro.example", Success},
+		{"toomany.example", Success},
+		{"nonexistent.example", Warning},
+		{"none.example", Warning},
+		{"permissive.example", Warning},
+		{"multiple.example", Failure},
+		{"syntax.example", Failure},
+		{"unknown.example", Failure},
+		{"cidr.example", Failure},
+		{"missing.example", Failure},
+		{"nested.example", Failure},
+		{"servfail.example", Error},
+		{"temp.example", Error},
+	}
+	for _, test := range tests {
+		result := c.checkSPF(context.Background(), test.domain)
+		if result.Status != test.status {
+			t.Errorf("checkSPF(%s) = %v, want status %s", test.domain, result, statusText[test.status])
+		}
+	}
+}
+
+func TestCheckDomainSPF(t *testing.T) {
+	c := spfChecker(map[string][]string{"domain": {"v=spf1 mx -all"}})
+	c.lookupMXOverride = mockLookupMX
+	c.CheckHostname = mockCheckHostname
+	c.checkMTASTSOverride = mockCheckMTASTS
+	c.lookupCAAOverride = mockLookupCAA
+	c.lookupDNSSECOverride = mockLookupDNSSEC
+	if _, ok := c.CheckDomain("domain", nil).ExtraResults[SPF]; ok {
+		t.Error("Expected SPF not to be checked by default")
+	}
+	c.CheckSPF = true
+	if r := c.CheckDomain("domain", nil).ExtraResults[SPF]; r == nil || r.Status != Success {
+		t.Errorf("Expected a valid SPF record, got %v", r)
+	}
+}
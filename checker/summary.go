@@ -0,0 +1,114 @@
+package checker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+var domainStatusText = map[DomainStatus]string{
+	DomainSuccess:            "SUCCESS",
+	DomainWarning:            "WARNING",
+	DomainFailure:            "FAILURE",
+	DomainError:              "ERROR",
+	DomainNoSTARTTLSFailure:  "NO STARTTLS",
+	DomainCouldNotConnect:    "COULD NOT CONNECT",
+	DomainBadHostnameFailure: "BAD HOSTNAME",
+}
+
+// Summary returns a one-line description of the result, for people reading a
+// scan's progress, e.g. "example.com: FAILURE (certificate: hostname mismatch;
+// 2/3 MX support STARTTLS)". It lists the checks that didn't succeed on any of
+// the domain's mailservers, and how many of them support STARTTLS.
+func (d DomainResult) Summary() string {
+	status, ok := domainStatusText[d.Status]
+	if !ok {
+		status = fmt.Sprintf("STATUS %d", d.Status)
+	}
+	reasons := []string{}
+	if d.Message != "" {
+		reasons = append(reasons, strings.TrimSuffix(d.Message, "."))
+	}
+	reasons = append(reasons, d.hostnameCheckReasons()...)
+	if len(d.HostnameResults) > 0 {
+		starttls := 0
+		for _, hostnameResult := range d.HostnameResults {
+			if hostnameResult.Result != nil && hostnameResult.couldSTARTTLS() {
+				starttls++
+			}
+		}
+		reasons = append(reasons, fmt.Sprintf("%d/%d MX support STARTTLS", starttls, len(d.HostnameResults)))
+	}
+	extras := []string{}
+	for name, result := range d.ExtraResults {
+		if result != nil && !result.Status.succeeded() && result.Status != Warning {
+			extras = append(extras, fmt.Sprintf("%s: %s", name, strings.ToLower(result.StatusText())))
+		}
+	}
+	sort.Strings(extras)
+	reasons = append(reasons, extras...)
+	if len(reasons) == 0 {
+		return fmt.Sprintf("%s: %s", d.Domain, status)
+	}
+	return fmt.Sprintf("%s: %s (%s)", d.Domain, status, strings.Join(reasons, "; "))
+}
+
+// hostnameCheckReasons describes each hostname check that failed (or
+// errored) on any of the domain's mailservers, in order of check name.
+// Certificate failures are described by their reasons.
+func (d DomainResult) hostnameCheckReasons() []string {
+	failed := make(map[string]map[string]bool)
+	for _, hostnameResult := range d.HostnameResults {
+		if hostnameResult.Result == nil {
+			continue
+		}
+		for name, check := range hostnameResult.Checks {
+			if check == nil || check.Status.succeeded() || check.Status == Warning {
+				continue
+			}
+			if failed[name] == nil {
+				failed[name] = make(map[string]bool)
+			}
+			if name == Certificate && len(hostnameResult.CertificateFailures) > 0 {
+				for _, failure := range hostnameResult.CertificateFailures {
+					failed[name][strings.Replace(string(failure), "_", " ", -1)] = true
+				}
+			} else {
+				failed[name][strings.ToLower(check.StatusText())] = true
+			}
+		}
+	}
+	names := []string{}
+	for name := range failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	reasons := []string{}
+	for _, name := range names {
+		descriptions := []string{}
+		for description := range failed[name] {
+			descriptions = append(descriptions, description)
+		}
+		sort.Strings(descriptions)
+		reasons = append(reasons, fmt.Sprintf("%s: %s", name, strings.Join(descriptions, ", ")))
+	}
+	return reasons
+}
+
+// PrintHandler writes the Summary of each domain result on its own line.
+// Implements ResultHandler.
+type PrintHandler struct {
+	// Out is where the summaries are written. If nil, os.Stdout is used.
+	Out io.Writer
+}
+
+// HandleDomain writes the summary of a single domain's result.
+func (p PrintHandler) HandleDomain(r DomainResult) {
+	out := p.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	fmt.Fprintln(out, r.Summary())
+}
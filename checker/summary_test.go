@@ -0,0 +1,67 @@
+package checker
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSummary(t *testing.T) {
+	good := HostnameResult{Result: &Result{Checks: map[string]*Result{
+		Connectivity: {Name: Connectivity, Status: Success},
+		STARTTLS:     {Name: STARTTLS, Status: Success},
+		Certificate:  {Name: Certificate, Status: Success},
+	}}}
+	badCert := HostnameResult{
+		Result: &Result{Checks: map[string]*Result{
+			Connectivity: {Name: Connectivity, Status: Success},
+			STARTTLS:     {Name: STARTTLS, Status: Success},
+			Certificate:  {Name: Certificate, Status: Failure},
+		}},
+		CertificateFailures: []CertificateFailure{CertHostnameMismatch},
+	}
+	noSTARTTLS := HostnameResult{Result: &Result{Checks: map[string]*Result{
+		Connectivity: {Name: Connectivity, Status: Success},
+		STARTTLS:     {Name: STARTTLS, Status: Failure},
+	}}}
+	tests := []struct {
+		result   DomainResult
+		expected string
+	}{
+		{
+			DomainResult{Domain: "example.com", Status: DomainSuccess,
+				HostnameResults: map[string]HostnameResult{"mx1": good}},
+			"example.com: SUCCESS (1/1 MX support STARTTLS)",
+		},
+		{
+			DomainResult{Domain: "example.com", Status: DomainFailure,
+				HostnameResults: map[string]HostnameResult{"mx1": good, "mx2": badCert, "mx3": noSTARTTLS}},
+			"example.com: FAILURE (certificate: hostname mismatch; starttls: failure; 2/3 MX support STARTTLS)",
+		},
+		{
+			DomainResult{Domain: "example.com", Status: DomainError, Message: "Timed out before all checks completed."},
+			"example.com: ERROR (Timed out before all checks completed)",
+		},
+		{
+			DomainResult{Domain: "example.com", Status: DomainSuccess,
+				HostnameResults: map[string]HostnameResult{"mx1": good},
+				ExtraResults:    map[string]*Result{ExpectedMXs: {Name: ExpectedMXs, Status: Failure}}},
+			"example.com: SUCCESS (1/1 MX support STARTTLS; expected-mxs: failure)",
+		},
+	}
+	for _, test := range tests {
+		if summary := test.result.Summary(); summary != test.expected {
+			t.Errorf("Expected summary %q, got %q", test.expected, summary)
+		}
+	}
+}
+
+func TestPrintHandler(t *testing.T) {
+	var out bytes.Buffer
+	handler := PrintHandler{Out: &out}
+	handler.HandleDomain(DomainResult{Domain: "a.com", Status: DomainCouldNotConnect})
+	handler.HandleDomain(DomainResult{Domain: "b.com"})
+	expected := "a.com: COULD NOT CONNECT\nb.com: SUCCESS\n"
+	if out.String() != expected {
+		t.Errorf("Expected output %q, got %q", expected, out.String())
+	}
+}
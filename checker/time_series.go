@@ -0,0 +1,72 @@
+package checker
+
+import (
+	"sync"
+	"time"
+)
+
+// TimeSeriesHandler aggregates domain results into a series of
+// AggregatedScans, each covering one Interval of time.
+// Implements ResultHandler, and is safe for concurrent use.
+type TimeSeriesHandler struct {
+	// Interval is the length of time covered by each AggregatedScan, e.g.
+	// time.Hour. Buckets are aligned to multiples of Interval since the zero
+	// time, so hourly buckets start on the hour.
+	Interval time.Duration
+
+	// Source labels each AggregatedScan.
+	Source string
+
+	// OnBucket is called with each completed AggregatedScan, whose Time is the
+	// start of its interval. A bucket is completed when a result for a later
+	// interval is handled, or when Flush is called.
+	OnBucket func(AggregatedScan)
+
+	mu      sync.Mutex
+	current *AggregatedScan
+
+	// nowOverride is used to mock the current time during testing.
+	nowOverride func() time.Time
+}
+
+func (h *TimeSeriesHandler) now() time.Time {
+	if h.nowOverride != nil {
+		return h.nowOverride()
+	}
+	return time.Now()
+}
+
+// HandleDomain adds the result of a single domain scan to the current
+// interval's AggregatedScan, first completing the previous interval's if
+// the interval has rolled over.
+func (h *TimeSeriesHandler) HandleDomain(r DomainResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	start := h.now().Truncate(h.Interval)
+	if h.current != nil && !h.current.Time.Equal(start) {
+		h.flush()
+	}
+	if h.current == nil {
+		// Buckets are reported through OnBucket instead of progress logs.
+		h.current = &AggregatedScan{Time: start, Source: h.Source}
+	}
+	h.current.HandleDomain(r)
+}
+
+// Flush completes the current interval's AggregatedScan, if any domains have
+// been handled in it. It should be called when a scan finishes.
+func (h *TimeSeriesHandler) Flush() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.flush()
+}
+
+func (h *TimeSeriesHandler) flush() {
+	if h.current == nil {
+		return
+	}
+	if h.OnBucket != nil {
+		h.OnBucket(*h.current)
+	}
+	h.current = nil
+}
@@ -0,0 +1,54 @@
+package checker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeSeriesHandler(t *testing.T) {
+	now := time.Date(2019, 1, 1, 10, 15, 0, 0, time.UTC)
+	buckets := []AggregatedScan{}
+	h := TimeSeriesHandler{
+		Interval:    time.Hour,
+		Source:      LocalSource,
+		OnBucket:    func(a AggregatedScan) { buckets = append(buckets, a) },
+		nowOverride: func() time.Time { return now },
+	}
+	withMX := DomainResult{HostnameResults: map[string]HostnameResult{"mx": {}}}
+
+	h.HandleDomain(withMX)
+	h.HandleDomain(DomainResult{})
+	if len(buckets) != 0 {
+		t.Fatalf("Expected no completed buckets within an interval, got %v", buckets)
+	}
+	now = now.Add(time.Hour)
+	h.HandleDomain(withMX)
+	now = now.Add(3 * time.Hour)
+	h.HandleDomain(withMX)
+	h.Flush()
+	h.Flush()
+
+	if len(buckets) != 3 {
+		t.Fatalf("Expected 3 completed buckets, got %d", len(buckets))
+	}
+	expected := []struct {
+		time      time.Time
+		attempted int
+	}{
+		{time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC), 2},
+		{time.Date(2019, 1, 1, 11, 0, 0, 0, time.UTC), 1},
+		{time.Date(2019, 1, 1, 14, 0, 0, 0, time.UTC), 1},
+	}
+	for i, bucket := range buckets {
+		if !bucket.Time.Equal(expected[i].time) || bucket.Attempted != expected[i].attempted {
+			t.Errorf("Expected bucket %d to start at %v with %d domains, got %v with %d",
+				i, expected[i].time, expected[i].attempted, bucket.Time, bucket.Attempted)
+		}
+		if bucket.Source != LocalSource {
+			t.Errorf("Expected bucket to be labeled %s, got %s", LocalSource, bucket.Source)
+		}
+	}
+	if buckets[0].WithMXs != 1 {
+		t.Errorf("Expected buckets to aggregate results, got %d domains with MXs", buckets[0].WithMXs)
+	}
+}
@@ -0,0 +1,90 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+)
+
+// TLSMode is how TLS is negotiated with a mailserver.
+type TLSMode string
+
+// Ways of negotiating TLS with a mailserver.
+const (
+	// ModeSTARTTLS connects in plaintext, then upgrades the connection with
+	// STARTTLS.
+	ModeSTARTTLS TLSMode = "starttls"
+	// ModeImplicitTLS negotiates TLS as soon as it connects, before the SMTP
+	// greeting (SMTPS, RFC 8314).
+	ModeImplicitTLS TLSMode = "implicit"
+)
+
+// DefaultSMTPPort is the port mailservers are checked on if the Checker
+// doesn't specify otherwise.
+const DefaultSMTPPort = 25
+
+// defaultPortModes are the TLS modes of well-known mail ports. Ports that
+// aren't listed here or in Checker.PortModes use STARTTLS.
+var defaultPortModes = map[int]TLSMode{
+	25:  ModeSTARTTLS,
+	465: ModeImplicitTLS,
+	587: ModeSTARTTLS,
+}
+
+// address returns the address to dial for hostname, which may include a
+// port, and the TLS mode of that port.
+func (d smtpDialer) address(hostname string) (string, TLSMode) {
+	host, portString, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host, portString = hostname, strconv.Itoa(DefaultSMTPPort)
+		if d.port != 0 {
+			portString = strconv.Itoa(d.port)
+		}
+	}
+	port, _ := strconv.Atoi(portString)
+	mode, ok := d.portModes[port]
+	if !ok {
+		mode, ok = defaultPortModes[port]
+	}
+	if !ok {
+		mode = ModeSTARTTLS
+	}
+	return net.JoinHostPort(host, portString), mode
+}
+
+// clientTLSConfig is the configuration used to negotiate TLS with the server
+// being checked. It accepts any certificate, so that the Certificate check can
+// report on it.
+func clientTLSConfig() *tls.Config {
+	return &tls.Config{InsecureSkipVerify: true}
+}
+
+// tlsHandshake opens a new connection to hostname and negotiates TLS with
+// config, with STARTTLS or implicitly depending on the port. Returns whether
+// the server accepted the handshake, or an error if it couldn't connect.
+func (d smtpDialer) tlsHandshake(ctx context.Context, hostname string, config *tls.Config) (bool, error) {
+	_, accepted, err := d.tlsConnectionState(ctx, hostname, config)
+	return accepted, err
+}
+
+// tlsConnectionState performs tlsHandshake, and also returns the state of
+// the connection if the handshake was accepted.
+func (d smtpDialer) tlsConnectionState(ctx context.Context, hostname string, config *tls.Config) (tls.ConnectionState, bool, error) {
+	session, err := d.dialSession(ctx, hostname, config)
+	if errors.Is(err, ErrTLSHandshake) {
+		return tls.ConnectionState{}, false, nil
+	}
+	if err != nil {
+		return tls.ConnectionState{}, false, err
+	}
+	defer session.quit(ctx)
+	if session.mode == ModeSTARTTLS {
+		if err := session.client.StartTLS(config); err != nil {
+			return tls.ConnectionState{}, false, nil
+		}
+	}
+	state, _ := session.connectionState()
+	return state, true, nil
+}
@@ -0,0 +1,101 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/mhale/smtpd"
+)
+
+func TestAddress(t *testing.T) {
+	tests := []struct {
+		dialer   smtpDialer
+		hostname string
+		address  string
+		mode     TLSMode
+	}{
+		{smtpDialer{}, "mx.example.com", "mx.example.com:25", ModeSTARTTLS},
+		{smtpDialer{}, "mx.example.com:465", "mx.example.com:465", ModeImplicitTLS},
+		{smtpDialer{}, "mx.example.com:587", "mx.example.com:587", ModeSTARTTLS},
+		{smtpDialer{}, "mx.example.com:2525", "mx.example.com:2525", ModeSTARTTLS},
+		{smtpDialer{port: 465}, "mx.example.com", "mx.example.com:465", ModeImplicitTLS},
+		{smtpDialer{port: 465}, "mx.example.com:25", "mx.example.com:25", ModeSTARTTLS},
+		{smtpDialer{portModes: map[int]TLSMode{2465: ModeImplicitTLS}}, "mx.example.com:2465", "mx.example.com:2465", ModeImplicitTLS},
+		{smtpDialer{portModes: map[int]TLSMode{465: ModeSTARTTLS}}, "mx.example.com:465", "mx.example.com:465", ModeSTARTTLS},
+	}
+	for _, test := range tests {
+		address, mode := test.dialer.address(test.hostname)
+		if address != test.address || mode != test.mode {
+			t.Errorf("address(%s) with %+v = %s, %s, want %s, %s", test.hostname, test.dialer, address, mode, test.address, test.mode)
+		}
+	}
+}
+
+// smtpsListenAndServe creates a test SMTP server that expects implicit TLS.
+func smtpsListenAndServe(t *testing.T) (net.Listener, int) {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "localhost:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &smtpd.Server{Handler: noopHandler, Hostname: "example.com"}
+	go srv.Serve(ln)
+	return ln, ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestImplicitTLS(t *testing.T) {
+	ln, port := smtpsListenAndServe(t)
+	defer ln.Close()
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM([]byte(certString))
+	dialer := smtpDialer{timeout: testTimeout, portModes: map[int]TLSMode{port: ModeImplicitTLS}}
+
+	result := fullCheckHostname(context.Background(), "", "localhost:"+strconv.Itoa(port), dialer, roots)
+	if result.TLSMode != ModeImplicitTLS {
+		t.Errorf("Expected implicit TLS to be reported, got %q", result.TLSMode)
+	}
+	if result.TLSVersion < tls.VersionTLS12 {
+		t.Errorf("Expected negotiated TLS version to be recorded, got %s", TLSVersionName(result.TLSVersion))
+	}
+	if result.Greeting == "" {
+		t.Error("Expected greeting sent over TLS to be recorded")
+	}
+	expected := Result{
+		Status: Info,
+		Checks: map[string]*Result{
+			Connectivity: {Name: Connectivity, Status: Success},
+			STARTTLS:     {Name: STARTTLS, Status: Info},
+			Certificate:  {Name: Certificate, Status: Success},
+			Version:      {Name: Version, Status: Success},
+		},
+	}
+	compareStatuses(t, expected, result)
+}
+
+func TestImplicitTLSOnSTARTTLSPort(t *testing.T) {
+	ln := smtpListenAndServe(t, nil)
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+	dialer := smtpDialer{timeout: testTimeout, portModes: map[int]TLSMode{port: ModeImplicitTLS}}
+
+	result := fullCheckHostname(context.Background(), "", ln.Addr().String(), dialer, nil)
+	if !errors.Is(result.Err(), ErrTLSHandshake) {
+		t.Errorf("Expected TLS handshake error, got %v", result.Err())
+	}
+	expected := Result{
+		Status: Failure,
+		Checks: map[string]*Result{
+			Connectivity: {Name: Connectivity, Status: Success},
+			STARTTLS:     {Name: STARTTLS, Status: Failure},
+		},
+	}
+	compareStatuses(t, expected, result)
+}
@@ -0,0 +1,50 @@
+package checker
+
+// TLSProfile is how strictly the Version check treats old TLS versions and
+// weak cipher suites.
+type TLSProfile string
+
+// TLS profiles, from strictest to most tolerant.
+const (
+	// TLSProfileModern requires TLS 1.2, warns about servers without TLS 1.3,
+	// and fails servers accepting any insecure cipher suite.
+	TLSProfileModern TLSProfile = "modern"
+	// TLSProfileIntermediate warns about servers without TLS 1.2 and those
+	// accepting 3DES, and fails those accepting SSLv3 or RC4.
+	TLSProfileIntermediate TLSProfile = "intermediate"
+	// TLSProfileLegacyTolerant only warns about servers accepting SSLv3 or
+	// RC4, and notes the rest, e.g. for audits of mailservers that still
+	// serve old clients.
+	TLSProfileLegacyTolerant TLSProfile = "legacy-tolerant"
+)
+
+// tlsProfileRules are the statuses a TLSProfile gives to each problem the
+// Version check finds.
+type tlsProfileRules struct {
+	// noTLS12 is the status of a server that negotiated a version older than
+	// TLS 1.2.
+	noTLS12 Status
+	// noTLS13 is the status of the TLS13 check for a server that didn't
+	// negotiate TLS 1.3.
+	noTLS13 Status
+	// sslv3 is the status of a server that accepted SSLv3.
+	sslv3 Status
+	// rc4, tripleDES and insecure are the statuses of a server that accepted
+	// RC4, 3DES or other insecure cipher suites, if they're enumerated.
+	rc4, tripleDES, insecure Status
+}
+
+var tlsProfiles = map[TLSProfile]tlsProfileRules{
+	TLSProfileModern:         {noTLS12: Failure, noTLS13: Warning, sslv3: Failure, rc4: Failure, tripleDES: Failure, insecure: Failure},
+	TLSProfileIntermediate:   {noTLS12: Warning, noTLS13: Info, sslv3: Failure, rc4: Failure, tripleDES: Warning, insecure: Info},
+	TLSProfileLegacyTolerant: {noTLS12: Info, noTLS13: Info, sslv3: Warning, rc4: Warning, tripleDES: Info, insecure: Info},
+}
+
+// rules returns the statuses p gives to each problem. An empty or unknown
+// profile is treated as TLSProfileIntermediate.
+func (p TLSProfile) rules() tlsProfileRules {
+	if rules, ok := tlsProfiles[p]; ok {
+		return rules
+	}
+	return tlsProfiles[TLSProfileIntermediate]
+}
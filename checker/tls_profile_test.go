@@ -0,0 +1,60 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+)
+
+func TestTLSProfileVersions(t *testing.T) {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		profile  TLSProfile
+		version  uint16
+		expected Status
+	}{
+		{"", tls.VersionTLS12, Info},
+		{"", tls.VersionTLS11, Warning},
+		{TLSProfileModern, tls.VersionTLS13, Success},
+		{TLSProfileModern, tls.VersionTLS12, Warning},
+		{TLSProfileModern, tls.VersionTLS11, Failure},
+		{TLSProfileLegacyTolerant, tls.VersionTLS11, Info},
+	}
+	ln := smtpListenAndServe(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer ln.Close()
+	for _, test := range tests {
+		// crypto/tls clients don't offer versions older than TLS 1.2, so the
+		// negotiated version is passed in rather than negotiated.
+		dialer := smtpDialer{timeout: testTimeout, profile: test.profile}
+		state := tls.ConnectionState{Version: test.version}
+		result := checkTLSVersion(context.Background(), state, true, ln.Addr().String(), dialer)
+		if result.Status != test.expected {
+			t.Errorf("Expected Version check to be %v for %s with profile %q, got %v", test.expected, TLSVersionName(test.version), test.profile, result)
+		}
+	}
+}
+
+func TestTLSProfileCipherSuites(t *testing.T) {
+	suites := []CipherSuite{makeCipherSuite(tls.TLS_RSA_WITH_RC4_128_SHA)}
+	tests := map[TLSProfile]Status{
+		"":                       Failure,
+		TLSProfileModern:         Failure,
+		TLSProfileIntermediate:   Failure,
+		TLSProfileLegacyTolerant: Warning,
+	}
+	for profile, expected := range tests {
+		result := MakeResult(Version)
+		checkCipherSuites(suites, profile.rules(), result)
+		if result.Status != expected {
+			t.Errorf("Expected RC4 to be %v with profile %q, got %v", expected, profile, result)
+		}
+	}
+	result := MakeResult(Version)
+	checkCipherSuites([]CipherSuite{makeCipherSuite(tls.TLS_RSA_WITH_AES_128_CBC_SHA256)}, TLSProfileModern.rules(), result)
+	if result.Status != Failure {
+		t.Errorf("Expected insecure cipher suites to fail with the modern profile, got %v", result)
+	}
+}
@@ -0,0 +1,221 @@
+package checker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TLSAAssociation is the certificate association of a TLSA record (RFC 6698),
+// which a mailserver's certificate chain can be checked against before the
+// record is published (see DomainOptions).
+type TLSAAssociation struct {
+	// Usage is which certificate in the chain is matched: 0 (PKIX-TA) or 2
+	// (DANE-TA) for a trust anchor, 1 (PKIX-EE) or 3 (DANE-EE) for the
+	// mailserver's own certificate.
+	Usage uint8 `json:"usage"`
+	// Selector is 0 to match the full certificate, or 1 to match its
+	// SubjectPublicKeyInfo.
+	Selector uint8 `json:"selector"`
+	// MatchingType is 0 to match the selected data exactly, or 1 or 2 to
+	// match its SHA-256 or SHA-512 digest.
+	MatchingType uint8 `json:"matching_type"`
+	// Data is the certificate association data.
+	Data []byte `json:"data"`
+}
+
+// TLSA certificate usages, selectors and matching types.
+const (
+	tlsaUsagePKIXTA = 0
+	tlsaUsagePKIXEE = 1
+	tlsaUsageDANETA = 2
+	tlsaUsageDANEEE = 3
+
+	tlsaSelectorCert = 0
+	tlsaSelectorSPKI = 1
+
+	tlsaMatchingFull   = 0
+	tlsaMatchingSHA256 = 1
+	tlsaMatchingSHA512 = 2
+)
+
+// ParseTLSAAssociation parses a TLSA record's data in presentation format,
+// e.g. "3 1 1 0c72ac70b745ac19998811b131d662c9ac69dbdbe7cb23e5b514b56664c5d3d6".
+func ParseTLSAAssociation(s string) (TLSAAssociation, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 4 {
+		return TLSAAssociation{}, fmt.Errorf("TLSA association %q should have a usage, selector, matching type and data", s)
+	}
+	var params [3]uint8
+	for i, field := range fields[:3] {
+		n, err := strconv.ParseUint(field, 10, 8)
+		if err != nil {
+			return TLSAAssociation{}, fmt.Errorf("invalid field %q in TLSA association %q", field, s)
+		}
+		params[i] = uint8(n)
+	}
+	data, err := hex.DecodeString(strings.Join(fields[3:], ""))
+	if err != nil {
+		return TLSAAssociation{}, fmt.Errorf("invalid data in TLSA association %q: %v", s, err)
+	}
+	a := TLSAAssociation{Usage: params[0], Selector: params[1], MatchingType: params[2], Data: data}
+	return a, a.validate()
+}
+
+func (a TLSAAssociation) validate() error {
+	if a.Usage > tlsaUsageDANEEE {
+		return fmt.Errorf("unknown TLSA certificate usage %d", a.Usage)
+	}
+	if a.Selector > tlsaSelectorSPKI {
+		return fmt.Errorf("unknown TLSA selector %d", a.Selector)
+	}
+	if a.MatchingType > tlsaMatchingSHA512 {
+		return fmt.Errorf("unknown TLSA matching type %d", a.MatchingType)
+	}
+	return nil
+}
+
+// String returns the association in presentation format.
+func (a TLSAAssociation) String() string {
+	return fmt.Sprintf("%d %d %d %s", a.Usage, a.Selector, a.MatchingType, hex.EncodeToString(a.Data))
+}
+
+// compute returns the association with a's parameters for cert.
+func (a TLSAAssociation) compute(cert *x509.Certificate) TLSAAssociation {
+	data := cert.Raw
+	if a.Selector == tlsaSelectorSPKI {
+		data = cert.RawSubjectPublicKeyInfo
+	}
+	switch a.MatchingType {
+	case tlsaMatchingSHA256:
+		digest := sha256.Sum256(data)
+		data = digest[:]
+	case tlsaMatchingSHA512:
+		digest := sha512.Sum512(data)
+		data = digest[:]
+	}
+	a.Data = data
+	return a
+}
+
+// candidates returns the certificates in state's chain that a's usage can
+// match: the leaf for end-entity usages, and the rest of the chain for trust
+// anchor usages.
+func (a TLSAAssociation) candidates(state tls.ConnectionState) []*x509.Certificate {
+	if a.Usage == tlsaUsagePKIXEE || a.Usage == tlsaUsageDANEEE {
+		return state.PeerCertificates[:1]
+	}
+	return state.PeerCertificates[1:]
+}
+
+// errTLSAMismatch is returned by verify when no certificate in the chain
+// matches the association.
+var errTLSAMismatch = errors.New("no certificate in the chain matches the association")
+
+// verify checks that state's chain matches a. With trust anchor usages, the
+// leaf must also chain to the matching certificate at now, and be valid for
+// one of names: the TLSA base domain or the next-hop domain (RFC 7672, section
+// 3.2.3). Returns errTLSAMismatch if no certificate matches at all.
+func (a TLSAAssociation) verify(state tls.ConnectionState, names []string, now time.Time) error {
+	err := errTLSAMismatch
+	for _, cert := range a.candidates(state) {
+		if !bytes.Equal(a.compute(cert).Data, a.Data) {
+			continue
+		}
+		if a.Usage == tlsaUsagePKIXEE || a.Usage == tlsaUsageDANEEE {
+			return nil
+		}
+		if err = verifyWithAnchor(state, cert, now); err == nil {
+			break
+		}
+	}
+	if err != nil || a.Usage == tlsaUsagePKIXEE || a.Usage == tlsaUsageDANEEE {
+		return err
+	}
+	for _, name := range names {
+		if name != "" && matchCertName(state.PeerCertificates[0], name) != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("the certificate isn't valid for %s", strings.Join(names, " or "))
+}
+
+// verifyWithAnchor checks that the leaf of state's chain chains to anchor at
+// now, through the other certificates in the chain.
+func verifyWithAnchor(state tls.ConnectionState, anchor *x509.Certificate, now time.Time) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(anchor)
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("the certificate doesn't chain to the matching trust anchor: %v", err)
+	}
+	return nil
+}
+
+// checkExpectedTLSA checks that the certificate chain presented by each of
+// hostnames matches at least one of expected, as it would need to if expected
+// were published as the hostname's TLSA records. DNS isn't queried.
+// On a mismatch, the association computed from the chain is reported
+// alongside each expected one: for the leaf with end-entity usages, and for
+// the top of the chain with trust anchor usages.
+func checkExpectedTLSA(hostnameResults map[string]HostnameResult, hostnames []string, expected []TLSAAssociation) *Result {
+	result := MakeResult(ExpectedTLSA)
+	sorted := append([]string{}, hostnames...)
+	sort.Strings(sorted)
+	for _, hostname := range sorted {
+		h := hostnameResults[hostname]
+		if !h.couldSTARTTLS() {
+			result.Failure("Couldn't negotiate TLS with %s, so it can't match the expected TLSA associations.", hostname)
+			continue
+		}
+		if h.tlsState == nil || len(h.tlsState.PeerCertificates) == 0 {
+			// e.g. if the result was read from a cache.
+			result.Error("Couldn't check %s against the expected TLSA associations, since its certificate chain isn't available.", hostname)
+			continue
+		}
+		state := *h.tlsState
+		names := []string{hostname, h.Domain}
+		errs := make([]error, len(expected))
+		matched := false
+		for i, a := range expected {
+			if errs[i] = a.verify(state, names, h.Timestamp); errs[i] == nil {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		for i, a := range expected {
+			if errs[i] != errTLSAMismatch {
+				result.Failure("Certificate chain presented by %s matches the expected TLSA association %s, but %v.", hostname, a, errs[i])
+				continue
+			}
+			cert := state.PeerCertificates[0]
+			if a.Usage == tlsaUsagePKIXTA || a.Usage == tlsaUsageDANETA {
+				cert = chainTop(state)
+			}
+			result.Failure("Certificate chain presented by %s doesn't match the expected TLSA association: computed %s, expected %s.",
+				hostname, a.compute(cert), a)
+		}
+	}
+	return result.Success()
+}
@@ -0,0 +1,112 @@
+package checker
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTLSAAssociation(t *testing.T) {
+	a, err := ParseTLSAAssociation("3 1 1 0c72ac70b745ac19 998811b131d662c9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Usage != 3 || a.Selector != 1 || a.MatchingType != 1 || hex.EncodeToString(a.Data) != "0c72ac70b745ac19998811b131d662c9" {
+		t.Errorf("Unexpected association %v", a)
+	}
+	if a.String() != "3 1 1 0c72ac70b745ac19998811b131d662c9" {
+		t.Errorf("Unexpected presentation format %q", a.String())
+	}
+	for _, invalid := range []string{"3 1 1", "3 1 x 00", "3 1 1 0g", "4 1 1 00", "3 2 1 00", "3 1 3 00"} {
+		if _, err := ParseTLSAAssociation(invalid); err == nil {
+			t.Errorf("Expected TLSA association %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestCheckExpectedTLSA(t *testing.T) {
+	now := time.Now()
+	root := issueTestCert(t, "Test Root", true, now.Add(-time.Hour), now.Add(time.Hour), nil)
+	leaf := issueTestCert(t, "mx.example.com", false, now.Add(-time.Hour), now.Add(time.Hour), root)
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf.cert, root.cert}}
+	leafSPKI := sha256.Sum256(leaf.cert.RawSubjectPublicKeyInfo)
+	rootCert := sha256.Sum256(root.cert.Raw)
+	starttls := MakeResult("hostnames")
+	starttls.addCheck(MakeResult(STARTTLS).Success())
+	hostnameResults := map[string]HostnameResult{
+		"mx.example.com":     {Result: starttls, tlsState: &state},
+		"cached.example.com": {Result: starttls},
+	}
+
+	tests := []struct {
+		expected TLSAAssociation
+		status   Status
+	}{
+		{TLSAAssociation{Usage: 3, Selector: 1, MatchingType: 1, Data: leafSPKI[:]}, Success},
+		{TLSAAssociation{Usage: 2, Selector: 0, MatchingType: 1, Data: rootCert[:]}, Success},
+		{TLSAAssociation{Usage: 3, Selector: 0, MatchingType: 0, Data: leaf.cert.Raw}, Success},
+		// The root's digest doesn't match the leaf.
+		{TLSAAssociation{Usage: 3, Selector: 0, MatchingType: 1, Data: rootCert[:]}, Failure},
+	}
+	for _, test := range tests {
+		result := checkExpectedTLSA(hostnameResults, []string{"mx.example.com"}, []TLSAAssociation{test.expected})
+		if result.Status != test.status {
+			t.Errorf("Expected %s to result in status %d, got %v", test.expected, test.status, result)
+		}
+	}
+
+	expected := TLSAAssociation{Usage: 3, Selector: 1, MatchingType: 1, Data: rootCert[:]}
+	result := checkExpectedTLSA(hostnameResults, []string{"mx.example.com"}, []TLSAAssociation{expected})
+	messages := strings.Join(result.Messages, "\n")
+	if !strings.Contains(messages, "computed 3 1 1 "+hex.EncodeToString(leafSPKI[:])) || !strings.Contains(messages, "expected "+expected.String()) {
+		t.Errorf("Expected mismatch to report the computed and expected associations, got %s", messages)
+	}
+
+	result = checkExpectedTLSA(hostnameResults, []string{"cached.example.com"}, []TLSAAssociation{expected})
+	if result.Status != Error {
+		t.Errorf("Expected a hostname without a certificate chain to result in an error, got %v", result)
+	}
+}
+
+func TestCheckDomainOptionsExpectedTLSA(t *testing.T) {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := smtpListenAndServe(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	digest := sha256.Sum256(cert.Certificate[0])
+
+	c := Checker{
+		Timeout:              testTimeout,
+		lookupMXOverride:     func(string) ([]*net.MX, error) { return []*net.MX{{Host: "localhost"}}, nil },
+		lookupHostOverride:   func(string) ([]string, error) { return []string{"127.0.0.1"}, nil },
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+		lookupTLSAOverride:   mockLookupTLSA,
+	}
+	c.Port, _ = strconv.Atoi(port)
+	opts := DomainOptions{ExpectedTLSA: []TLSAAssociation{{Usage: 3, Selector: 0, MatchingType: 1, Data: digest[:]}}}
+	result := c.CheckDomainOptions(context.Background(), "example.com", opts)
+	if r := result.ExtraResults[ExpectedTLSA]; r == nil || r.Status != Success {
+		t.Errorf("Expected the certificate to match the expected TLSA association, got %v", r)
+	}
+
+	result = c.CheckDomainOptions(context.Background(), "example.com", DomainOptions{})
+	if _, ok := result.ExtraResults[ExpectedTLSA]; ok {
+		t.Errorf("Expected no ExpectedTLSA check without expected associations")
+	}
+	result = c.CheckDomainOptions(context.Background(), "example.com", DomainOptions{ExpectedTLSA: []TLSAAssociation{{Usage: 4}}})
+	if result.Status != DomainError {
+		t.Errorf("Expected an invalid association to result in an error, got %v", result.Status)
+	}
+}
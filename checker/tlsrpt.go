@@ -0,0 +1,80 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// checkTLSRPT checks the TLS reporting (RFC 8460) TXT record of domain, at
+// _smtp._tls.<domain>, which tells senders where to report failures to
+// negotiate TLS with the domain's mailservers, e.g. under MTA-STS or DANE.
+// Not publishing one is only noted.
+func (c *Checker) checkTLSRPT(ctx context.Context, domain string) *Result {
+	result := MakeResult(TLSRPT)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	name := fmt.Sprintf("_smtp._tls.%s", domain)
+	records, err := c.lookupTXT(ctx, name)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		records, err = nil, nil
+	}
+	if err != nil && (expired(ctx) || isTimeout(err)) {
+		return result.Error("Timed out looking up the TLS-RPT TXT record.")
+	}
+	if err != nil {
+		return result.Error("Couldn't look up the TLS-RPT TXT record at %s: %v.", name, err)
+	}
+	return validateTLSRPTRecord(name, records, result)
+}
+
+func validateTLSRPTRecord(name string, records []string, result *Result) *Result {
+	records = filterByPrefix(records, "v=TLSRPTv1")
+	if len(records) == 0 {
+		return result.Info("No TLS-RPT TXT record found at %s, so senders won't report failures to negotiate TLS with your mailservers.", name)
+	}
+	if len(records) != 1 {
+		return result.Failure("Exactly 1 TLS-RPT TXT record required, found %d.", len(records))
+	}
+	// URIs may contain "=", so fields are only split at the first one.
+	var rua string
+	for _, field := range strings.Split(records[0], ";") {
+		pair := strings.SplitN(field, "=", 2)
+		if len(pair) == 2 && strings.TrimSpace(pair[0]) == "rua" {
+			rua = strings.TrimSpace(pair[1])
+		}
+	}
+	if rua == "" {
+		return result.Failure("TLS-RPT TXT record must specify rua, the URIs to send reports to.")
+	}
+	invalid := []string{}
+	for _, uri := range strings.Split(rua, ",") {
+		if uri = strings.TrimSpace(uri); !validTLSRPTURI(uri) {
+			invalid = append(invalid, uri)
+		}
+	}
+	if len(invalid) > 0 {
+		return result.Failure("These rua URIs in your TLS-RPT TXT record aren't valid: %s. Each must be a mailto: or https: URI.",
+			strings.Join(invalid, ", "))
+	}
+	return result.Success()
+}
+
+// validTLSRPTURI returns whether uri is a valid rua URI: a mailto: URI with an
+// email address, or an https: URI (RFC 8460, section 3).
+func validTLSRPTURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "mailto":
+		at := strings.LastIndex(u.Opaque, "@")
+		return at > 0 && at < len(u.Opaque)-1
+	case "https":
+		return u.Host != ""
+	}
+	return false
+}
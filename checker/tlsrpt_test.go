@@ -0,0 +1,73 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestValidateTLSRPTRecord(t *testing.T) {
+	tests := []struct {
+		records []string
+		status  Status
+	}{
+		{[]string{"v=TLSRPTv1; rua=mailto:tlsrpt@example.com"}, Success},
+		{[]string{"v=TLSRPTv1;rua=mailto:tlsrpt@example.com,https://reports.example.com/v1?domain=example.com"}, Success},
+		{[]string{"v=spf1 -all", "v=TLSRPTv1; rua=https://reports.example.com"}, Success},
+		{[]string{}, Info},
+		{[]string{"v=spf1 -all"}, Info},
+		{[]string{"v=TLSRPTv1; rua=mailto:a@example.com", "v=TLSRPTv1; rua=mailto:b@example.com"}, Failure},
+		{[]string{"v=TLSRPTv1"}, Failure},
+		{[]string{"v=TLSRPTv1; rua=tlsrpt@example.com"}, Failure},
+		{[]string{"v=TLSRPTv1; rua=http://reports.example.com"}, Failure},
+		{[]string{"v=TLSRPTv1; rua=mailto:example.com"}, Failure},
+		{[]string{"v=TLSRPTv1; rua=mailto:a@example.com,https://"}, Failure},
+	}
+	for _, test := range tests {
+		result := validateTLSRPTRecord("_smtp._tls.example.com", test.records, MakeResult(TLSRPT))
+		if result.Status != test.status {
+			t.Errorf("validateTLSRPTRecord(%q) = %v, want status %s", test.records, result, statusText[test.status])
+		}
+	}
+}
+
+func TestCheckTLSRPT(t *testing.T) {
+	var lookups []string
+	records := map[string][]string{"_smtp._tls.domain": {"v=TLSRPTv1; rua=mailto:tlsrpt@domain"}}
+	c := Checker{
+		lookupTXTOverride: func(name string) ([]string, error) {
+			lookups = append(lookups, name)
+			if name == "_smtp._tls.error" {
+				return nil, errors.New("SERVFAIL")
+			}
+			if r, ok := records[name]; ok {
+				return r, nil
+			}
+			return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+		},
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	result := c.CheckDomain("domain", nil)
+	if r := result.ExtraResults[TLSRPT]; r == nil || r.Status != Success {
+		t.Errorf("Expected a valid TLS-RPT record, got %v", r)
+	}
+	if len(lookups) != 1 || lookups[0] != "_smtp._tls.domain" {
+		t.Errorf("Expected the TLS-RPT record to be looked up at _smtp._tls.domain, got %v", lookups)
+	}
+	if r := c.checkTLSRPT(context.Background(), "nostarttls"); r.Status != Info {
+		t.Errorf("Expected a missing TLS-RPT record to be noted, got %v", r)
+	}
+	if r := c.checkTLSRPT(context.Background(), "error"); r.Status != Error {
+		t.Errorf("Expected a failed lookup to result in an error, got %v", r)
+	}
+
+	c.QuickMode = true
+	if _, ok := c.CheckDomain("domain", nil).ExtraResults[TLSRPT]; ok {
+		t.Error("Expected no TLS-RPT check in QuickMode")
+	}
+}
@@ -1,12 +1,21 @@
 package checker
 
 import (
+	"bufio"
+	"context"
 	"encoding/csv"
+	"encoding/json"
+	"fmt"
 	"io"
-	"log"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/net/idna"
 )
 
 // AggregatedScan compiles aggregated stats across domains.
@@ -20,6 +29,170 @@ type AggregatedScan struct {
 	MTASTSTestingList []string
 	MTASTSEnforce     int
 	MTASTSEnforceList []string
+	// MTASTSNoneCount counts domains whose MTA-STS policy is in "none" mode,
+	// having deliberately opted out of MTA-STS.
+	MTASTSNoneCount int `json:",omitempty"`
+	// TLSVersionCounts counts domains by the oldest TLS version negotiated by
+	// any of their mailservers, e.g. {"TLSv1.2": 3}.
+	TLSVersionCounts map[string]int `json:",omitempty"`
+	// TLS13SupportCount counts domains any of whose mailservers negotiated
+	// TLS 1.3 (see PercentTLS13). TLSVersionCounts counts those all of whose
+	// mailservers did.
+	TLS13SupportCount int `json:",omitempty"`
+	// SCTCount counts domains any of whose mailservers presented a
+	// certificate with signed certificate timestamps, so it's been logged for
+	// Certificate Transparency (see PercentSCT).
+	SCTCount int `json:",omitempty"`
+	// IssuerCounts counts domains by the issuers of their mailservers'
+	// certificates. Once there are maxIssuers distinct issuers, the rest are
+	// counted as OtherIssuer.
+	IssuerCounts map[string]int `json:",omitempty"`
+	// NoMXCount counts domains without MX records, which are assumed not to
+	// be email domains.
+	NoMXCount int `json:",omitempty"`
+	// ScoreTotal is the sum of the scores of domains with MX records (see
+	// DomainResult.Score and AverageScore).
+	ScoreTotal int `json:",omitempty"`
+	// ConnectionFailureCounts counts mailservers that couldn't be connected to
+	// by the reason, e.g. {"refused": 2, "timeout": 1}.
+	ConnectionFailureCounts map[ConnectionFailure]int `json:",omitempty"`
+	// FailureReasons counts domains by the reasons their mailservers failed,
+	// e.g. {"hostname_mismatch": 4, "no_starttls": 2}. A domain is counted once
+	// for each different reason. See FailureUnreachable, FailureNoSTARTTLS and
+	// CertificateFailure.
+	FailureReasons map[string]int `json:",omitempty"`
+	// WithMXsUnreachable counts domains with MX records none of whose
+	// mailservers could be connected to. They're included in WithMXs.
+	WithMXsUnreachable int `json:",omitempty"`
+	// STARTTLSSupportCount counts domains every one of whose reachable
+	// mailservers supports STARTTLS (or any, with STARTTLSAnyMX).
+	STARTTLSSupportCount int `json:",omitempty"`
+	// STARTTLSSupportList lists the domains counted by STARTTLSSupportCount,
+	// if ListSTARTTLSSupport is set.
+	STARTTLSSupportList []string `json:",omitempty"`
+
+	// STARTTLSAnyMX specifies whether domains are counted by
+	// STARTTLSSupportCount if any of their reachable mailservers supports
+	// STARTTLS, rather than every one.
+	STARTTLSAnyMX bool `json:"-"`
+
+	// ListSTARTTLSSupport specifies whether the domains supporting STARTTLS
+	// are listed in STARTTLSSupportList. The list can be very long.
+	ListSTARTTLSSupport bool `json:"-"`
+
+	// ExcludeNoMX specifies whether domains without MX records are left out
+	// of Attempted, as well as the counts of domains with MXs. They are counted
+	// by NoMXCount either way. Percentages like PercentMTASTS are always of
+	// WithMXs, so they aren't affected.
+	ExcludeNoMX bool `json:"-"`
+
+	// OnlySource specifies whether only domain results whose Source matches
+	// the aggregated scan's Source are counted. The rest are ignored.
+	OnlySource bool `json:"-"`
+
+	// Logger specifies where progress is logged while domains are handled.
+	// If nil, the standard logger is used. Use NopLogger to silence it.
+	Logger Logger `json:"-"`
+
+	// ProgressInterval specifies how many domains are handled between each
+	// progress log. If zero or negative, progress isn't logged.
+	// MakeAggregatedScan sets it to DefaultProgressInterval.
+	ProgressInterval int `json:"-"`
+
+	// Verbose specifies whether progress logs include the lists of domains
+	// supporting MTA-STS, which can be very long.
+	Verbose bool `json:"-"`
+
+	// mu holds the *sync.Mutex guarding the counts while domains are handled,
+	// so that HandleDomain and Snapshot can be called concurrently. It's
+	// created on first use (see lock), and holds a pointer so that
+	// AggregatedScans can still be copied.
+	mu atomic.Value
+}
+
+// lock returns a's mutex, creating it if needed.
+func (a *AggregatedScan) lock() *sync.Mutex {
+	if mu, ok := a.mu.Load().(*sync.Mutex); ok {
+		return mu
+	}
+	a.mu.CompareAndSwap(nil, &sync.Mutex{})
+	return a.mu.Load().(*sync.Mutex)
+}
+
+// Snapshot returns a copy of the stats aggregated so far, which is safe to
+// call while another goroutine is handling domains, e.g. to report the
+// progress of a long scan.
+func (a *AggregatedScan) Snapshot() AggregatedScan {
+	mu := a.lock()
+	mu.Lock()
+	defer mu.Unlock()
+	snapshot := *a
+	snapshot.mu = atomic.Value{}
+	snapshot.MTASTSTestingList = append([]string(nil), a.MTASTSTestingList...)
+	snapshot.MTASTSEnforceList = append([]string(nil), a.MTASTSEnforceList...)
+	snapshot.STARTTLSSupportList = append([]string(nil), a.STARTTLSSupportList...)
+	snapshot.TLSVersionCounts = copyCounts(a.TLSVersionCounts)
+	snapshot.IssuerCounts = copyCounts(a.IssuerCounts)
+	snapshot.FailureReasons = copyCounts(a.FailureReasons)
+	if a.ConnectionFailureCounts != nil {
+		snapshot.ConnectionFailureCounts = make(map[ConnectionFailure]int, len(a.ConnectionFailureCounts))
+		for failure, count := range a.ConnectionFailureCounts {
+			snapshot.ConnectionFailureCounts[failure] = count
+		}
+	}
+	return snapshot
+}
+
+func copyCounts(counts map[string]int) map[string]int {
+	if counts == nil {
+		return nil
+	}
+	copied := make(map[string]int, len(counts))
+	for key, count := range counts {
+		copied[key] = count
+	}
+	return copied
+}
+
+// MarshalJSON writes AggregatedScan to JSON with the lists of domains sorted,
+// so that the output doesn't depend on the order domains were handled in.
+// (Maps, like the counts, are always written with sorted keys.)
+func (a AggregatedScan) MarshalJSON() ([]byte, error) {
+	// FakeAggregatedScan lets us access the default json.Marshal result.
+	type FakeAggregatedScan AggregatedScan
+	fake := FakeAggregatedScan(a)
+	fake.MTASTSTestingList = sortedCopy(a.MTASTSTestingList)
+	fake.MTASTSEnforceList = sortedCopy(a.MTASTSEnforceList)
+	fake.STARTTLSSupportList = sortedCopy(a.STARTTLSSupportList)
+	return json.Marshal(fake)
+}
+
+func sortedCopy(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	sorted := append([]string{}, list...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// DefaultProgressInterval is the ProgressInterval set by MakeAggregatedScan.
+const DefaultProgressInterval = 1000
+
+// maxIssuers caps the number of distinct issuers in IssuerCounts.
+const maxIssuers = 100
+
+// OtherIssuer buckets the long tail of certificate issuers in IssuerCounts.
+const OtherIssuer = "Other"
+
+// MakeAggregatedScan constructs an AggregatedScan of domains from source,
+// which logs its progress every DefaultProgressInterval domains.
+func MakeAggregatedScan(source string, t time.Time) *AggregatedScan {
+	return &AggregatedScan{
+		Time:             t,
+		Source:           source,
+		ProgressInterval: DefaultProgressInterval,
+	}
 }
 
 const (
@@ -34,8 +207,9 @@ func (a AggregatedScan) TotalMTASTS() int {
 	return a.MTASTSTesting + a.MTASTSEnforce
 }
 
-// PercentMTASTS returns the fraction of domains with MXs that support
-// MTA-STS, represented as a float between 0 and 1.
+// PercentMTASTS returns the percentage of domains with MXs that support
+// MTA-STS. Its denominator is WithMXs rather than Attempted, so domains
+// without MXs don't affect it, regardless of ExcludeNoMX.
 func (a AggregatedScan) PercentMTASTS() float64 {
 	if a.WithMXs == 0 {
 		return 0
@@ -43,21 +217,136 @@ func (a AggregatedScan) PercentMTASTS() float64 {
 	return 100 * float64(a.TotalMTASTS()) / float64(a.WithMXs)
 }
 
+// PercentSTARTTLS returns the percentage of domains with MXs that support
+// STARTTLS (see STARTTLSSupportCount). Like PercentMTASTS, its denominator is
+// WithMXs.
+func (a AggregatedScan) PercentSTARTTLS() float64 {
+	if a.WithMXs == 0 {
+		return 0
+	}
+	return 100 * float64(a.STARTTLSSupportCount) / float64(a.WithMXs)
+}
+
+// PercentTLS13 returns the percentage of domains with MXs that support TLS 1.3
+// (see TLS13SupportCount). Like PercentMTASTS, its denominator is WithMXs.
+func (a AggregatedScan) PercentTLS13() float64 {
+	if a.WithMXs == 0 {
+		return 0
+	}
+	return 100 * float64(a.TLS13SupportCount) / float64(a.WithMXs)
+}
+
+// PercentSCT returns the percentage of domains with MXs whose certificates
+// have SCTs (see SCTCount). Like PercentMTASTS, its denominator is WithMXs.
+func (a AggregatedScan) PercentSCT() float64 {
+	if a.WithMXs == 0 {
+		return 0
+	}
+	return 100 * float64(a.SCTCount) / float64(a.WithMXs)
+}
+
+// AverageScore returns the average score of domains with MX records.
+func (a AggregatedScan) AverageScore() float64 {
+	if a.WithMXs == 0 {
+		return 0
+	}
+	return float64(a.ScoreTotal) / float64(a.WithMXs)
+}
+
+// PercentUnreachable returns the percentage of domains with MXs none of
+// whose mailservers could be connected to.
+func (a AggregatedScan) PercentUnreachable() float64 {
+	if a.WithMXs == 0 {
+		return 0
+	}
+	return 100 * float64(a.WithMXsUnreachable) / float64(a.WithMXs)
+}
+
 // HandleDomain adds the result of a single domain scan to aggregated stats.
+// If a.OnlySource is set, results from other sources are ignored.
+// It may be called concurrently, including with Snapshot.
 func (a *AggregatedScan) HandleDomain(r DomainResult) {
-	a.Attempted++
+	if a.OnlySource && r.Source != a.Source {
+		return
+	}
+	mu := a.lock()
+	mu.Lock()
+	attempted, ok := a.add(r)
+	mu.Unlock()
+	if !ok {
+		return
+	}
 	// Show progress.
-	if a.Attempted%1000 == 0 {
-		log.Printf("\n%v\n", a)
-		log.Println(a.MTASTSTestingList)
-		log.Println(a.MTASTSEnforceList)
+	if interval := a.ProgressInterval; interval > 0 && attempted%interval == 0 {
+		snapshot := a.Snapshot()
+		logger := loggerOrDefault(a.Logger)
+		logger.Printf("\n%v\n", &snapshot)
+		if a.Verbose {
+			logger.Printf("%v", snapshot.MTASTSTestingList)
+			logger.Printf("%v", snapshot.MTASTSEnforceList)
+		}
 	}
+}
 
+// add counts r in the aggregated stats, and returns the number of domains
+// attempted so far. Returns false if r isn't counted as attempted (see
+// ExcludeNoMX). a.mu must be held.
+func (a *AggregatedScan) add(r DomainResult) (int, bool) {
 	if len(r.HostnameResults) == 0 {
 		// No MX records - assume this isn't an email domain.
-		return
+		a.NoMXCount++
+		if a.ExcludeNoMX {
+			return a.Attempted, false
+		}
+	}
+	a.Attempted++
+	if len(r.HostnameResults) == 0 {
+		return a.Attempted, true
 	}
 	a.WithMXs++
+	if !r.Reachable() {
+		a.WithMXsUnreachable++
+	}
+	if r.supportsSTARTTLS(a.STARTTLSAnyMX) {
+		a.STARTTLSSupportCount++
+		if a.ListSTARTTLSSupport {
+			a.STARTTLSSupportList = append(a.STARTTLSSupportList, r.Domain)
+		}
+	}
+	a.ScoreTotal += r.Score()
+	if version := r.worstTLSVersion(); version != 0 {
+		if a.TLSVersionCounts == nil {
+			a.TLSVersionCounts = make(map[string]int)
+		}
+		a.TLSVersionCounts[TLSVersionName(version)]++
+	}
+	if r.supportsTLS13() {
+		a.TLS13SupportCount++
+	}
+	if r.hasSCTs() {
+		a.SCTCount++
+	}
+	for _, issuer := range r.certificateIssuers() {
+		if a.IssuerCounts == nil {
+			a.IssuerCounts = make(map[string]int)
+		}
+		if _, ok := a.IssuerCounts[issuer]; !ok && len(a.IssuerCounts) >= maxIssuers {
+			issuer = OtherIssuer
+		}
+		a.IssuerCounts[issuer]++
+	}
+	for _, reason := range r.failureReasons() {
+		if a.FailureReasons == nil {
+			a.FailureReasons = make(map[string]int)
+		}
+		a.FailureReasons[reason]++
+	}
+	for _, failure := range r.connectionFailures() {
+		if a.ConnectionFailureCounts == nil {
+			a.ConnectionFailureCounts = make(map[ConnectionFailure]int)
+		}
+		a.ConnectionFailureCounts[failure]++
+	}
 	if r.MTASTSResult != nil {
 		switch r.MTASTSResult.Mode {
 		case "enforce":
@@ -66,8 +355,36 @@ func (a *AggregatedScan) HandleDomain(r DomainResult) {
 		case "testing":
 			a.MTASTSTesting++
 			a.MTASTSTestingList = append(a.MTASTSTestingList, r.Domain)
+		case "none":
+			a.MTASTSNoneCount++
 		}
 	}
+	return a.Attempted, true
+}
+
+// IssuerCount is the number of domains with a certificate from Issuer.
+type IssuerCount struct {
+	Issuer string
+	Count  int
+}
+
+// TopIssuers returns the n issuers counted for the most domains, from most to
+// least common.
+func (a AggregatedScan) TopIssuers(n int) []IssuerCount {
+	issuers := make([]IssuerCount, 0, len(a.IssuerCounts))
+	for issuer, count := range a.IssuerCounts {
+		issuers = append(issuers, IssuerCount{issuer, count})
+	}
+	sort.Slice(issuers, func(i, j int) bool {
+		if issuers[i].Count != issuers[j].Count {
+			return issuers[i].Count > issuers[j].Count
+		}
+		return issuers[i].Issuer < issuers[j].Issuer
+	})
+	if n < len(issuers) {
+		issuers = issuers[:n]
+	}
+	return issuers
 }
 
 // ResultHandler processes domain results.
@@ -76,42 +393,275 @@ type ResultHandler interface {
 	HandleDomain(DomainResult)
 }
 
+type multiHandler []ResultHandler
+
+// MultiHandler returns a ResultHandler that passes each result to every one of
+// handlers, in the order given. A handler finishes with each result before the
+// next handler receives it, and with every result before the following result
+// is handled, so handlers don't need to be safe for concurrent use. In turn, a
+// slow handler holds up the others and, in CheckCSV, the whole scan.
+func MultiHandler(handlers ...ResultHandler) ResultHandler {
+	return multiHandler(handlers)
+}
+
+func (m multiHandler) HandleDomain(r DomainResult) {
+	for _, handler := range m {
+		handler.HandleDomain(r)
+	}
+}
+
 const defaultPoolSize = 16
 
-// CheckCSV runs the checker on a csv of domains, processing the results according
-// to resultHandler.
-func (c *Checker) CheckCSV(domains *csv.Reader, resultHandler ResultHandler, domainColumn int) {
-	poolSize, err := strconv.Atoi(os.Getenv("CONNECTION_POOL_SIZE"))
-	if err != nil || poolSize <= 0 {
-		poolSize = defaultPoolSize
+// ScanSummary summarizes a run of CheckCSV or CheckList, whatever its
+// ResultHandler.
+type ScanSummary struct {
+	// Processed counts the domains whose results were handled.
+	Processed int
+	// Errors counts the domains whose results have status DomainError.
+	Errors int
+	// Skipped counts the input rows that were skipped because they were
+	// malformed.
+	Skipped int
+	// Duration is how long the run took.
+	Duration time.Duration
+}
+
+// Throughput returns the number of domains processed per second.
+func (s ScanSummary) Throughput() float64 {
+	if s.Duration <= 0 {
+		return 0
 	}
-	work := make(chan string)
-	results := make(chan DomainResult)
+	return float64(s.Processed) / s.Duration.Seconds()
+}
+
+func (s ScanSummary) String() string {
+	return fmt.Sprintf("processed %d domains (%d errors, %d malformed rows skipped) in %s, %.1f domains/s",
+		s.Processed, s.Errors, s.Skipped, s.Duration.Round(time.Millisecond), s.Throughput())
+}
 
+// CheckCSV runs the checker on a csv of domains, processing the results according
+// to resultHandler, and returns a summary of the run. Malformed records, and
+// records without domainColumn, are skipped and logged to the Checker's
+// Logger. Reading stops at the first error that isn't due to a malformed
+// record. Domains that c.Include or c.Exclude filter out are skipped without a
+// result. If the Checker has a Checkpoint, domains it has completed are
+// skipped, and each domain is recorded once its result has been handled.
+//
+// A domain may be followed by a port, as in "example.com:2525", to check its
+// mailservers on that port rather than c.Port. Its results aren't cached, since
+// the caches don't distinguish ports. A domain with an invalid port gets a
+// DomainError result.
+func (c *Checker) CheckCSV(domains *csv.Reader, resultHandler ResultHandler, domainColumn int) ScanSummary {
+	skipped := 0
+	work := make(chan string)
 	go func() {
 		for {
 			data, err := domains.Read()
+			if _, ok := err.(*csv.ParseError); ok {
+				c.logger().Printf("Skipping malformed CSV record: %v", err)
+				skipped++
+				continue
+			}
+			if err == nil && len(data) <= domainColumn {
+				c.logger().Printf("Skipping CSV record without column %d: %q", domainColumn, data)
+				skipped++
+				continue
+			}
 			if err != nil {
 				if err != io.EOF {
-					log.Println("Error reading CSV")
-					log.Fatal(err)
+					c.logger().Printf("Error reading CSV: %v", err)
 				}
 				break
 			}
-			if len(data) > 0 {
-				work <- data[domainColumn]
-			}
+			work <- data[domainColumn]
 		}
 		close(work)
 	}()
+	summary := c.RunPool(context.Background(), work, resultHandler)
+	// The reader has finished, since work is closed.
+	summary.Skipped = skipped
+	return summary
+}
+
+// CheckList performs CheckCSV on a list of domains, one per line. Whitespace
+// around domains is trimmed, and blank lines and lines starting with # are
+// skipped. If ctx expires, no more domains are read, and the checks in
+// progress are marked as timed out (see CheckDomainContext).
+func (c *Checker) CheckList(ctx context.Context, domains io.Reader, resultHandler ResultHandler) ScanSummary {
+	work := make(chan string)
+	go func() {
+		defer close(work)
+		scanner := bufio.NewScanner(domains)
+		for scanner.Scan() {
+			domain := strings.TrimSpace(scanner.Text())
+			if domain == "" || strings.HasPrefix(domain, "#") {
+				continue
+			}
+			select {
+			case work <- domain:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			c.logger().Printf("Error reading domain list: %v", err)
+		}
+	}()
+	return c.RunPool(ctx, work, resultHandler)
+}
+
+// CheckDomains performs CheckCSV on a list of domains. If ctx expires, no more
+// domains are checked, and the checks in progress are marked as timed out.
+func (c *Checker) CheckDomains(ctx context.Context, domains []string, resultHandler ResultHandler) ScanSummary {
+	work := make(chan string)
+	go func() {
+		defer close(work)
+		for _, domain := range domains {
+			select {
+			case work <- domain:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c.RunPool(ctx, work, resultHandler)
+}
+
+// splitDomainPort splits an entry in a list of domains into the domain and its
+// port, if it has one (see CheckCSV). The port is 0 if it doesn't.
+func splitDomainPort(entry string) (string, int, error) {
+	i := strings.LastIndex(entry, ":")
+	if i < 0 {
+		return entry, 0, nil
+	}
+	domain, portString := entry[:i], entry[i+1:]
+	port, err := strconv.Atoi(portString)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port %q for domain %s", portString, domain)
+	}
+	return domain, port, nil
+}
+
+// normalizeDomain lowercases domain and strips it of surrounding whitespace
+// and any trailing dot, and converts it to ASCII if it's internationalized, so
+// that it can be compared with other domains.
+func normalizeDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+		return ascii
+	}
+	return domain
+}
+
+// validateDomainPattern returns an error unless pattern is a domain, or a
+// domain following "*." (see Checker.Include).
+func validateDomainPattern(pattern string) error {
+	name := strings.TrimPrefix(pattern, "*.")
+	if name == "" || strings.Contains(name, "*") {
+		return fmt.Errorf("must be a domain, optionally preceded by \"*.\"")
+	}
+	_, err := idna.Lookup.ToASCII(name)
+	return err
+}
+
+// matchesDomainPattern returns whether the normalized domain matches any of
+// patterns, as described by Checker.Include.
+func matchesDomainPattern(domain string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(domain, "."+normalizeDomain(pattern[2:])) {
+				return true
+			}
+		} else if domain == normalizeDomain(pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// filtered returns whether domain is skipped because of c.Include or
+// c.Exclude.
+func (c *Checker) filtered(domain string) bool {
+	domain = normalizeDomain(domain)
+	if len(c.Include) > 0 && !matchesDomainPattern(domain, c.Include) {
+		return true
+	}
+	return matchesDomainPattern(domain, c.Exclude)
+}
+
+// withPort returns a copy of c which checks mailservers on port, without
+// c's caches of results. CRLs don't depend on the port, so the copy shares c's
+// CRL cache, which is created first so that it isn't copied while another
+// check creates it.
+func (c *Checker) withPort(port int) *Checker {
+	c.crlCache()
+	checker := *c
+	checker.Port = port
+	checker.Cache = nil
+	checker.DomainCache = nil
+	return &checker
+}
+
+// RunPool checks the domains received from work with a pool of workers (of
+// size CONNECTION_POOL_SIZE, from the environment), and handles each result
+// with resultHandler as it completes. Results are unordered: they needn't be
+// handled in the order the domains were received. resultHandler is only
+// called from RunPool's goroutine, one result at a time.
+//
+// It's the building block of CheckCSV, CheckList and CheckDomains, and can be
+// fed from other sources, e.g. a database cursor or a message queue. Entries
+// may have ports, domains are filtered by c.Include and c.Exclude, and
+// skipped and recorded with c.Checkpoint, as CheckCSV describes. The caller must close work once it has sent every
+// domain; RunPool returns after that, once every result has been handled. If
+// ctx expires, the checks in progress are marked as timed out, and no more
+// domains are received from work, so the caller should stop sending then too.
+// Timed out results aren't recorded with c.Checkpoint, so that a resumed scan
+// checks them again. The summary counts the results and errors (but not
+// skipped rows, which only the caller knows about).
+func (c *Checker) RunPool(ctx context.Context, work <-chan string, resultHandler ResultHandler) ScanSummary {
+	start := time.Now()
+	poolSize, err := strconv.Atoi(os.Getenv("CONNECTION_POOL_SIZE"))
+	if err != nil || poolSize <= 0 {
+		poolSize = defaultPoolSize
+	}
+	results := make(chan DomainResult)
 
 	done := make(chan struct{})
 	for i := 0; i < poolSize; i++ {
 		go func() {
-			for domain := range work {
-				results <- c.CheckDomain(domain, nil)
+			defer func() { done <- struct{}{} }()
+			for {
+				// Once ctx expires, stop even if work is ready too.
+				if expired(ctx) {
+					return
+				}
+				var entry string
+				var ok bool
+				select {
+				case entry, ok = <-work:
+				case <-ctx.Done():
+					return
+				}
+				if !ok {
+					return
+				}
+				domain, port, err := splitDomainPort(entry)
+				if err != nil {
+					results <- DomainResult{Domain: entry}.reportError(err)
+					continue
+				}
+				if c.filtered(domain) {
+					continue
+				}
+				if c.Checkpoint != nil && c.Checkpoint.Completed(domain) {
+					continue
+				}
+				checker := c
+				if port != 0 {
+					checker = c.withPort(port)
+				}
+				results <- checker.CheckDomainContext(ctx, domain, nil)
 			}
-			done <- struct{}{}
 		}()
 	}
 
@@ -123,7 +673,19 @@ func (c *Checker) CheckCSV(domains *csv.Reader, resultHandler ResultHandler, dom
 		close(results)
 	}()
 
+	var summary ScanSummary
 	for r := range results {
 		resultHandler.HandleDomain(r)
+		summary.Processed++
+		if r.Status == DomainError {
+			summary.Errors++
+		}
+		if c.Checkpoint != nil && !r.TimedOut {
+			if err := c.Checkpoint.record(r.Domain); err != nil {
+				c.logger().Printf("Error recording checkpoint for %s: %v", r.Domain, err)
+			}
+		}
 	}
+	summary.Duration = time.Since(start)
+	return summary
 }
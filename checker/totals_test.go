@@ -1,8 +1,16 @@
 package checker
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/csv"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -12,10 +20,12 @@ func TestCheckCSV(t *testing.T) {
 	reader := csv.NewReader(strings.NewReader(in))
 
 	c := Checker{
-		Cache:               MakeSimpleCache(10 * time.Minute),
-		lookupMXOverride:    mockLookupMX,
-		CheckHostname:       mockCheckHostname,
-		checkMTASTSOverride: mockCheckMTASTS,
+		Cache:                MakeSimpleCache(10 * time.Minute),
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
 	}
 	totals := AggregatedScan{}
 	c.CheckCSV(reader, &totals, 0)
@@ -30,3 +40,639 @@ func TestCheckCSV(t *testing.T) {
 		t.Errorf("Expected 5 domains in MTA-STS testing mode, got %d", len(totals.MTASTSTestingList))
 	}
 }
+
+func TestCheckCSVSummary(t *testing.T) {
+	in := "domain\n\"bad\"quote\ndomain.tld:70000\ntoo,many\nnostarttls\n"
+	c := Checker{
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	summary := c.CheckCSV(csv.NewReader(strings.NewReader(in)), &SliceHandler{}, 0)
+	if summary.Processed != 3 || summary.Errors != 1 || summary.Skipped != 2 {
+		t.Errorf("Expected 3 domains processed with 1 error and 2 rows skipped, got %v", summary)
+	}
+	if summary.Duration <= 0 || summary.Throughput() <= 0 {
+		t.Errorf("Expected the run to be timed, got %v", summary)
+	}
+}
+
+func TestCheckCSVPorts(t *testing.T) {
+	ln := smtpListenAndServe(t, &tls.Config{})
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	in := "a.example.com:" + port + "\nb.example.com:smtp\nc.example.com:70000\n"
+
+	c := Checker{
+		Timeout:              testTimeout,
+		lookupMXOverride:     func(string) ([]*net.MX, error) { return []*net.MX{{Host: "localhost"}}, nil },
+		lookupHostOverride:   func(string) ([]string, error) { return []string{"127.0.0.1"}, nil },
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	handler := SliceHandler{}
+	c.CheckCSV(csv.NewReader(strings.NewReader(in)), &handler, 0)
+	results := make(map[string]DomainResult)
+	for _, result := range handler.Results() {
+		results[result.Domain] = result
+	}
+	if r := results["a.example.com"]; !r.HostnameResults["localhost"].couldConnect() {
+		t.Errorf("Expected a.example.com to be checked on port %s, got %v", port, r.HostnameResults)
+	}
+	for _, entry := range []string{"b.example.com:smtp", "c.example.com:70000"} {
+		if r := results[entry]; r.Status != DomainError || !strings.Contains(r.Message, "invalid port") {
+			t.Errorf("Expected %s to get an error, got status %d and %q", entry, r.Status, r.Message)
+		}
+	}
+}
+
+func TestExcludeNoMX(t *testing.T) {
+	for _, exclude := range []bool{false, true} {
+		totals := AggregatedScan{ExcludeNoMX: exclude, Logger: NopLogger}
+		totals.HandleDomain(NewSampleDomainResult("example.com"))
+		totals.HandleDomain(DomainResult{Domain: "no-mx.example.com"})
+		totals.HandleDomain(DomainResult{Domain: "no-mx.example.org"})
+		attempted := 3
+		if exclude {
+			attempted = 1
+		}
+		if totals.Attempted != attempted || totals.WithMXs != 1 || totals.NoMXCount != 2 {
+			t.Errorf("ExcludeNoMX %t: expected %d attempted, 1 with MXs and 2 without, got %d, %d and %d",
+				exclude, attempted, totals.Attempted, totals.WithMXs, totals.NoMXCount)
+		}
+		if totals.PercentMTASTS() != 100 {
+			t.Errorf("ExcludeNoMX %t: expected 100%% MTA-STS support, got %v", exclude, totals.PercentMTASTS())
+		}
+	}
+}
+
+func TestCheckList(t *testing.T) {
+	in := "# Domains to check\n  domain  \n\ndomain.tld\n# nostarttls\nnostarttls\n"
+	c := Checker{
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	handler := SliceHandler{}
+	c.CheckList(context.Background(), strings.NewReader(in), &handler)
+
+	domains := []string{}
+	for _, result := range handler.Results() {
+		domains = append(domains, result.Domain)
+	}
+	sort.Strings(domains)
+	expected := []string{"domain", "domain.tld", "nostarttls"}
+	if !reflect.DeepEqual(domains, expected) {
+		t.Errorf("Expected domains %v to be checked, got %v", expected, domains)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	handler = SliceHandler{}
+	c.CheckList(ctx, strings.NewReader(in), &handler)
+	for _, result := range handler.Results() {
+		if !result.TimedOut {
+			t.Errorf("Expected %s to time out once the context is canceled", result.Domain)
+		}
+	}
+}
+
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func TestRunPool(t *testing.T) {
+	c := Checker{
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	work := make(chan string)
+	go func() {
+		for _, domain := range []string{"domain", "domain.tld", "nostarttls"} {
+			work <- domain
+		}
+		close(work)
+	}()
+	handler := SliceHandler{}
+	summary := c.RunPool(context.Background(), work, &handler)
+	if summary.Processed != 3 || len(handler.Results()) != 3 {
+		t.Errorf("Expected 3 domains to be handled, got %v and %d results", summary, len(handler.Results()))
+	}
+}
+
+func TestRunPoolStopsWhenContextExpires(t *testing.T) {
+	var recorded bytes.Buffer
+	checkpoint, err := MakeCheckpoint(nil, &recorded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := Checker{
+		Checkpoint:       checkpoint,
+		lookupMXOverride: mockLookupMX,
+		// The deadline expires while the first domain is being checked.
+		CheckHostname: func(domain string, hostname string, timeout time.Duration) HostnameResult {
+			cancel()
+			return mockCheckHostname(domain, hostname, timeout)
+		},
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	work := make(chan string, 3)
+	for _, domain := range []string{"domain", "domain.tld", "nostarttls"} {
+		work <- domain
+	}
+	t.Setenv("CONNECTION_POOL_SIZE", "1")
+	handler := SliceHandler{}
+	summary := c.RunPool(ctx, work, &handler)
+	if summary.Processed != 1 {
+		t.Errorf("Expected no more domains to be checked once ctx expired, got %d", summary.Processed)
+	}
+	if results := handler.Results(); len(results) != 1 || !results[0].TimedOut {
+		t.Fatalf("Expected a timed out result, got %v", results)
+	}
+	if recorded.Len() != 0 || checkpoint.Len() != 0 {
+		t.Errorf("Expected timed out domains not to be recorded, got %q", recorded.String())
+	}
+}
+
+func TestCheckDomains(t *testing.T) {
+	c := Checker{
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	handler := SliceHandler{}
+	c.CheckDomains(context.Background(), []string{"domain", "domain.tld"}, &handler)
+	domains := []string{}
+	for _, result := range handler.Results() {
+		domains = append(domains, result.Domain)
+	}
+	sort.Strings(domains)
+	if expected := []string{"domain", "domain.tld"}; !reflect.DeepEqual(domains, expected) {
+		t.Errorf("Expected domains %v to be checked, got %v", expected, domains)
+	}
+}
+
+func TestIncludeExclude(t *testing.T) {
+	tests := []struct {
+		include  []string
+		exclude  []string
+		expected []string
+	}{
+		{nil, nil, []string{"a.example.gov", "example.com", "example.gov", "mail.example.org"}},
+		{[]string{"*.gov"}, nil, []string{"a.example.gov", "example.gov"}},
+		{[]string{"*.GOV."}, []string{"a.example.gov"}, []string{"example.gov"}},
+		{[]string{"Example.com", "*.example.org"}, nil, []string{"example.com", "mail.example.org"}},
+		{nil, []string{"example.com", "*.example.gov"}, []string{"example.gov", "mail.example.org"}},
+	}
+	for _, test := range tests {
+		c := Checker{
+			Include:              test.include,
+			Exclude:              test.exclude,
+			lookupMXOverride:     mockLookupMX,
+			CheckHostname:        mockCheckHostname,
+			checkMTASTSOverride:  mockCheckMTASTS,
+			lookupCAAOverride:    mockLookupCAA,
+			lookupDNSSECOverride: mockLookupDNSSEC,
+		}
+		handler := SliceHandler{}
+		in := "example.com\nEXAMPLE.gov.\na.example.gov\nmail.example.org\n"
+		summary := c.CheckList(context.Background(), strings.NewReader(in), &handler)
+		domains := []string{}
+		for _, result := range handler.Results() {
+			domains = append(domains, normalizeDomain(result.Domain))
+		}
+		sort.Strings(domains)
+		if !reflect.DeepEqual(domains, test.expected) || summary.Processed != len(test.expected) {
+			t.Errorf("Include %v, exclude %v: expected domains %v to be checked, got %v", test.include, test.exclude, test.expected, domains)
+		}
+	}
+}
+
+func TestCheckCSVLogsReadErrors(t *testing.T) {
+	in := "domain\n\"unterminated\n"
+	reader := csv.NewReader(strings.NewReader(in))
+
+	logger := &recordingLogger{}
+	c := Checker{
+		Logger:               logger,
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	totals := AggregatedScan{Logger: NopLogger}
+	c.CheckCSV(reader, &totals, 0)
+
+	if totals.Attempted != 1 {
+		t.Errorf("Expected 1 attempted connection, got %d", totals.Attempted)
+	}
+	if len(logger.messages) != 1 || !strings.HasPrefix(logger.messages[0], "Skipping malformed CSV record") {
+		t.Errorf("Expected malformed CSV record to be logged, got %v", logger.messages)
+	}
+}
+
+func TestAggregatedScanProgressInterval(t *testing.T) {
+	tests := []struct {
+		interval int
+		verbose  bool
+		expected int
+	}{
+		{0, true, 0},
+		{DefaultProgressInterval, false, 1},
+		{DefaultProgressInterval, true, 3},
+		{250, false, 4},
+		{-1, true, 0},
+	}
+	for _, test := range tests {
+		logger := &recordingLogger{}
+		totals := AggregatedScan{Logger: logger, ProgressInterval: test.interval, Verbose: test.verbose}
+		for i := 0; i < 1000; i++ {
+			totals.HandleDomain(DomainResult{})
+		}
+		if len(logger.messages) != test.expected {
+			t.Errorf("Expected interval %d (verbose %t) to log %d messages, got %d",
+				test.interval, test.verbose, test.expected, len(logger.messages))
+		}
+	}
+}
+
+func TestMakeAggregatedScan(t *testing.T) {
+	now := time.Now()
+	totals := MakeAggregatedScan("source", now)
+	if totals.Source != "source" || !totals.Time.Equal(now) || totals.ProgressInterval != DefaultProgressInterval {
+		t.Errorf("Unexpected aggregated scan %+v", totals)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	totals := AggregatedScan{Logger: &recordingLogger{}, ProgressInterval: 10, Verbose: true}
+	var wg sync.WaitGroup
+	for w := 0; w < 2; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				totals.HandleDomain(NewSampleDomainResult(fmt.Sprintf("%d-%d.example.com", w, i)))
+			}
+		}(w)
+	}
+	for i := 0; i < 10; i++ {
+		snapshot := totals.Snapshot()
+		if snapshot.TotalMTASTS() > snapshot.WithMXs || len(snapshot.MTASTSEnforceList) != snapshot.MTASTSEnforce {
+			t.Errorf("Expected a consistent snapshot, got %d of %d with MTA-STS and %d enforcing domains listed",
+				snapshot.TotalMTASTS(), snapshot.WithMXs, len(snapshot.MTASTSEnforceList))
+		}
+	}
+	wg.Wait()
+
+	snapshot := totals.Snapshot()
+	if snapshot.Attempted != 100 || snapshot.WithMXs != 100 {
+		t.Errorf("Expected 100 domains in the final snapshot, got %d attempted and %d with MXs", snapshot.Attempted, snapshot.WithMXs)
+	}
+	snapshot.MTASTSEnforceList[0] = "changed.example.com"
+	if totals.MTASTSEnforceList[0] == "changed.example.com" {
+		t.Error("Expected snapshot not to share lists with the AggregatedScan")
+	}
+}
+
+func TestSnapshotCopiesEveryField(t *testing.T) {
+	totals := &AggregatedScan{}
+	v := reflect.ValueOf(totals).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.Bool:
+			field.SetBool(true)
+		case reflect.Int:
+			field.SetInt(1)
+		case reflect.String:
+			field.SetString("example")
+		case reflect.Slice:
+			field.Set(reflect.ValueOf([]string{"example.com"}))
+		case reflect.Map:
+			field.Set(reflect.MakeMap(field.Type()))
+			field.SetMapIndex(reflect.New(field.Type().Key()).Elem(), reflect.ValueOf(1))
+		case reflect.Interface:
+			field.Set(reflect.ValueOf(NopLogger))
+		case reflect.Struct:
+			field.Set(reflect.ValueOf(time.Now()))
+		default:
+			t.Fatalf("Unexpected kind of field %s", v.Type().Field(i).Name)
+		}
+	}
+	snapshot := reflect.ValueOf(totals.Snapshot())
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).CanSet() && !reflect.DeepEqual(v.Field(i).Interface(), snapshot.Field(i).Interface()) {
+			t.Errorf("Expected Snapshot to copy %s", v.Type().Field(i).Name)
+		}
+	}
+}
+
+func TestTLSVersionCounts(t *testing.T) {
+	hostnameResult := func(version uint16) HostnameResult {
+		return HostnameResult{Result: MakeResult("hostnames"), TLSVersion: version}
+	}
+	totals := AggregatedScan{Logger: NopLogger}
+	totals.HandleDomain(DomainResult{HostnameResults: map[string]HostnameResult{
+		"mx1": hostnameResult(tls.VersionTLS13),
+		"mx2": hostnameResult(tls.VersionTLS11),
+	}})
+	totals.HandleDomain(DomainResult{HostnameResults: map[string]HostnameResult{
+		"mx1": hostnameResult(tls.VersionTLS13),
+		"mx2": hostnameResult(0),
+	}})
+	totals.HandleDomain(DomainResult{HostnameResults: map[string]HostnameResult{
+		"mx1": hostnameResult(0),
+	}})
+	expected := map[string]int{"TLSv1.1": 1, "TLSv1.3": 1}
+	if !reflect.DeepEqual(totals.TLSVersionCounts, expected) {
+		t.Errorf("Expected TLS version counts %v, got %v", expected, totals.TLSVersionCounts)
+	}
+}
+
+func TestTLS13SupportCount(t *testing.T) {
+	hostnameResult := func(version uint16) HostnameResult {
+		return HostnameResult{Result: MakeResult("hostnames"), TLSVersion: version}
+	}
+	totals := AggregatedScan{Logger: NopLogger}
+	totals.HandleDomain(DomainResult{HostnameResults: map[string]HostnameResult{
+		"mx1": hostnameResult(tls.VersionTLS13),
+		"mx2": hostnameResult(tls.VersionTLS12),
+	}})
+	totals.HandleDomain(DomainResult{HostnameResults: map[string]HostnameResult{
+		"mx1": hostnameResult(tls.VersionTLS12),
+	}})
+	if totals.TLS13SupportCount != 1 || totals.PercentTLS13() != 50 {
+		t.Errorf("Expected 1 of 2 domains to support TLS 1.3, got %d (%v%%)", totals.TLS13SupportCount, totals.PercentTLS13())
+	}
+}
+
+func TestSCTCount(t *testing.T) {
+	hostnameResult := func(scts ...SCT) HostnameResult {
+		return HostnameResult{Result: MakeResult("hostnames"), Certificate: &CertificateInfo{SCTs: scts}}
+	}
+	totals := AggregatedScan{Logger: NopLogger}
+	totals.HandleDomain(DomainResult{HostnameResults: map[string]HostnameResult{
+		"mx1": hostnameResult(SCT{Source: SCTEmbedded}),
+		"mx2": hostnameResult(),
+	}})
+	totals.HandleDomain(DomainResult{HostnameResults: map[string]HostnameResult{
+		"mx1": hostnameResult(),
+	}})
+	if totals.SCTCount != 1 || totals.PercentSCT() != 50 {
+		t.Errorf("Expected 1 of 2 domains to have SCTs, got %d (%v%%)", totals.SCTCount, totals.PercentSCT())
+	}
+}
+
+func TestMTASTSModeCounts(t *testing.T) {
+	totals := AggregatedScan{Logger: NopLogger}
+	for _, mode := range []string{"enforce", "testing", "none", "none", "start_turtles"} {
+		totals.HandleDomain(DomainResult{
+			Domain:          mode + ".example.com",
+			HostnameResults: map[string]HostnameResult{"mx": {Result: MakeResult("hostnames")}},
+			MTASTSResult:    &MTASTSResult{Result: MakeResult(MTASTS), Mode: mode},
+		})
+	}
+	if totals.MTASTSEnforce != 1 || totals.MTASTSTesting != 1 || totals.MTASTSNoneCount != 2 {
+		t.Errorf("Expected 1 enforce, 1 testing and 2 none, got %d, %d and %d",
+			totals.MTASTSEnforce, totals.MTASTSTesting, totals.MTASTSNoneCount)
+	}
+}
+
+func TestIssuerCounts(t *testing.T) {
+	domainWithIssuers := func(issuers ...string) DomainResult {
+		r := DomainResult{HostnameResults: map[string]HostnameResult{}}
+		for i, issuer := range issuers {
+			r.HostnameResults[fmt.Sprintf("mx%d", i)] = HostnameResult{
+				Result:      MakeResult("hostnames"),
+				Certificate: &CertificateInfo{Issuer: issuer + " CA", IssuerOrganization: []string{issuer}},
+			}
+		}
+		return r
+	}
+	totals := AggregatedScan{Logger: NopLogger}
+	totals.HandleDomain(domainWithIssuers("Let's Encrypt", "Let's Encrypt"))
+	totals.HandleDomain(domainWithIssuers("Let's Encrypt", "DigiCert Inc"))
+	totals.HandleDomain(domainWithIssuers("Sectigo Limited"))
+	totals.HandleDomain(DomainResult{HostnameResults: map[string]HostnameResult{
+		"mx": {Result: MakeResult("hostnames"), Certificate: &CertificateInfo{Issuer: "Internal CA"}},
+	}})
+
+	top := totals.TopIssuers(2)
+	expected := []IssuerCount{{"Let's Encrypt", 2}, {"DigiCert Inc", 1}}
+	if !reflect.DeepEqual(top, expected) {
+		t.Errorf("Expected top issuers %v, got %v", expected, top)
+	}
+	if totals.IssuerCounts["Internal CA"] != 1 {
+		t.Errorf("Expected issuers without an organization to be counted by name, got %v", totals.IssuerCounts)
+	}
+
+	for i := 0; i < maxIssuers; i++ {
+		totals.HandleDomain(domainWithIssuers(fmt.Sprintf("CA %d", i)))
+	}
+	if len(totals.IssuerCounts) != maxIssuers+1 || totals.IssuerCounts[OtherIssuer] != 4 {
+		t.Errorf("Expected long tail of issuers to be counted as %s, got %d issuers and %d others",
+			OtherIssuer, len(totals.IssuerCounts), totals.IssuerCounts[OtherIssuer])
+	}
+}
+
+func TestConnectionFailureCounts(t *testing.T) {
+	totals := AggregatedScan{Logger: NopLogger}
+	totals.HandleDomain(DomainResult{HostnameResults: map[string]HostnameResult{
+		"mx1": {Result: MakeResult("hostnames"), ConnectionFailure: ConnRefused},
+		"mx2": {Result: MakeResult("hostnames"), ConnectionFailure: ConnTimeout},
+		"mx3": {Result: MakeResult("hostnames")},
+	}})
+	totals.HandleDomain(DomainResult{HostnameResults: map[string]HostnameResult{
+		"mx": {Result: MakeResult("hostnames"), ConnectionFailure: ConnRefused},
+	}})
+	expected := map[ConnectionFailure]int{ConnRefused: 2, ConnTimeout: 1}
+	if !reflect.DeepEqual(totals.ConnectionFailureCounts, expected) {
+		t.Errorf("Expected connection failure counts %v, got %v", expected, totals.ConnectionFailureCounts)
+	}
+}
+
+func TestWithMXsUnreachable(t *testing.T) {
+	reachable := HostnameResult{Result: MakeResult("hostnames")}
+	reachable.addCheck(MakeResult(Connectivity))
+	unreachable := HostnameResult{Result: MakeResult("hostnames")}
+	unreachable.addCheck(MakeResult(Connectivity).Error("Could not establish connection"))
+
+	totals := AggregatedScan{Logger: NopLogger}
+	totals.HandleDomain(DomainResult{HostnameResults: map[string]HostnameResult{
+		"mx1": reachable, "mx2": unreachable,
+	}})
+	totals.HandleDomain(DomainResult{HostnameResults: map[string]HostnameResult{
+		"mx": unreachable,
+	}})
+	totals.HandleDomain(DomainResult{})
+	if totals.WithMXs != 2 || totals.WithMXsUnreachable != 1 {
+		t.Errorf("Expected 1 of 2 domains with MXs to be unreachable, got %d of %d", totals.WithMXsUnreachable, totals.WithMXs)
+	}
+	if percent := totals.PercentUnreachable(); percent != 50 {
+		t.Errorf("Expected 50%% of domains to be unreachable, got %v", percent)
+	}
+}
+
+func TestSTARTTLSSupportCount(t *testing.T) {
+	hostnameResult := func(starttls Status) HostnameResult {
+		h := HostnameResult{Result: MakeResult("hostnames")}
+		h.addCheck(MakeResult(Connectivity))
+		h.addCheck(&Result{Name: STARTTLS, Status: starttls})
+		return h
+	}
+	unreachable := HostnameResult{Result: MakeResult("hostnames")}
+	unreachable.addCheck(MakeResult(Connectivity).Error("Could not establish connection"))
+	domains := []DomainResult{
+		{Domain: "all.com", HostnameResults: map[string]HostnameResult{"mx1": hostnameResult(Success), "mx2": unreachable}},
+		{Domain: "some.com", HostnameResults: map[string]HostnameResult{"mx1": hostnameResult(Success), "mx2": hostnameResult(Failure)}},
+		{Domain: "none.com", HostnameResults: map[string]HostnameResult{"mx": hostnameResult(Failure)}},
+		{Domain: "unreachable.com", HostnameResults: map[string]HostnameResult{"mx": unreachable}},
+	}
+	tests := []struct {
+		anyMX    bool
+		expected []string
+	}{
+		{false, []string{"all.com"}},
+		{true, []string{"all.com", "some.com"}},
+	}
+	for _, test := range tests {
+		totals := AggregatedScan{STARTTLSAnyMX: test.anyMX, ListSTARTTLSSupport: true, Logger: NopLogger}
+		for _, domain := range domains {
+			totals.HandleDomain(domain)
+		}
+		if totals.STARTTLSSupportCount != len(test.expected) || !reflect.DeepEqual(totals.STARTTLSSupportList, test.expected) {
+			t.Errorf("STARTTLSAnyMX %t: expected %v to support STARTTLS, got %d: %v",
+				test.anyMX, test.expected, totals.STARTTLSSupportCount, totals.STARTTLSSupportList)
+		}
+		if percent := totals.PercentSTARTTLS(); percent != 25*float64(len(test.expected)) {
+			t.Errorf("STARTTLSAnyMX %t: expected %v%% to support STARTTLS, got %v", test.anyMX, 25*len(test.expected), percent)
+		}
+	}
+
+	totals := AggregatedScan{Logger: NopLogger}
+	totals.HandleDomain(domains[0])
+	if totals.STARTTLSSupportCount != 1 || totals.STARTTLSSupportList != nil {
+		t.Errorf("Expected domains to be counted but not listed by default, got %d and %v", totals.STARTTLSSupportCount, totals.STARTTLSSupportList)
+	}
+}
+
+func TestFailureReasons(t *testing.T) {
+	hostnameResult := func(starttls Status, certFailures ...CertificateFailure) HostnameResult {
+		h := HostnameResult{Result: MakeResult("hostnames"), CertificateFailures: certFailures}
+		h.addCheck(MakeResult(Connectivity))
+		h.addCheck(&Result{Name: STARTTLS, Status: starttls})
+		return h
+	}
+	unreachable := HostnameResult{Result: MakeResult("hostnames")}
+	unreachable.addCheck(MakeResult(Connectivity).Error("Could not establish connection"))
+
+	totals := AggregatedScan{Logger: NopLogger}
+	totals.HandleDomain(DomainResult{HostnameResults: map[string]HostnameResult{
+		"mx1": hostnameResult(Success, CertHostnameMismatch, CertSelfSigned),
+		"mx2": hostnameResult(Success, CertHostnameMismatch),
+		"mx3": unreachable,
+	}})
+	totals.HandleDomain(DomainResult{HostnameResults: map[string]HostnameResult{
+		"mx1": hostnameResult(Failure),
+		"mx2": hostnameResult(Success, CertExpired),
+	}})
+	totals.HandleDomain(DomainResult{HostnameResults: map[string]HostnameResult{"mx": unreachable}})
+	totals.HandleDomain(DomainResult{HostnameResults: map[string]HostnameResult{"mx": hostnameResult(Success)}})
+	expected := map[string]int{
+		string(CertHostnameMismatch): 1,
+		string(CertSelfSigned):       1,
+		string(CertExpired):          1,
+		FailureNoSTARTTLS:            1,
+		FailureUnreachable:           1,
+	}
+	if !reflect.DeepEqual(totals.FailureReasons, expected) {
+		t.Errorf("Expected failure reasons %v, got %v", expected, totals.FailureReasons)
+	}
+}
+
+func TestAggregatedScanOnlySource(t *testing.T) {
+	topDomains := AggregatedScan{Source: TopDomainsSource, OnlySource: true, Logger: NopLogger}
+	local := AggregatedScan{Source: LocalSource, OnlySource: true, Logger: NopLogger}
+	all := AggregatedScan{Source: LocalSource, Logger: NopLogger}
+	for _, source := range []string{TopDomainsSource, TopDomainsSource, LocalSource, ""} {
+		r := DomainResult{Source: source}
+		topDomains.HandleDomain(r)
+		local.HandleDomain(r)
+		all.HandleDomain(r)
+	}
+	if topDomains.Attempted != 2 || local.Attempted != 1 || all.Attempted != 4 {
+		t.Errorf("Expected 2 top domains, 1 local and 4 total domains, got %d, %d and %d",
+			topDomains.Attempted, local.Attempted, all.Attempted)
+	}
+
+	c := Checker{
+		Source:               TopDomainsSource,
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	if source := c.CheckDomain("domain", nil).Source; source != TopDomainsSource {
+		t.Errorf("Expected result to be labeled with the Checker's source, got %q", source)
+	}
+}
+
+type recordingHandler struct {
+	name  string
+	calls *[]string
+}
+
+func (h recordingHandler) HandleDomain(r DomainResult) {
+	*h.calls = append(*h.calls, h.name+":"+r.Domain)
+}
+
+func TestMultiHandler(t *testing.T) {
+	in := "domain\n"
+	reader := csv.NewReader(strings.NewReader(in))
+	c := Checker{
+		lookupMXOverride:     mockLookupMX,
+		CheckHostname:        mockCheckHostname,
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+	}
+	calls := []string{}
+	totals := AggregatedScan{Logger: NopLogger}
+	handler := MultiHandler(&totals, recordingHandler{"a", &calls}, recordingHandler{"b", &calls})
+	c.CheckCSV(reader, handler, 0)
+
+	if totals.Attempted != 1 {
+		t.Errorf("Expected 1 attempted connection, got %d", totals.Attempted)
+	}
+	expected := []string{"a:domain", "b:domain"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected handlers to be called in order %v, got %v", expected, calls)
+	}
+}
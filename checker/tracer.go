@@ -0,0 +1,53 @@
+package checker
+
+import "context"
+
+// Tracer is the interface used by the checker to record spans of the work it
+// does, e.g. to export them to OpenTelemetry and see where the time checking
+// a domain goes. Implementations can wrap a tracing library without the
+// checker depending on it.
+//
+// CheckDomain starts a "domain" span for each domain, and child spans for
+// its MX lookup ("dns.mx"), each mailserver ("hostname"), and the CAA
+// ("caa"), MTA-STS ("mta-sts", with "mta-sts.record" and
+// "mta-sts.policy-fetch"), TLS-RPT ("tls-rpt"), SPF ("spf", with
+// Checker.CheckSPF), DMARC ("dmarc", with Checker.CheckDMARC), DKIM ("dkim",
+// with Checker.CheckDKIM) and policy list ("policylist") checks, and the
+// DNSSEC lookups ("dnssec"). Each mailserver's span has children for
+// connecting ("dial"), STARTTLS ("starttls"), the connection that checks the
+// TLS version ("version"), the connections that enumerate cipher suites
+// ("ciphers", with Checker.EnumerateCipherSuites) and the DANE check ("dane").
+type Tracer interface {
+	// Start starts a span called name, as a child of the span in ctx if there
+	// is one, and returns a context containing the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SetAttribute records an attribute of the span, e.g. the hostname being
+	// checked, or the status of a check once it's done.
+	SetAttribute(key string, value interface{})
+	// End ends the span.
+	End()
+}
+
+// nopTracer starts spans that do nothing.
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(string, interface{}) {}
+
+func (nopSpan) End() {}
+
+func tracerOrDefault(t Tracer) Tracer {
+	if t != nil {
+		return t
+	}
+	return nopTracer{}
+}
@@ -0,0 +1,105 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type spanKey struct{}
+
+// recordingTracer records the path of each span started, e.g.
+// "domain/hostname/dial", and the attributes of each path.
+type recordingTracer struct {
+	mu         sync.Mutex
+	paths      []string
+	attributes map[string]map[string]interface{}
+}
+
+type recordingSpan struct {
+	tracer *recordingTracer
+	path   string
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	path := name
+	if parent, ok := ctx.Value(spanKey{}).(string); ok {
+		path = parent + "/" + name
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paths = append(t.paths, path)
+	return context.WithValue(ctx, spanKey{}, path), recordingSpan{t, path}
+}
+
+func (s recordingSpan) SetAttribute(key string, value interface{}) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	if s.tracer.attributes == nil {
+		s.tracer.attributes = make(map[string]map[string]interface{})
+	}
+	if s.tracer.attributes[s.path] == nil {
+		s.tracer.attributes[s.path] = make(map[string]interface{})
+	}
+	s.tracer.attributes[s.path][key] = value
+}
+
+func (s recordingSpan) End() {}
+
+func TestTracer(t *testing.T) {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := smtpListenAndServe(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	tracer := &recordingTracer{}
+	c := Checker{
+		Timeout:              testTimeout,
+		Tracer:               tracer,
+		lookupMXOverride:     func(string) ([]*net.MX, error) { return []*net.MX{{Host: "localhost"}}, nil },
+		lookupHostOverride:   func(string) ([]string, error) { return []string{"127.0.0.1"}, nil },
+		checkMTASTSOverride:  mockCheckMTASTS,
+		lookupCAAOverride:    mockLookupCAA,
+		lookupDNSSECOverride: mockLookupDNSSEC,
+		lookupTLSAOverride:   mockLookupTLSA,
+		CheckDNSSEC:          true,
+	}
+	c.Port, _ = strconv.Atoi(port)
+	c.CheckDomain("example.com", nil)
+
+	sort.Strings(tracer.paths)
+	expected := []string{
+		"domain",
+		"domain/caa",
+		"domain/dns.mx",
+		"domain/dnssec",
+		"domain/hostname",
+		"domain/hostname/dane",
+		"domain/hostname/dial",
+		"domain/hostname/starttls",
+		"domain/hostname/version",
+		"domain/mta-sts",
+		"domain/tls-rpt",
+	}
+	if !reflect.DeepEqual(tracer.paths, expected) {
+		t.Fatalf("Expected spans %v, got %v", expected, tracer.paths)
+	}
+	hostname := tracer.attributes["domain/hostname"]
+	if hostname["hostname"] != "localhost" || hostname["tls_version"] == nil {
+		t.Errorf("Expected hostname span to record the hostname and TLS version, got %v", hostname)
+	}
+	if status := tracer.attributes["domain/hostname/starttls"]["status"]; status != "Success" {
+		t.Errorf("Expected STARTTLS span to record its status, got %v", status)
+	}
+	if domain := tracer.attributes["domain"]; domain["domain"] != "example.com" || domain["status"] == nil {
+		t.Errorf("Expected domain span to record the domain and its status, got %v", domain)
+	}
+}
@@ -1,18 +1,22 @@
 module github.com/EFForg/starttls-backend
 
-go 1.11
+go 1.21
 
 require (
-	github.com/certifi/gocertifi v0.0.0-20190506164543-d2eda7129713 // indirect
-	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/getsentry/raven-go v0.2.0
 	github.com/gorilla/handlers v1.4.0
 	github.com/joho/godotenv v1.3.0
 	github.com/lib/pq v1.1.1
 	github.com/mhale/smtpd v0.0.0-20181125220505-3c4c908952b8
+	github.com/ulule/limiter v2.2.2+incompatible
+	golang.org/x/net v0.17.0
+)
+
+require (
+	github.com/certifi/gocertifi v0.0.0-20190506164543-d2eda7129713 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pkg/errors v0.8.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/testify v1.2.2 // indirect
-	github.com/ulule/limiter v2.2.2+incompatible
-	golang.org/x/net v0.0.0-20190611141213-3f473d35a33a
+	golang.org/x/text v0.13.0 // indirect
 )
@@ -36,5 +36,9 @@ func (s Scan) SupportsMTASTS() bool {
 	if s.Data.MTASTSResult == nil {
 		return false
 	}
-	return s.Data.MTASTSResult.Status == checker.Success || s.Data.MTASTSResult.Status == checker.Warning
+	switch s.Data.MTASTSResult.Status {
+	case checker.Success, checker.Info, checker.Warning:
+		return true
+	}
+	return false
 }
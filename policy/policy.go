@@ -2,10 +2,13 @@ package policy
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 )
@@ -35,6 +38,34 @@ func (l *List) Add(domain string, policy TLSPolicy) {
 	l.Policies[domain] = policy
 }
 
+// HasDomain returns true if a domain is present on the list.
+func (l *List) HasDomain(domain string) bool {
+	_, err := l.get(domain)
+	return err == nil
+}
+
+// LoadList parses a policy list in the same JSON format as EFF's published list.
+func LoadList(r io.Reader) (List, error) {
+	var list List
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return List{}, fmt.Errorf("couldn't parse policy list: %v", err)
+	}
+	if list.Policies == nil {
+		list.Policies = make(map[string]TLSPolicy)
+	}
+	return list, nil
+}
+
+// LoadListFile parses the policy list in the JSON file at path.
+func LoadListFile(path string) (List, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return List{}, err
+	}
+	defer f.Close()
+	return LoadList(f)
+}
+
 // get retrieves the TLSPolicy for a domain, and resolves
 // aliases if they exist.
 func (l *List) get(domain string) (TLSPolicy, error) {
@@ -52,10 +83,11 @@ func (l *List) get(domain string) (TLSPolicy, error) {
 }
 
 // UpdatedList wraps a list that is updated from a remote
-// policyURL every hour. Safe for concurrent calls to `Get`.
+// policyURL periodically (every hour by default). Safe for concurrent calls to `Get`.
 type UpdatedList struct {
 	mu sync.RWMutex
 	*List
+	fetchedAt time.Time
 }
 
 // DomainsToValidate [interface Validator] retrieves domains from the
@@ -120,35 +152,109 @@ func (p TLSPolicy) clone() TLSPolicy {
 	return policy
 }
 
-// fetchListFn returns a new policy list. It can be used to update UpdatedList
+// fetchListFn returns a new policy list. It can be used to update UpdatedList.
+// It returns errNotModified if the list hasn't changed since the last fetch.
 type fetchListFn func() (List, error)
 
-// Retrieve and parse List from policyURL
-func fetchListHTTP() (List, error) {
-	resp, err := http.Get(policyURL)
+// errNotModified is returned by a fetchListFn if the list hasn't changed.
+var errNotModified = errors.New("policy list not modified")
+
+// maxListSize caps the size of the policy list fetched by httpListFetcher,
+// so that a broken or malicious server can't exhaust memory.
+const maxListSize = 16 << 20
+
+// httpListFetcher fetches the policy list from url, using conditional
+// requests so that an unchanged list isn't downloaded again.
+type httpListFetcher struct {
+	url    string
+	client *http.Client
+	// maxSize is the most bytes read of the list. If zero, maxListSize is
+	// used.
+	maxSize      int64
+	etag         string
+	lastModified string
+}
+
+// Retrieve and parse List from the fetcher's URL.
+// Only called by a single goroutine at a time.
+func (f *httpListFetcher) fetch() (List, error) {
+	req, err := http.NewRequest(http.MethodGet, f.url, nil)
+	if err != nil {
+		return List{}, err
+	}
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+	if f.lastModified != "" {
+		req.Header.Set("If-Modified-Since", f.lastModified)
+	}
+	resp, err := f.client.Do(req)
 	if err != nil {
 		return List{}, err
 	}
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotModified {
+		return List{}, errNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return List{}, fmt.Errorf("fetching %s returned HTTP status %d", f.url, resp.StatusCode)
+	}
+	maxSize := f.maxSize
+	if maxSize <= 0 {
+		maxSize = maxListSize
+	}
+	// Read one byte more than allowed, to tell if the list is too large.
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return List{}, err
+	}
+	if int64(len(body)) > maxSize {
+		return List{}, fmt.Errorf("policy list at %s is larger than %d bytes", f.url, maxSize)
+	}
 	var policyList List
 	err = json.Unmarshal(body, &policyList)
 	if err != nil {
 		return List{}, err
 	}
+	f.etag = resp.Header.Get("ETag")
+	f.lastModified = resp.Header.Get("Last-Modified")
 	return policyList, nil
 }
 
-// Get a new policy list and safely assign it the UpdatedList
+// Get a new policy list and safely assign it the UpdatedList.
+// If fetching fails, the stale list continues to be used.
 func (l *UpdatedList) update(fetch fetchListFn) {
 	newList, err := fetch()
-	if err != nil {
-		log.Printf("Error updating policy list: %s\n", err)
-	} else {
+	if err == errNotModified {
 		l.mu.Lock()
-		l.List = &newList
+		l.fetchedAt = time.Now()
 		l.mu.Unlock()
+		return
+	}
+	if err != nil {
+		l.mu.RLock()
+		fetchedAt := l.fetchedAt
+		l.mu.RUnlock()
+		if fetchedAt.IsZero() {
+			log.Printf("Error updating policy list: %s\n", err)
+		} else {
+			log.Printf("Warning: error updating policy list, still using list fetched at %v: %s\n", fetchedAt, err)
+		}
+		return
 	}
+	l.mu.Lock()
+	l.List = &newList
+	l.fetchedAt = time.Now()
+	l.mu.Unlock()
+}
+
+// FetchedAt returns the time at which the list was last successfully fetched
+// (or confirmed to be unchanged). It is the zero time if the list has never
+// been fetched.
+func (l *UpdatedList) FetchedAt() time.Time {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.fetchedAt
 }
 
 // makeUpdatedList constructs an UpdatedList object and launches a
@@ -160,14 +266,25 @@ func makeUpdatedList(fetch fetchListFn, updateFrequency time.Duration) *UpdatedL
 
 	go func() {
 		for {
-			l.update(fetch)
 			time.Sleep(updateFrequency)
+			l.update(fetch)
 		}
 	}()
 	return &l
 }
 
-// MakeUpdatedList wraps makeUpdatedList to use FetchListHTTP by default to update policy list
+// MakeUpdatedList wraps makeUpdatedList to fetch EFF's policy list every hour.
 func MakeUpdatedList() *UpdatedList {
-	return makeUpdatedList(fetchListHTTP, time.Hour)
+	return MakeUpdatedListWithInterval(time.Hour)
+}
+
+// MakeUpdatedListWithInterval fetches EFF's policy list, and refreshes it
+// every updateFrequency. Refreshes use conditional requests, so an unchanged
+// list isn't downloaded again.
+func MakeUpdatedListWithInterval(updateFrequency time.Duration) *UpdatedList {
+	fetcher := &httpListFetcher{
+		url:    policyURL,
+		client: &http.Client{Timeout: time.Minute},
+	}
+	return makeUpdatedList(fetcher.fetch, updateFrequency)
 }
@@ -2,7 +2,10 @@ package policy
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -128,3 +131,85 @@ func TestCloneDoesntChangeOriginal(t *testing.T) {
 		t.Errorf("Expected original to remain unchanged after changing copy")
 	}
 }
+
+func TestLoadList(t *testing.T) {
+	in := `{"policy-aliases": {"gmail": {"mode": "testing", "mxs": [".mx.google.com"]}},
+		"policies": {"gmail.com": {"policy-alias": "gmail"}, "example.com": {"mode": "enforce"}}}`
+	list, err := LoadList(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, domain := range []string{"gmail.com", "example.com"} {
+		if !list.HasDomain(domain) {
+			t.Errorf("Expected %s to be on the loaded list", domain)
+		}
+	}
+	if list.HasDomain("eff.org") {
+		t.Error("Expected eff.org not to be on the loaded list")
+	}
+	if _, err := LoadList(strings.NewReader("{")); err == nil {
+		t.Error("Expected malformed list to return an error")
+	}
+}
+
+func TestHTTPFetchIsConditional(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `{"policies": {"eff.org": {"mode": "testing"}}}`)
+	}))
+	defer server.Close()
+
+	fetcher := &httpListFetcher{url: server.URL, client: server.Client()}
+	list, err := fetcher.fetch()
+	if err != nil || !list.HasDomain("eff.org") {
+		t.Fatalf("Expected first fetch to return the list, got %v, %v", list, err)
+	}
+	if _, err := fetcher.fetch(); err != errNotModified {
+		t.Errorf("Expected second fetch to return errNotModified, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 requests, got %d", requests)
+	}
+}
+
+func TestHTTPFetchSizeLimit(t *testing.T) {
+	body := `{"policies": {"eff.org": {"mode": "testing"}}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	fetcher := &httpListFetcher{url: server.URL, client: server.Client(), maxSize: int64(len(body)) - 1}
+	if _, err := fetcher.fetch(); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("Expected oversized list to return an error, got %v", err)
+	}
+	fetcher.maxSize = int64(len(body))
+	if list, err := fetcher.fetch(); err != nil || !list.HasDomain("eff.org") {
+		t.Errorf("Expected list within the limit to be fetched, got %v, %v", list, err)
+	}
+}
+
+func TestFailedUpdateKeepsStaleList(t *testing.T) {
+	list := makeUpdatedList(mockFetchHTTP, time.Hour)
+	fetchedAt := list.FetchedAt()
+	if fetchedAt.IsZero() {
+		t.Fatal("Expected fetch time to be recorded")
+	}
+	list.update(mockErroringFetchHTTP)
+	if !list.HasDomain("eff.org") {
+		t.Error("Expected stale list to be kept after a failed update")
+	}
+	if list.FetchedAt() != fetchedAt {
+		t.Error("Expected fetch time not to change after a failed update")
+	}
+	list.update(func() (List, error) { return List{}, errNotModified })
+	if !list.HasDomain("eff.org") || !list.FetchedAt().After(fetchedAt) {
+		t.Error("Expected unmodified list to be kept, with a new fetch time")
+	}
+}